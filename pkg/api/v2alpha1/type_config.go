@@ -3,6 +3,7 @@ package v2alpha1
 import (
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/image"
@@ -29,6 +30,54 @@ type ImageSetConfigurationSpec struct {
 	Mirror Mirror `json:"mirror"`
 	// ArchiveSize is the size of the segmented archive in GB
 	ArchiveSize int64 `json:"archiveSize,omitempty"`
+	// ArchiveEncryption defines how the archive is encrypted at rest
+	ArchiveEncryption ArchiveEncryption `json:"archiveEncryption,omitempty"`
+	// TrustPolicy defines the signatures the images are verified with before they are mirrored
+	TrustPolicy TrustPolicy `json:"trustPolicy,omitempty"`
+}
+
+// ArchiveEncryption defines the recipients the archive chunks are encrypted to.
+type ArchiveEncryption struct {
+	// Recipients is a list of age public keys (age1...), or paths to files
+	// containing age recipients or an OpenPGP public key.
+	// age and OpenPGP recipients can't be mixed.
+	Recipients []string `json:"recipients,omitempty"`
+}
+
+const (
+	// TrustPolicyAccept accepts the images, signed or not
+	TrustPolicyAccept = "accept"
+	// TrustPolicyReject rejects the images
+	TrustPolicyReject = "reject"
+)
+
+// TrustPolicy defines the keys the images of registries, namespaces or repositories are signed with.
+// The images are verified before they are copied from their registry (to the cache or the destination).
+type TrustPolicy struct {
+	// Default is the policy of the images outside the scopes: accept (default) or reject
+	Default string `json:"default,omitempty"`
+	// Scopes are the registries, namespaces and repositories whose images are verified.
+	// The most specific scope of an image applies.
+	Scopes []TrustScope `json:"scopes,omitempty"`
+}
+
+// TrustScope defines the keys the images of a scope are signed with.
+// Without key, the images of the scope are accepted, signed or not.
+type TrustScope struct {
+	// Scope is a registry (registry.redhat.io), a namespace or a repository (quay.io/openshift-release-dev/ocp-release),
+	// or a wildcard of registries (*.example.com)
+	Scope string `json:"scope"`
+	// Keys are the GPG public key files the simple signatures of the images are signed with
+	Keys []string `json:"keys,omitempty"`
+	// SigstorePublicKeys are the public key files the cosign signatures of the images are signed with
+	SigstorePublicKeys []string `json:"sigstorePublicKeys,omitempty"`
+	// Lookaside is the URL of the signature storage of the simple signatures, for the registries not serving them
+	Lookaside string `json:"lookaside,omitempty"`
+}
+
+// IsEmpty returns true when the trust policy doesn't verify any image
+func (t TrustPolicy) IsEmpty() bool {
+	return len(t.Scopes) == 0 && t.Default != TrustPolicyReject
 }
 
 // DeleteImageSetConfiguration object kind.
@@ -90,6 +139,9 @@ type Platform struct {
 	// Graph defines whether Cincinnati graph data will
 	// downloaded and publish
 	Graph bool `json:"graph,omitempty"`
+	// GraphData defines local sources for building the graph image,
+	// for environments without network access (enclaves)
+	GraphData GraphData `json:"graphData,omitempty"`
 	// Channels defines the configuration for individual
 	// OCP and OKD channels
 	Channels []ReleaseChannel `json:"channels,omitempty"`
@@ -104,11 +156,20 @@ type Platform struct {
 	// will be used to extract the kubeVirtContainer image
 	// from the release payload file 0000_50_installer_coreos-bootimages
 	KubeVirtContainer bool `json:"kubeVirtContainer,omitempty"`
+	// UpdateService defines the UpdateService custom resources
+	// generated for the graph and the mirrored releases
+	UpdateService UpdateService `json:"updateService,omitempty"`
+	// Signatures defines how the signatures of the mirrored releases
+	// are acquired and verified
+	Signatures ReleaseSignatures `json:"signatures,omitempty"`
 }
 
 func (p Platform) DeepCopy() Platform {
 	platformCopy := Platform{
-		Graph: p.Graph,
+		Graph:         p.Graph,
+		GraphData:     p.GraphData,
+		UpdateService: p.UpdateService,
+		Signatures:    p.Signatures,
 	}
 
 	platformCopy.Channels = make([]ReleaseChannel, len(p.Channels))
@@ -117,9 +178,63 @@ func (p Platform) DeepCopy() Platform {
 	platformCopy.Architectures = make([]string, len(p.Architectures))
 	copy(platformCopy.Architectures, p.Architectures)
 
+	platformCopy.Signatures.Stores = slices.Clone(p.Signatures.Stores)
+	platformCopy.Signatures.PublicKeys = slices.Clone(p.Signatures.PublicKeys)
+
 	return platformCopy
 }
 
+// UpdateService defines the UpdateService custom resources generated by oc-mirror.
+// One UpdateService is generated for each repository the releases are mirrored to
+// (i.e. OCP and OKD releases), named after the repository when there are several of them.
+type UpdateService struct {
+	// Name of the UpdateService. Default is update-service-oc-mirror.
+	Name string `json:"name,omitempty"`
+	// Namespace of the UpdateService. Default is the namespace it is applied to.
+	Namespace string `json:"namespace,omitempty"`
+	// Replicas is the number of pods of the UpdateService. Default is 2.
+	Replicas int32 `json:"replicas,omitempty"`
+}
+
+// Policies applied to the mirrored releases without a valid signature
+const (
+	// SignaturePolicyRequire fails the mirroring
+	SignaturePolicyRequire = "require"
+	// SignaturePolicyWarn only logs a warning
+	SignaturePolicyWarn = "warn"
+	// SignaturePolicySkip doesn't acquire the signatures
+	SignaturePolicySkip = "skip"
+)
+
+// ReleaseSignatures defines where the detached signatures of the releases are fetched from,
+// and the keys they are verified with. The verified signatures are stored in the signatures
+// directory of the working-dir, and end up in the signature ConfigMap of the cluster resources.
+type ReleaseSignatures struct {
+	// Stores are the signature stores the signatures are looked up in, in order:
+	// http(s):// URLs or local directories (file:// or a path), with the layout
+	// <store>/sha256=<digest>/signature-<n>.
+	// Default is https://mirror.openshift.com/pub/openshift-v4/signatures/openshift/release
+	Stores []string `json:"stores,omitempty"`
+	// PublicKeys are the GPG public key files (armored or binary) the signatures
+	// are verified with. Default is /etc/pki/rpm-gpg/RPM-GPG-KEY-redhat-release
+	PublicKeys []string `json:"publicKeys,omitempty"`
+	// Policy applied to the releases without a valid signature: require, warn or skip.
+	// Default is warn when Stores or PublicKeys are set, skip otherwise: the signatures
+	// are only acquired when asked for
+	Policy string `json:"policy,omitempty"`
+}
+
+// GraphData defines local sources for building the graph image,
+// instead of the Cincinnati graph-data endpoint and the UBI9 base image
+type GraphData struct {
+	// Tarball is the path to a graph-data tarball (tar.gz),
+	// as served by the Cincinnati graph-data endpoint
+	Tarball string `json:"tarball,omitempty"`
+	// BaseImage is the image the graph image is built on: an OCI layout
+	// (oci:///path/to/layout) or an image of the cache (i.e. ubi9/ubi:latest)
+	BaseImage string `json:"baseImage,omitempty"`
+}
+
 // ReleaseChannel defines the configuration for individual
 // OCP and OKD channels
 type ReleaseChannel struct {
@@ -185,6 +300,13 @@ type Operator struct {
 	// path on disk for a template to use to complete catalogSource custom resource
 	// generated by oc-mirror
 	TargetCatalogSourceTemplate string `json:"targetCatalogSourceTemplate,omitempty"`
+	// path on disk for a template to use to complete clusterCatalog custom resource
+	// generated by oc-mirror (i.e. priority, pollIntervalMinutes, labels)
+	TargetClusterCatalogTemplate string `json:"targetClusterCatalogTemplate,omitempty"`
+	// CatalogSourceName is the name of the catalogSource and clusterCatalog custom resources
+	// generated by oc-mirror for this catalog. If unset, the name is derived from the
+	// repository and the tag (or digest) of the catalog, and changes with them.
+	CatalogSourceName string `json:"catalogSourceName,omitempty"`
 }
 
 // GetUniqueName determines the catalog name that will
@@ -2,24 +2,78 @@ package archive
 
 import (
 	"archive/tar"
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
+	"strings"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
 )
 
-func addFileToWriter(fi fs.FileInfo, pathToFile, pathInTar string, tarWriter *tar.Writer) error {
+// archiveEntry is a file to add to the archive: a file of the disk (working-dir, image set configuration),
+// or a file of the cache, read through its storage driver
+type archiveEntry struct {
+	header *tar.Header
+	// source identifies the file in the logs
+	source string
+	open   func() (io.ReadCloser, error)
+}
+
+// diskEntry is the archive entry of the file pathToFile of the disk, stored at pathInTar
+func diskEntry(fi fs.FileInfo, pathToFile, pathInTar string) (archiveEntry, error) {
 	header, err := tar.FileInfoHeader(fi, fi.Name())
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return archiveEntry{}, fmt.Errorf("%w", err)
 	}
 	header.Name = pathInTar
+	return archiveEntry{
+		header: header,
+		source: pathToFile,
+		open: func() (io.ReadCloser, error) {
+			// nolint: wrapcheck
+			return os.Open(pathToFile)
+		},
+	}, nil
+}
+
+// cacheEntry is the archive entry of the file fi of the cache: its path in the storage driver
+// (/docker/registry/v2/...) is its path in the archive
+func cacheEntry(ctx context.Context, cacheStorage driver.StorageDriver, fi driver.FileInfo) archiveEntry {
+	return archiveEntry{
+		header: &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     strings.TrimPrefix(fi.Path(), "/"),
+			Size:     fi.Size(),
+			Mode:     0644,
+			ModTime:  fi.ModTime(),
+		},
+		source: fi.Path(),
+		open: func() (io.ReadCloser, error) {
+			// nolint: wrapcheck
+			return cacheStorage.Reader(ctx, fi.Path(), 0)
+		},
+	}
+}
+
+// walkCacheFolder calls add for each file found under folder in the storage driver of the cache
+func walkCacheFolder(ctx context.Context, cacheStorage driver.StorageDriver, folder string, add func(archiveEntry) error) error {
+	// nolint: wrapcheck
+	return cacheStorage.Walk(ctx, folder, func(fi driver.FileInfo) error {
+		if fi.IsDir() { // skip directories
+			return nil
+		}
+		return add(cacheEntry(ctx, cacheStorage, fi))
+	})
+}
 
-	if err := tarWriter.WriteHeader(header); err != nil {
+func addEntryToWriter(entry archiveEntry, tarWriter *tar.Writer) error {
+	if err := tarWriter.WriteHeader(entry.header); err != nil {
 		return fmt.Errorf("%w", err)
 	}
 	// Open the file for reading
-	file, err := os.Open(pathToFile)
+	file, err := entry.open()
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
@@ -4,32 +4,40 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
+	"io"
+	"path"
 	"path/filepath"
+	"sort"
 	"time"
 
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/google/uuid"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/cache"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/config"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/history"
 	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
 	digest "github.com/opencontainers/go-digest"
 )
 
 const (
-	archiveFilePrefix           = "mirror"
-	imageSetConfigPrefix        = "isc_"
-	cacheRepositoriesDir        = "docker/registry/v2/repositories"
-	cacheBlobsDir               = "docker/registry/v2/blobs"
-	cacheFilePrefix             = "docker/registry/v2"
-	workingDirectory            = "working-dir"
-	errMessageFolder            = "unable to create folder %s: %w"
-	segMultiplier         int64 = 1024 * 1024 * 1024
-	defaultSegSize        int64 = 500
-	archiveFileNameFormat       = "%s_%06d.tar"
+	archiveFilePrefix          = "mirror"
+	imageSetConfigPrefix       = "isc_"
+	cacheRepositoriesDir       = "docker/registry/v2/repositories"
+	cacheBlobsDir              = "docker/registry/v2/blobs"
+	cacheFilePrefix            = "docker/registry/v2"
+	cacheUploadsDir            = "docker/registry/v2/oc-mirror-uploads"
+	workingDirectory           = "working-dir"
+	errMessageFolder           = "unable to create folder %s: %w"
+	segMultiplier        int64 = 1024 * 1024 * 1024
+	defaultSegSize       int64 = 500
+	chunkBoundaryFormat        = ".oc-mirror/chunk_%06d"
 )
 
 type BlobsGatherer interface {
 	GatherBlobs(ctx context.Context, imgRef string) (map[string]string, error)
+	GatherImageBlobs(ctx context.Context, imgRef string) (ImageBlobs, error)
 }
 
 type Archiver interface {
@@ -43,7 +51,9 @@ type UnArchiver interface {
 type archiveAdder interface {
 	addFile(pathToFile string, pathInTar string) error
 	addAllFolder(folderToAdd string, relativeTo string) error
+	addCacheFolder(ctx context.Context, cacheStorage driver.StorageDriver, folderToAdd string) error
 	close() error
+	chunks() []string
 }
 
 type MirrorArchive struct {
@@ -52,42 +62,115 @@ type MirrorArchive struct {
 	destination  string
 	iscPath      string
 	workingDir   string
-	cacheDir     string
+	cacheStorage driver.StorageDriver
 	history      history.History
 	blobGatherer BlobsGatherer
+	log          clog.PluggableLoggerInterface
+	// blob inventory of the disconnected side, used instead of the history metadata when set
+	baselineInventory string
+	// generation of the archive being built, noGeneration for stream archives
+	generation int
+	// number of archive generations to keep in destination, 0 to keep them all
+	keepGenerations int
+	// identifies the run in the history metadata
+	runID string
+	mode  string
+	// history files falling out of this policy are compacted once the history is updated
+	historyRetention history.Retention
 }
 
 // NewMirrorArchive creates a new MirrorArchive instance with permissiveAdder:
 // any files that exceed the maxArchiveSize specified in the imageSetConfig will
-// be added to standalone archives, and flagged in a warning at the end of the execution
+// be added to standalone archives, and flagged in a warning at the end of the execution.
+// Each run creates a new generation of chunks in the destination: see opts.KeepArchiveGenerations.
+// Chunks are encrypted when opts.ArchiveRecipients is set
 func NewPermissiveMirrorArchive(opts *common.MirrorOptions, log clog.PluggableLoggerInterface, maxSize int64) (*MirrorArchive, error) {
+	if maxSize == 0 {
+		maxSize = defaultSegSize
+	}
+	maxSize *= segMultiplier
 
-	// create the history interface
-	history, err := history.NewHistory(opts.WorkingDir, opts.Since, log, history.OSFileCreator{})
+	encryption, err := NewArchiveEncryption(opts.ArchiveRecipients)
+	if err != nil {
+		return &MirrorArchive{}, err
+	}
+	generation, err := nextGeneration(opts.Destination)
+	if err != nil {
+		return &MirrorArchive{}, err
+	}
+	a, err := newPermissiveAdder(maxSize, opts.Destination, generation, encryption, log)
 	if err != nil {
 		return &MirrorArchive{}, fmt.Errorf("%w", err)
 	}
+	ma, err := newMirrorArchive(opts, log, a)
+	if err != nil {
+		return &MirrorArchive{}, err
+	}
+	ma.generation = generation
+	ma.keepGenerations = opts.KeepArchiveGenerations
+	return ma, nil
+}
 
-	bg := NewImageBlobGatherer(opts)
-
+// NewStreamMirrorArchive creates a new MirrorArchive instance with streamAdder:
+// the archive is written as one continuous tar stream to `writer`, with chunk
+// boundary markers inserted every maxSize GB
+func NewStreamMirrorArchive(opts *common.MirrorOptions, log clog.PluggableLoggerInterface, maxSize int64, writer io.Writer) (*MirrorArchive, error) {
 	if maxSize == 0 {
 		maxSize = defaultSegSize
 	}
 	maxSize *= segMultiplier
 
-	a, err := newPermissiveAdder(maxSize, opts.Destination, log)
+	encryption, err := NewArchiveEncryption(opts.ArchiveRecipients)
+	if err != nil {
+		return &MirrorArchive{}, err
+	}
+	a, err := newStreamAdder(maxSize, writer, encryption, log)
 	if err != nil {
 		return &MirrorArchive{}, fmt.Errorf("%w", err)
 	}
+	ma, err := newMirrorArchive(opts, log, a)
+	if err != nil {
+		return &MirrorArchive{}, err
+	}
+	ma.generation = noGeneration
+	return ma, nil
+}
+
+func newMirrorArchive(opts *common.MirrorOptions, log clog.PluggableLoggerInterface, adder archiveAdder) (*MirrorArchive, error) {
+	// create the history interface
+	h, err := history.NewHistory(opts.WorkingDir, opts.Since, log, history.OSFileCreator{})
+	if err != nil {
+		return &MirrorArchive{}, fmt.Errorf("%w", err)
+	}
+
+	bg := NewImageBlobGatherer(opts)
+
+	storageConfig, err := cache.NewStorageConfig(opts.CacheDriver, opts.CacheDir, opts.CacheDriverParams)
+	if err != nil {
+		return &MirrorArchive{}, err
+	}
+	cacheStorage, err := storageConfig.Open(context.Background())
+	if err != nil {
+		return &MirrorArchive{}, err
+	}
 
 	ma := MirrorArchive{
 		destination:  opts.Destination,
-		history:      history,
+		history:      h,
 		blobGatherer: bg,
 		workingDir:   opts.WorkingDir,
-		cacheDir:     opts.CacheDir,
+		cacheStorage: cacheStorage,
 		iscPath:      opts.ConfigPath,
-		adder:        a,
+		adder:        adder,
+		log:          log,
+
+		baselineInventory: opts.BaselineInventory,
+		runID:             opts.UUID.String(),
+		mode:              opts.Mode,
+		historyRetention:  history.Retention{Keep: opts.HistoryKeep, Since: opts.HistoryKeepSince},
+	}
+	if opts.UUID == uuid.Nil {
+		ma.runID = uuid.NewString()
 	}
 	return &ma, nil
 }
@@ -97,12 +180,39 @@ func NewPermissiveMirrorArchive(opts *common.MirrorOptions, log clog.PluggableLo
 // * docker/v2/blobs/sha256 : blobs that haven't been mirrored (diff)
 // * working-dir
 // * image set config
+// Once all chunks are written, the generation they belong to becomes the latest one
+// in the destination, and past generations are pruned according to keepGenerations.
 func (o *MirrorArchive) BuildArchive(ctx context.Context, collectedImages []v2alpha1.CopyImageSchema) error {
+	err := o.buildChunks(ctx, collectedImages)
+	if err != nil {
+		return err
+	}
+	if o.generation == noGeneration {
+		return nil
+	}
+	err = writeLatestGeneration(o.destination, o.generation)
+	if err != nil {
+		return fmt.Errorf("unable to update the latest archive generation : %w", err)
+	}
+	removed, err := removePastGenerations(o.destination, o.keepGenerations)
+	if err != nil {
+		return fmt.Errorf("unable to remove past archive generations : %w", err)
+	}
+	for _, chunk := range removed {
+		o.log.Debug("removed archive chunk of a past generation %s", chunk)
+	}
+	return nil
+}
+
+func (o *MirrorArchive) buildChunks(ctx context.Context, collectedImages []v2alpha1.CopyImageSchema) (retErr error) {
 	// 0 - make sure that any tarWriters or files opened by the adder are closed as we leave this method
-	defer o.adder.close()
+	defer func() {
+		if err := o.adder.close(); err != nil && retErr == nil {
+			retErr = fmt.Errorf("unable to close the archive : %w", err)
+		}
+	}()
 	// 1 - Add files and directories under the cache's docker/v2/repositories to the archive
-	repositoriesDir := filepath.Join(o.cacheDir, cacheRepositoriesDir)
-	err := o.adder.addAllFolder(repositoriesDir, o.cacheDir)
+	err := o.adder.addCacheFolder(ctx, o.cacheStorage, "/"+cacheRepositoriesDir)
 	if err != nil {
 		return fmt.Errorf("unable to add cache repositories to the archive : %w", err)
 	}
@@ -118,34 +228,87 @@ func (o *MirrorArchive) BuildArchive(ctx context.Context, collectedImages []v2al
 		return fmt.Errorf("unable to add image set configuration to the archive : %w", err)
 	}
 	// 4 - Add blobs
-	blobsInHistory, err := o.history.Read()
-	if err != nil && !errors.Is(err, &history.EmptyHistoryError{}) {
-		return fmt.Errorf("unable to read history metadata from working-dir : %w", err)
+	blobsInHistory, err := o.readBaseline()
+	if err != nil {
+		return err
 	}
-	// ignoring the error otherwise: continuing with an empty map in blobsInHistory
 
-	addedBlobs, err := o.addImagesDiff(ctx, collectedImages, blobsInHistory, o.cacheDir)
+	record, err := o.newHistoryRecord()
+	if err != nil {
+		return err
+	}
+	addedBlobs, err := o.addImagesDiff(ctx, collectedImages, blobsInHistory, &record)
 	if err != nil {
 		return fmt.Errorf("unable to add image blobs to the archive : %w", err)
 	}
-	// 5 - update history file with addedBlobs
-	_, err = o.history.Append(addedBlobs)
+	// 5 - update history file with the record of this run
+	for blob := range addedBlobs {
+		record.Blobs = append(record.Blobs, blob)
+	}
+	sort.Strings(record.Blobs)
+	record.Chunks = append(record.Chunks, o.adder.chunks()...)
+	_, err = o.history.Append(record)
 	if err != nil {
 		return fmt.Errorf("unable to update history metadata: %w", err)
 	}
+	// 6 - compact the history files falling out of the retention policy
+	compacted, err := o.history.Compact(o.historyRetention)
+	if err != nil {
+		return fmt.Errorf("unable to compact history metadata: %w", err)
+	}
+	if len(compacted) > 0 {
+		o.log.Debug("compacted the history of %d runs into a baseline", len(compacted))
+	}
 
 	return nil
 }
 
-func (o *MirrorArchive) addImagesDiff(ctx context.Context, collectedImages []v2alpha1.CopyImageSchema, historyBlobs map[string]string, cacheDir string) (map[string]string, error) {
+// readBaseline returns the blobs already present on the disconnected side, which are not added to the archive:
+// the blobs of the baseline inventory when provided, the blobs of the history metadata otherwise
+func (o *MirrorArchive) readBaseline() (map[string]string, error) {
+	if o.baselineInventory != "" {
+		return ReadBlobInventory(o.baselineInventory)
+	}
+	blobsInHistory, err := o.history.Read()
+	if err != nil && !errors.Is(err, &history.EmptyHistoryError{}) {
+		return nil, fmt.Errorf("unable to read history metadata from working-dir : %w", err)
+	}
+	// ignoring the error otherwise: continuing with an empty map in blobsInHistory
+	return blobsInHistory, nil
+}
+
+// newHistoryRecord creates the history record of this run, identified by the digest
+// of the image set configuration it uses
+func (o *MirrorArchive) newHistoryRecord() (history.Record, error) {
+	iscDigest, err := config.Digest(o.iscPath)
+	if err != nil {
+		return history.Record{}, fmt.Errorf("unable to read image set configuration : %w", err)
+	}
+	return history.NewRecord(o.runID, iscDigest, o.mode), nil
+}
+
+// addImagesDiff adds to the archive the blobs of collectedImages missing from historyBlobs,
+// and records each image in record
+func (o *MirrorArchive) addImagesDiff(ctx context.Context, collectedImages []v2alpha1.CopyImageSchema, historyBlobs map[string]string, record *history.Record) (map[string]string, error) {
 	allAddedBlobs := map[string]string{}
 	for _, img := range collectedImages {
-		imgBlobs, err := o.blobGatherer.GatherBlobs(ctx, img.Destination)
+		imgBlobs, err := o.blobGatherer.GatherImageBlobs(ctx, img.Destination)
 		if err != nil {
 			return nil, fmt.Errorf("unable to find blobs corresponding to %s: %w", img.Destination, err)
 		}
+		imageRecord := history.ImageRecord{
+			Origin:      img.Origin,
+			Destination: img.Destination,
+			Digest:      imgBlobs.Digest,
+			Blobs:       []string{},
+		}
+		for blob := range imgBlobs.Blobs {
+			imageRecord.Blobs = append(imageRecord.Blobs, blob)
+		}
+		sort.Strings(imageRecord.Blobs)
+		record.Images = append(record.Images, imageRecord)
 
-		addedBlobs, err := o.addBlobsDiff(imgBlobs, historyBlobs, allAddedBlobs)
+		addedBlobs, err := o.addBlobsDiff(ctx, imgBlobs.Blobs, historyBlobs, allAddedBlobs)
 		if err != nil {
 			return nil, fmt.Errorf("unable to add blobs corresponding to %s: %w", img.Destination, err)
 		}
@@ -159,7 +322,7 @@ func (o *MirrorArchive) addImagesDiff(ctx context.Context, collectedImages []v2a
 	return allAddedBlobs, nil
 }
 
-func (o *MirrorArchive) addBlobsDiff(collectedBlobs, historyBlobs map[string]string, alreadyAddedBlobs map[string]string) (map[string]string, error) {
+func (o *MirrorArchive) addBlobsDiff(ctx context.Context, collectedBlobs, historyBlobs map[string]string, alreadyAddedBlobs map[string]string) (map[string]string, error) {
 	blobsInDiff := map[string]string{}
 	for hash := range collectedBlobs {
 		_, alreadyMirrored := historyBlobs[hash]
@@ -171,8 +334,8 @@ func (o *MirrorArchive) addBlobsDiff(collectedBlobs, historyBlobs map[string]str
 			if err != nil {
 				return nil, fmt.Errorf("%w", err)
 			}
-			blobPath := filepath.Join(o.cacheDir, cacheBlobsDir, d.Algorithm().String(), d.Encoded()[:2], d.Encoded())
-			err = o.adder.addAllFolder(blobPath, o.cacheDir)
+			blobPath := path.Join("/", cacheBlobsDir, d.Algorithm().String(), d.Encoded()[:2], d.Encoded())
+			err = o.adder.addCacheFolder(ctx, o.cacheStorage, blobPath)
 			if err != nil {
 				return nil, fmt.Errorf("%w", err)
 			}
@@ -181,21 +344,3 @@ func (o *MirrorArchive) addBlobsDiff(collectedBlobs, historyBlobs map[string]str
 	}
 	return blobsInDiff, nil
 }
-
-// nolint: unused
-func removePastArchives(destination string) error {
-	_, err := os.Stat(destination)
-	if err == nil {
-		files, err := filepath.Glob(filepath.Join(destination, "mirror_*.tar"))
-		if err != nil {
-			return fmt.Errorf("%w", err)
-		}
-		for _, file := range files {
-			err := os.Remove(file)
-			if err != nil {
-				return fmt.Errorf("%w", err)
-			}
-		}
-	}
-	return nil
-}
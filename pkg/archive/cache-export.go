@@ -0,0 +1,215 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	digest "github.com/opencontainers/go-digest"
+)
+
+const cacheExportIndexFile = "oc-mirror-cache-export.json"
+
+// CacheExportImage is an image of the cache to export
+type CacheExportImage struct {
+	Repository string `json:"repository"`
+	// Digest is the digest of the manifest (or manifest list) of the image
+	Digest string `json:"digest"`
+	// Tags are the tags of the repository pointing to Digest, found while exporting
+	Tags []string `json:"tags,omitempty"`
+	// Blobs is the closure of the blobs of the image, manifests included (see ImageBlobGatherer.GatherImageBlobs)
+	Blobs map[string]string `json:"-"`
+}
+
+// CacheExportIndex is the first entry of a cache export: it describes its content
+type CacheExportIndex struct {
+	Images []CacheExportImage `json:"images"`
+	Blobs  int                `json:"blobs"`
+	Size   int64              `json:"size"`
+}
+
+// CacheImportReport summarizes the import of a cache export
+type CacheImportReport struct {
+	CacheExportIndex
+	// ImportedBlobs are the blobs written to the cache, the other ones were already present
+	ImportedBlobs int   `json:"importedBlobs"`
+	ImportedSize  int64 `json:"importedSize"`
+}
+
+// ExportCache writes to w a tar of images, as stored in the cache: their blobs, then the links of their
+// repositories (revisions, layers, and the tags pointing to the images), at their path in the cache.
+// The blobs come first so that the links of an interrupted import never point to a missing blob.
+func ExportCache(ctx context.Context, cacheStorage driver.StorageDriver, images []CacheExportImage, w io.Writer) (CacheExportIndex, error) {
+	index := CacheExportIndex{Images: images}
+	entries := []archiveEntry{}
+	blobs := map[string]bool{}
+	for _, image := range images {
+		for blob := range image.Blobs {
+			if blobs[blob] {
+				continue
+			}
+			blobs[blob] = true
+			if err := digest.Digest(blob).Validate(); err != nil {
+				return CacheExportIndex{}, fmt.Errorf("invalid blob %s of %s@%s: %w", blob, image.Repository, image.Digest, err)
+			}
+			fi, err := cacheStorage.Stat(ctx, cacheBlobPath(digest.Digest(blob)))
+			if err != nil {
+				return CacheExportIndex{}, fmt.Errorf("blob %s of %s@%s is missing from the cache: %w", blob, image.Repository, image.Digest, err)
+			}
+			entries = append(entries, cacheEntry(ctx, cacheStorage, fi))
+			index.Size += fi.Size()
+		}
+	}
+	index.Blobs = len(blobs)
+	// the blobs of an image are in no particular order: sort them to make exports reproducible
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].header.Name < entries[j].header.Name
+	})
+
+	links := map[string]bool{}
+	for i := range index.Images {
+		repositoryLinks, tags, err := repositoryLinksOf(ctx, cacheStorage, index.Images[i])
+		if err != nil {
+			return CacheExportIndex{}, err
+		}
+		index.Images[i].Tags = tags
+		for _, link := range repositoryLinks {
+			if !links[link.Path()] {
+				links[link.Path()] = true
+				entries = append(entries, cacheEntry(ctx, cacheStorage, link))
+			}
+		}
+	}
+
+	tarWriter := tar.NewWriter(w)
+	content, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return CacheExportIndex{}, fmt.Errorf("%w", err)
+	}
+	if err := tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: cacheExportIndexFile, Size: int64(len(content)), Mode: 0644}); err != nil {
+		return CacheExportIndex{}, fmt.Errorf("%w", err)
+	}
+	if _, err := tarWriter.Write(content); err != nil {
+		return CacheExportIndex{}, fmt.Errorf("%w", err)
+	}
+	for _, entry := range entries {
+		if err := addEntryToWriter(entry, tarWriter); err != nil {
+			return CacheExportIndex{}, fmt.Errorf("unable to export %s: %w", entry.source, err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return CacheExportIndex{}, fmt.Errorf("%w", err)
+	}
+	return index, nil
+}
+
+// repositoryLinksOf returns the link files of the repository of image referencing its blobs,
+// and the tags pointing to it
+func repositoryLinksOf(ctx context.Context, cacheStorage driver.StorageDriver, image CacheExportImage) ([]driver.FileInfo, []string, error) {
+	repositoryDir := path.Join("/", cacheRepositoriesDir, image.Repository)
+	links := []driver.FileInfo{}
+	tags := []string{}
+	err := cacheStorage.Walk(ctx, repositoryDir, func(fi driver.FileInfo) error {
+		if fi.IsDir() || path.Base(fi.Path()) != "link" {
+			return nil
+		}
+		// the files of the nested repositories start with their own name
+		parts := strings.Split(strings.TrimPrefix(fi.Path(), repositoryDir+"/"), "/")
+		switch {
+		// _layers/<algorithm>/<hex>/link and _manifests/revisions/<algorithm>/<hex>/link
+		case len(parts) == 4 && parts[0] == "_layers",
+			len(parts) == 5 && parts[0] == "_manifests" && parts[1] == "revisions":
+			blob := parts[len(parts)-3] + ":" + parts[len(parts)-2]
+			if _, ok := image.Blobs[blob]; ok {
+				links = append(links, fi)
+			}
+		// _manifests/tags/<tag>/current/link
+		case len(parts) == 5 && parts[0] == "_manifests" && parts[1] == "tags" && parts[3] == "current":
+			current, err := cacheStorage.GetContent(ctx, fi.Path())
+			if err != nil {
+				return fmt.Errorf("%w", err)
+			}
+			if strings.TrimSpace(string(current)) == image.Digest {
+				links = append(links, fi)
+				tags = append(tags, parts[2])
+			}
+		// _manifests/tags/<tag>/index/<algorithm>/<hex>/link
+		case len(parts) == 7 && parts[0] == "_manifests" && parts[1] == "tags" && parts[3] == "index":
+			if parts[4]+":"+parts[5] == image.Digest {
+				links = append(links, fi)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to export repository %s: %w", image.Repository, err)
+	}
+	slices.Sort(tags)
+	return links, tags, nil
+}
+
+// ImportCache loads a cache export (see ExportCache) into the cache. The blobs already present are skipped,
+// the others are verified against their digest. The tags of the export are moved to the exported images.
+func ImportCache(ctx context.Context, cacheStorage driver.StorageDriver, r io.Reader) (CacheImportReport, error) {
+	tarReader := tar.NewReader(r)
+	header, err := tarReader.Next()
+	if err != nil {
+		return CacheImportReport{}, fmt.Errorf("unable to read the cache export: %w", err)
+	}
+	if header.Name != cacheExportIndexFile {
+		return CacheImportReport{}, fmt.Errorf("not a cache export: %s is missing", cacheExportIndexFile)
+	}
+	report := CacheImportReport{}
+	if err := json.NewDecoder(tarReader).Decode(&report.CacheExportIndex); err != nil {
+		return CacheImportReport{}, fmt.Errorf("invalid cache export index: %w", err)
+	}
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return CacheImportReport{}, fmt.Errorf("unable to read the cache export: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if path.Clean(header.Name) != header.Name || !strings.HasPrefix(header.Name, cacheFilePrefix+"/") {
+			return CacheImportReport{}, fmt.Errorf("unexpected entry %s in the cache export", header.Name)
+		}
+		var expected digest.Digest
+		if strings.HasPrefix(header.Name, cacheBlobsDir+"/") {
+			// docker/registry/v2/blobs/<algorithm>/<hex[:2]>/<hex>/data
+			parts := strings.Split(strings.TrimPrefix(header.Name, cacheBlobsDir+"/"), "/")
+			if len(parts) != 4 || parts[3] != blobDataFileName {
+				return CacheImportReport{}, fmt.Errorf("unexpected entry %s in the cache export", header.Name)
+			}
+			expected = digest.NewDigestFromEncoded(digest.Algorithm(parts[0]), parts[2])
+			if err := expected.Validate(); err != nil {
+				return CacheImportReport{}, fmt.Errorf("unexpected entry %s in the cache export: %w", header.Name, err)
+			}
+			if _, err := cacheStorage.Stat(ctx, "/"+header.Name); err == nil {
+				continue
+			}
+			report.ImportedBlobs++
+			report.ImportedSize += header.Size
+		}
+		if err := writeCacheFile(cacheStorage, header.Name, tarReader, expected); err != nil {
+			return CacheImportReport{}, err
+		}
+	}
+	return report, nil
+}
+
+// cacheBlobPath is the path of the data of blob in the storage driver of the cache
+func cacheBlobPath(blob digest.Digest) string {
+	return path.Join("/", cacheBlobsDir, blob.Algorithm().String(), blob.Encoded()[:2], blob.Encoded(), blobDataFileName)
+}
@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"path"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheExportImport(t *testing.T) {
+	ctx := context.Background()
+	source := newTestCacheStorage(t, t.TempDir())
+	manifest := putTestCacheContent(t, source, "manifest")
+	config := putTestCacheContent(t, source, "config")
+	layer := putTestCacheContent(t, source, "layer")
+	other := putTestCacheContent(t, source, "other manifest")
+
+	repositoryDir := path.Join("/", cacheRepositoriesDir, "ns/img")
+	files := map[string]string{
+		path.Join(repositoryDir, "_manifests/revisions", manifest.Algorithm().String(), manifest.Encoded(), "link"):     manifest.String(),
+		path.Join(repositoryDir, "_layers", config.Algorithm().String(), config.Encoded(), "link"):                      config.String(),
+		path.Join(repositoryDir, "_layers", layer.Algorithm().String(), layer.Encoded(), "link"):                        layer.String(),
+		path.Join(repositoryDir, "_manifests/tags/v1/current/link"):                                                     manifest.String(),
+		path.Join(repositoryDir, "_manifests/tags/v1/index", manifest.Algorithm().String(), manifest.Encoded(), "link"): manifest.String(),
+	}
+	excluded := map[string]string{
+		// another image of the repository
+		path.Join(repositoryDir, "_manifests/revisions", other.Algorithm().String(), other.Encoded(), "link"): other.String(),
+		path.Join(repositoryDir, "_manifests/tags/v2/current/link"):                                           other.String(),
+		// a nested repository
+		path.Join(repositoryDir, "sub/_layers", layer.Algorithm().String(), layer.Encoded(), "link"): layer.String(),
+	}
+	for _, content := range []map[string]string{files, excluded} {
+		for file, link := range content {
+			require.NoError(t, source.PutContent(ctx, file, []byte(link)))
+		}
+	}
+
+	image := CacheExportImage{
+		Repository: "ns/img",
+		Digest:     manifest.String(),
+		Blobs:      map[string]string{manifest.String(): "", config.String(): "", layer.String(): ""},
+	}
+	export := &bytes.Buffer{}
+	index, err := ExportCache(ctx, source, []CacheExportImage{image}, export)
+	require.NoError(t, err)
+	require.Equal(t, 3, index.Blobs)
+	require.Equal(t, []string{"v1"}, index.Images[0].Tags)
+
+	// the blobs come before the links
+	tarReader := tar.NewReader(bytes.NewReader(export.Bytes()))
+	names := []string{}
+	for header, err := tarReader.Next(); err == nil; header, err = tarReader.Next() {
+		names = append(names, header.Name)
+	}
+	require.Len(t, names, 1+3+len(files))
+	require.Equal(t, cacheExportIndexFile, names[0])
+	for _, name := range names[1:4] {
+		require.Contains(t, name, cacheBlobsDir)
+	}
+
+	target := inmemory.New()
+	report, err := ImportCache(ctx, target, bytes.NewReader(export.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, 3, report.ImportedBlobs)
+	require.Equal(t, index.Blobs, report.Blobs)
+	require.Equal(t, index.Size, report.Size)
+	require.Equal(t, []string{"v1"}, report.Images[0].Tags)
+	for file, link := range files {
+		content, err := target.GetContent(ctx, file)
+		require.NoError(t, err)
+		require.Equal(t, link, string(content))
+	}
+	for file := range excluded {
+		_, err := target.Stat(ctx, file)
+		require.ErrorAs(t, err, &driver.PathNotFoundError{})
+	}
+	content, err := target.GetContent(ctx, cacheBlobPath(layer))
+	require.NoError(t, err)
+	require.Equal(t, "layer", string(content))
+
+	// the blobs already present are skipped
+	report, err = ImportCache(ctx, target, bytes.NewReader(export.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, 0, report.ImportedBlobs)
+}
+
+func TestCacheImportRejectsInvalidContent(t *testing.T) {
+	ctx := context.Background()
+	layer := digest.FromString("layer")
+	type testCase struct {
+		caseName      string
+		name          string
+		content       string
+		expectedError string
+	}
+	testCases := []testCase{
+		{
+			caseName:      "blob not matching its digest",
+			name:          cacheBlobPath(layer)[1:],
+			content:       "tampered",
+			expectedError: "doesn't match its digest",
+		},
+		{
+			caseName:      "entry outside of the cache",
+			name:          "working-dir/.history/history",
+			expectedError: "unexpected entry",
+		},
+		{
+			caseName:      "entry escaping the cache",
+			name:          cacheFilePrefix + "/../../../etc/passwd",
+			expectedError: "unexpected entry",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			export := &bytes.Buffer{}
+			tarWriter := tar.NewWriter(export)
+			for _, entry := range []struct{ name, content string }{{cacheExportIndexFile, "{}"}, {testCase.name, testCase.content}} {
+				require.NoError(t, tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: entry.name, Size: int64(len(entry.content)), Mode: 0644}))
+				_, err := tarWriter.Write([]byte(entry.content))
+				require.NoError(t, err)
+			}
+			require.NoError(t, tarWriter.Close())
+
+			target := inmemory.New()
+			_, err := ImportCache(ctx, target, export)
+			require.ErrorContains(t, err, testCase.expectedError)
+			_, err = target.Stat(ctx, cacheBlobPath(layer))
+			require.ErrorAs(t, err, &driver.PathNotFoundError{})
+		})
+	}
+}
+
+func putTestCacheContent(t *testing.T, cacheStorage driver.StorageDriver, content string) digest.Digest {
+	blob := digest.FromString(content)
+	require.NoError(t, cacheStorage.PutContent(context.Background(), cacheBlobPath(blob), []byte(content)))
+	return blob
+}
@@ -0,0 +1,239 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+)
+
+const (
+	ageRecipientPrefix   = "age1"
+	ageIdentityPrefix    = "AGE-SECRET-KEY-"
+	ageHeaderPrefix      = "age-encryption.org/"
+	pgpArmorHeaderPrefix = "-----BEGIN PGP"
+	ageChunkExtension    = ".age"
+	pgpChunkExtension    = ".gpg"
+)
+
+// ArchiveEncryption encrypts archive chunks to a set of recipients.
+// All recipients are either age recipients or OpenPGP public keys: both kinds
+// can't be mixed, since each chunk is encrypted once, with a single tool.
+type ArchiveEncryption struct {
+	ageRecipients []age.Recipient
+	pgpRecipients openpgp.EntityList
+}
+
+// ArchiveDecryption decrypts archive chunks using the identities (age identities
+// or OpenPGP private keys) provided by the user.
+type ArchiveDecryption struct {
+	ageIdentities []age.Identity
+	pgpKeyRing    openpgp.EntityList
+}
+
+// NewArchiveEncryption parses the recipients the archive should be encrypted to.
+// Each recipient is either an age public key (age1...), or the path to a file containing
+// age recipients (one per line) or an OpenPGP public key (armored or binary).
+// It returns nil when no recipient is given: the archive is then left in clear.
+func NewArchiveEncryption(recipients []string) (*ArchiveEncryption, error) {
+	if len(recipients) == 0 {
+		return nil, nil
+	}
+	e := &ArchiveEncryption{}
+	for _, recipient := range recipients {
+		if strings.HasPrefix(recipient, ageRecipientPrefix) {
+			r, err := age.ParseX25519Recipient(recipient)
+			if err != nil {
+				return nil, fmt.Errorf("invalid age recipient %s : %w", recipient, err)
+			}
+			e.ageRecipients = append(e.ageRecipients, r)
+			continue
+		}
+		data, err := os.ReadFile(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read archive recipient %s : %w", recipient, err)
+		}
+		if bytes.Contains(data, []byte(ageRecipientPrefix)) && !bytes.Contains(data, []byte(pgpArmorHeaderPrefix)) {
+			r, err := age.ParseRecipients(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("invalid age recipients file %s : %w", recipient, err)
+			}
+			e.ageRecipients = append(e.ageRecipients, r...)
+			continue
+		}
+		keys, err := readPGPKeyRing(data)
+		if err != nil {
+			return nil, fmt.Errorf("archive recipient %s is neither an age recipient nor an OpenPGP public key : %w", recipient, err)
+		}
+		e.pgpRecipients = append(e.pgpRecipients, keys...)
+	}
+	if len(e.ageRecipients) > 0 && len(e.pgpRecipients) > 0 {
+		return nil, fmt.Errorf("archive recipients must be either all age recipients or all OpenPGP public keys")
+	}
+	return e, nil
+}
+
+// NewArchiveDecryption loads the identity files used to decrypt the archive.
+// Each file contains either age identities (AGE-SECRET-KEY-...) or an OpenPGP private key
+// (armored or binary). It returns nil when no identity is given.
+func NewArchiveDecryption(identities []string) (*ArchiveDecryption, error) {
+	if len(identities) == 0 {
+		return nil, nil
+	}
+	d := &ArchiveDecryption{}
+	for _, identity := range identities {
+		data, err := os.ReadFile(identity)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read archive identity %s : %w", identity, err)
+		}
+		if bytes.Contains(data, []byte(ageIdentityPrefix)) {
+			ids, err := age.ParseIdentities(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("invalid age identity file %s : %w", identity, err)
+			}
+			d.ageIdentities = append(d.ageIdentities, ids...)
+			continue
+		}
+		keys, err := readPGPKeyRing(data)
+		if err != nil {
+			return nil, fmt.Errorf("archive identity %s is neither an age identity nor an OpenPGP private key : %w", identity, err)
+		}
+		d.pgpKeyRing = append(d.pgpKeyRing, keys...)
+	}
+	return d, nil
+}
+
+// extension returns the suffix added to the name of the encrypted chunks
+func (o *ArchiveEncryption) extension() string {
+	switch {
+	case o == nil:
+		return ""
+	case len(o.ageRecipients) > 0:
+		return ageChunkExtension
+	default:
+		return pgpChunkExtension
+	}
+}
+
+// encrypt wraps `writer` so that everything written is encrypted to the recipients.
+// Closing the returned WriteCloser finalizes the encryption but doesn't close `writer`.
+func (o *ArchiveEncryption) encrypt(writer io.Writer) (io.WriteCloser, error) {
+	if o == nil {
+		return nopWriteCloser{writer}, nil
+	}
+	if len(o.ageRecipients) > 0 {
+		w, err := age.Encrypt(writer, o.ageRecipients...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encrypt archive : %w", err)
+		}
+		return w, nil
+	}
+	w, err := openpgp.Encrypt(writer, o.pgpRecipients, nil, &openpgp.FileHints{IsBinary: true}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt archive : %w", err)
+	}
+	return w, nil
+}
+
+// decrypt detects whether `reader` is encrypted (age or OpenPGP) and returns a reader
+// on the clear content. Archives that are not encrypted are returned as is.
+// `source` is only used for error messages.
+func (o *ArchiveDecryption) decrypt(reader io.Reader, source string) (io.Reader, error) {
+	header, reader, err := peekHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading archive %s: %w", source, err)
+	}
+	switch {
+	case bytes.HasPrefix(header, []byte(ageHeaderPrefix)):
+		if o == nil || len(o.ageIdentities) == 0 {
+			return nil, fmt.Errorf("archive %s is encrypted with age: use --archive-identity to provide the age identity to decrypt it", source)
+		}
+		r, err := age.Decrypt(reader, o.ageIdentities...)
+		if err != nil {
+			var noMatch *age.NoIdentityMatchError
+			if errors.As(err, &noMatch) {
+				return nil, fmt.Errorf("unable to decrypt archive %s: none of the age identities provided matches the archive recipients", source)
+			}
+			return nil, fmt.Errorf("unable to decrypt archive %s: %w", source, err)
+		}
+		return r, nil
+	case len(header) > 0 && header[0]&0x80 != 0:
+		// a tar archive starts with a file name, while the first byte of an OpenPGP
+		// message is a packet tag, which always has its most significant bit set
+		if o == nil || len(o.pgpKeyRing) == 0 {
+			return nil, fmt.Errorf("archive %s is encrypted with OpenPGP: use --archive-identity to provide the private key to decrypt it", source)
+		}
+		md, err := openpgp.ReadMessage(reader, o.pgpKeyRing, nil, nil)
+		if err != nil {
+			if errors.Is(err, pgperrors.ErrKeyIncorrect) {
+				return nil, fmt.Errorf("unable to decrypt archive %s: none of the OpenPGP private keys provided matches the archive recipients", source)
+			}
+			return nil, fmt.Errorf("unable to decrypt archive %s: %w", source, err)
+		}
+		return md.UnverifiedBody, nil
+	default:
+		return reader, nil
+	}
+}
+
+// peekHeader returns the first bytes of `reader`, and a reader on its whole content.
+// A seekable reader (a chunk file) is rewound and returned as is, rather than buffered,
+// so that tar can seek over the content of the files it skips.
+func peekHeader(reader io.Reader) ([]byte, io.Reader, error) {
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok {
+		br := bufio.NewReader(reader)
+		header, err := br.Peek(len(ageHeaderPrefix))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, nil, err
+		}
+		return header, br, nil
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := make([]byte, len(ageHeaderPrefix))
+	n, err := io.ReadFull(seeker, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, nil, err
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	return header[:n], seeker, nil
+}
+
+// drain reads what is left of a reader returned by decrypt, once the tar it contains is extracted:
+// the end of an encrypted archive (the final age chunk, the OpenPGP modification detection code)
+// is only authenticated when it is read, so a truncated or tampered archive is only detected here.
+func drain(reader io.Reader, source string) error {
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("unable to decrypt archive %s: %w", source, err)
+	}
+	return nil
+}
+
+func readPGPKeyRing(data []byte) (openpgp.EntityList, error) {
+	if bytes.Contains(data, []byte(pgpArmorHeaderPrefix)) {
+		// nolint: wrapcheck
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+	// nolint: wrapcheck
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
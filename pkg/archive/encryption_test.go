@@ -0,0 +1,197 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedArchiveRoundTrip(t *testing.T) {
+	type testCase struct {
+		caseName      string
+		recipient     string
+		identity      string
+		expectedChunk string
+		expectedError string
+	}
+
+	keysDir := t.TempDir()
+	ageRecipient, ageIdentity := generateAgeKey(t, keysDir, "age")
+	_, otherAgeIdentity := generateAgeKey(t, keysDir, "other-age")
+	pgpPublicKey, pgpPrivateKey := generatePGPKey(t, keysDir, "pgp")
+	_, otherPGPPrivateKey := generatePGPKey(t, keysDir, "other-pgp")
+
+	testCases := []testCase{
+		{
+			caseName:      "age recipient and matching identity should succeed",
+			recipient:     ageRecipient,
+			identity:      ageIdentity,
+			expectedChunk: "mirror_g0001_000001.tar.age",
+		},
+		{
+			caseName:      "OpenPGP public key and matching private key should succeed",
+			recipient:     pgpPublicKey,
+			identity:      pgpPrivateKey,
+			expectedChunk: "mirror_g0001_000001.tar.gpg",
+		},
+		{
+			caseName:      "age identity not matching the recipient should fail",
+			recipient:     ageRecipient,
+			identity:      otherAgeIdentity,
+			expectedChunk: "mirror_g0001_000001.tar.age",
+			expectedError: "none of the age identities provided matches the archive recipients",
+		},
+		{
+			caseName:      "OpenPGP private key not matching the recipient should fail",
+			recipient:     pgpPublicKey,
+			identity:      otherPGPPrivateKey,
+			expectedChunk: "mirror_g0001_000001.tar.gpg",
+			expectedError: "none of the OpenPGP private keys provided matches the archive recipients",
+		},
+		{
+			caseName:      "encrypted archive without identity should fail",
+			recipient:     ageRecipient,
+			expectedChunk: "mirror_g0001_000001.tar.age",
+			expectedError: "use --archive-identity",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			archiveDir := t.TempDir()
+			sourceFile := filepath.Join(t.TempDir(), "file.txt")
+			require.NoError(t, os.WriteFile(sourceFile, []byte("content"), 0600))
+
+			encryption, err := NewArchiveEncryption([]string{testCase.recipient})
+			require.NoError(t, err)
+			adder, err := newPermissiveAdder(0, archiveDir, 1, encryption, clog.New("error"))
+			require.NoError(t, err)
+			require.NoError(t, adder.addFile(sourceFile, "working-dir/file.txt"))
+			adder.close()
+			require.FileExists(t, filepath.Join(archiveDir, testCase.expectedChunk))
+
+			identities := []string{}
+			if testCase.identity != "" {
+				identities = append(identities, testCase.identity)
+			}
+			extractDir := t.TempDir()
+			extractor, err := NewArchiveExtractor(archiveDir, filepath.Join(extractDir, "working-dir"), newTestCacheStorage(t, filepath.Join(extractDir, "cache")), identities)
+			require.NoError(t, err)
+			err = extractor.Unarchive()
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			content, err := os.ReadFile(filepath.Join(extractDir, "working-dir", "file.txt"))
+			require.NoError(t, err)
+			require.Equal(t, "content", string(content))
+		})
+	}
+}
+
+func TestNewArchiveEncryptionMixedRecipients(t *testing.T) {
+	keysDir := t.TempDir()
+	ageRecipient, _ := generateAgeKey(t, keysDir, "age")
+	pgpPublicKey, _ := generatePGPKey(t, keysDir, "pgp")
+
+	_, err := NewArchiveEncryption([]string{ageRecipient, pgpPublicKey})
+	require.ErrorContains(t, err, "either all age recipients or all OpenPGP public keys")
+}
+
+func TestTamperedArchiveTail(t *testing.T) {
+	keysDir := t.TempDir()
+	pgpPublicKey, pgpPrivateKey := generatePGPKey(t, keysDir, "pgp")
+	encryption, err := NewArchiveEncryption([]string{pgpPublicKey})
+	require.NoError(t, err)
+	sourceFile := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(sourceFile, []byte("content"), 0600))
+
+	// the tar ends before the modification detection code of the OpenPGP message:
+	// it is only checked once the decrypted content is read to the end
+	archiveDir := t.TempDir()
+	adder, err := newPermissiveAdder(0, archiveDir, 1, encryption, clog.New("error"))
+	require.NoError(t, err)
+	require.NoError(t, adder.addFile(sourceFile, "working-dir/file.txt"))
+	adder.close()
+	chunkPath := filepath.Join(archiveDir, "mirror_g0001_000001.tar.gpg")
+	chunk, err := os.ReadFile(chunkPath)
+	require.NoError(t, err)
+	chunk[len(chunk)-1] ^= 0x01
+	require.NoError(t, os.WriteFile(chunkPath, chunk, 0600))
+
+	extractDir := t.TempDir()
+	extractor, err := NewArchiveExtractor(archiveDir, filepath.Join(extractDir, "working-dir"), newTestCacheStorage(t, filepath.Join(extractDir, "cache")), []string{pgpPrivateKey})
+	require.NoError(t, err)
+	require.ErrorContains(t, extractor.Unarchive(), "MDC hash mismatch")
+
+	inspector, err := NewArchiveInspector(archiveDir, []string{pgpPrivateKey})
+	require.NoError(t, err)
+	_, err = inspector.Inspect()
+	require.ErrorContains(t, err, "MDC hash mismatch")
+
+	stream := &bytes.Buffer{}
+	streamAdder, err := newStreamAdder(0, stream, encryption, clog.New("error"))
+	require.NoError(t, err)
+	require.NoError(t, streamAdder.addFile(sourceFile, "working-dir/file.txt"))
+	require.NoError(t, streamAdder.close())
+	tampered := stream.Bytes()
+	tampered[len(tampered)-1] ^= 0x01
+	streamExtractor, err := NewStreamExtractor(bytes.NewReader(tampered), filepath.Join(extractDir, "working-dir"), newTestCacheStorage(t, filepath.Join(extractDir, "cache")), []string{pgpPrivateKey})
+	require.NoError(t, err)
+	require.ErrorContains(t, streamExtractor.Unarchive(), "MDC hash mismatch")
+}
+
+func TestDecryptClearChunkIsNotBuffered(t *testing.T) {
+	chunkPath := filepath.Join(t.TempDir(), "mirror_000001.tar")
+	require.NoError(t, os.WriteFile(chunkPath, []byte("working-dir/"), 0644))
+	chunkFile, err := os.Open(chunkPath)
+	require.NoError(t, err)
+	defer chunkFile.Close()
+
+	decryption, err := NewArchiveDecryption(nil)
+	require.NoError(t, err)
+	reader, err := decryption.decrypt(chunkFile, chunkPath)
+	require.NoError(t, err)
+	// the chunk file itself is returned, rewound, so that tar can seek in it
+	require.Same(t, chunkFile, reader)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, "working-dir/", string(content))
+}
+
+func generateAgeKey(t *testing.T, dir, name string) (string, string) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	identityFile := filepath.Join(dir, name+".txt")
+	require.NoError(t, os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600))
+	return identity.Recipient().String(), identityFile
+}
+
+func generatePGPKey(t *testing.T, dir, name string) (string, string) {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	require.NoError(t, err)
+
+	publicKeyFile := filepath.Join(dir, name+".pub.asc")
+	f, err := os.Create(publicKeyFile)
+	require.NoError(t, err)
+	w, err := armor.Encode(f, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	privateKeyFile := filepath.Join(dir, name+".key")
+	f, err = os.Create(privateKeyFile)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivate(f, nil))
+	require.NoError(t, f.Close())
+	return publicKeyFile, privateKeyFile
+}
@@ -0,0 +1,218 @@
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	generationChunkFormat  = "%s_g%04d_%06d.tar"
+	generationMarkerFormat = ".oc-mirror/generation_%04d"
+	generationMarkerPrefix = ".oc-mirror/generation_"
+	latestGenerationFile   = archiveFilePrefix + "_latest"
+	// legacyGeneration is the generation of the chunks named mirror_<id>.tar,
+	// created before archive generations were introduced
+	legacyGeneration = 0
+	// noGeneration disables the generation checks when extracting (stream archives)
+	noGeneration = -1
+)
+
+var chunkNameRegexp = regexp.MustCompile("^" + archiveFilePrefix + `_(?:g([0-9]{4,})_)?([0-9]{6})\.tar(\` + ageChunkExtension + `|\` + pgpChunkExtension + ")?$")
+
+// archiveChunk is a chunk file of an archive, and the generation it belongs to
+type archiveChunk struct {
+	path       string
+	generation int
+	id         int
+}
+
+// listArchiveChunks returns all the chunks found under archivePath, whatever their generation,
+// sorted by generation and chunk id
+func listArchiveChunks(archivePath string) ([]archiveChunk, error) {
+	files, err := os.ReadDir(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	chunks := []archiveChunk{}
+	for _, file := range files {
+		match := chunkNameRegexp.FindStringSubmatch(file.Name())
+		if file.IsDir() || match == nil {
+			continue
+		}
+		generation := legacyGeneration
+		if match[1] != "" {
+			generation, err = strconv.Atoi(match[1])
+			if err != nil {
+				return nil, fmt.Errorf("%w", err)
+			}
+		}
+		id, err := strconv.Atoi(match[2])
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		chunks = append(chunks, archiveChunk{path: filepath.Join(archivePath, file.Name()), generation: generation, id: id})
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		if chunks[i].generation != chunks[j].generation {
+			return chunks[i].generation < chunks[j].generation
+		}
+		return chunks[i].id < chunks[j].id
+	})
+	return chunks, nil
+}
+
+// findArchiveChunks returns the paths of the chunks of the archive generation to extract from archivePath,
+// and that generation: the one referenced by the latest generation pointer when it exists,
+// otherwise the only generation present. Chunks of different generations are never mixed.
+func findArchiveChunks(archivePath string) ([]string, int, error) {
+	chunks, err := listArchiveChunks(archivePath)
+	if err != nil {
+		return nil, noGeneration, err
+	}
+	generation, found, err := readLatestGeneration(archivePath)
+	if err != nil {
+		return nil, noGeneration, err
+	}
+	if !found {
+		generations := generationsOf(chunks)
+		if len(generations) > 1 {
+			return nil, noGeneration, fmt.Errorf("found archive chunks of generations %v under %s, and no %s file to select one of them: refusing to mix chunks from different archive generations", generations, archivePath, latestGenerationFile)
+		}
+		generation = legacyGeneration
+		if len(generations) == 1 {
+			generation = generations[0]
+		}
+	}
+	archiveFiles := []string{}
+	for _, chunk := range chunks {
+		if chunk.generation == generation {
+			archiveFiles = append(archiveFiles, chunk.path)
+		}
+	}
+	if found && len(archiveFiles) == 0 {
+		return nil, noGeneration, fmt.Errorf("%s references generation %d, but no chunk of this generation was found under %s", latestGenerationFile, generation, archivePath)
+	}
+	return archiveFiles, generation, nil
+}
+
+// nextGeneration returns the generation number to use for a new archive under destination
+func nextGeneration(destination string) (int, error) {
+	chunks, err := listArchiveChunks(destination)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return noGeneration, err
+	}
+	latest, _, err := readLatestGeneration(destination)
+	if err != nil {
+		return noGeneration, err
+	}
+	for _, chunk := range chunks {
+		latest = max(latest, chunk.generation)
+	}
+	return latest + 1, nil
+}
+
+// readLatestGeneration reads the generation referenced by the latest generation pointer of archivePath.
+// The boolean is false when there is no pointer.
+func readLatestGeneration(archivePath string) (int, bool, error) {
+	content, err := os.ReadFile(filepath.Join(archivePath, latestGenerationFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return legacyGeneration, false, nil
+	}
+	if err != nil {
+		return noGeneration, false, fmt.Errorf("%w", err)
+	}
+	generation, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return noGeneration, false, fmt.Errorf("invalid archive generation in %s : %w", latestGenerationFile, err)
+	}
+	return generation, true, nil
+}
+
+// writeLatestGeneration points the latest generation pointer of destination to generation
+func writeLatestGeneration(destination string, generation int) error {
+	pointer := filepath.Join(destination, latestGenerationFile)
+	// write to a temporary file first, so that the pointer is never left half written
+	tmp := pointer + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(generation)+"\n"), 0644); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if err := os.Rename(tmp, pointer); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// removePastGenerations removes the chunks of all generations, except the `keep` most recent ones.
+// It returns the paths of the chunks removed.
+func removePastGenerations(destination string, keep int) ([]string, error) {
+	chunks, err := listArchiveChunks(destination)
+	if err != nil {
+		return nil, err
+	}
+	generations := generationsOf(chunks)
+	if keep <= 0 || len(generations) <= keep {
+		return nil, nil
+	}
+	oldest := generations[len(generations)-keep]
+	removed := []string{}
+	for _, chunk := range chunks {
+		if chunk.generation < oldest {
+			if err := os.Remove(chunk.path); err != nil {
+				return removed, fmt.Errorf("%w", err)
+			}
+			removed = append(removed, chunk.path)
+		}
+	}
+	return removed, nil
+}
+
+// generationsOf returns the distinct generations of chunks, sorted
+func generationsOf(chunks []archiveChunk) []int {
+	generations := []int{}
+	for _, chunk := range chunks {
+		if len(generations) == 0 || generations[len(generations)-1] != chunk.generation {
+			generations = append(generations, chunk.generation)
+		}
+	}
+	return generations
+}
+
+// writeGenerationMarker writes the empty entry identifying the generation of a chunk
+// at the beginning of that chunk
+func writeGenerationMarker(tarWriter *tar.Writer, generation int) error {
+	header := &tar.Header{
+		Name:     fmt.Sprintf(generationMarkerFormat, generation),
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     0,
+		ModTime:  time.Now(),
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("unable to write generation marker : %w", err)
+	}
+	return nil
+}
+
+// checkGenerationMarker verifies that the generation marker entry `name` matches the generation
+// being extracted
+func checkGenerationMarker(name, source string, generation int) error {
+	if generation == noGeneration {
+		return nil
+	}
+	chunkGeneration, err := strconv.Atoi(strings.TrimPrefix(name, generationMarkerPrefix))
+	if err != nil {
+		return fmt.Errorf("invalid generation marker %s in archive %s : %w", name, source, err)
+	}
+	if chunkGeneration != generation {
+		return fmt.Errorf("archive %s belongs to generation %d while generation %d is being extracted: refusing to mix chunks from different archive generations", source, chunkGeneration, generation)
+	}
+	return nil
+}
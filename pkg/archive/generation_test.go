@@ -0,0 +1,121 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveGenerations(t *testing.T) {
+	type testCase struct {
+		caseName           string
+		generations        []int
+		latest             string
+		renameChunk        [2]string
+		expectedGeneration int
+		expectedChunks     []string
+		expectedError      string
+	}
+	testCases := []testCase{
+		{
+			caseName:           "single generation without pointer should be extracted",
+			generations:        []int{1},
+			expectedGeneration: 1,
+			expectedChunks:     []string{"mirror_g0001_000001.tar"},
+		},
+		{
+			caseName:           "latest pointer should select its generation only",
+			generations:        []int{1, 2},
+			latest:             "2",
+			expectedGeneration: 2,
+			expectedChunks:     []string{"mirror_g0002_000001.tar"},
+		},
+		{
+			caseName:      "several generations without pointer should fail",
+			generations:   []int{1, 2},
+			expectedError: "refusing to mix chunks from different archive generations",
+		},
+		{
+			caseName:      "pointer to a missing generation should fail",
+			generations:   []int{1},
+			latest:        "3",
+			expectedError: "no chunk of this generation was found",
+		},
+		{
+			caseName:           "chunk renamed to another generation should fail when extracting",
+			generations:        []int{1, 2},
+			latest:             "2",
+			renameChunk:        [2]string{"mirror_g0001_000001.tar", "mirror_g0002_000002.tar"},
+			expectedGeneration: 2,
+			expectedChunks:     []string{"mirror_g0002_000001.tar", "mirror_g0002_000002.tar"},
+			expectedError:      "belongs to generation 1 while generation 2 is being extracted",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			archiveDir := t.TempDir()
+			createGenerations(t, archiveDir, testCase.generations)
+			if testCase.latest != "" {
+				require.NoError(t, os.WriteFile(filepath.Join(archiveDir, latestGenerationFile), []byte(testCase.latest), 0600))
+			}
+			if testCase.renameChunk[0] != "" {
+				require.NoError(t, os.Rename(filepath.Join(archiveDir, testCase.renameChunk[0]), filepath.Join(archiveDir, testCase.renameChunk[1])))
+			}
+
+			extractDir := t.TempDir()
+			extractor, err := NewArchiveExtractor(archiveDir, filepath.Join(extractDir, workingDirectory), newTestCacheStorage(t, filepath.Join(extractDir, "cache")), nil)
+			if testCase.expectedChunks == nil {
+				require.ErrorContains(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedGeneration, extractor.generation)
+			expectedPaths := []string{}
+			for _, chunk := range testCase.expectedChunks {
+				expectedPaths = append(expectedPaths, filepath.Join(archiveDir, chunk))
+			}
+			require.Equal(t, expectedPaths, extractor.archiveFiles)
+			err = extractor.Unarchive()
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestRemovePastGenerations(t *testing.T) {
+	archiveDir := t.TempDir()
+	createGenerations(t, archiveDir, []int{1, 2, 3})
+	// legacy chunks belong to generation 0
+	require.NoError(t, os.WriteFile(filepath.Join(archiveDir, "mirror_000001.tar"), []byte{}, 0600))
+
+	generation, err := nextGeneration(archiveDir)
+	require.NoError(t, err)
+	require.Equal(t, 4, generation)
+
+	removed, err := removePastGenerations(archiveDir, 2)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		filepath.Join(archiveDir, "mirror_000001.tar"),
+		filepath.Join(archiveDir, "mirror_g0001_000001.tar"),
+	}, removed)
+	chunks, err := listArchiveChunks(archiveDir)
+	require.NoError(t, err)
+	require.Equal(t, []int{2, 3}, generationsOf(chunks))
+}
+
+func createGenerations(t *testing.T, archiveDir string, generations []int) {
+	sourceFile := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(sourceFile, []byte("content"), 0600))
+	for _, generation := range generations {
+		adder, err := newPermissiveAdder(0, archiveDir, generation, nil, clog.New("error"))
+		require.NoError(t, err)
+		require.NoError(t, adder.addFile(sourceFile, "working-dir/file.txt"))
+		require.NoError(t, adder.close())
+	}
+}
@@ -4,14 +4,16 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/transports/alltransports"
-	"github.com/containers/image/v5/types"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/mirror"
 )
 
+const dockerProtocol = "docker://"
+
 type ImageBlobGatherer struct {
 	BlobsGatherer
 	opts *common.MirrorOptions
@@ -23,80 +25,123 @@ func NewImageBlobGatherer(opts *common.MirrorOptions) *ImageBlobGatherer {
 	}
 }
 
-func (o *ImageBlobGatherer) GatherBlobs(ctx context.Context, imgRef string) (blobs map[string]string, retErr error) {
-	blobs = map[string]string{}
+// ImageBlobs are the blobs of an image, and the digest of its manifest (or manifest list)
+type ImageBlobs struct {
+	Digest string
+	Blobs  map[string]string
+}
+
+func (o *ImageBlobGatherer) GatherBlobs(ctx context.Context, imgRef string) (map[string]string, error) {
+	imageBlobs, err := o.GatherImageBlobs(ctx, imgRef)
+	if err != nil {
+		return nil, err
+	}
+	return imageBlobs.Blobs, nil
+}
+
+// GatherImageBlobs returns the digest of the manifest of imgRef, along with all its blobs,
+// manifests included. With SigstoreArtifacts, the blobs of the cosign signatures, attestations
+// and SBOMs attached to imgRef in the cache are included, so that they are carried by the archive.
+func (o *ImageBlobGatherer) GatherImageBlobs(ctx context.Context, imgRef string) (ImageBlobs, error) {
 	o.opts.RemoveSignatures, _ = strconv.ParseBool("true")
 
 	if err := mirror.ReexecIfNecessaryForImages([]string{imgRef}...); err != nil {
-		return blobs, fmt.Errorf("%w", err)
+		return ImageBlobs{}, fmt.Errorf("%w", err)
 	}
 
-	// TODO should we verify signatures while gathering blobs?
-	// More broadly, should we include anything in the archive to
-	// help with signature verification after the archive is untarred
-	// inside the enclave?
+	imageBlobs, err := o.gatherManifestBlobs(ctx, imgRef)
+	if err != nil {
+		return ImageBlobs{}, err
+	}
+	if !o.opts.SigstoreArtifacts || !strings.HasPrefix(imgRef, dockerProtocol) {
+		return imageBlobs, nil
+	}
+	artifacts, err := mirror.SigstoreArtifacts(ctx, imgRef, o.opts)
+	if err != nil {
+		return ImageBlobs{}, fmt.Errorf("%w", err)
+	}
+	for _, artifact := range artifacts {
+		artifactRef, err := mirror.SigstoreArtifactImage(imgRef, artifact)
+		if err != nil {
+			return ImageBlobs{}, fmt.Errorf("%w", err)
+		}
+		artifactBlobs, err := o.gatherManifestBlobs(ctx, artifactRef)
+		if err != nil {
+			return ImageBlobs{}, fmt.Errorf("unable to find the blobs of the sigstore artifact %s: %w", artifactRef, err)
+		}
+		for digest := range artifactBlobs.Blobs {
+			imageBlobs.Blobs[digest] = ""
+		}
+	}
+	return imageBlobs, nil
+}
+
+// gatherManifestBlobs returns the digest of the manifest of imgRef, along with all its blobs, manifests included
+func (o *ImageBlobGatherer) gatherManifestBlobs(ctx context.Context, imgRef string) (ImageBlobs, error) {
+	blobs := map[string]string{}
 
 	srcRef, err := alltransports.ParseImageName(imgRef)
 	if err != nil {
-		return nil, fmt.Errorf("invalid source name %s: %w", imgRef, err)
+		return ImageBlobs{}, fmt.Errorf("invalid source name %s: %w", imgRef, err)
 	}
-	// we are always gathering blobs from the local cache registry - skipping tls verification
+	// we are always gathering blobs from the local cache registry - skipping tls verification,
+	// unless it is served over TLS with the ephemeral CA of the run
 	sourceCtx := o.opts.NewSystemContext()
-	sourceCtx.DockerInsecureSkipTLSVerify = types.NewOptionalBool(true)
+	o.opts.SetLocalStorageTLS(sourceCtx)
 	if err != nil {
-		return nil, fmt.Errorf("%w", err)
+		return ImageBlobs{}, fmt.Errorf("%w", err)
 	}
 
 	img, err := srcRef.NewImageSource(ctx, sourceCtx)
 	if err != nil {
-		return nil, fmt.Errorf("%w", err)
+		return ImageBlobs{}, fmt.Errorf("%w", err)
 	}
 
 	manifestBytes, mime, err := img.GetManifest(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("%w", err)
+		return ImageBlobs{}, fmt.Errorf("%w", err)
 	}
 
 	digest, err := manifest.Digest(manifestBytes)
 	if err != nil {
-		return nil, fmt.Errorf("%w", err)
+		return ImageBlobs{}, fmt.Errorf("%w", err)
 	}
 	blobs[digest.String()] = ""
 
 	if manifest.MIMETypeIsMultiImage(mime) {
 		manifestList, err := manifest.ListFromBlob(manifestBytes, mime)
 		if err != nil {
-			return nil, fmt.Errorf("%w", err)
+			return ImageBlobs{}, fmt.Errorf("%w", err)
 		}
 		instances := manifestList.Instances()
 		for _, digest := range instances {
 			blobs[digest.String()] = ""
 			singleArchManifest, singleArchMime, err := img.GetManifest(ctx, &digest)
 			if err != nil {
-				return nil, fmt.Errorf("%w", err)
+				return ImageBlobs{}, fmt.Errorf("%w", err)
 			}
 			singleArchBlobs, err := o.getBlobsOfManifest(singleArchManifest, singleArchMime)
 			if err != nil {
-				return nil, fmt.Errorf("%w", err)
+				return ImageBlobs{}, fmt.Errorf("%w", err)
 			}
 			for _, digest := range singleArchBlobs {
 				blobs[digest] = ""
 			}
 			if err != nil {
-				return nil, fmt.Errorf("%w", err)
+				return ImageBlobs{}, fmt.Errorf("%w", err)
 			}
 		}
 	} else {
 
 		manifestBlobs, err := o.getBlobsOfManifest(manifestBytes, mime)
 		if err != nil {
-			return nil, fmt.Errorf("%w", err)
+			return ImageBlobs{}, fmt.Errorf("%w", err)
 		}
 		for _, digest := range manifestBlobs {
 			blobs[digest] = ""
 		}
 	}
-	return blobs, nil
+	return ImageBlobs{Digest: digest.String(), Blobs: blobs}, nil
 }
 
 func (o *ImageBlobGatherer) getBlobsOfManifest(manifestBytes []byte, mimeType string) ([]string, error) {
@@ -0,0 +1,269 @@
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxLargestBlobs     = 10
+	maxLinkFileSize     = 1024
+	manifestsDir        = "/_manifests/"
+	layersDir           = "/_layers/"
+	tagLinkSuffix       = "/current/link"
+	historyEntryPrefix  = workingDirectory + "/.history/.history-"
+	blobDataFileName    = "data"
+	manifestTagsDir     = "tags/"
+	manifestRevisionDir = "revisions/"
+)
+
+// ArchiveInspection describes the content of an archive, as read from the chunk tar headers
+type ArchiveInspection struct {
+	ArchivePath           string                   `json:"archivePath"`
+	Generation            int                      `json:"generation"`
+	Chunks                []InspectedChunk         `json:"chunks"`
+	ImageSetConfiguration *InspectedImageSetConfig `json:"imageSetConfiguration,omitempty"`
+	HistoryWindow         InspectedHistoryWindow   `json:"historyWindow"`
+	Repositories          []InspectedRepository    `json:"repositories"`
+	BlobCount             int                      `json:"blobCount"`
+	TotalBlobBytes        int64                    `json:"totalBlobBytes"`
+	LargestBlobs          []InspectedBlob          `json:"largestBlobs"`
+	repositories          map[string]*InspectedRepository
+}
+
+// InspectedChunk is one of the chunk files of the archive
+type InspectedChunk struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// InspectedImageSetConfig is the image set configuration embedded in the archive
+type InspectedImageSetConfig struct {
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+	Content   string    `json:"content"`
+}
+
+// InspectedHistoryWindow is the period of time covered by the archive:
+// content mirrored after Since (the latest history metadata the archive was
+// built against), until Until (the creation of the archive).
+// Since is empty when the archive is a full one.
+type InspectedHistoryWindow struct {
+	Since *time.Time `json:"since,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// InspectedRepository lists the tags and manifest digests of a repository in the archive
+type InspectedRepository struct {
+	Name    string         `json:"name"`
+	Tags    []InspectedTag `json:"tags"`
+	Digests []string       `json:"digests"`
+}
+
+// InspectedTag is a tag of a repository, and the digest it points to
+type InspectedTag struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+}
+
+// InspectedBlob is a blob included in the archive
+type InspectedBlob struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Chunk  string `json:"chunk"`
+}
+
+type MirrorArchiveInspector struct {
+	archivePath  string
+	archiveFiles []string
+	generation   int
+	decryption   *ArchiveDecryption
+}
+
+// NewArchiveInspector creates an inspector for the archive chunks found under archivePath.
+// `identities` are the files containing the keys used to decrypt encrypted chunks.
+func NewArchiveInspector(archivePath string, identities []string) (MirrorArchiveInspector, error) {
+	decryption, err := NewArchiveDecryption(identities)
+	if err != nil {
+		return MirrorArchiveInspector{}, err
+	}
+	archiveFiles, generation, err := findArchiveChunks(archivePath)
+	if err != nil {
+		return MirrorArchiveInspector{}, err
+	}
+	if len(archiveFiles) == 0 {
+		return MirrorArchiveInspector{}, fmt.Errorf("no archive chunk found under %s", archivePath)
+	}
+	return MirrorArchiveInspector{
+		archivePath:  archivePath,
+		archiveFiles: archiveFiles,
+		generation:   generation,
+		decryption:   decryption,
+	}, nil
+}
+
+// Inspect reads the tar headers of all the chunks of the archive, without extracting them.
+// Only the small link files of the repositories and the image set configuration are read.
+func (o MirrorArchiveInspector) Inspect() (ArchiveInspection, error) {
+	inspection := ArchiveInspection{
+		ArchivePath:  o.archivePath,
+		Generation:   o.generation,
+		Chunks:       []InspectedChunk{},
+		Repositories: []InspectedRepository{},
+		LargestBlobs: []InspectedBlob{},
+		repositories: map[string]*InspectedRepository{},
+	}
+	blobs := []InspectedBlob{}
+	for _, chunkPath := range o.archiveFiles {
+		chunkBlobs, err := o.inspectChunk(chunkPath, &inspection)
+		if err != nil {
+			return ArchiveInspection{}, err
+		}
+		blobs = append(blobs, chunkBlobs...)
+	}
+
+	for _, blob := range blobs {
+		inspection.TotalBlobBytes += blob.Size
+	}
+	inspection.BlobCount = len(blobs)
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].Size > blobs[j].Size })
+	inspection.LargestBlobs = append(inspection.LargestBlobs, blobs[:min(len(blobs), maxLargestBlobs)]...)
+
+	for _, repo := range inspection.repositories {
+		sort.Slice(repo.Tags, func(i, j int) bool { return repo.Tags[i].Name < repo.Tags[j].Name })
+		sort.Strings(repo.Digests)
+		inspection.Repositories = append(inspection.Repositories, *repo)
+	}
+	sort.Slice(inspection.Repositories, func(i, j int) bool {
+		return inspection.Repositories[i].Name < inspection.Repositories[j].Name
+	})
+	if inspection.ImageSetConfiguration != nil {
+		until := inspection.ImageSetConfiguration.Timestamp
+		inspection.HistoryWindow.Until = &until
+	}
+	return inspection, nil
+}
+
+func (o MirrorArchiveInspector) inspectChunk(chunkPath string, inspection *ArchiveInspection) ([]InspectedBlob, error) {
+	chunkFile, err := os.Open(chunkPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer chunkFile.Close()
+	fi, err := chunkFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	chunkName := filepath.Base(chunkPath)
+	inspection.Chunks = append(inspection.Chunks, InspectedChunk{
+		Name:      chunkName,
+		Size:      fi.Size(),
+		Encrypted: filepath.Ext(chunkName) != ".tar",
+	})
+
+	chunkReader, err := o.decryption.decrypt(chunkFile, chunkPath)
+	if err != nil {
+		return nil, err
+	}
+	blobs := []InspectedBlob{}
+	reader := tar.NewReader(chunkReader)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading archive %s: %w", chunkPath, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(header.Name, cacheBlobsDir+"/") && filepath.Base(header.Name) == blobDataFileName:
+			blobs = append(blobs, InspectedBlob{
+				Digest: "sha256:" + filepath.Base(filepath.Dir(header.Name)),
+				Size:   header.Size,
+				Chunk:  chunkName,
+			})
+		case strings.HasPrefix(header.Name, cacheRepositoriesDir+"/"):
+			if err := inspection.addRepositoryEntry(strings.TrimPrefix(header.Name, cacheRepositoriesDir+"/"), reader); err != nil {
+				return nil, fmt.Errorf("error reading %s in archive %s: %w", header.Name, chunkPath, err)
+			}
+		case strings.HasPrefix(header.Name, imageSetConfigPrefix):
+			content, err := io.ReadAll(reader)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s in archive %s: %w", header.Name, chunkPath, err)
+			}
+			timestamp, _ := time.Parse(time.RFC3339, strings.TrimPrefix(header.Name, imageSetConfigPrefix))
+			inspection.ImageSetConfiguration = &InspectedImageSetConfig{
+				Name:      header.Name,
+				Timestamp: timestamp,
+				Content:   string(content),
+			}
+		case strings.HasPrefix(header.Name, generationMarkerPrefix):
+			if err := checkGenerationMarker(header.Name, chunkPath, o.generation); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(header.Name, historyEntryPrefix):
+			// the working-dir is archived before the history metadata of the current run is written:
+			// the latest history file in the archive is the one the archive was built against
+			timestamp, err := time.Parse(time.RFC3339, strings.TrimPrefix(header.Name, historyEntryPrefix))
+			if err == nil && (inspection.HistoryWindow.Since == nil || timestamp.After(*inspection.HistoryWindow.Since)) {
+				inspection.HistoryWindow.Since = &timestamp
+			}
+		}
+	}
+	if err := drain(chunkReader, chunkPath); err != nil {
+		return nil, err
+	}
+	return blobs, nil
+}
+
+// addRepositoryEntry records the repository of `entry` (a path relative to the repositories folder of the cache),
+// as well as the tag or the manifest digest it references
+func (o *ArchiveInspection) addRepositoryEntry(entry string, reader io.Reader) error {
+	var repoName string
+	switch {
+	case strings.Contains(entry, manifestsDir):
+		repoName = entry[:strings.Index(entry, manifestsDir)]
+	case strings.Contains(entry, layersDir):
+		repoName = entry[:strings.Index(entry, layersDir)]
+	default:
+		return nil
+	}
+	repo, ok := o.repositories[repoName]
+	if !ok {
+		repo = &InspectedRepository{Name: repoName, Tags: []InspectedTag{}, Digests: []string{}}
+		o.repositories[repoName] = repo
+	}
+	if !strings.Contains(entry, manifestsDir) {
+		return nil
+	}
+	manifestEntry := strings.TrimPrefix(entry[strings.Index(entry, manifestsDir):], manifestsDir)
+	switch {
+	case strings.HasPrefix(manifestEntry, manifestTagsDir) && strings.HasSuffix(manifestEntry, tagLinkSuffix):
+		link, err := io.ReadAll(io.LimitReader(reader, maxLinkFileSize))
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		repo.Tags = append(repo.Tags, InspectedTag{
+			Name:   strings.TrimSuffix(strings.TrimPrefix(manifestEntry, manifestTagsDir), tagLinkSuffix),
+			Digest: strings.TrimSpace(string(link)),
+		})
+	case strings.HasPrefix(manifestEntry, manifestRevisionDir) && filepath.Base(manifestEntry) == "link":
+		// revisions/<algorithm>/<hex>/link
+		revision := strings.Split(strings.TrimPrefix(manifestEntry, manifestRevisionDir), "/")
+		if len(revision) == 3 {
+			repo.Digests = append(repo.Digests, revision[0]+":"+revision[1])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package archive
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveInspect(t *testing.T) {
+	cacheDir := t.TempDir()
+	workingDir := filepath.Join(t.TempDir(), workingDirectory)
+	archiveDir := t.TempDir()
+
+	manifestDigest := "sha256:4cd0ae2a1c2a3c8f6b0b5f1b4d1f4c0e3e1a9e6f5b2c4d8a7e6f5d4c3b2a1f0e"
+	layerDigest := "sha256:9f8e7d6c5b4a39281706f5e4d3c2b1a09f8e7d6c5b4a39281706f5e4d3c2b1a0"
+	files := map[string]string{
+		filepath.Join(cacheDir, cacheRepositoriesDir, "ubi8/ubi/_manifests/tags/latest/current/link"):                     manifestDigest,
+		filepath.Join(cacheDir, cacheRepositoriesDir, "ubi8/ubi/_manifests/revisions/sha256", manifestDigest[7:], "link"): manifestDigest,
+		filepath.Join(cacheDir, cacheRepositoriesDir, "ubi8/ubi/_layers/sha256", layerDigest[7:], "link"):                 layerDigest,
+		filepath.Join(cacheDir, cacheBlobsDir, "sha256", manifestDigest[7:9], manifestDigest[7:], "data"):                 "{}",
+		filepath.Join(cacheDir, cacheBlobsDir, "sha256", layerDigest[7:9], layerDigest[7:], "data"):                       "layer content",
+		filepath.Join(workingDir, ".history", ".history-2024-06-01T10:00:00Z"):                                            layerDigest,
+		filepath.Join(workingDir, "isc.yaml"): "kind: ImageSetConfiguration",
+	}
+	for path, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+
+	adder, err := newPermissiveAdder(0, archiveDir, 1, nil, clog.New("error"))
+	require.NoError(t, err)
+	cacheStorage := newTestCacheStorage(t, cacheDir)
+	require.NoError(t, adder.addCacheFolder(context.Background(), cacheStorage, "/"+cacheRepositoriesDir))
+	require.NoError(t, adder.addAllFolder(workingDir, filepath.Dir(workingDir)))
+	require.NoError(t, adder.addFile(filepath.Join(workingDir, "isc.yaml"), imageSetConfigPrefix+"2024-06-02T10:00:00Z"))
+	require.NoError(t, adder.addCacheFolder(context.Background(), cacheStorage, "/"+cacheBlobsDir))
+	adder.close()
+
+	inspector, err := NewArchiveInspector(archiveDir, nil)
+	require.NoError(t, err)
+	inspection, err := inspector.Inspect()
+	require.NoError(t, err)
+
+	require.Len(t, inspection.Chunks, 1)
+	require.Equal(t, []InspectedRepository{
+		{
+			Name:    "ubi8/ubi",
+			Tags:    []InspectedTag{{Name: "latest", Digest: manifestDigest}},
+			Digests: []string{manifestDigest},
+		},
+	}, inspection.Repositories)
+	require.Equal(t, 2, inspection.BlobCount)
+	require.Equal(t, int64(len("{}")+len("layer content")), inspection.TotalBlobBytes)
+	require.Equal(t, layerDigest, inspection.LargestBlobs[0].Digest)
+	require.NotNil(t, inspection.ImageSetConfiguration)
+	require.Equal(t, "kind: ImageSetConfiguration", inspection.ImageSetConfiguration.Content)
+	require.NotNil(t, inspection.HistoryWindow.Since)
+	require.Equal(t, "2024-06-01T10:00:00Z", inspection.HistoryWindow.Since.Format("2006-01-02T15:04:05Z07:00"))
+	require.Equal(t, "2024-06-02T10:00:00Z", inspection.HistoryWindow.Until.Format("2006-01-02T15:04:05Z07:00"))
+}
@@ -0,0 +1,90 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// ReadBlobInventory reads a blob inventory: one blob digest per line.
+// Empty lines and lines starting with # are ignored.
+// The blobs are returned in the same form as the history metadata.
+func ReadBlobInventory(inventoryPath string) (map[string]string, error) {
+	file, err := os.Open(inventoryPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open blob inventory : %w", err)
+	}
+	defer file.Close()
+
+	blobs := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		blob := strings.TrimSpace(scanner.Text())
+		if blob == "" || strings.HasPrefix(blob, "#") {
+			continue
+		}
+		if _, err := digest.Parse(blob); err != nil {
+			return nil, fmt.Errorf("invalid digest %q in blob inventory %s line %d : %w", blob, inventoryPath, line, err)
+		}
+		blobs[blob] = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read blob inventory : %w", err)
+	}
+	return blobs, nil
+}
+
+// WriteBlobInventory writes the blob inventory of the cache, read through its storage driver, to writer.
+func WriteBlobInventory(ctx context.Context, cacheStorage driver.StorageDriver, writer io.Writer) error {
+	blobs, err := listCacheBlobs(ctx, cacheStorage)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(writer)
+	for _, blob := range blobs {
+		if _, err := w.WriteString(blob + "\n"); err != nil {
+			return fmt.Errorf("unable to write blob inventory : %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("unable to write blob inventory : %w", err)
+	}
+	return nil
+}
+
+// listCacheBlobs returns the sorted digests of all blobs stored in the cache:
+// blobs are stored under docker/registry/v2/blobs/<algorithm>/<2 first chars>/<hex>/data
+func listCacheBlobs(ctx context.Context, cacheStorage driver.StorageDriver) ([]string, error) {
+	blobsDir := "/" + cacheBlobsDir
+	blobs := []string{}
+	err := cacheStorage.Walk(ctx, blobsDir, func(fi driver.FileInfo) error {
+		if fi.IsDir() || path.Base(fi.Path()) != blobDataFileName {
+			return nil
+		}
+		parts := strings.Split(strings.TrimPrefix(fi.Path(), blobsDir+"/"), "/")
+		if len(parts) != 4 {
+			return nil
+		}
+		blobs = append(blobs, parts[0]+":"+parts[2])
+		return nil
+	})
+	if errors.As(err, &driver.PathNotFoundError{}) {
+		return blobs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the blobs of the cache : %w", err)
+	}
+	sort.Strings(blobs)
+	return blobs, nil
+}
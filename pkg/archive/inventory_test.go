@@ -0,0 +1,102 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobInventory(t *testing.T) {
+	type testCase struct {
+		caseName     string
+		cacheStorage func(t *testing.T) driver.StorageDriver
+	}
+	testCases := []testCase{
+		{
+			caseName: "filesystem cache",
+			cacheStorage: func(t *testing.T) driver.StorageDriver {
+				return newTestCacheStorage(t, t.TempDir())
+			},
+		},
+		{
+			caseName: "in-memory cache",
+			cacheStorage: func(t *testing.T) driver.StorageDriver {
+				return inmemory.New()
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			ctx := context.Background()
+			cacheStorage := testCase.cacheStorage(t)
+			blobs := []string{
+				"sha256:1111111111111111111111111111111111111111111111111111111111111111",
+				"sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			}
+			newBlob := "sha256:3333333333333333333333333333333333333333333333333333333333333333"
+			for _, blob := range blobs {
+				putTestCacheBlob(t, cacheStorage, blob)
+			}
+
+			inventory := &bytes.Buffer{}
+			require.NoError(t, WriteBlobInventory(ctx, cacheStorage, inventory))
+			require.Equal(t, blobs[0]+"\n"+blobs[1]+"\n", inventory.String())
+
+			inventoryFile := filepath.Join(t.TempDir(), "inventory.txt")
+			require.NoError(t, os.WriteFile(inventoryFile, append([]byte("# exported from the enclave\n\n"), inventory.Bytes()...), 0600))
+			archiveDir := t.TempDir()
+			adder, err := newPermissiveAdder(0, archiveDir, 1, nil, clog.New("error"))
+			require.NoError(t, err)
+			archive := MirrorArchive{baselineInventory: inventoryFile, cacheStorage: cacheStorage, adder: adder}
+			baseline, err := archive.readBaseline()
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{blobs[0]: "", blobs[1]: ""}, baseline)
+
+			// only the blob missing from the baseline is added to the archive
+			putTestCacheBlob(t, cacheStorage, newBlob)
+			addedBlobs, err := archive.addBlobsDiff(ctx, map[string]string{blobs[0]: "", newBlob: ""}, baseline, map[string]string{})
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{newBlob: ""}, addedBlobs)
+			require.NoError(t, adder.close())
+
+			// and it is extracted to the cache of the disconnected side
+			extractedStorage := inmemory.New()
+			extractor, err := NewArchiveExtractor(archiveDir, filepath.Join(t.TempDir(), workingDirectory), extractedStorage, nil)
+			require.NoError(t, err)
+			require.NoError(t, extractor.Unarchive())
+			inventory.Reset()
+			require.NoError(t, WriteBlobInventory(ctx, extractedStorage, inventory))
+			require.Equal(t, newBlob+"\n", inventory.String())
+
+			require.NoError(t, os.WriteFile(inventoryFile, []byte("not-a-digest\n"), 0600))
+			_, err = ReadBlobInventory(inventoryFile)
+			require.ErrorContains(t, err, "line 1")
+		})
+	}
+}
+
+func TestBlobInventoryEmptyCache(t *testing.T) {
+	inventory := &bytes.Buffer{}
+	require.NoError(t, WriteBlobInventory(context.Background(), newTestCacheStorage(t, t.TempDir()), inventory))
+	require.Empty(t, inventory.String())
+}
+
+func newTestCacheStorage(t *testing.T, cacheDir string) driver.StorageDriver {
+	cacheStorage, err := filesystem.FromParameters(map[string]interface{}{"rootdirectory": cacheDir})
+	require.NoError(t, err)
+	return cacheStorage
+}
+
+func putTestCacheBlob(t *testing.T, cacheStorage driver.StorageDriver, blob string) {
+	blobPath := path.Join("/", cacheBlobsDir, "sha256", blob[7:9], blob[7:], blobDataFileName)
+	require.NoError(t, cacheStorage.PutContent(context.Background(), blobPath, []byte("blob")))
+}
@@ -2,23 +2,29 @@ package archive
 
 import (
 	"archive/tar"
+	"context"
 	"fmt"
 	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
 
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+
 	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
 )
 
 type permissiveAdder struct {
 	destination        string
 	archiveFile        *os.File
+	chunkWriter        io.WriteCloser
 	tarWriter          *tar.Writer
+	encryption         *ArchiveEncryption
+	generation         int
 	maxArchiveSize     int64
 	currentChunkId     int
 	sizeOfCurrentChunk int64
 	oversizedFiles     map[string]int64
+	chunkNames         []string
 	logger             clog.PluggableLoggerInterface
 }
 
@@ -26,23 +32,14 @@ type permissiveAdder struct {
 // This implementation allows  files to exceed the maxArchiveSize specified in the
 // imageSetConfig. It places them in special archive chunks, on their own, and keeps track of the list
 // of oversized files.
-func newPermissiveAdder(maxSize int64, destination string, logger clog.PluggableLoggerInterface) (*permissiveAdder, error) {
+// Chunks are named after the archive `generation` they belong to.
+// When `encryption` is not nil, each chunk is encrypted to its recipients.
+func newPermissiveAdder(maxSize int64, destination string, generation int, encryption *ArchiveEncryption, logger clog.PluggableLoggerInterface) (*permissiveAdder, error) {
 	chunk := 1
-	archiveFileName := fmt.Sprintf(archiveFileNameFormat, archiveFilePrefix, chunk)
 	err := os.MkdirAll(destination, 0755)
 	if err != nil {
 		return &permissiveAdder{}, fmt.Errorf("%w", err)
 	}
-	archivePath := filepath.Join(destination, archiveFileName)
-	// Create a new tar archive file
-	// to be closed by BuildArchive
-	archiveFile, err := os.Create(archivePath)
-	if err != nil {
-		return &permissiveAdder{}, fmt.Errorf("%w", err)
-	}
-	// Create a new tar writer
-	// to be closed by BuildArchive
-	tarWriter := tar.NewWriter(archiveFile)
 	if maxSize == 0 {
 		maxSize = defaultSegSize * segMultiplier
 	}
@@ -51,14 +48,51 @@ func newPermissiveAdder(maxSize int64, destination string, logger clog.Pluggable
 		currentChunkId:     chunk,
 		sizeOfCurrentChunk: int64(0),
 		destination:        destination,
-		archiveFile:        archiveFile,
-		tarWriter:          tarWriter,
+		encryption:         encryption,
+		generation:         generation,
 		logger:             logger,
 		oversizedFiles:     map[string]int64{},
 	}
+	// Create a new tar archive file and tar writer
+	// to be closed by BuildArchive
+	p.archiveFile, p.chunkWriter, p.tarWriter, err = p.createChunk(chunk)
+	if err != nil {
+		return &permissiveAdder{}, err
+	}
 	return &p, nil
 }
 
+// createChunk creates the archive file of chunk `id`, the writer to use for it
+// (the file itself, or the encryption layer on top of it when the archive is encrypted),
+// and the tar writer on top of them, with the generation marker already written.
+// All are to be closed by the caller, in the reverse order.
+func (o *permissiveAdder) createChunk(id int) (*os.File, io.WriteCloser, *tar.Writer, error) {
+	archiveFileName := fmt.Sprintf(generationChunkFormat, archiveFilePrefix, o.generation, id) + o.encryption.extension()
+	archiveFile, err := os.Create(filepath.Join(o.destination, archiveFileName))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w", err)
+	}
+	chunkWriter, err := o.encryption.encrypt(archiveFile)
+	if err != nil {
+		archiveFile.Close()
+		return nil, nil, nil, err
+	}
+	tarWriter := tar.NewWriter(chunkWriter)
+	if err := writeGenerationMarker(tarWriter, o.generation); err != nil {
+		// the encrypting writer first: it releases its state before the file beneath it
+		chunkWriter.Close()
+		archiveFile.Close()
+		return nil, nil, nil, err
+	}
+	o.chunkNames = append(o.chunkNames, archiveFileName)
+	return archiveFile, chunkWriter, tarWriter, nil
+}
+
+// chunks returns the names of the chunk files created so far
+func (o *permissiveAdder) chunks() []string {
+	return o.chunkNames
+}
+
 func (o *permissiveAdder) close() error {
 	// create a warning with the archiveSize that should be set, and the list of files that
 	// were exceeding the max
@@ -82,7 +116,15 @@ func (o *permissiveAdder) close() error {
 	if err != nil {
 		o.logger.Warn("error closing archive writer : %v", err)
 	}
-	return fmt.Errorf("%w", o.archiveFile.Close())
+	err = o.chunkWriter.Close()
+	if err != nil {
+		o.logger.Warn("error closing archive encryption : %v", err)
+	}
+	err = o.archiveFile.Close()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
 
 }
 
@@ -97,29 +139,11 @@ func (o *permissiveAdder) addFile(pathToFile string, pathInTar string) error {
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
-
-	// when a file is already bigger than the maxArchiveSize, it will not fit any chunk.
-	// It is put on its own in an exceptionChunk and flagged as oversized. The method returns.
-	if fi.Size() > o.maxArchiveSize {
-		o.logger.Warn("maxArchiveSize %dG is too small compared to sizes of files that need to be included in the archive.\n%s: %dG", o.maxArchiveSize/segMultiplier, pathToFile, fi.Size()/segMultiplier)
-		o.oversizedFiles[pathToFile] = fi.Size()
-
-		return o.exceptionChunk(fi, pathToFile, pathInTar)
-	}
-	// check if we should add this file to the archive without exceeding the maxArchiveSize
-	if fi.Size()+o.sizeOfCurrentChunk > o.maxArchiveSize {
-		err = o.nextChunk()
-		if err != nil {
-			return fmt.Errorf("%w", err)
-		}
-	}
-	err = addFileToWriter(fi, pathToFile, pathInTar, o.tarWriter)
+	entry, err := diskEntry(fi, pathToFile, pathInTar)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return err
 	}
-
-	o.sizeOfCurrentChunk += fi.Size()
-	return nil
+	return o.addEntry(entry)
 }
 
 // addAllFolder copies the contents of the `folderToAdd` from the disk into
@@ -137,26 +161,6 @@ func (o *permissiveAdder) addAllFolder(folderToAdd string, relativeTo string) er
 		if info.IsDir() { // skip directories
 			return nil
 		}
-		// when a file is already bigger than the maxArchiveSize, it will not fit any chunk.
-		// It is put on its own in an exceptionChunk and flagged as oversized. The method returns.
-		if info.Size() > o.maxArchiveSize {
-			o.logger.Warn("maxArchiveSize %dG is too small compared to sizes of files that need to be included in the archive.\n%s: %dG", o.maxArchiveSize/segMultiplier, info.Name(), info.Size()/segMultiplier)
-			o.oversizedFiles[path] = info.Size()
-			fileNameInArchive, err := filepath.Rel(relativeTo, path)
-			if err != nil {
-				return fmt.Errorf("%w", err)
-			}
-			return o.exceptionChunk(info, path, fileNameInArchive)
-
-		}
-		// check if we should add this file to the archive without exceeding the maxArchiveSize
-		if info.Size()+o.sizeOfCurrentChunk > o.maxArchiveSize {
-			err := o.nextChunk()
-			if err != nil {
-				return fmt.Errorf("%w", err)
-			}
-		}
-
 		// Use full path as name (FileInfoHeader only takes the basename)
 		// If we don't do this the directory strucuture would
 		// not be preserved
@@ -165,15 +169,43 @@ func (o *permissiveAdder) addAllFolder(folderToAdd string, relativeTo string) er
 		if err != nil {
 			return fmt.Errorf("%w", err)
 		}
+		entry, err := diskEntry(info, path, pathInTar)
+		if err != nil {
+			return err
+		}
+		return o.addEntry(entry)
+	})
+}
 
-		err = addFileToWriter(info, path, pathInTar, o.tarWriter)
+// addCacheFolder copies the contents of `folderToAdd` from the storage driver of the cache
+// into the current chunk archive, at the same path, the same way addAllFolder does.
+func (o *permissiveAdder) addCacheFolder(ctx context.Context, cacheStorage driver.StorageDriver, folderToAdd string) error {
+	return walkCacheFolder(ctx, cacheStorage, folderToAdd, o.addEntry)
+}
+
+func (o *permissiveAdder) addEntry(entry archiveEntry) error {
+	size := entry.header.Size
+	// when a file is already bigger than the maxArchiveSize, it will not fit any chunk.
+	// It is put on its own in an exceptionChunk and flagged as oversized. The method returns.
+	if size > o.maxArchiveSize {
+		o.logger.Warn("maxArchiveSize %dG is too small compared to sizes of files that need to be included in the archive.\n%s: %dG", o.maxArchiveSize/segMultiplier, entry.source, size/segMultiplier)
+		o.oversizedFiles[entry.source] = size
+		return o.exceptionChunk(entry)
+	}
+	// check if we should add this file to the archive without exceeding the maxArchiveSize
+	if size+o.sizeOfCurrentChunk > o.maxArchiveSize {
+		err := o.nextChunk()
 		if err != nil {
 			return fmt.Errorf("%w", err)
 		}
+	}
+	err := addEntryToWriter(entry, o.tarWriter)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
 
-		o.sizeOfCurrentChunk += info.Size()
-		return nil
-	})
+	o.sizeOfCurrentChunk += size
+	return nil
 }
 
 // nextChunk is called in order to close the current chunk archive
@@ -186,6 +218,10 @@ func (o *permissiveAdder) nextChunk() error {
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
+	err = o.chunkWriter.Close()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
 	err = o.archiveFile.Close()
 	if err != nil {
 		return fmt.Errorf("%w", err)
@@ -197,61 +233,32 @@ func (o *permissiveAdder) nextChunk() error {
 
 	// Create a new tar archive file
 	// to be closed by BuildArchive
-	archiveFileName := fmt.Sprintf(archiveFileNameFormat, archiveFilePrefix, o.currentChunkId)
-	archivePath := filepath.Join(o.destination, archiveFileName)
-
-	o.archiveFile, err = os.Create(archivePath)
+	o.archiveFile, o.chunkWriter, o.tarWriter, err = o.createChunk(o.currentChunkId)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return err
 	}
-	o.tarWriter = tar.NewWriter(o.archiveFile)
 	return nil
 }
 
 // exceptionChunk handles creating a new archive file to copy the oversized file in it
 // then immediately closes that exceptionChunk. It doesn't alter the o.tarWriter, o.sizeOfCurrentChunk.
 // It just increments the currentChunkId in order to show that this id has been used.
-func (o *permissiveAdder) exceptionChunk(oversizedFileInfo fs.FileInfo, oversizedFilePath, pathInTar string) error {
+func (o *permissiveAdder) exceptionChunk(oversizedEntry archiveEntry) error {
 	// next chunk init
 	o.currentChunkId += 1
 	// Create a new tar archive file
-	exceptionArchiveFileName := fmt.Sprintf(archiveFileNameFormat, archiveFilePrefix, o.currentChunkId)
-	exceptionArchivePath := filepath.Join(o.destination, exceptionArchiveFileName)
-
-	exceptionArchiveFile, err := os.Create(exceptionArchivePath)
+	exceptionArchiveFile, exceptionChunkWriter, exceptionTarWriter, err := o.createChunk(o.currentChunkId)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return err
 	}
-	exceptionTarWriter := tar.NewWriter(exceptionArchiveFile)
 
 	// immediately close the exceptionChunk file when this method is done
 	defer func() {
 		exceptionTarWriter.Flush()
 		exceptionTarWriter.Close()
+		exceptionChunkWriter.Close()
 		exceptionArchiveFile.Close()
 	}()
 
-	// create the header for the file
-	header, err := tar.FileInfoHeader(oversizedFileInfo, oversizedFileInfo.Name())
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-	header.Name = pathInTar
-
-	if err := exceptionTarWriter.WriteHeader(header); err != nil {
-		return fmt.Errorf("%w", err)
-	}
-	// Open the file for reading
-	file, err := os.Open(oversizedFilePath)
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-	defer file.Close()
-
-	// Copy the file contents to the tar archive
-	if _, err := io.Copy(exceptionTarWriter, file); err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	return nil
+	return addEntryToWriter(oversizedEntry, exceptionTarWriter)
 }
@@ -0,0 +1,170 @@
+package archive
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+type streamAdder struct {
+	writer             io.WriteCloser
+	tarWriter          *tar.Writer
+	maxArchiveSize     int64
+	currentChunkId     int
+	sizeOfCurrentChunk int64
+	oversizedFiles     map[string]int64
+	logger             clog.PluggableLoggerInterface
+}
+
+// `newStreamAdder` initializes the streamAdder implementation for the `archiveAdder` interface.
+// Instead of creating chunk files under a destination folder, it writes one continuous
+// tar stream to `writer` (stdout, a named pipe or a device).
+// Chunk boundaries are still computed using maxArchiveSize, and are signalled in the stream
+// by an empty marker entry (see chunkBoundaryFormat) placed at the beginning of each chunk,
+// so that the receiving end is able to split the stream again if needed.
+// When `encryption` is not nil, the whole stream is encrypted to its recipients.
+func newStreamAdder(maxSize int64, writer io.Writer, encryption *ArchiveEncryption, logger clog.PluggableLoggerInterface) (*streamAdder, error) {
+	if maxSize == 0 {
+		maxSize = defaultSegSize * segMultiplier
+	}
+	streamWriter, err := encryption.encrypt(writer)
+	if err != nil {
+		return &streamAdder{}, err
+	}
+	s := streamAdder{
+		writer:             streamWriter,
+		tarWriter:          tar.NewWriter(streamWriter),
+		maxArchiveSize:     maxSize,
+		currentChunkId:     1,
+		sizeOfCurrentChunk: int64(0),
+		oversizedFiles:     map[string]int64{},
+		logger:             logger,
+	}
+	if err := s.writeChunkBoundary(); err != nil {
+		return &streamAdder{}, err
+	}
+	return &s, nil
+}
+
+// close flushes and closes the tar stream.
+// The underlying writer is owned by the caller and is not closed here.
+func (o *streamAdder) close() error {
+	if len(o.oversizedFiles) > 0 {
+		o.logger.Warn("The following files exceed the archiveSize configured: ")
+		for f, s := range o.oversizedFiles {
+			o.logger.Warn("%s: %d", f, s/segMultiplier)
+		}
+	}
+	if err := o.tarWriter.Flush(); err != nil {
+		o.logger.Warn("error flushing archive stream : %v", err)
+	}
+	if err := o.tarWriter.Close(); err != nil {
+		return fmt.Errorf("error closing archive stream : %w", err)
+	}
+	if err := o.writer.Close(); err != nil {
+		return fmt.Errorf("error closing archive stream encryption : %w", err)
+	}
+	return nil
+}
+
+// chunks returns no chunk name: the archive is a single stream
+func (o *streamAdder) chunks() []string {
+	return []string{}
+}
+
+// addFile copies the contents of the `pathToFile` file from the disk into
+// the stream at `pathInTar` location, emitting a chunk boundary first
+// when the current chunk would exceed the maxArchiveSize.
+func (o *streamAdder) addFile(pathToFile string, pathInTar string) error {
+	fi, err := os.Stat(pathToFile)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	entry, err := diskEntry(fi, pathToFile, pathInTar)
+	if err != nil {
+		return err
+	}
+	return o.add(entry)
+}
+
+// addAllFolder copies the contents of the `folderToAdd` from the disk into
+// the stream under `relativeTo` path.
+func (o *streamAdder) addAllFolder(folderToAdd string, relativeTo string) error {
+	// nolint: wrapcheck
+	return filepath.Walk(folderToAdd, func(path string, info os.FileInfo, incomingError error) error {
+		if incomingError != nil {
+			return fmt.Errorf("%w", incomingError)
+		}
+		if info.IsDir() { // skip directories
+			return nil
+		}
+		pathInTar, err := filepath.Rel(relativeTo, path)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		entry, err := diskEntry(info, path, pathInTar)
+		if err != nil {
+			return err
+		}
+		return o.add(entry)
+	})
+}
+
+// addCacheFolder copies the contents of `folderToAdd` from the storage driver of the cache
+// into the stream, at the same path.
+func (o *streamAdder) addCacheFolder(ctx context.Context, cacheStorage driver.StorageDriver, folderToAdd string) error {
+	return walkCacheFolder(ctx, cacheStorage, folderToAdd, o.add)
+}
+
+func (o *streamAdder) add(entry archiveEntry) error {
+	size := entry.header.Size
+	// an oversized file can't fit any chunk: it gets a chunk on its own
+	// and the next file will start a new chunk
+	oversized := size > o.maxArchiveSize
+	if oversized {
+		o.logger.Warn("maxArchiveSize %dG is too small compared to sizes of files that need to be included in the archive.\n%s: %dG", o.maxArchiveSize/segMultiplier, entry.source, size/segMultiplier)
+		o.oversizedFiles[entry.source] = size
+	}
+	if o.sizeOfCurrentChunk > 0 && (oversized || size+o.sizeOfCurrentChunk > o.maxArchiveSize) {
+		if err := o.nextChunk(); err != nil {
+			return err
+		}
+	}
+	if err := addEntryToWriter(entry, o.tarWriter); err != nil {
+		return err
+	}
+	o.sizeOfCurrentChunk += size
+	if oversized {
+		// force the following file in a new chunk
+		o.sizeOfCurrentChunk = o.maxArchiveSize
+	}
+	return nil
+}
+
+func (o *streamAdder) nextChunk() error {
+	o.currentChunkId += 1
+	o.sizeOfCurrentChunk = 0
+	return o.writeChunkBoundary()
+}
+
+// writeChunkBoundary writes an empty entry marking the start of a new chunk in the stream
+func (o *streamAdder) writeChunkBoundary() error {
+	header := &tar.Header{
+		Name:     fmt.Sprintf(chunkBoundaryFormat, o.currentChunkId),
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     0,
+		ModTime:  time.Now(),
+	}
+	if err := o.tarWriter.WriteHeader(header); err != nil {
+		return fmt.Errorf("unable to write chunk boundary %d : %w", o.currentChunkId, err)
+	}
+	return nil
+}
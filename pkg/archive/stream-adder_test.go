@@ -0,0 +1,97 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestStreamArchiveRoundTrip(t *testing.T) {
+	type testCase struct {
+		caseName  string
+		encrypted bool
+	}
+	testCases := []testCase{
+		{caseName: "clear stream"},
+		{caseName: "encrypted stream", encrypted: true},
+	}
+	keysDir := t.TempDir()
+	ageRecipient, ageIdentity := generateAgeKey(t, keysDir, "age")
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			ctx := context.Background()
+			sourceDir := t.TempDir()
+			workingDirFile := filepath.Join(sourceDir, workingDirectory, "cluster-resources", "idms.yaml")
+			require.NoError(t, os.MkdirAll(filepath.Dir(workingDirFile), 0755))
+			require.NoError(t, os.WriteFile(workingDirFile, []byte("idms"), 0644))
+			sourceCache := newTestCacheStorage(t, filepath.Join(sourceDir, "cache"))
+			blob := path.Join(cacheBlobsDir, "sha256", "ab", "abcdef", blobDataFileName)
+			link := path.Join(cacheRepositoriesDir, "ns/img/_manifests/tags/v1/current/link")
+			require.NoError(t, sourceCache.PutContent(ctx, "/"+blob, []byte("blob content")))
+			require.NoError(t, sourceCache.PutContent(ctx, "/"+link, []byte("sha256:abcdef")))
+
+			var recipients, identities []string
+			if testCase.encrypted {
+				recipients, identities = []string{ageRecipient}, []string{ageIdentity}
+			}
+			encryption, err := NewArchiveEncryption(recipients)
+			require.NoError(t, err)
+			stream := &bytes.Buffer{}
+			// every file gets a chunk of its own
+			adder, err := newStreamAdder(4, stream, encryption, clog.New("error"))
+			require.NoError(t, err)
+			require.NoError(t, adder.addAllFolder(filepath.Join(sourceDir, workingDirectory), sourceDir))
+			require.NoError(t, adder.addCacheFolder(ctx, sourceCache, "/"+cacheFilePrefix))
+			require.NoError(t, adder.close())
+			require.Empty(t, adder.chunks())
+
+			if !testCase.encrypted {
+				require.Equal(t, []string{
+					".oc-mirror/chunk_000001", "working-dir/cluster-resources/idms.yaml",
+					".oc-mirror/chunk_000002", blob,
+					".oc-mirror/chunk_000003", link,
+				}, streamEntries(t, stream.Bytes()))
+			}
+
+			targetDir := t.TempDir()
+			targetCache := newTestCacheStorage(t, filepath.Join(targetDir, "cache"))
+			extractor, err := NewStreamExtractor(bytes.NewReader(stream.Bytes()), filepath.Join(targetDir, workingDirectory), targetCache, identities)
+			require.NoError(t, err)
+			require.NoError(t, extractor.Unarchive())
+
+			content, err := os.ReadFile(filepath.Join(targetDir, workingDirectory, "cluster-resources", "idms.yaml"))
+			require.NoError(t, err)
+			require.Equal(t, "idms", string(content))
+			content, err = targetCache.GetContent(ctx, "/"+blob)
+			require.NoError(t, err)
+			require.Equal(t, "blob content", string(content))
+			content, err = targetCache.GetContent(ctx, "/"+link)
+			require.NoError(t, err)
+			require.Equal(t, "sha256:abcdef", string(content))
+		})
+	}
+}
+
+func streamEntries(t *testing.T, stream []byte) []string {
+	names := []string{}
+	reader := tar.NewReader(bytes.NewReader(stream))
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return names
+		}
+		require.NoError(t, err)
+		names = append(names, strings.TrimPrefix(header.Name, "/"))
+	}
+}
@@ -2,48 +2,79 @@ package archive
 
 import (
 	"archive/tar"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/google/uuid"
+	digest "github.com/opencontainers/go-digest"
 )
 
 type MirrorUnArchiver struct {
 	UnArchiver
 	workingDir   string
-	cacheDir     string
+	cacheStorage driver.StorageDriver
 	archiveFiles []string
+	generation   int
+	decryption   *ArchiveDecryption
 }
 
-func NewArchiveExtractor(archivePath, workingDir, cacheDir string) (MirrorUnArchiver, error) {
-	ae := MirrorUnArchiver{
-		workingDir: workingDir,
-		cacheDir:   cacheDir,
-	}
-	files, err := os.ReadDir(archivePath)
+type MirrorStreamUnArchiver struct {
+	UnArchiver
+	workingDir   string
+	cacheStorage driver.StorageDriver
+	reader       io.Reader
+	decryption   *ArchiveDecryption
+}
+
+// NewArchiveExtractor creates an UnArchiver for the archive chunks found under archivePath.
+// Only the chunks of a single generation are extracted (see findArchiveChunks).
+// The content of the cache is written through cacheStorage, the storage driver of the cache.
+// `identities` are the files containing the keys used to decrypt encrypted chunks.
+func NewArchiveExtractor(archivePath, workingDir string, cacheStorage driver.StorageDriver, identities []string) (MirrorUnArchiver, error) {
+	decryption, err := NewArchiveDecryption(identities)
 	if err != nil {
-		return MirrorUnArchiver{}, fmt.Errorf("%w", err)
+		return MirrorUnArchiver{}, err
 	}
-
-	//nolint: gocritic
-	rxp, err := regexp.Compile(archiveFilePrefix + "_[0-9]{6}\\.tar")
+	archiveFiles, generation, err := findArchiveChunks(archivePath)
 	if err != nil {
-		return MirrorUnArchiver{}, fmt.Errorf("%w", err)
+		return MirrorUnArchiver{}, err
 	}
-	for _, chunk := range files {
-
-		if rxp.MatchString(chunk.Name()) {
-			ae.archiveFiles = append(ae.archiveFiles, filepath.Join(archivePath, chunk.Name()))
-		}
+	ae := MirrorUnArchiver{
+		workingDir:   workingDir,
+		cacheStorage: cacheStorage,
+		decryption:   decryption,
+		archiveFiles: archiveFiles,
+		generation:   generation,
 	}
 	return ae, nil
 }
 
+// NewStreamExtractor creates an UnArchiver that reads the continuous tar stream
+// produced by a mirror-to-disk to a stream destination (see NewStreamMirrorArchive).
+// The content of the cache is written through cacheStorage, the storage driver of the cache.
+// `identities` are the files containing the keys used to decrypt an encrypted stream.
+func NewStreamExtractor(reader io.Reader, workingDir string, cacheStorage driver.StorageDriver, identities []string) (MirrorStreamUnArchiver, error) {
+	decryption, err := NewArchiveDecryption(identities)
+	if err != nil {
+		return MirrorStreamUnArchiver{}, err
+	}
+	return MirrorStreamUnArchiver{
+		workingDir:   workingDir,
+		cacheStorage: cacheStorage,
+		reader:       reader,
+		decryption:   decryption,
+	}, nil
+}
+
 // Unarchive extracts:
-// * docker/v2* to cacheDir
+// * docker/v2* to the cache
 // * working-dir to workingDir
 func (o MirrorUnArchiver) Unarchive() error {
 	for _, chunkPath := range o.archiveFiles {
@@ -52,82 +83,162 @@ func (o MirrorUnArchiver) Unarchive() error {
 			return fmt.Errorf("%w", err)
 		}
 		defer chunkFile.Close()
-		reader := tar.NewReader(chunkFile)
-		// make sure workingDir exists
-		err = os.MkdirAll(o.workingDir, 0755)
+		chunkReader, err := o.decryption.decrypt(chunkFile, chunkFile.Name())
 		if err != nil {
-			return fmt.Errorf(errMessageFolder, o.workingDir, err)
+			return err
 		}
-		// make sure cacheDir exists
-		err = os.MkdirAll(o.cacheDir, 0755)
+		err = extractTar(tar.NewReader(chunkReader), chunkFile.Name(), o.generation, o.workingDir, o.cacheStorage)
 		if err != nil {
-			return fmt.Errorf(errMessageFolder, o.cacheDir, err)
+			return err
 		}
-		for {
-			header, err := reader.Next()
+		if err := drain(chunkReader, chunkFile.Name()); err != nil {
+			return err
+		}
+	}
 
-			// break the infinite loop when EOF
-			if errors.Is(err, io.EOF) {
-				break
-			}
+	return nil
+}
 
-			if err != nil {
-				return fmt.Errorf("error reading archive %s: %w", chunkFile.Name(), err)
-			}
+// Unarchive extracts the whole stream:
+// * docker/v2* to the cache
+// * working-dir to workingDir
+// chunk boundary markers present in the stream are ignored
+func (o MirrorStreamUnArchiver) Unarchive() error {
+	streamReader, err := o.decryption.decrypt(o.reader, "stream")
+	if err != nil {
+		return err
+	}
+	err = extractTar(tar.NewReader(streamReader), "stream", noGeneration, o.workingDir, o.cacheStorage)
+	if err != nil {
+		return err
+	}
+	return drain(streamReader, "stream")
+}
 
-			if header == nil {
-				continue
+// extractTar extracts the regular files of the tar `reader` that belong
+// to the working-dir or to the cache. `source` is only used for error messages.
+// When the tar contains a generation marker, it must match `generation`.
+func extractTar(reader *tar.Reader, source string, generation int, workingDir string, cacheStorage driver.StorageDriver) error {
+	// make sure workingDir exists
+	err := os.MkdirAll(workingDir, 0755)
+	if err != nil {
+		return fmt.Errorf(errMessageFolder, workingDir, err)
+	}
+	for {
+		header, err := reader.Next()
+
+		// break the infinite loop when EOF
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("error reading archive %s: %w", source, err)
+		}
+
+		if header == nil {
+			continue
+		}
+		// taking only files into account
+		// because we are considering that all parent folders will be
+		// created recursively, and that, to the best of our knowledge
+		// the archive doesn't include any symbolic links
+
+		// for the moment we ignore imageSetConfig that is
+		// included in the tar
+		// as well as any other files that are not
+		// working-dir or cache (i.e chunk boundary markers)
+
+		if strings.HasPrefix(header.Name, generationMarkerPrefix) {
+			if err := checkGenerationMarker(header.Name, source, generation); err != nil {
+				return err
 			}
-			// taking only files into account
-			// because we are considering that all parent folders will be
-			// created recursively, and that, to the best of our knowledge
-			// the archive doesn't include any symbolic links
-
-			// for the moment we ignore imageSetConfig that is
-			// included in the tar
-			// as well as any other files that are not
-			// working-dir or cache
-
-			if header.Typeflag == tar.TypeReg {
-				descriptor := ""
-				// case file belongs to working-dir
-				// nolint: gocritic
-				if strings.Contains(header.Name, workingDirectory) {
-					workingDirParent := filepath.Dir(o.workingDir)
-					// #nosec G305
-					descriptor = filepath.Join(workingDirParent, header.Name)
-				} else if strings.Contains(header.Name, cacheFilePrefix) {
-					// case file belongs to the cache
-					// #nosec G305
-					descriptor = filepath.Join(o.cacheDir, header.Name)
-				} else {
-					continue
-				}
-				// make sure all the parent directories exist
-				descriptorParent := filepath.Dir(descriptor)
-				if err := os.MkdirAll(descriptorParent, 0755); err != nil {
-					return fmt.Errorf(errMessageFolder, descriptorParent, err)
+			continue
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			descriptor := ""
+			// case file belongs to working-dir
+			// nolint: gocritic
+			if strings.Contains(header.Name, workingDirectory) && !strings.HasPrefix(header.Name, cacheFilePrefix+"/") {
+				// the entries of a stream may come from anywhere: they must stay under working-dir
+				if path.Clean(header.Name) != header.Name || !strings.HasPrefix(header.Name, workingDirectory+"/") {
+					return fmt.Errorf("unexpected entry %s in archive %s", header.Name, source)
 				}
-				// if it's a file create it, making sure it's at least writable and executable by the user
-				// since with every UnArchive, we should be able to rewrite the file
-				// #nosec G115
-				f, err := os.OpenFile(descriptor, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode)|0755)
-				if err != nil {
-					return fmt.Errorf("unable to create file %s: %w", descriptor, err)
+				workingDirParent := filepath.Dir(workingDir)
+				descriptor = filepath.Join(workingDirParent, filepath.FromSlash(header.Name))
+			} else if strings.Contains(header.Name, cacheFilePrefix) {
+				// case file belongs to the cache: written through its storage driver,
+				// which doesn't prevent a path from escaping the cache (i.e. with ..)
+				if path.Clean(header.Name) != header.Name || !strings.HasPrefix(header.Name, cacheFilePrefix+"/") {
+					return fmt.Errorf("unexpected entry %s in archive %s", header.Name, source)
 				}
-				// copy  contents
-				// #nosec G110
-				if _, err := io.Copy(f, reader); err != nil { // #nosec G115
-					return fmt.Errorf("error copying file %s: %w", descriptor, err)
+				if err := writeCacheFile(cacheStorage, header.Name, reader, ""); err != nil {
+					return err
 				}
+				continue
+			} else {
+				continue
+			}
+			// make sure all the parent directories exist
+			descriptorParent := filepath.Dir(descriptor)
+			if err := os.MkdirAll(descriptorParent, 0755); err != nil {
+				return fmt.Errorf(errMessageFolder, descriptorParent, err)
+			}
+			// if it's a file create it, making sure it's at least writable and executable by the user
+			// since with every UnArchive, we should be able to rewrite the file
+			// #nosec G115
+			f, err := os.OpenFile(descriptor, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode)|0755)
+			if err != nil {
+				return fmt.Errorf("unable to create file %s: %w", descriptor, err)
+			}
+			// copy  contents
+			// #nosec G110
+			if _, err := io.Copy(f, reader); err != nil { // #nosec G115
+				return fmt.Errorf("error copying file %s: %w", descriptor, err)
+			}
 
-				// manually close here after each file operation; defering would cause each file close
-				// to wait until all operations have completed.
-				f.Close()
+			// manually close here after each file operation; defering would cause each file close
+			// to wait until all operations have completed.
+			f.Close()
 
-			}
 		}
 	}
+	return nil
+}
 
+// writeCacheFile writes the content of reader to the file name (docker/registry/v2/...) of the cache.
+// The content is staged under cacheUploadsDir and moved in place once complete: the cache may be
+// served by the local storage registry of another run at the same time, which must never read a partial blob.
+// When expected is set, the content must match this digest.
+func writeCacheFile(cacheStorage driver.StorageDriver, name string, reader io.Reader, expected digest.Digest) error {
+	ctx := context.Background()
+	staging := path.Join("/", cacheUploadsDir, uuid.NewString())
+	writer, err := cacheStorage.Writer(ctx, staging, false)
+	if err != nil {
+		return fmt.Errorf("unable to create cache file %s: %w", name, err)
+	}
+	defer writer.Close()
+	var verifier digest.Verifier
+	if expected != "" {
+		verifier = expected.Verifier()
+		reader = io.TeeReader(reader, verifier)
+	}
+	// #nosec G110
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Cancel(ctx)
+		return fmt.Errorf("error copying cache file %s: %w", name, err)
+	}
+	if err := writer.Commit(ctx); err != nil {
+		return fmt.Errorf("error copying cache file %s: %w", name, err)
+	}
+	if verifier != nil && !verifier.Verified() {
+		cacheStorage.Delete(ctx, staging)
+		return fmt.Errorf("cache file %s doesn't match its digest %s", name, expected)
+	}
+	if err := cacheStorage.Move(ctx, staging, "/"+name); err != nil {
+		cacheStorage.Delete(ctx, staging)
+		return fmt.Errorf("error copying cache file %s: %w", name, err)
+	}
 	return nil
 }
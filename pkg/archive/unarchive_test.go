@@ -0,0 +1,43 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnarchiveRejectsEscapingEntries(t *testing.T) {
+	type testCase struct {
+		caseName string
+		name     string
+	}
+	testCases := []testCase{
+		{caseName: "parent directory", name: cacheFilePrefix + "/../../../../escaped"},
+		{caseName: "not under the cache root", name: "x/" + cacheFilePrefix + "/escaped"},
+		{caseName: "working-dir parent directory", name: workingDirectory + "/../../../escaped"},
+		{caseName: "not under working-dir", name: "x/" + workingDirectory + "/../../../../escaped"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			root := t.TempDir()
+			cacheDir := filepath.Join(root, "a", "b", "cache")
+			buf := &bytes.Buffer{}
+			tw := tar.NewWriter(buf)
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: testCase.name, Typeflag: tar.TypeReg, Mode: 0644, Size: 4}))
+			_, err := tw.Write([]byte("data"))
+			require.NoError(t, err)
+			require.NoError(t, tw.Close())
+
+			extractor, err := NewStreamExtractor(buf, filepath.Join(root, "a", "b", workingDirectory), newTestCacheStorage(t, cacheDir), nil)
+			require.NoError(t, err)
+			err = extractor.Unarchive()
+			require.ErrorContains(t, err, "unexpected entry")
+			_, err = os.Stat(filepath.Join(root, "escaped"))
+			require.True(t, os.IsNotExist(err))
+		})
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
 	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/mirror"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/signature"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/spinners"
 	"github.com/vbauerster/mpb/v8"
 	"github.com/vbauerster/mpb/v8/decor"
@@ -42,12 +43,15 @@ type ChannelConcurrentBatch struct {
 	LogsDir       string
 	Mirror        mirror.MirrorInterface
 	MaxGoroutines int
+	// TrustPolicy, when set, reports the verification of the images copied from their registry
+	TrustPolicy *signature.TrustPolicy
 }
 
 type GoroutineResult struct {
-	err     *mirrorSchemaError
-	imgType v2alpha1.ImageType
-	img     v2alpha1.CopyImageSchema
+	err          *mirrorSchemaError
+	imgType      v2alpha1.ImageType
+	img          v2alpha1.CopyImageSchema
+	verification *signature.VerificationResult
 }
 
 func New(
@@ -73,6 +77,7 @@ func (o *ChannelConcurrentBatch) Worker(ctx context.Context, collectorSchema v2a
 	}
 
 	var errArray []mirrorSchemaError
+	verifications := []signature.VerificationResult{}
 
 	var m sync.RWMutex
 	var wg sync.WaitGroup
@@ -160,6 +165,11 @@ func (o *ChannelConcurrentBatch) Worker(ctx context.Context, collectorSchema v2a
 							if opts.IsCopy() {
 								// nolint: contextcheck
 								err = o.Mirror.Copy(timeoutCtx, img.Source, img.Destination, opts)
+								result.verification = o.verification(img, err, opts)
+								if err == nil && opts.SigstoreArtifacts {
+									// nolint: contextcheck
+									err = o.Mirror.CopySigstoreArtifacts(timeoutCtx, img.Source, img.Destination, opts)
+								}
 							} else {
 								// nolint: contextcheck
 								err = o.Mirror.Delete(timeoutCtx, img.Destination, opts)
@@ -196,6 +206,9 @@ func (o *ChannelConcurrentBatch) Worker(ctx context.Context, collectorSchema v2a
 	for completed < len(collectorSchema.AllImages) {
 		res := <-results
 		err := res.err
+		if res.verification != nil {
+			verifications = append(verifications, *res.verification)
+		}
 		if err == nil {
 			logImageSuccess(o.Log, &res.img, opts)
 			copiedImages.AllImages = append(copiedImages.AllImages, res.img)
@@ -220,6 +233,15 @@ func (o *ChannelConcurrentBatch) Worker(ctx context.Context, collectorSchema v2a
 	p.Wait()
 
 	logResults(o.Log, opts.Function, &copiedImages, &collectorSchema)
+	if len(verifications) > 0 {
+		logVerifications(o.Log, verifications)
+		filename, err := signature.SaveVerificationReport(o.LogsDir, verifications)
+		if err != nil {
+			o.Log.Error(workerPrefix+"unable to save the signature verification report: %s", err.Error())
+		} else {
+			o.Log.Info(emoji.Memo+" signature verification report saved in %s/%s", o.LogsDir, filename)
+		}
+	}
 
 	if len(errArray) > 0 {
 		filename, err := saveErrors(o.Log, o.LogsDir, errArray)
@@ -265,6 +287,36 @@ func logResults(log clog.PluggableLoggerInterface, copyMode string, copiedImages
 	logResult(log, copyMode, "helm", copiedImages.TotalHelmImages, collectorSchema.TotalHelmImages)
 }
 
+// verification returns the verification of img by the trust policy, when copied from its registry,
+// nil otherwise (i.e. copied from the cache, already verified)
+func (o *ChannelConcurrentBatch) verification(img v2alpha1.CopyImageSchema, err error, opts *common.MirrorOptions) *signature.VerificationResult {
+	if o.TrustPolicy == nil || !strings.HasPrefix(img.Source, "docker://") || strings.Contains(img.Source, opts.LocalStorageFQDN) {
+		return nil
+	}
+	verification := o.TrustPolicy.Verification(img.Source, err)
+	return &verification
+}
+
+func logVerifications(log clog.PluggableLoggerInterface, verifications []signature.VerificationResult) {
+	totals := map[string]int{}
+	for _, verification := range verifications {
+		totals[verification.Status]++
+	}
+	log.Info("=== Signature verification ===")
+	if totals[signature.VerificationVerified] > 0 {
+		log.Info(emoji.SpinnerCheckMark+" %d images verified", totals[signature.VerificationVerified])
+	}
+	if totals[signature.VerificationAccepted] > 0 {
+		log.Info(emoji.SpinnerCheckMark+" %d images accepted without verification", totals[signature.VerificationAccepted])
+	}
+	if totals[signature.VerificationRejected] > 0 {
+		log.Info(emoji.SpinnerCrossMark+" %d images rejected by the trust policy", totals[signature.VerificationRejected])
+	}
+	if totals[signature.VerificationFailed] > 0 {
+		log.Info(emoji.SpinnerCrossMark+" %d images failed to be copied", totals[signature.VerificationFailed])
+	}
+}
+
 func logResult(log clog.PluggableLoggerInterface, copyMode, imageType string, copied, total int) {
 	if total != 0 {
 		if copied == total {
@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// GCOptions are the options of the garbage collection of the cache
+type GCOptions struct {
+	// DryRun only reports what would be removed
+	DryRun bool
+	// KeepManifests, when not nil, lists the manifest digests to keep per repository:
+	// all other manifests are removed, along with the tags pointing to them.
+	// When nil, all manifests are kept and only the blobs they don't reference are removed.
+	KeepManifests map[string]map[digest.Digest]struct{}
+}
+
+// GCReport is the outcome of a garbage collection of the cache
+type GCReport struct {
+	DryRun         bool            `json:"dryRun"`
+	MarkedBlobs    int             `json:"markedBlobs"`
+	Blobs          []CollectedBlob `json:"blobs"`
+	Manifests      []Manifest      `json:"manifests"`
+	LayerLinks     int             `json:"layerLinks"`
+	ReclaimedBytes int64           `json:"reclaimedBytes"`
+}
+
+// CollectedBlob is a blob removed (or to remove, in dry run) from the cache
+type CollectedBlob struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// Manifest is a manifest of a repository of the cache, and the tags pointing to it
+type Manifest struct {
+	Repository string   `json:"repository"`
+	Digest     string   `json:"digest"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+// GarbageCollect runs a mark and sweep over the cache stored as described by storageConfig,
+// through its distribution storage driver:
+// the blobs referenced by the manifests kept are marked, all the others are swept.
+// The cache must not be in use by a local storage instance while it is collected.
+func GarbageCollect(ctx context.Context, storageConfig StorageConfig, opts GCOptions) (GCReport, error) {
+	storageDriver, registry, err := openCache(ctx, storageConfig)
+	if err != nil {
+		return GCReport{}, err
+	}
+	collector := garbageCollector{
+		registry:   registry,
+		vacuum:     storage.NewVacuum(ctx, storageDriver),
+		opts:       opts,
+		marked:     map[digest.Digest]struct{}{},
+		layerLinks: map[string][]digest.Digest{},
+		report:     GCReport{DryRun: opts.DryRun, Blobs: []CollectedBlob{}, Manifests: []Manifest{}},
+	}
+	if err := collector.mark(ctx); err != nil {
+		return GCReport{}, fmt.Errorf("unable to mark the content of the cache : %w", err)
+	}
+	if err := collector.sweep(ctx); err != nil {
+		return collector.report, fmt.Errorf("unable to sweep the content of the cache : %w", err)
+	}
+	return collector.report, nil
+}
+
+type garbageCollector struct {
+	registry distribution.Namespace
+	vacuum   storage.Vacuum
+	opts     GCOptions
+	// blobs referenced by the manifests kept, in any repository
+	marked map[digest.Digest]struct{}
+	// layer links not referenced by the manifests kept in their repository
+	layerLinks map[string][]digest.Digest
+	report     GCReport
+}
+
+// mark marks the blobs referenced by the manifests kept in each repository, and collects
+// the manifests not kept and the unreferenced layer links
+func (o *garbageCollector) mark(ctx context.Context) error {
+	enumerator, ok := o.registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to enumerate the repositories of the cache")
+	}
+	// nolint: wrapcheck
+	return enumerator.Enumerate(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("invalid repository name %s : %w", repoName, err)
+		}
+		repo, err := o.registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		manifests, err := repo.Manifests(ctx)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		digests, err := listManifests(ctx, repoName, manifests)
+		if err != nil {
+			return err
+		}
+
+		repoMarked := map[digest.Digest]struct{}{}
+		for _, dgst := range digests {
+			if o.keep(repoName, dgst) {
+				if err := markManifest(ctx, manifests, dgst, repoMarked); err != nil {
+					return err
+				}
+			}
+		}
+		for _, dgst := range digests {
+			if _, ok := repoMarked[dgst]; ok {
+				continue
+			}
+			tags, err := repo.Tags(ctx).Lookup(ctx, v1.Descriptor{Digest: dgst})
+			if err != nil {
+				return fmt.Errorf("unable to find the tags of %s@%s : %w", repoName, dgst, err)
+			}
+			sort.Strings(tags)
+			o.report.Manifests = append(o.report.Manifests, Manifest{Repository: repoName, Digest: dgst.String(), Tags: tags})
+		}
+		for dgst := range repoMarked {
+			o.marked[dgst] = struct{}{}
+		}
+
+		layerEnumerator, ok := repo.Blobs(ctx).(distribution.ManifestEnumerator)
+		if !ok {
+			return fmt.Errorf("unable to enumerate the layers of %s", repoName)
+		}
+		err = layerEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+			if _, ok := repoMarked[dgst]; !ok {
+				o.layerLinks[repoName] = append(o.layerLinks[repoName], dgst)
+			}
+			return nil
+		})
+		if err != nil && !errors.As(err, &driver.PathNotFoundError{}) {
+			return fmt.Errorf("%w", err)
+		}
+		return nil
+	})
+}
+
+// openCache opens the cache stored as described by storageConfig, through its distribution storage driver
+// nolint: ireturn
+func openCache(ctx context.Context, storageConfig StorageConfig) (driver.StorageDriver, distribution.Namespace, error) {
+	storageDriver, err := storageConfig.Open(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	registry, err := storage.NewRegistry(ctx, storageDriver, storage.EnableDelete)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to open the cache %s : %w", storageConfig, err)
+	}
+	return storageDriver, registry, nil
+}
+
+// listManifests returns the digests of all the manifests of the repository repoName
+func listManifests(ctx context.Context, repoName string, manifests distribution.ManifestService) ([]digest.Digest, error) {
+	manifestEnumerator, ok := manifests.(distribution.ManifestEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to enumerate the manifests of %s", repoName)
+	}
+	digests := []digest.Digest{}
+	err := manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		digests = append(digests, dgst)
+		return nil
+	})
+	// a repository without _manifests folder (unfinished upload...) has no manifest
+	if err != nil && !errors.As(err, &driver.PathNotFoundError{}) {
+		return nil, fmt.Errorf("unable to enumerate the manifests of %s : %w", repoName, err)
+	}
+	return digests, nil
+}
+
+// keep checks whether the manifest dgst of repoName is a root of the marking
+func (o *garbageCollector) keep(repoName string, dgst digest.Digest) bool {
+	if o.opts.KeepManifests == nil {
+		return true
+	}
+	_, ok := o.opts.KeepManifests[repoName][dgst]
+	return ok
+}
+
+// markManifest marks the manifest dgst, and all the blobs and manifests it references, recursively
+func markManifest(ctx context.Context, manifests distribution.ManifestService, dgst digest.Digest, marked map[digest.Digest]struct{}) error {
+	if _, ok := marked[dgst]; ok {
+		return nil
+	}
+	marked[dgst] = struct{}{}
+	manifest, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		return fmt.Errorf("unable to read manifest %s : %w", dgst, err)
+	}
+	for _, descriptor := range manifest.References() {
+		if _, ok := marked[descriptor.Digest]; ok {
+			continue
+		}
+		if exists, _ := manifests.Exists(ctx, descriptor.Digest); exists {
+			if err := markManifest(ctx, manifests, descriptor.Digest, marked); err != nil {
+				return err
+			}
+			continue
+		}
+		marked[descriptor.Digest] = struct{}{}
+	}
+	return nil
+}
+
+// sweep removes the manifests not kept, the blobs not marked, and the unreferenced layer links.
+// In dry run, it only computes the report.
+func (o *garbageCollector) sweep(ctx context.Context) error {
+	err := o.registry.Blobs().Enumerate(ctx, func(dgst digest.Digest) error {
+		if _, ok := o.marked[dgst]; ok {
+			return nil
+		}
+		descriptor, err := o.registry.BlobStatter().Stat(ctx, dgst)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		o.report.Blobs = append(o.report.Blobs, CollectedBlob{Digest: dgst.String(), Size: descriptor.Size})
+		o.report.ReclaimedBytes += descriptor.Size
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to enumerate the blobs of the cache : %w", err)
+	}
+	o.report.MarkedBlobs = len(o.marked)
+	for _, links := range o.layerLinks {
+		o.report.LayerLinks += len(links)
+	}
+	sort.Slice(o.report.Blobs, func(i, j int) bool { return o.report.Blobs[i].Digest < o.report.Blobs[j].Digest })
+	if o.opts.DryRun {
+		return nil
+	}
+
+	for _, manifest := range o.report.Manifests {
+		if err := o.removeManifest(ctx, manifest); err != nil {
+			return err
+		}
+	}
+	for _, blob := range o.report.Blobs {
+		if err := o.vacuum.RemoveBlob(blob.Digest); err != nil {
+			return fmt.Errorf("unable to remove blob %s : %w", blob.Digest, err)
+		}
+	}
+	for repoName, links := range o.layerLinks {
+		for _, dgst := range links {
+			if err := o.vacuum.RemoveLayer(repoName, dgst); err != nil {
+				return fmt.Errorf("unable to remove layer link %s of %s : %w", dgst, repoName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeManifest removes the tags pointing to the manifest, then the manifest revision
+// and all the tag index entries referencing it
+func (o *garbageCollector) removeManifest(ctx context.Context, manifest Manifest) error {
+	named, err := reference.WithName(manifest.Repository)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	repo, err := o.registry.Repository(ctx, named)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	for _, tag := range manifest.Tags {
+		if err := repo.Tags(ctx).Untag(ctx, tag); err != nil {
+			return fmt.Errorf("unable to remove tag %s:%s : %w", manifest.Repository, tag, err)
+		}
+	}
+	allTags, err := repo.Tags(ctx).All(ctx)
+	if err != nil && !errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		return fmt.Errorf("unable to list the tags of %s : %w", manifest.Repository, err)
+	}
+	if err := o.vacuum.RemoveManifest(manifest.Repository, digest.Digest(manifest.Digest), append(allTags, manifest.Tags...)); err != nil {
+		return fmt.Errorf("unable to remove manifest %s@%s : %w", manifest.Repository, manifest.Digest, err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	type testCase struct {
+		caseName          string
+		dryRun            bool
+		keepOnlyFirst     bool
+		expectedManifests int
+		expectedBlobs     int
+	}
+	testCases := []testCase{
+		{
+			caseName:      "dry run should report unreferenced blobs without removing them",
+			dryRun:        true,
+			expectedBlobs: 1,
+		},
+		{
+			caseName:      "unreferenced blobs should be removed",
+			expectedBlobs: 1,
+		},
+		{
+			caseName:          "manifests not kept should be removed with their blobs",
+			keepOnlyFirst:     true,
+			expectedManifests: 1,
+			// the orphan blob, the manifest and the layer of the second image (the config is shared)
+			expectedBlobs: 3,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			ctx := context.Background()
+			cacheDir := t.TempDir()
+			registry := newTestRegistry(t, cacheDir)
+			first := putTestImage(t, registry, "ns/img", "v1", "layer-1")
+			putTestImage(t, registry, "ns/img", "v2", "layer-2")
+			orphan := putTestBlob(t, registry, "ns/orphan", "orphan")
+
+			opts := GCOptions{DryRun: testCase.dryRun}
+			if testCase.keepOnlyFirst {
+				opts.KeepManifests = map[string]map[digest.Digest]struct{}{"ns/img": {first: {}}}
+			}
+			report, err := GarbageCollect(ctx, testStorageConfig(t, cacheDir), opts)
+			require.NoError(t, err)
+			require.Len(t, report.Manifests, testCase.expectedManifests)
+			require.Len(t, report.Blobs, testCase.expectedBlobs)
+			require.Contains(t, report.Blobs, CollectedBlob{Digest: orphan.Digest.String(), Size: orphan.Size})
+			require.Positive(t, report.ReclaimedBytes)
+
+			_, err = registry.BlobStatter().Stat(ctx, orphan.Digest)
+			if testCase.dryRun {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, distribution.ErrBlobUnknown)
+			}
+			_, err = registry.BlobStatter().Stat(ctx, first)
+			require.NoError(t, err)
+
+			// a second collection has nothing left to remove
+			if !testCase.dryRun {
+				report, err = GarbageCollect(ctx, testStorageConfig(t, cacheDir), opts)
+				require.NoError(t, err)
+				require.Empty(t, report.Blobs)
+				require.Empty(t, report.Manifests)
+			}
+		})
+	}
+}
+
+func testStorageConfig(t *testing.T, cacheDir string) StorageConfig {
+	storageConfig, err := NewStorageConfig(FilesystemDriver, cacheDir, nil)
+	require.NoError(t, err)
+	return storageConfig
+}
+
+func newTestRegistry(t *testing.T, cacheDir string) distribution.Namespace {
+	storageDriver, err := filesystem.FromParameters(map[string]interface{}{"rootdirectory": cacheDir})
+	require.NoError(t, err)
+	registry, err := storage.NewRegistry(context.Background(), storageDriver, storage.EnableDelete)
+	require.NoError(t, err)
+	return registry
+}
+
+func putTestBlob(t *testing.T, registry distribution.Namespace, repoName, content string) v1.Descriptor {
+	ctx := context.Background()
+	named, err := reference.WithName(repoName)
+	require.NoError(t, err)
+	repo, err := registry.Repository(ctx, named)
+	require.NoError(t, err)
+	descriptor, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageLayer, []byte(content))
+	require.NoError(t, err)
+	return descriptor
+}
+
+func putTestImage(t *testing.T, registry distribution.Namespace, repoName, tag, layerContent string) digest.Digest {
+	ctx := context.Background()
+	config := putTestBlob(t, registry, repoName, "{}")
+	config.MediaType = v1.MediaTypeImageConfig
+	layer := putTestBlob(t, registry, repoName, layerContent)
+	manifest, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []v1.Descriptor{layer},
+	})
+	require.NoError(t, err)
+	named, err := reference.WithName(repoName)
+	require.NoError(t, err)
+	repo, err := registry.Repository(ctx, named)
+	require.NoError(t, err)
+	manifests, err := repo.Manifests(ctx)
+	require.NoError(t, err)
+	dgst, err := manifests.Put(ctx, manifest)
+	require.NoError(t, err)
+	require.NoError(t, repo.Tags(ctx).Tag(ctx, tag, v1.Descriptor{Digest: dgst, MediaType: v1.MediaTypeImageManifest}))
+	return dgst
+}
@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// ManifestReferenced is the status of a manifest mirrored by the image set configuration
+	ManifestReferenced = "referenced"
+	// ManifestOrphaned is the status of a manifest not mirrored by the image set configuration
+	ManifestOrphaned = "orphaned"
+)
+
+// Stats describes the content of the cache
+type Stats struct {
+	CacheDir     string            `json:"cacheDir"`
+	Repositories []RepositoryStats `json:"repositories"`
+	// Blobs and TotalBytes count each blob stored in the cache once
+	Blobs      int   `json:"blobs"`
+	TotalBytes int64 `json:"totalBytes"`
+	// SharedBlobs are the blobs referenced by more than one repository
+	SharedBlobs int   `json:"sharedBlobs"`
+	SharedBytes int64 `json:"sharedBytes"`
+	// SharedRatio is the share of TotalBytes referenced by more than one repository
+	SharedRatio float64 `json:"sharedRatio"`
+	// UnreferencedBlobs are the blobs no manifest references: see cache gc
+	UnreferencedBlobs int   `json:"unreferencedBlobs"`
+	UnreferencedBytes int64 `json:"unreferencedBytes"`
+}
+
+// RepositoryStats describes a repository of the cache
+type RepositoryStats struct {
+	Name      string          `json:"name"`
+	Manifests []ManifestStats `json:"manifests"`
+	// Blobs and TotalBytes count the blobs referenced by the manifests of the repository, manifests included
+	Blobs      int   `json:"blobs"`
+	TotalBytes int64 `json:"totalBytes"`
+	// UniqueBytes are the bytes of the blobs referenced by this repository only
+	UniqueBytes int64 `json:"uniqueBytes"`
+	// SharedRatio is the share of TotalBytes also referenced by other repositories
+	SharedRatio float64 `json:"sharedRatio"`
+}
+
+// ManifestStats describes a manifest of a repository, and the tags pointing to it
+type ManifestStats struct {
+	Digest string   `json:"digest"`
+	Tags   []string `json:"tags,omitempty"`
+	// Status is ManifestReferenced or ManifestOrphaned when compared to an image set configuration
+	Status string `json:"status,omitempty"`
+}
+
+// Inspect enumerates the repositories, manifests and tags of the cache stored as described by
+// storageConfig, and computes the sizes of the blobs they reference.
+// When referenced is not nil, each manifest is flagged as referenced when it is listed
+// for its repository (or belongs to a manifest list listed), as orphaned otherwise.
+func Inspect(ctx context.Context, storageConfig StorageConfig, referenced map[string]map[digest.Digest]struct{}) (Stats, error) {
+	_, registry, err := openCache(ctx, storageConfig)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{CacheDir: storageConfig.String(), Repositories: []RepositoryStats{}}
+	sizes := map[digest.Digest]int64{}
+	err = registry.Blobs().Enumerate(ctx, func(dgst digest.Digest) error {
+		descriptor, err := registry.BlobStatter().Stat(ctx, dgst)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		sizes[dgst] = descriptor.Size
+		stats.Blobs++
+		stats.TotalBytes += descriptor.Size
+		return nil
+	})
+	if err != nil {
+		return Stats{}, fmt.Errorf("unable to enumerate the blobs of the cache : %w", err)
+	}
+
+	enumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return Stats{}, fmt.Errorf("unable to enumerate the repositories of the cache")
+	}
+	// repositories referencing each blob
+	blobRepositories := map[digest.Digest]int{}
+	repoBlobs := map[string]map[digest.Digest]struct{}{}
+	err = enumerator.Enumerate(ctx, func(repoName string) error {
+		repoStats, blobs, err := inspectRepository(ctx, registry, repoName, referenced)
+		if err != nil {
+			return err
+		}
+		for dgst := range blobs {
+			blobRepositories[dgst]++
+		}
+		repoBlobs[repoName] = blobs
+		stats.Repositories = append(stats.Repositories, repoStats)
+		return nil
+	})
+	if err != nil {
+		return Stats{}, fmt.Errorf("unable to inspect the repositories of the cache : %w", err)
+	}
+
+	for i := range stats.Repositories {
+		repoStats := &stats.Repositories[i]
+		sharedBytes := int64(0)
+		for dgst := range repoBlobs[repoStats.Name] {
+			size, stored := sizes[dgst]
+			if !stored {
+				// referenced, but not stored in the cache
+				continue
+			}
+			repoStats.Blobs++
+			repoStats.TotalBytes += size
+			if blobRepositories[dgst] > 1 {
+				sharedBytes += size
+			} else {
+				repoStats.UniqueBytes += size
+			}
+		}
+		repoStats.SharedRatio = ratio(sharedBytes, repoStats.TotalBytes)
+	}
+	for dgst, size := range sizes {
+		if blobRepositories[dgst] == 0 {
+			stats.UnreferencedBlobs++
+			stats.UnreferencedBytes += size
+		} else if blobRepositories[dgst] > 1 {
+			stats.SharedBlobs++
+			stats.SharedBytes += size
+		}
+	}
+	stats.SharedRatio = ratio(stats.SharedBytes, stats.TotalBytes)
+	sort.Slice(stats.Repositories, func(i, j int) bool { return stats.Repositories[i].Name < stats.Repositories[j].Name })
+	return stats, nil
+}
+
+// inspectRepository lists the manifests of the repository repoName and their tags,
+// and returns the blobs they reference, manifests included
+func inspectRepository(ctx context.Context, registry distribution.Namespace, repoName string, referenced map[string]map[digest.Digest]struct{}) (RepositoryStats, map[digest.Digest]struct{}, error) {
+	repoStats := RepositoryStats{Name: repoName, Manifests: []ManifestStats{}}
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return repoStats, nil, fmt.Errorf("invalid repository name %s : %w", repoName, err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		return repoStats, nil, fmt.Errorf("%w", err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return repoStats, nil, fmt.Errorf("%w", err)
+	}
+	digests, err := listManifests(ctx, repoName, manifests)
+	if err != nil {
+		return repoStats, nil, err
+	}
+
+	tagsByDigest := map[digest.Digest][]string{}
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil && !errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		return repoStats, nil, fmt.Errorf("unable to list the tags of %s : %w", repoName, err)
+	}
+	for _, tag := range tags {
+		descriptor, err := repo.Tags(ctx).Get(ctx, tag)
+		if err != nil {
+			return repoStats, nil, fmt.Errorf("unable to read tag %s:%s : %w", repoName, tag, err)
+		}
+		tagsByDigest[descriptor.Digest] = append(tagsByDigest[descriptor.Digest], tag)
+	}
+
+	// the manifests referenced, along with the manifests they reference (manifest lists)
+	repoReferenced := map[digest.Digest]struct{}{}
+	for dgst := range referenced[repoName] {
+		if exists, _ := manifests.Exists(ctx, dgst); exists {
+			if err := markManifest(ctx, manifests, dgst, repoReferenced); err != nil {
+				return repoStats, nil, err
+			}
+		}
+	}
+
+	blobs := map[digest.Digest]struct{}{}
+	for _, dgst := range digests {
+		if err := markManifest(ctx, manifests, dgst, blobs); err != nil {
+			return repoStats, nil, err
+		}
+		manifestStats := ManifestStats{Digest: dgst.String(), Tags: tagsByDigest[dgst]}
+		sort.Strings(manifestStats.Tags)
+		if referenced != nil {
+			manifestStats.Status = ManifestOrphaned
+			if _, ok := repoReferenced[dgst]; ok {
+				manifestStats.Status = ManifestReferenced
+			}
+		}
+		repoStats.Manifests = append(repoStats.Manifests, manifestStats)
+	}
+	return repoStats, blobs, nil
+}
+
+func ratio(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total)
+}
@@ -0,0 +1,39 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	cacheDir := t.TempDir()
+	registry := newTestRegistry(t, cacheDir)
+	first := putTestImage(t, registry, "ns/img", "v1", "layer-1")
+	other := putTestImage(t, registry, "ns/other", "v1", "layer-2")
+	orphan := putTestBlob(t, registry, "ns/orphan", "orphan")
+
+	stats, err := Inspect(context.Background(), testStorageConfig(t, cacheDir), map[string]map[digest.Digest]struct{}{"ns/img": {first: {}}})
+	require.NoError(t, err)
+
+	// ns/orphan has no manifest: it is not a repository yet
+	require.Len(t, stats.Repositories, 2)
+	img := stats.Repositories[0]
+	require.Equal(t, "ns/img", img.Name)
+	require.Equal(t, []ManifestStats{{Digest: first.String(), Tags: []string{"v1"}, Status: ManifestReferenced}}, img.Manifests)
+	// manifest, config and layer
+	require.Equal(t, 3, img.Blobs)
+	// the config is shared with ns/other
+	require.Equal(t, img.TotalBytes-2, img.UniqueBytes)
+
+	require.Equal(t, []ManifestStats{{Digest: other.String(), Tags: []string{"v1"}, Status: ManifestOrphaned}}, stats.Repositories[1].Manifests)
+
+	// 2 manifests, 1 shared config, 2 layers and the orphan blob
+	require.Equal(t, 6, stats.Blobs)
+	require.Equal(t, 1, stats.SharedBlobs)
+	require.Equal(t, int64(2), stats.SharedBytes)
+	require.Equal(t, 1, stats.UnreferencedBlobs)
+	require.Equal(t, orphan.Size, stats.UnreferencedBytes)
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/s3-aws"
+)
+
+const (
+	// FilesystemDriver stores the cache in a local directory (the default)
+	FilesystemDriver = "filesystem"
+	// InMemoryDriver stores the cache in memory, for the duration of the run
+	InMemoryDriver = "inmemory"
+	// S3Driver stores the cache in an S3 compatible bucket (AWS, MinIO...)
+	S3Driver = "s3"
+
+	// names of the drivers in the distribution factory
+	s3RegistryDriver       = "s3aws"
+	sharedInMemoryRegistry = "oc-mirror-inmemory"
+)
+
+// StorageDrivers are the storage drivers supported for the cache
+var StorageDrivers = []string{FilesystemDriver, InMemoryDriver, S3Driver}
+
+// the in-memory cache shared by the local storage registry and the direct accesses
+// to the cache (archive, gc...) of the run
+var (
+	sharedInMemory     *inmemory.Driver
+	sharedInMemoryOnce sync.Once
+)
+
+func init() {
+	factory.Register(sharedInMemoryRegistry, sharedInMemoryFactory{})
+}
+
+type sharedInMemoryFactory struct{}
+
+// nolint: ireturn
+func (sharedInMemoryFactory) Create(ctx context.Context, parameters map[string]interface{}) (driver.StorageDriver, error) {
+	sharedInMemoryOnce.Do(func() {
+		sharedInMemory = inmemory.New()
+	})
+	return sharedInMemory, nil
+}
+
+// StorageConfig selects the storage driver of the cache, and its parameters
+type StorageConfig struct {
+	// Driver is one of StorageDrivers
+	Driver string
+	// Parameters are the parameters of the distribution storage driver
+	Parameters map[string]interface{}
+}
+
+// NewStorageConfig returns the storage configuration of the cache: the filesystem driver
+// stores the cache under rootDirectory, the other drivers are configured by params only
+// (see the distribution documentation of the s3 storage driver for its parameters).
+func NewStorageConfig(driverName, rootDirectory string, params map[string]string) (StorageConfig, error) {
+	config := StorageConfig{Driver: driverName, Parameters: map[string]interface{}{}}
+	for key, value := range params {
+		config.Parameters[key] = value
+	}
+	switch driverName {
+	case "", FilesystemDriver:
+		config.Driver = FilesystemDriver
+		if len(params) > 0 {
+			return StorageConfig{}, fmt.Errorf("the %s cache driver doesn't accept any parameter: use the cache directory instead", FilesystemDriver)
+		}
+		config.Parameters["rootdirectory"] = rootDirectory
+	case InMemoryDriver:
+		if len(params) > 0 {
+			return StorageConfig{}, fmt.Errorf("the %s cache driver doesn't accept any parameter", InMemoryDriver)
+		}
+	case S3Driver:
+		if params["bucket"] == "" {
+			return StorageConfig{}, fmt.Errorf("the %s cache driver requires the bucket parameter", S3Driver)
+		}
+		if params["region"] == "" && params["regionendpoint"] == "" {
+			return StorageConfig{}, fmt.Errorf("the %s cache driver requires the region or the regionendpoint parameter", S3Driver)
+		}
+	default:
+		return StorageConfig{}, fmt.Errorf("unsupported cache driver %s: use one of %s", driverName, strings.Join(StorageDrivers, ", "))
+	}
+	return config, nil
+}
+
+// RegistryDriver is the name of the driver in the storage section of a distribution configuration
+func (o StorageConfig) RegistryDriver() string {
+	switch o.Driver {
+	case InMemoryDriver:
+		return sharedInMemoryRegistry
+	case S3Driver:
+		return s3RegistryDriver
+	default:
+		return o.Driver
+	}
+}
+
+// IsPersistent checks whether the cache outlives the run
+func (o StorageConfig) IsPersistent() bool {
+	return o.Driver != InMemoryDriver
+}
+
+// String describes the location of the cache, for the logs and reports
+func (o StorageConfig) String() string {
+	switch o.Driver {
+	case FilesystemDriver:
+		return fmt.Sprint(o.Parameters["rootdirectory"])
+	case S3Driver:
+		location := fmt.Sprintf("s3://%v", o.Parameters["bucket"])
+		if rootDirectory, ok := o.Parameters["rootdirectory"]; ok {
+			location += "/" + strings.TrimPrefix(fmt.Sprint(rootDirectory), "/")
+		}
+		return location
+	default:
+		return o.Driver
+	}
+}
+
+// Open returns the storage driver giving access to the content of the cache.
+// With InMemoryDriver, it is the same instance as the one of the local storage registry.
+// nolint: ireturn
+func (o StorageConfig) Open(ctx context.Context) (driver.StorageDriver, error) {
+	storageDriver, err := factory.Create(ctx, o.RegistryDriver(), o.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the cache %s : %w", o, err)
+	}
+	return storageDriver, nil
+}
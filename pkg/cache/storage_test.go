@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStorageConfig(t *testing.T) {
+	type testCase struct {
+		caseName         string
+		driver           string
+		params           map[string]string
+		expectedConfig   StorageConfig
+		expectedLocation string
+		expectedError    string
+	}
+	testCases := []testCase{
+		{
+			caseName:         "default driver is filesystem",
+			driver:           "",
+			expectedConfig:   StorageConfig{Driver: FilesystemDriver, Parameters: map[string]interface{}{"rootdirectory": "/cache"}},
+			expectedLocation: "/cache",
+		},
+		{
+			caseName:      "filesystem doesn't accept parameters",
+			driver:        FilesystemDriver,
+			params:        map[string]string{"rootdirectory": "/elsewhere"},
+			expectedError: "doesn't accept any parameter",
+		},
+		{
+			caseName:         "inmemory",
+			driver:           InMemoryDriver,
+			expectedConfig:   StorageConfig{Driver: InMemoryDriver, Parameters: map[string]interface{}{}},
+			expectedLocation: InMemoryDriver,
+		},
+		{
+			caseName: "s3 with a region endpoint",
+			driver:   S3Driver,
+			params:   map[string]string{"bucket": "oc-mirror", "regionendpoint": "http://minio:9000", "rootdirectory": "/mirror1"},
+			expectedConfig: StorageConfig{Driver: S3Driver, Parameters: map[string]interface{}{
+				"bucket": "oc-mirror", "regionendpoint": "http://minio:9000", "rootdirectory": "/mirror1",
+			}},
+			expectedLocation: "s3://oc-mirror/mirror1",
+		},
+		{
+			caseName:      "s3 without bucket",
+			driver:        S3Driver,
+			params:        map[string]string{"region": "us-east-1"},
+			expectedError: "requires the bucket parameter",
+		},
+		{
+			caseName:      "s3 without region",
+			driver:        S3Driver,
+			params:        map[string]string{"bucket": "oc-mirror"},
+			expectedError: "requires the region or the regionendpoint parameter",
+		},
+		{
+			caseName:      "unsupported driver",
+			driver:        "azure",
+			expectedError: "unsupported cache driver azure",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			config, err := NewStorageConfig(testCase.driver, "/cache", testCase.params)
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedConfig, config)
+			require.Equal(t, testCase.expectedLocation, config.String())
+		})
+	}
+}
+
+func TestOpenInMemoryIsShared(t *testing.T) {
+	ctx := context.Background()
+	config, err := NewStorageConfig(InMemoryDriver, "", nil)
+	require.NoError(t, err)
+	require.False(t, config.IsPersistent())
+
+	first, err := config.Open(ctx)
+	require.NoError(t, err)
+	require.NoError(t, first.PutContent(ctx, "/docker/registry/v2/blobs/data", []byte("blob")))
+
+	// the local storage registry and the archive see the same content during the run
+	second, err := config.Open(ctx)
+	require.NoError(t, err)
+	content, err := second.GetContent(ctx, "/docker/registry/v2/blobs/data")
+	require.NoError(t, err)
+	require.Equal(t, []byte("blob"), content)
+}
@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/archive"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+type ArchiveInspectController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+	Out     io.Writer
+}
+
+func NewArchiveInspectController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) ArchiveInspectController {
+	return ArchiveInspectController{
+		Log:     log,
+		Options: opts,
+		Out:     os.Stdout,
+	}
+}
+
+// Process inspects the archive found in the folder passed as argument,
+// and prints a summary of its content (text or json)
+func (o ArchiveInspectController) Process(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("archive inspect expects exactly one argument: the folder containing the archive")
+	}
+	if err := checkOutputFormat(o.Options.OutputFormat); err != nil {
+		return err
+	}
+	archivePath := strings.TrimPrefix(args[0], fileProtocol)
+	inspector, err := archive.NewArchiveInspector(archivePath, o.Options.ArchiveIdentities)
+	if err != nil {
+		return err
+	}
+	inspection, err := inspector.Inspect()
+	if err != nil {
+		return err
+	}
+	if o.Options.OutputFormat == jsonOutput {
+		encoder := json.NewEncoder(o.Out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(inspection)
+	}
+	return o.printInspection(inspection)
+}
+
+func (o ArchiveInspectController) printInspection(inspection archive.ArchiveInspection) error {
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Archive:\t%s (generation %d)\n", inspection.ArchivePath, inspection.Generation)
+	if inspection.ImageSetConfiguration != nil {
+		fmt.Fprintf(w, "Image set configuration:\t%s\n", inspection.ImageSetConfiguration.Name)
+	}
+	since, until := "full archive (no previous history)", "-"
+	if inspection.HistoryWindow.Since != nil {
+		since = inspection.HistoryWindow.Since.Format(time.RFC3339)
+	}
+	if inspection.HistoryWindow.Until != nil {
+		until = inspection.HistoryWindow.Until.Format(time.RFC3339)
+	}
+	fmt.Fprintf(w, "History window:\t%s -> %s\n", since, until)
+	fmt.Fprintf(w, "Blobs:\t%d (%s)\n", inspection.BlobCount, humanSize(inspection.TotalBlobBytes))
+
+	fmt.Fprintln(w, "\nCHUNK\tSIZE\tENCRYPTED")
+	for _, chunk := range inspection.Chunks {
+		fmt.Fprintf(w, "%s\t%s\t%t\n", chunk.Name, humanSize(chunk.Size), chunk.Encrypted)
+	}
+
+	fmt.Fprintln(w, "\nREPOSITORY\tTAGS\tDIGESTS")
+	for _, repo := range inspection.Repositories {
+		tags := make([]string, 0, len(repo.Tags))
+		for _, tag := range repo.Tags {
+			tags = append(tags, tag.Name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\n", repo.Name, strings.Join(tags, ","), len(repo.Digests))
+	}
+
+	fmt.Fprintln(w, "\nLARGEST BLOBS\tSIZE\tCHUNK")
+	for _, blob := range inspection.LargestBlobs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", blob.Digest, humanSize(blob.Size), blob.Chunk)
+	}
+	return w.Flush()
+}
+
+func checkOutputFormat(format string) error {
+	if format != "" && format != textOutput && format != jsonOutput {
+		return fmt.Errorf("unsupported output format %s: use one of (%s, %s)", format, textOutput, jsonOutput)
+	}
+	return nil
+}
+
+// humanSize formats a number of bytes using binary units
+func humanSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
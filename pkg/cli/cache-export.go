@@ -0,0 +1,295 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry"
+	"github.com/distribution/reference"
+	"github.com/sirupsen/logrus"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/archive"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/config"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+const cacheRegistryStartTimeout = 10 * time.Second
+
+type CacheExportController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+	Out     io.Writer
+}
+
+type CacheImportController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+	In      io.Reader
+}
+
+func NewCacheExportController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) CacheExportController {
+	return CacheExportController{
+		Log:     log,
+		Options: opts,
+		Out:     os.Stdout,
+	}
+}
+
+func NewCacheImportController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) CacheImportController {
+	return CacheImportController{
+		Log:     log,
+		Options: opts,
+		In:      os.Stdin,
+	}
+}
+
+// Process exports the images of --images from the cache, with the closure of their blobs, to the tar file
+// passed as argument (- for stdout). --images is either an image set configuration, whose images are the ones
+// recorded by its latest run in the history of --workspace, or a file listing one image of the cache per line.
+func (o CacheExportController) Process(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("cache export expects one argument: the file to write the export to, or - for stdout")
+	}
+	if o.Options.CacheExportImages == "" {
+		return fmt.Errorf("use the --images flag it is mandatory")
+	}
+	storageConfig, err := existingCacheStorage(o.Options)
+	if err != nil {
+		return err
+	}
+	unlockCache, err := lockCache(o.Log, standaloneCacheDirectory(o.Options.CacheDir), false)
+	if err != nil {
+		return err
+	}
+	defer unlockCache()
+	refs, err := o.imagesToExport()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	stopRegistry, err := serveCacheReadOnly(o.Log, o.Options)
+	if err != nil {
+		return err
+	}
+	defer stopRegistry()
+	gatherer := archive.NewImageBlobGatherer(o.Options)
+	images := []archive.CacheExportImage{}
+	for _, ref := range refs {
+		imageBlobs, err := gatherer.GatherImageBlobs(ctx, dockerProtocol+o.Options.LocalStorageFQDN+"/"+ref.String())
+		if err != nil {
+			return fmt.Errorf("unable to export %s: %w", ref, err)
+		}
+		images = append(images, archive.CacheExportImage{Repository: ref.Name(), Digest: imageBlobs.Digest, Blobs: imageBlobs.Blobs})
+	}
+
+	cacheStorage, err := storageConfig.Open(ctx)
+	if err != nil {
+		return err
+	}
+	out := o.Out
+	if args[0] != stdStream {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return fmt.Errorf("unable to create the cache export: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	index, err := archive.ExportCache(ctx, cacheStorage, images, out)
+	if err != nil {
+		return err
+	}
+	o.Log.Info(emoji.Package+" exported %d image(s), %d blob(s) (%s) from %s", len(index.Images), index.Blobs, humanSize(index.Size), storageConfig)
+	return nil
+}
+
+// imagesToExport returns the references of the images to export, without the host of the cache
+func (o CacheExportController) imagesToExport() ([]reference.Named, error) {
+	data, err := os.ReadFile(o.Options.CacheExportImages)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the images to export: %w", err)
+	}
+	var refs []reference.Named
+	if typeMeta, err := config.GetTypeMeta(data); err == nil && typeMeta.Kind == v2alpha1.ImageSetConfigurationKind {
+		refs, err = o.imagesOfConfig()
+	} else {
+		refs, err = o.imagesOfList(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// both paths: exporting nothing would succeed with an empty archive
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("no image to export found in %s", o.Options.CacheExportImages)
+	}
+	return refs, nil
+}
+
+// imagesOfConfig returns the images of the cache recorded by the runs of the image set configuration --images
+func (o CacheExportController) imagesOfConfig() ([]reference.Named, error) {
+	o.Options.ConfigPath = o.Options.CacheExportImages
+	manifests, err := manifestsOfConfig(o.Log, o.Options)
+	if err != nil {
+		return nil, err
+	}
+	refs := []reference.Named{}
+	for repository, digests := range manifests {
+		for d := range digests {
+			ref, err := cacheImageReference(repository + "@" + d.String())
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, ref)
+		}
+	}
+	return refs, nil
+}
+
+// imagesOfList returns the images listed one per line in data, skipping the empty lines and the comments
+func (o CacheExportController) imagesOfList(data []byte) ([]reference.Named, error) {
+	refs := []reference.Named{}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for line := 1; scanner.Scan(); line++ {
+		image := strings.TrimSpace(scanner.Text())
+		if image == "" || strings.HasPrefix(image, "#") {
+			continue
+		}
+		ref, err := cacheImageReference(image)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", o.Options.CacheExportImages, line, err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// cacheImageReference parses the reference of an image of the cache: its repository, with a tag or a digest.
+// The host, if any (i.e. localhost:55000), is ignored.
+func cacheImageReference(image string) (reference.Named, error) {
+	image = strings.TrimPrefix(image, dockerProtocol)
+	if host, repository, ok := strings.Cut(image, "/"); ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		image = repository
+	}
+	ref, err := reference.Parse(image)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %w", image, err)
+	}
+	named, ok := ref.(reference.Named)
+	if !ok {
+		return nil, fmt.Errorf("invalid image %s: a repository is expected", image)
+	}
+	_, tagged := named.(reference.Tagged)
+	_, digested := named.(reference.Digested)
+	if !tagged && !digested {
+		return nil, fmt.Errorf("invalid image %s: a tag or a digest is expected", image)
+	}
+	return named, nil
+}
+
+// Process imports the cache export passed as argument (- for stdin) into the cache
+func (o CacheImportController) Process(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("cache import expects one argument: the cache export to import, or - for stdin")
+	}
+	// the cache of a new host is created by the import
+	unlockCache, err := lockCache(o.Log, standaloneCacheDirectory(o.Options.CacheDir), false)
+	if err != nil {
+		return err
+	}
+	defer unlockCache()
+	storageConfig, err := existingCacheStorage(o.Options)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	cacheStorage, err := storageConfig.Open(ctx)
+	if err != nil {
+		return err
+	}
+	in := o.In
+	if args[0] != stdStream {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return fmt.Errorf("unable to open the cache export: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	report, err := archive.ImportCache(ctx, cacheStorage, in)
+	if err != nil {
+		return err
+	}
+	for _, image := range report.Images {
+		o.Log.Info("imported %s@%s %s", image.Repository, image.Digest, strings.Join(image.Tags, ","))
+	}
+	o.Log.Info(emoji.Package+" imported %d image(s) into %s: %d new blob(s) (%s), %d already present", len(report.Images), storageConfig,
+		report.ImportedBlobs, humanSize(report.ImportedSize), report.Blobs-report.ImportedBlobs)
+	return nil
+}
+
+// serveCacheReadOnly serves the cache as a read-only registry on a free port of the loopback,
+// for the commands reading images of the cache, until the returned function is called
+func serveCacheReadOnly(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) (func(), error) {
+	port, err := freeLocalPort()
+	if err != nil {
+		return nil, err
+	}
+	opts.Port = port
+	opts.LocalStorageFQDN = "localhost:" + strconv.Itoa(port)
+	opts.LocalStorageDisk = standaloneCacheDirectory(opts.CacheDir)
+	opts.RegistryReadOnly = true
+	config, err := setupLocalRegistryConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	// the registry logs every request through logrus
+	if opts.LogLevel != "debug" && opts.LogLevel != "trace" {
+		logrus.SetOutput(io.Discard)
+	}
+	reg, err := registry.NewRegistry(context.Background(), config)
+	if err != nil {
+		return nil, fmt.Errorf("setting up registry %w", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- reg.ListenAndServe()
+	}()
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := reg.Shutdown(ctx); err != nil {
+			log.Warn("Registry shutdown failure: %v", err)
+		}
+	}
+	for deadline := time.Now().Add(cacheRegistryStartTimeout); ; time.Sleep(100 * time.Millisecond) {
+		select {
+		case err := <-serveErr:
+			if !errors.Is(err, http.ErrServerClosed) {
+				return nil, fmt.Errorf("unable to serve the cache: %w", err)
+			}
+		default:
+		}
+		if conn, err := net.Dial("tcp", opts.LocalStorageFQDN); err == nil {
+			conn.Close()
+			log.Debug("cache served read-only on %s", opts.LocalStorageFQDN)
+			return stop, nil
+		}
+		if time.Now().After(deadline) {
+			stop()
+			return nil, fmt.Errorf("unable to serve the cache: %s is not reachable", opts.LocalStorageFQDN)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestImagesToExport(t *testing.T) {
+	ctx := context.Background()
+	logger := clog.New("error")
+	cacheRegistry := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer cacheRegistry.Close()
+	cacheHost := strings.TrimPrefix(cacheRegistry.URL, "http://")
+	appDigest := pushTestImage(t, cacheHost+"/ns/app:v1")
+
+	// the image set configuration of a disk-to-mirror run: its images of the cache
+	opts := newTestDiskToMirrorOptions(t, cacheHost)
+	require.NoError(t, MirrorFlowController{Log: logger, Options: opts}.recordRun(ctx, []v2alpha1.CopyImageSchema{
+		{
+			Origin:      "docker://quay.io/ns/app:v1",
+			Source:      "docker://" + cacheHost + "/ns/app:v1",
+			Destination: "docker://registry.enclave/mirror/ns/app:v1",
+		},
+	}))
+	opts.CacheExportImages = opts.ConfigPath
+	refs, err := CacheExportController{Log: logger, Options: opts}.imagesToExport()
+	require.NoError(t, err)
+	require.Len(t, refs, 1)
+	require.Equal(t, "ns/app@"+appDigest.String(), refs[0].String())
+
+	// a run referencing no image of the cache: nothing to export
+	opts = newTestDiskToMirrorOptions(t, cacheHost)
+	opts.Mode = mirrorToMirror
+	require.NoError(t, MirrorFlowController{Log: logger, Options: opts}.recordRun(ctx, []v2alpha1.CopyImageSchema{
+		{Origin: "docker://quay.io/ns/app:v1", Source: "docker://quay.io/ns/app:v1", Destination: "docker://registry.enclave/mirror/ns/app:v1"},
+	}))
+	opts.CacheExportImages = opts.ConfigPath
+	_, err = CacheExportController{Log: logger, Options: opts}.imagesToExport()
+	require.Error(t, err)
+
+	// a list of images
+	listPath := filepath.Join(t.TempDir(), "images.txt")
+	require.NoError(t, os.WriteFile(listPath, []byte("# the application\nns/app:v1\n\n"+cacheHost+"/ns/app@"+appDigest.String()+"\n"), 0600))
+	opts.CacheExportImages = listPath
+	refs, err = CacheExportController{Log: logger, Options: opts}.imagesToExport()
+	require.NoError(t, err)
+	require.Len(t, refs, 2)
+
+	require.NoError(t, os.WriteFile(listPath, []byte("# nothing\n"), 0600))
+	_, err = CacheExportController{Log: logger, Options: opts}.imagesToExport()
+	require.ErrorContains(t, err, "no image to export found")
+}
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/distribution/reference"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/cache"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/config"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/history"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+type CacheGCController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+	Out     io.Writer
+}
+
+func NewCacheGCController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) CacheGCController {
+	return CacheGCController{
+		Log:     log,
+		Options: opts,
+		Out:     os.Stdout,
+	}
+}
+
+// Process garbage collects the blobs of the cache that no manifest references anymore.
+// With --config, the manifests not referenced by the latest mirror-to-disk, disk-to-mirror and mirror-to-mirror
+// runs of this image set configuration recorded in the history of --workspace are removed first.
+func (o CacheGCController) Process(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("cache gc doesn't expect any argument")
+	}
+	if err := checkOutputFormat(o.Options.OutputFormat); err != nil {
+		return err
+	}
+	storageConfig, err := existingCacheStorage(o.Options)
+	if err != nil {
+		return err
+	}
+	// a dry run only reads the cache
+	unlockCache, err := lockCache(o.Log, standaloneCacheDirectory(o.Options.CacheDir), !o.Options.DryRun)
+	if err != nil {
+		return err
+	}
+	defer unlockCache()
+	gcOpts := cache.GCOptions{DryRun: o.Options.DryRun}
+	if o.Options.ConfigPath != "" {
+		keep, err := manifestsOfConfig(o.Log, o.Options)
+		if err != nil {
+			return err
+		}
+		gcOpts.KeepManifests = keep
+	}
+	// the storage driver logs every removal through logrus
+	if o.Options.LogLevel != "debug" && o.Options.LogLevel != "trace" {
+		logrus.SetOutput(io.Discard)
+	}
+	report, err := cache.GarbageCollect(context.Background(), storageConfig, gcOpts)
+	if err != nil {
+		return err
+	}
+	if o.Options.OutputFormat == jsonOutput {
+		encoder := json.NewEncoder(o.Out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	return o.printReport(report)
+}
+
+// manifestsOfConfig returns the manifests, per repository of the cache, of the images recorded by the latest
+// run of each mode (mirror-to-disk, disk-to-mirror, mirror-to-mirror) of the image set configuration --config
+// in the history of --workspace. The runs are matched on the parsed configuration, so that reformatting the file
+// doesn't lose them: the records written before carry the digest of the file as is, which still matches it unchanged.
+func manifestsOfConfig(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) (map[string]map[digest.Digest]struct{}, error) {
+	iscDigest, err := config.Digest(opts.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the image set configuration: %w", err)
+	}
+	isc, err := os.ReadFile(opts.ConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the image set configuration: %w", err)
+	}
+	iscFileDigest := digest.FromBytes(isc).String()
+	records, err := readHistoryRecords(log, opts)
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]history.Record{}
+	for _, record := range records {
+		if record.ISCHash == iscDigest || record.ISCHash == iscFileDigest {
+			latest[record.Mode] = record
+		}
+	}
+	if len(latest) == 0 {
+		return nil, fmt.Errorf("no run of the image set configuration %s found in the history of the workspace: mirror it first", opts.ConfigPath)
+	}
+	keep := map[string]map[digest.Digest]struct{}{}
+	for mode, record := range latest {
+		log.Debug("using the images of the %s run %s", mode, record.RunID)
+		for _, image := range record.Images {
+			if image.Digest == "" {
+				continue
+			}
+			cacheReference := image.CacheReference
+			if cacheReference == "" {
+				cacheReference = image.Destination
+			}
+			named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(cacheReference, dockerProtocol))
+			if err != nil {
+				return nil, fmt.Errorf("invalid image reference %s in the history: %w", cacheReference, err)
+			}
+			repo := reference.Path(named)
+			if keep[repo] == nil {
+				keep[repo] = map[digest.Digest]struct{}{}
+			}
+			keep[repo][digest.Digest(image.Digest)] = struct{}{}
+		}
+	}
+	// an empty set would let gc remove the whole cache
+	if len(keep) == 0 {
+		return nil, fmt.Errorf("the runs of the image set configuration %s recorded in the history of the workspace reference no image of the cache", opts.ConfigPath)
+	}
+	return keep, nil
+}
+
+func (o CacheGCController) printReport(report cache.GCReport) error {
+	action := "removed"
+	if report.DryRun {
+		action = "to remove (dry run)"
+	}
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Blobs marked:\t%d\n", report.MarkedBlobs)
+	fmt.Fprintf(w, "Manifests %s:\t%d\n", action, len(report.Manifests))
+	fmt.Fprintf(w, "Blobs %s:\t%d\n", action, len(report.Blobs))
+	fmt.Fprintf(w, "Layer links %s:\t%d\n", action, report.LayerLinks)
+	fmt.Fprintf(w, "Space reclaimed:\t%s\n", humanSize(report.ReclaimedBytes))
+	if len(report.Manifests) > 0 {
+		fmt.Fprintln(w, "\nREPOSITORY\tDIGEST\tTAGS")
+		for _, manifest := range report.Manifests {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", manifest.Repository, manifest.Digest, strings.Join(manifest.Tags, ","))
+		}
+	}
+	return w.Flush()
+}
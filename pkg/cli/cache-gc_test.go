@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+const testGCConfig = `kind: ImageSetConfiguration
+apiVersion: mirror.openshift.io/v2alpha1
+mirror:
+  additionalImages:
+  - name: quay.io/ns/app:v1
+`
+
+func TestManifestsOfConfig(t *testing.T) {
+	ctx := context.Background()
+	logger := clog.New("error")
+	cacheRegistry := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer cacheRegistry.Close()
+	cacheHost := strings.TrimPrefix(cacheRegistry.URL, "http://")
+	appDigest := pushTestImage(t, cacheHost+"/ns/app:v1")
+	releaseDigest := pushTestImage(t, cacheHost+"/openshift/release-images:4.18.1-x86_64")
+
+	opts := newTestDiskToMirrorOptions(t, cacheHost)
+	configPath := opts.ConfigPath
+	controller := MirrorFlowController{Log: logger, Options: opts}
+
+	_, err := manifestsOfConfig(logger, opts)
+	require.ErrorContains(t, err, "no run of the image set configuration")
+
+	// disk-to-mirror copies the images from the cache (Source) to the registry of the enclave,
+	// Origin being the image upstream
+	require.NoError(t, controller.recordRun(ctx, []v2alpha1.CopyImageSchema{
+		{
+			Origin:      "docker://quay.io/ns/app:v1",
+			Source:      "docker://" + cacheHost + "/ns/app:v1",
+			Destination: "docker://registry.enclave/mirror/ns/app:v1",
+		},
+		{
+			Origin:      "quay.io/openshift-release-dev/ocp-release:4.18.1-x86_64",
+			Source:      "docker://" + cacheHost + "/openshift/release-images:4.18.1-x86_64",
+			Destination: "docker://registry.enclave/mirror/openshift/release-images:4.18.1-x86_64",
+		},
+	}))
+	expected := map[string]map[digest.Digest]struct{}{
+		"ns/app":                   {digest.Digest(appDigest.String()): {}},
+		"openshift/release-images": {digest.Digest(releaseDigest.String()): {}},
+	}
+	keep, err := manifestsOfConfig(logger, opts)
+	require.NoError(t, err)
+	require.Equal(t, expected, keep)
+
+	// the configuration is reformatted: its runs still match it
+	require.NoError(t, os.WriteFile(configPath, []byte("# reformatted\n"+testGCConfig), 0600))
+	keep, err = manifestsOfConfig(logger, opts)
+	require.NoError(t, err)
+	require.Equal(t, expected, keep)
+
+	// a changed configuration has no run yet
+	require.NoError(t, os.WriteFile(configPath, []byte(testGCConfig+"  - name: quay.io/ns/app:v2\n"), 0600))
+	_, err = manifestsOfConfig(logger, opts)
+	require.ErrorContains(t, err, "no run of the image set configuration")
+
+	// a mirror-to-mirror run of it caches nothing: gc must not take it for an empty cache to keep
+	opts.Mode = mirrorToMirror
+	require.NoError(t, controller.recordRun(ctx, []v2alpha1.CopyImageSchema{
+		{Origin: "docker://quay.io/ns/app:v2", Source: "docker://quay.io/ns/app:v2", Destination: "docker://registry.enclave/mirror/ns/app:v2"},
+	}))
+	_, err = manifestsOfConfig(logger, opts)
+	require.ErrorContains(t, err, "reference no image of the cache")
+}
+
+// pushTestImage pushes a random image to ref, and returns the digest of its manifest
+func pushTestImage(t *testing.T, ref string) v1.Hash {
+	img, err := random.Image(256, 1)
+	require.NoError(t, err)
+	parsed, err := name.ParseReference(ref)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(parsed, img))
+	imgDigest, err := img.Digest()
+	require.NoError(t, err)
+	return imgDigest
+}
+
+// newTestDiskToMirrorOptions returns the options of a disk-to-mirror run of testGCConfig
+// from the cache served at cacheHost, recorded in a new workspace
+func newTestDiskToMirrorOptions(t *testing.T, cacheHost string) *common.MirrorOptions {
+	workspace := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(workspace, workingDir), 0755))
+	configPath := filepath.Join(t.TempDir(), "isc.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(testGCConfig), 0600))
+	return &common.MirrorOptions{
+		Mode:             diskToMirror,
+		ConfigPath:       configPath,
+		Workspace:        fileProtocol + workspace,
+		WorkingDir:       filepath.Join(workspace, workingDir),
+		LocalStorageFQDN: cacheHost,
+		OutputFormat:     textOutput,
+	}
+}
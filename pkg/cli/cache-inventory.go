@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/archive"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+type CacheInventoryController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+	Out     io.Writer
+}
+
+func NewCacheInventoryController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) CacheInventoryController {
+	return CacheInventoryController{
+		Log:     log,
+		Options: opts,
+		Out:     os.Stdout,
+	}
+}
+
+// Process exports the inventory of the blobs present in the cache, one digest per line,
+// to the file passed as argument, or to stdout.
+// On the connected side, this inventory is the baseline used by --baseline-inventory.
+func (o CacheInventoryController) Process(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("cache inventory expects at most one argument: the file to write the inventory to")
+	}
+	storageConfig, err := existingCacheStorage(o.Options)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	cacheStorage, err := storageConfig.Open(ctx)
+	if err != nil {
+		return err
+	}
+	out := o.Out
+	if len(args) == 1 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return fmt.Errorf("unable to create the inventory file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	err = archive.WriteBlobInventory(ctx, cacheStorage, out)
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		o.Log.Info(emoji.Memo+" blob inventory of %s written to %s", storageConfig, args[0])
+	}
+	return nil
+}
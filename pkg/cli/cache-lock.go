@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/lock"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+// lockCache takes the lock of the cache cacheDir, and returns the function releasing it.
+// The runs using the cache (mirror, delete, serve) hold it in shared mode for their whole duration,
+// the commands rewriting the cache behind the back of the registries (gc) hold it in exclusive mode:
+// they fail immediately while the cache is in use.
+func lockCache(log clog.PluggableLoggerInterface, cacheDir string, exclusive bool) (func(), error) {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to lock the cache: %w", err)
+	}
+	lockPath := filepath.Join(cacheDir, cacheLockFile)
+	acquire := lock.AcquireShared
+	if exclusive {
+		acquire = lock.AcquireExclusive
+	}
+	cacheLock, err := acquire(lockPath, 0)
+	if errors.Is(err, &lock.BusyError{}) {
+		if exclusive {
+			return nil, fmt.Errorf("the cache %s is in use by another oc-mirror process: retry once it completes", cacheDir)
+		}
+		return nil, fmt.Errorf("the cache %s is being rewritten by another oc-mirror process (cache gc): retry once it completes", cacheDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("acquired the lock of cache %s", cacheDir)
+	return func() {
+		if err := cacheLock.Release(); err != nil {
+			log.Warn("%v", err)
+		}
+	}, nil
+}
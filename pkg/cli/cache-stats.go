@@ -0,0 +1,116 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/cache"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+	digest "github.com/opencontainers/go-digest"
+)
+
+type CacheListController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+	Out     io.Writer
+}
+
+type CacheDiskUsageController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+	Out     io.Writer
+}
+
+func NewCacheListController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) CacheListController {
+	return CacheListController{
+		Log:     log,
+		Options: opts,
+		Out:     os.Stdout,
+	}
+}
+
+func NewCacheDiskUsageController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) CacheDiskUsageController {
+	return CacheDiskUsageController{
+		Log:     log,
+		Options: opts,
+		Out:     os.Stdout,
+	}
+}
+
+// Process lists the repositories of the cache, with their manifests and tags.
+// With --config, each manifest is flagged as referenced by the image set configuration, or orphaned.
+func (o CacheListController) Process(args []string) error {
+	stats, err := inspectCache(o.Log, o.Options, args, "cache ls")
+	if err != nil {
+		return err
+	}
+	if o.Options.OutputFormat == jsonOutput {
+		encoder := json.NewEncoder(o.Out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats.Repositories)
+	}
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tDIGEST\tTAGS\tSTATUS")
+	for _, repo := range stats.Repositories {
+		for _, manifest := range repo.Manifests {
+			status := manifest.Status
+			if status == "" {
+				status = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", repo.Name, manifest.Digest, strings.Join(manifest.Tags, ","), status)
+		}
+	}
+	return w.Flush()
+}
+
+// Process prints the sizes of the blobs of the cache: per repository, in total,
+// and how much of them is shared between repositories
+func (o CacheDiskUsageController) Process(args []string) error {
+	stats, err := inspectCache(o.Log, o.Options, args, "cache du")
+	if err != nil {
+		return err
+	}
+	if o.Options.OutputFormat == jsonOutput {
+		encoder := json.NewEncoder(o.Out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(stats)
+	}
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REPOSITORY\tMANIFESTS\tBLOBS\tSIZE\tUNIQUE\tSHARED")
+	for _, repo := range stats.Repositories {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%.0f%%\n", repo.Name, len(repo.Manifests), repo.Blobs, humanSize(repo.TotalBytes), humanSize(repo.UniqueBytes), repo.SharedRatio*100)
+	}
+	fmt.Fprintf(w, "\nCache:\t%s\n", stats.CacheDir)
+	fmt.Fprintf(w, "Blobs:\t%d (%s)\n", stats.Blobs, humanSize(stats.TotalBytes))
+	fmt.Fprintf(w, "Shared between repositories:\t%d (%s, %.0f%%)\n", stats.SharedBlobs, humanSize(stats.SharedBytes), stats.SharedRatio*100)
+	fmt.Fprintf(w, "Unreferenced (see cache gc):\t%d (%s)\n", stats.UnreferencedBlobs, humanSize(stats.UnreferencedBytes))
+	return w.Flush()
+}
+
+// inspectCache inspects the cache, comparing it to the image set configuration --config when set
+func inspectCache(log clog.PluggableLoggerInterface, opts *common.MirrorOptions, args []string, command string) (cache.Stats, error) {
+	if len(args) != 0 {
+		return cache.Stats{}, fmt.Errorf("%s doesn't expect any argument", command)
+	}
+	if err := checkOutputFormat(opts.OutputFormat); err != nil {
+		return cache.Stats{}, err
+	}
+	storageConfig, err := existingCacheStorage(opts)
+	if err != nil {
+		return cache.Stats{}, err
+	}
+	var referenced map[string]map[digest.Digest]struct{}
+	if opts.ConfigPath != "" {
+		referenced, err = manifestsOfConfig(log, opts)
+		if err != nil {
+			return cache.Stats{}, err
+		}
+	}
+	return cache.Inspect(context.Background(), storageConfig, referenced)
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/cache"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestInspectCacheAfterDiskToMirror(t *testing.T) {
+	ctx := context.Background()
+	logger := clog.New("error")
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	cacheDir := filepath.Join(home, cacheRelativePath)
+	require.NoError(t, os.MkdirAll(cacheDir, 0755))
+	// the cache is served as by the local storage registry of the mirroring runs, which logs through logrus
+	logrus.SetOutput(io.Discard)
+	t.Cleanup(func() { logrus.SetOutput(os.Stderr) })
+	registryConfig, err := setupLocalRegistryConfig(&common.MirrorOptions{LocalStorageDisk: cacheDir, Port: 55000, LogLevel: "error"})
+	require.NoError(t, err)
+	cacheRegistry := httptest.NewServer(handlers.NewApp(ctx, registryConfig))
+	defer cacheRegistry.Close()
+	cacheHost := strings.TrimPrefix(cacheRegistry.URL, "http://")
+	appDigest := pushTestImage(t, cacheHost+"/ns/app:v1")
+	staleDigest := pushTestImage(t, cacheHost+"/ns/stale:v1")
+
+	opts := newTestDiskToMirrorOptions(t, cacheHost)
+	// the enclave host only has the disk-to-mirror run in its history
+	require.NoError(t, MirrorFlowController{Log: logger, Options: opts}.recordRun(ctx, []v2alpha1.CopyImageSchema{
+		{
+			Origin:      "docker://quay.io/ns/app:v1",
+			Source:      "docker://" + cacheHost + "/ns/app:v1",
+			Destination: "docker://registry.enclave/mirror/ns/app:v1",
+		},
+	}))
+
+	stats, err := inspectCache(logger, opts, nil, "cache ls")
+	require.NoError(t, err)
+	statuses := map[string]string{}
+	for _, repo := range stats.Repositories {
+		for _, manifest := range repo.Manifests {
+			statuses[repo.Name+"@"+manifest.Digest] = manifest.Status
+		}
+	}
+	require.Equal(t, map[string]string{
+		"ns/app@" + appDigest.String():     cache.ManifestReferenced,
+		"ns/stale@" + staleDigest.String(): cache.ManifestOrphaned,
+	}, statuses)
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/clusterresources"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+type ClusterResourcesApplyController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+	Out     io.Writer
+}
+
+func NewClusterResourcesApplyController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) ClusterResourcesApplyController {
+	return ClusterResourcesApplyController{
+		Log:     log,
+		Options: opts,
+		Out:     os.Stdout,
+	}
+}
+
+// Process server-side applies the cluster resources of --workspace, or of the directory passed as argument,
+// to the cluster of --kubeconfig, and reports the objects created, updated and unchanged
+func (o ClusterResourcesApplyController) Process(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("cluster-resources apply expects at most one argument: the cluster resources directory")
+	}
+	if err := checkOutputFormat(o.Options.OutputFormat); err != nil {
+		return err
+	}
+	var dir string
+	if len(args) == 1 {
+		dir = args[0]
+	} else {
+		workingDir, err := historyWorkingDir(o.Options)
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(workingDir, clusterResourcesDir)
+	}
+	applier, err := clusterresources.NewApplierForKubeconfig(o.Log, o.Options.Kubeconfig, o.Options.ApplyDiffOnly)
+	if err != nil {
+		return err
+	}
+	applied, err := applier.ApplyDir(context.Background(), dir)
+	if err != nil {
+		// the objects applied before the failure are reported in the logs
+		logAppliedObjects(o.Log, applied, o.Options.ApplyDiffOnly)
+		return err
+	}
+	if o.Options.OutputFormat == jsonOutput {
+		encoder := json.NewEncoder(o.Out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(applied)
+	}
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tKIND\tNAMESPACE\tNAME\tRESULT")
+	for _, object := range applied {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", object.File, object.Kind, object.Namespace, object.Name, object.Result)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if o.Options.ApplyDiffOnly {
+		for _, object := range applied {
+			if object.Diff != "" {
+				fmt.Fprintf(o.Out, "\n%s %s:\n%s", object.Kind, object.Name, object.Diff)
+			}
+		}
+	}
+	return nil
+}
+
+// logAppliedObjects logs the report of the apply of the cluster resources
+func logAppliedObjects(log clog.PluggableLoggerInterface, applied []clusterresources.AppliedObject, diffOnly bool) {
+	counts := map[clusterresources.ApplyResult]int{}
+	for _, object := range applied {
+		counts[object.Result]++
+		log.Info("%s %s %s (%s)", object.Kind, object.Name, object.Result, object.File)
+		if diffOnly && object.Diff != "" {
+			log.Info("%s", strings.TrimSuffix(object.Diff, "\n"))
+		}
+	}
+	verb := "applied"
+	if diffOnly {
+		verb = "compared (diff only)"
+	}
+	log.Info(emoji.CheckMarkButton+" cluster resources %s: %d created, %d updated, %d unchanged, %d skipped", verb,
+		counts[clusterresources.ApplyCreated], counts[clusterresources.ApplyUpdated], counts[clusterresources.ApplyUnchanged], counts[clusterresources.ApplySkipped])
+}
@@ -8,12 +8,16 @@ const (
 	ociProtocol                   string = "oci://"
 	dirProtocol                   string = "dir://"
 	fileProtocol                  string = "file://"
+	streamProtocol                string = "stream://"
+	stdStream                     string = "-"
 	releaseImageDir               string = "release-images"
 	logsDir                       string = "logs"
 	workingDir                    string = "working-dir"
 	ocmirrorRelativePath          string = ".oc-mirror"
 	cacheRelativePath             string = ".oc-mirror/.cache"
 	cacheEnvVar                   string = "OC_MIRROR_CACHE"
+	cacheRegistryDir              string = "docker/registry/v2"
+	localStorageTLSDirPrefix      string = "oc-mirror-local-storage-tls-"
 	additionalImages              string = "additional-images"
 	releaseImageExtractDir        string = "hold-release"
 	cincinnatiGraphDataDir        string = "cincinnati-graph-data"
@@ -34,6 +38,28 @@ const (
 	limitOverallParallelDownloads uint   = 200
 	mirrorCommand                 string = "mirror"
 	deleteCommand                 string = "delete"
+	serveCommand                  string = "serve"
+	archiveCommand                string = "archive"
+	inspectSubCommand             string = "inspect"
+	cacheCommand                  string = "cache"
+	inventorySubCommand           string = "inventory"
+	gcSubCommand                  string = "gc"
+	exportSubCommand              string = "export"
+	importSubCommand              string = "import"
+	lsSubCommand                  string = "ls"
+	duSubCommand                  string = "du"
+	historyCommand                string = "history"
+	listSubCommand                string = "list"
+	showSubCommand                string = "show"
+	pruneSubCommand               string = "prune"
+	clusterResourcesCommand       string = "cluster-resources"
+	applySubCommand               string = "apply"
+	workspaceLockFile             string = ".oc-mirror.lock"
+	cacheLockFile                 string = ".oc-mirror-cache.lock"
+	sharedLocalStorageFile        string = ".oc-mirror-registry.json"
+	sharedLocalStorageLockFile    string = ".oc-mirror-registry.lock"
+	textOutput                    string = "text"
+	jsonOutput                    string = "json"
 	mirrorToDisk                  string = "mirror-to-disk"
 	diskToMirror                  string = "disk-to-mirror"
 	mirrorToMirror                string = "mirror-to-mirror"
@@ -1,12 +1,14 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/additional"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/archive"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/batch"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/clusterresources"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/collector"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/config"
@@ -44,6 +46,19 @@ func (o DeleteFlowController) Process(args []string) error {
 	o.Log.Info(emoji.WavingHandSign + " Hello, welcome to oc-mirror (version refactor)")
 	o.Log.Info(emoji.Gear + "  setting up the environment for you...")
 
+	// the locks are taken before setting up the directories, which resets the cluster resources of the workspace
+	unlock, err := lockWorkspace(o.Log, o.Options.WorkingDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	unlockCache, err := lockCache(o.Log, cacheDirectory(o.Options.CacheDir), false)
+	if err != nil {
+		return err
+	}
+	defer unlockCache()
+
 	setup := Setup{Log: o.Log, Options: o.Options}
 	err = setup.CreateDirectories()
 	if err != nil {
@@ -102,6 +117,9 @@ func (o DeleteFlowController) Process(args []string) error {
 		return err
 	}
 
+	// the registry is stopped on every exit path: as the owner of a shared registry,
+	// this run waits for the runs still using it, even when it fails
+	defer localStorage.StopLocalRegistry()
 	go localStorage.StartLocalRegistry()
 
 	// use single responsibility principle
@@ -129,14 +147,62 @@ func (o DeleteFlowController) Process(args []string) error {
 			o.Log.Error("%v", err)
 			return err
 		}
+		if o.Options.DeleteKeepConfig != "" {
+			keptImages, err := o.collectKeptImages(mirror)
+			if err != nil {
+				o.Log.Error("%v", err)
+				return err
+			}
+			images, err = deleteReg.ExcludeKeptImages(context.Background(), images, keptImages)
+			if err != nil {
+				o.Log.Error("%v", err)
+				return err
+			}
+		}
 		err = deleteReg.DeleteRegistryImages(images)
 		if err != nil {
 			o.Log.Error("%v", err)
 			return err
 		}
+		// the deleted images are no longer mirrored
+		deletedImages := []v2alpha1.CopyImageSchema{}
+		for _, img := range images.Items {
+			deletedImages = append(deletedImages, v2alpha1.CopyImageSchema{Origin: img.ImageName, Destination: img.ImageReference, Type: img.Type})
+		}
+		clusterRes := clusterresources.New(o.Log, isc, o.Options)
+		err = clusterRes.RemoveFromCumulativeMirrorSets(deletedImages)
+		if err != nil {
+			o.Log.Error("%v", err)
+			return err
+		}
+		err = clusterRes.UpdateKustomization()
+		if err != nil {
+			o.Log.Error("%v", err)
+			return err
+		}
 	}
 
-	localStorage.StopLocalRegistry()
 	o.Log.Info(emoji.WavingHandSign + " Goodbye, thank you for using oc-mirror")
 	return nil
 }
+
+// collectKeptImages returns the images of the image set configuration of the content kept in the registry (--keep-config),
+// with their destination in the registry
+func (o DeleteFlowController) collectKeptImages(mirror mirror.MirrorInterface) ([]v2alpha1.CopyImageSchema, error) {
+	config := config.Config{}
+	cfg, err := config.Read(o.Options.DeleteKeepConfig, v2alpha1.ImageSetConfigurationKind)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the image set configuration to keep: %w", err)
+	}
+	keepConfig := cfg.(v2alpha1.ImageSetConfiguration)
+	collectManager := collector.New(o.Log, keepConfig, o.Options)
+	collectManager.AddCollector(release.New(o.Log, mirror, keepConfig, o.Options))
+	collectManager.AddCollector(additional.New(o.Log, keepConfig, o.Options))
+	collectManager.AddCollector(operator.New(o.Log, mirror, keepConfig, o.Options))
+	collectManager.AddCollector(helm.New(o.Log, keepConfig, o.Options))
+	allCollectorSchema, err := collectManager.CollectAllImages()
+	if err != nil {
+		return nil, fmt.Errorf("unable to collect the images to keep: %w", err)
+	}
+	return getUpdatedCopiedImages(allCollectorSchema).AllImages, nil
+}
@@ -47,6 +47,15 @@ func (o DeleteValidate) CheckArgs(args []string) error {
 		return fmt.Errorf("the --delete-v1-images flag can only be used alongside the --generate flag")
 	}
 
+	if o.Options.DeleteKeepConfig != "" {
+		if o.Options.DeleteGenerate {
+			return fmt.Errorf("the --keep-config flag can't be used with the --generate flag: the shared manifests are found when deleting")
+		}
+		if _, err := os.Stat(o.Options.DeleteKeepConfig); err != nil {
+			return fmt.Errorf("unable to access the image set configuration to keep: %w", err)
+		}
+	}
+
 	dest, ok := argsContain(args, dockerProtocol)
 	if ok {
 		o.Options.WorkingDir = strings.TrimPrefix(o.Options.Workspace, fileProtocol) + "/working-dir"
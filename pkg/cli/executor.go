@@ -4,9 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/containers/common/pkg/retry"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/cache"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/clusterresources"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
 	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
 	"golang.org/x/term"
@@ -36,11 +40,13 @@ func Execute() error {
 	mainCmd.StringVar(&options.CacheDir, "cache-dir", "", "oc-mirror cache directory location. Default is $HOME")
 	mainCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
 	mainCmd.StringVar(&options.Workspace, "workspace", "", "oc-mirror workspace where resources and internal artifacts are generated")
-	mainCmd.IntVar(&options.Port, "port", 55000, "HTTP port used by oc-mirror's local storage instance")
+	mainCmd.IntVar(&options.Port, "port", 55000, "HTTP port used by oc-mirror's local storage instance. 0 picks a free port")
+	mainCmd.BoolVar(&options.LocalStorageTLS, "local-storage-tls", false, "Serve oc-mirror's local storage instance over TLS, with a CA generated for the run (instead of plain HTTP)")
+	mainCmd.BoolVar(&options.SharedLocalStorage, "shared-local-storage", false, "Reuse the local storage instance already served by another oc-mirror run using the same cache on this host, instead of starting a second one. Without such a run, the local storage instance of this run is shared with the next ones, and served until they all complete")
 	mainCmd.BoolVar(&options.V2, "v2", false, "Redirect the flow to oc-mirror v2")
 	mainCmd.IntVar(&options.ParallelLayerImages, "parallel-layers", 10, "Indicates the number of image layers mirrored in parallel")
 	mainCmd.IntVar(&options.ParallelImages, "parallel-images", 6, "Indicates the number of images mirrored in parallel")
-	mainCmd.StringVar(&options.From, "from", "", "Local storage directory for disk to mirror workflow")
+	mainCmd.StringVar(&options.From, "from", "", "Local storage directory for disk to mirror workflow, or - (stdin) / stream://<path> to read a streamed archive")
 	mainCmd.BoolVar(&options.DryRun, "dry-run", false, "Print actions without mirroring images")
 	mainCmd.BoolVar(&options.Quiet, "quiet", false, "Enable detailed logging when copying images")
 	mainCmd.BoolVar(&options.Force, "force", false, "Force the copy and mirror functionality")
@@ -53,6 +59,39 @@ func Execute() error {
 	mainCmd.BoolVar(&options.DestinationTlsVerify, "dest-tls-verify", false, "Use http (default) set to true to enable destination tls-verify")
 	mainCmd.BoolVar(&options.SourceTlsVerify, "src-tls-verify", false, "Use http (default) set to true to enable source tls-verify")
 	mainCmd.StringVar(&options.MultiArch, "multi-arch", "system", "Override by setting the value to 'all' (default is 'system')")
+	mainCmd.IntVar(&options.KeepArchiveGenerations, "keep-archive-generations", 0, "Number of archive generations (including the new one) to keep in the mirror-to-disk destination. Older generations are removed. 0 keeps all of them")
+	mainCmd.IntVar(&options.HistoryKeep, "history-keep", 0, "Number of most recent history files to keep as is. Older ones are compacted into a single baseline. 0 keeps all of them")
+	mainCmd.StringVar(&options.HistoryKeepSinceString, "history-keep-since", "", "Keep history files dated from this date (format yyyy-MM-dd) as is. Older ones are compacted into a single baseline")
+	mainCmd.StringVar(&options.BaselineInventory, "baseline-inventory", "", "Blob inventory of the disconnected side (see oc-mirror cache inventory): the archive excludes exactly these blobs, instead of the ones recorded in the history metadata")
+	mainCmd.Func("archive-recipient", "Encrypt the archive to this age public key, or to the age recipients or OpenPGP public key in this file (can be repeated)", func(s string) error {
+		options.ArchiveRecipients = append(options.ArchiveRecipients, s)
+		return nil
+	})
+	mainCmd.Func("mirror-set-format", "Format of the mirror configuration generated in cluster-resources one of ("+strings.Join(clusterresources.MirrorSetFormats, ", ")+"). registries-conf is a registries.conf drop-in for podman and CRI-O (can be repeated, default idms-itms)", func(s string) error {
+		if !slices.Contains(clusterresources.MirrorSetFormats, s) {
+			return fmt.Errorf("expected one of (%s)", strings.Join(clusterresources.MirrorSetFormats, ", "))
+		}
+		if !slices.Contains(options.MirrorSetFormats, s) {
+			options.MirrorSetFormats = append(options.MirrorSetFormats, s)
+		}
+		return nil
+	})
+	mainCmd.BoolVar(&options.ApplyClusterResources, "apply", false, "Server-side apply the cluster resources generated by the disk-to-mirror or mirror-to-mirror run to the cluster of --kubeconfig")
+	mainCmd.BoolVar(&options.ApplyDiffOnly, "diff-only", false, "With --apply, only report the cluster resources that would be created or updated, without applying them")
+	mainCmd.StringVar(&options.Kubeconfig, "kubeconfig", "", "kubeconfig of the cluster the cluster resources are applied to. Default is $KUBECONFIG, then $HOME/.kube/config")
+	mainCmd.StringVar(&options.KustomizeNamespace, "kustomize-namespace", "", "Namespace set by the kustomization.yaml generated in cluster-resources to all the namespaced cluster resources")
+	mainCmd.Func("kustomize-label", "Label added by the kustomization.yaml generated in cluster-resources to all the cluster resources, as key=value (can be repeated)", func(s string) error {
+		return addKeyValue(&options.KustomizeLabels, s)
+	})
+	mainCmd.Func("kustomize-annotation", "Annotation added by the kustomization.yaml generated in cluster-resources to all the cluster resources, as key=value (can be repeated)", func(s string) error {
+		return addKeyValue(&options.KustomizeAnnotations, s)
+	})
+	mainCmd.BoolVar(&options.HelmChart, "helm-chart", false, "Generate a helm chart wrapping the cluster resources in cluster-resources/chart")
+	mainCmd.BoolVar(&options.SigstoreArtifacts, "sigstore-artifacts", false, "Mirror the cosign signatures, attestations and SBOMs attached to the images (sha256-<digest>.sig/.att/.sbom tags and OCI referrers) along with them. Set it in both mirror-to-disk and disk-to-mirror to carry them through the archive. The manifest lists are then mirrored with all their images")
+	mainCmd.Func("archive-identity", "age identity or OpenPGP private key file used to decrypt the archive (can be repeated)", func(s string) error {
+		options.ArchiveIdentities = append(options.ArchiveIdentities, s)
+		return nil
+	})
 
 	deleteCmd := flag.NewFlagSet("delete", flag.ExitOnError)
 
@@ -62,8 +101,97 @@ func Execute() error {
 	deleteCmd.StringVar(&options.Workspace, "workspace", "", "oc-mirror workspace where resources and internal artifacts are generated")
 	deleteCmd.BoolVar(&options.ForceCacheDelete, "force-cache-delete", false, "Used to force delete  the local cache manifests and blobs")
 	deleteCmd.BoolVar(&options.DeleteGenerate, "generate", false, "Used to generate the delete yaml for the list of manifests and blobs , used in the step to actually delete from local cahce and remote registry")
+	deleteCmd.StringVar(&options.DeleteKeepConfig, "keep-config", "", "Image set configuration of the content kept in the registry: the images to delete sharing their manifest with this content are not deleted, as explained in the delete analysis of the working-dir")
 	deleteCmd.BoolVar(&options.DeleteV1, "delete-v1-images", false, "Used during the migration, along with --generate, in order to target images previously mirrored with oc-mirror v1")
 
+	archiveInspectCmd := flag.NewFlagSet("archive inspect", flag.ExitOnError)
+	archiveInspectCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+	archiveInspectCmd.StringVar(&options.OutputFormat, "output", textOutput, "Output format one of (text, json)")
+	archiveInspectCmd.Func("archive-identity", "age identity or OpenPGP private key file used to decrypt the archive (can be repeated)", func(s string) error {
+		options.ArchiveIdentities = append(options.ArchiveIdentities, s)
+		return nil
+	})
+
+	cacheInventoryCmd := flag.NewFlagSet("cache inventory", flag.ExitOnError)
+	cacheInventoryCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+	cacheInventoryCmd.StringVar(&options.CacheDir, "cache-dir", "", "oc-mirror cache directory location. Default is $HOME/.oc-mirror/.cache")
+
+	cacheGCCmd := flag.NewFlagSet("cache gc", flag.ExitOnError)
+	cacheGCCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+	cacheGCCmd.StringVar(&options.CacheDir, "cache-dir", "", "oc-mirror cache directory location. Default is $HOME/.oc-mirror/.cache")
+	cacheGCCmd.BoolVar(&options.DryRun, "dry-run", false, "Only report what would be removed from the cache")
+	cacheGCCmd.StringVar(&options.OutputFormat, "output", textOutput, "Output format one of (text, json)")
+	cacheGCCmd.StringVar(&options.ConfigPath, "config", "", "Image set configuration: remove the manifests not referenced by its latest mirror-to-disk, disk-to-mirror and mirror-to-mirror runs recorded in the history of --workspace")
+	cacheGCCmd.StringVar(&options.Workspace, "workspace", "", "oc-mirror workspace whose history records the runs of --config")
+
+	cacheExportCmd := flag.NewFlagSet("cache export", flag.ExitOnError)
+	cacheExportCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+	cacheExportCmd.StringVar(&options.CacheDir, "cache-dir", "", "oc-mirror cache directory location. Default is $HOME/.oc-mirror/.cache")
+	cacheExportCmd.StringVar(&options.CacheExportImages, "images", "", "Images to export: an image set configuration, whose images are the ones of its latest run recorded in the history of --workspace, or a file listing one image of the cache per line (ns/img:tag or ns/img@sha256:...)")
+	cacheExportCmd.StringVar(&options.Workspace, "workspace", "", "oc-mirror workspace whose history records the runs of the image set configuration --images")
+
+	cacheImportCmd := flag.NewFlagSet("cache import", flag.ExitOnError)
+	cacheImportCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+	cacheImportCmd.StringVar(&options.CacheDir, "cache-dir", "", "oc-mirror cache directory location. Default is $HOME/.oc-mirror/.cache")
+
+	cacheListCmd := flag.NewFlagSet("cache ls", flag.ExitOnError)
+	cacheDiskUsageCmd := flag.NewFlagSet("cache du", flag.ExitOnError)
+	for _, cmd := range []*flag.FlagSet{cacheListCmd, cacheDiskUsageCmd} {
+		cmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+		cmd.StringVar(&options.CacheDir, "cache-dir", "", "oc-mirror cache directory location. Default is $HOME/.oc-mirror/.cache")
+		cmd.StringVar(&options.OutputFormat, "output", textOutput, "Output format one of (text, json)")
+		cmd.StringVar(&options.ConfigPath, "config", "", "Image set configuration: flag the manifests referenced by its latest run recorded in the history of --workspace, and the orphaned ones")
+		cmd.StringVar(&options.Workspace, "workspace", "", "oc-mirror workspace whose history records the runs of --config")
+	}
+
+	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	serveCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+	serveCmd.StringVar(&options.CacheDir, "cache-dir", "", "oc-mirror cache directory location. Default is $HOME/.oc-mirror/.cache")
+	serveCmd.IntVar(&options.Port, "port", 55000, "HTTP port the registry listens on. 0 picks a free port")
+	serveCmd.StringVar(&options.RegistryTLSCert, "tls-cert", "", "Certificate file served by the registry, to enable TLS (requires --tls-key)")
+	serveCmd.StringVar(&options.RegistryTLSKey, "tls-key", "", "Key file of the --tls-cert certificate")
+	serveCmd.StringVar(&options.RegistryHtpasswd, "htpasswd", "", "htpasswd file (bcrypt) used to authenticate the clients of the registry")
+	serveCmd.BoolVar(&options.RegistryReadOnly, "read-only", false, "Serve the cache in read-only mode: pushes and deletes are refused")
+
+	for _, cmd := range []*flag.FlagSet{mainCmd, serveCmd, cacheInventoryCmd, cacheGCCmd, cacheExportCmd, cacheImportCmd, cacheListCmd, cacheDiskUsageCmd} {
+		cmd.StringVar(&options.CacheDriver, "cache-driver", cache.FilesystemDriver, "Storage driver of the cache one of ("+strings.Join(cache.StorageDrivers, ", ")+"). inmemory only lives for the duration of a mirroring run")
+		cmd.Func("cache-driver-param", "Parameter of the cache storage driver as key=value, e.g. bucket=oc-mirror or regionendpoint=http://minio:9000 for s3 (can be repeated)", func(s string) error {
+			key, value, ok := strings.Cut(s, "=")
+			if !ok || key == "" {
+				return fmt.Errorf("expected key=value")
+			}
+			if options.CacheDriverParams == nil {
+				options.CacheDriverParams = map[string]string{}
+			}
+			options.CacheDriverParams[strings.ToLower(key)] = value
+			return nil
+		})
+	}
+
+	historyListCmd := flag.NewFlagSet("history list", flag.ExitOnError)
+	historyListCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+	historyListCmd.StringVar(&options.Workspace, "workspace", "", "oc-mirror workspace the history metadata belongs to")
+	historyListCmd.StringVar(&options.OutputFormat, "output", textOutput, "Output format one of (text, json)")
+	historyListCmd.StringVar(&options.HistoryImageFilter, "image", "", "Only list the runs including an image whose origin or destination contains this value, or with this digest")
+
+	historyShowCmd := flag.NewFlagSet("history show", flag.ExitOnError)
+	historyShowCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+	historyShowCmd.StringVar(&options.Workspace, "workspace", "", "oc-mirror workspace the history metadata belongs to")
+	historyShowCmd.StringVar(&options.OutputFormat, "output", textOutput, "Output format one of (text, json)")
+
+	historyPruneCmd := flag.NewFlagSet("history prune", flag.ExitOnError)
+	historyPruneCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+	historyPruneCmd.StringVar(&options.Workspace, "workspace", "", "oc-mirror workspace the history metadata belongs to")
+	historyPruneCmd.IntVar(&options.HistoryKeep, "keep", 0, "Number of most recent history files to keep as is")
+	historyPruneCmd.StringVar(&options.HistoryKeepSinceString, "keep-since", "", "Keep history files dated from this date (format yyyy-MM-dd) as is")
+
+	clusterResourcesApplyCmd := flag.NewFlagSet("cluster-resources apply", flag.ExitOnError)
+	clusterResourcesApplyCmd.StringVar(&options.LogLevel, "log-level", "info", "Log level one of (info, debug, trace, error)")
+	clusterResourcesApplyCmd.StringVar(&options.Workspace, "workspace", "", "oc-mirror workspace whose cluster resources are applied, unless a cluster resources directory is passed as argument")
+	clusterResourcesApplyCmd.StringVar(&options.Kubeconfig, "kubeconfig", "", "kubeconfig of the cluster the cluster resources are applied to. Default is $KUBECONFIG, then $HOME/.kube/config")
+	clusterResourcesApplyCmd.BoolVar(&options.ApplyDiffOnly, "diff-only", false, "Only report the cluster resources that would be created or updated, without applying them")
+	clusterResourcesApplyCmd.StringVar(&options.OutputFormat, "output", textOutput, "Output format one of (text, json)")
+
 	usage := `
 	usage: oc-mirror -c <image set configuration path> [--from | --workspace] <destination prefix>:<destination location> --v2
 
@@ -75,14 +203,89 @@ func Execute() error {
 	# Disk To Mirror
 	oc-mirror -c ./isc.yaml --from file:///home/<user>/oc-mirror/mirror1 docker://localhost:6000 --v2
 
+	# Mirror To Disk, streaming the archive to stdout (or to a named pipe with stream:///path/to/pipe)
+	oc-mirror -c ./isc.yaml --workspace file:///home/<user>/oc-mirror/mirror1 file://- --v2 | ssh <host> 'cat > mirror.tar'
+
+	# Disk To Mirror, reading the archive stream from stdin (or from a named pipe with --from stream:///path/to/pipe)
+	cat mirror.tar | oc-mirror -c ./isc.yaml --from - --workspace file:///home/<user>/oc-mirror/mirror1 docker://localhost:6000 --v2
+
+	# Mirror To Disk, encrypting the archive (and decrypting it during Disk To Mirror)
+	oc-mirror -c ./isc.yaml --archive-recipient age1<public key> file:///home/<user>/oc-mirror/mirror1 --v2
+	oc-mirror -c ./isc.yaml --archive-identity ./key.txt --from file:///home/<user>/oc-mirror/mirror1 docker://localhost:6000 --v2
+
 	# Mirror To Mirror
 	oc-mirror -c ./isc.yaml --workspace file:///home/<user>/oc-mirror/mirror1 docker://localhost:6000 --v2
 
+	# Mirror To Mirror, with the local storage instance on a free port, served over TLS
+	oc-mirror -c ./isc.yaml --workspace file:///home/<user>/oc-mirror/mirror1 docker://localhost:6000 --port 0 --local-storage-tls --v2
+
+	# Mirror To Mirror, with an ImageContentSourcePolicy (OpenShift < 4.13) and a registries.conf (podman, CRI-O) besides IDMS and ITMS
+	oc-mirror -c ./isc.yaml --workspace file:///home/<user>/oc-mirror/mirror1 docker://localhost:6000 --mirror-set-format idms-itms --mirror-set-format icsp --mirror-set-format registries-conf --v2
+
+	# Disk To Mirror, applying the generated cluster resources to the cluster
+	oc-mirror -c ./isc.yaml --from file:///home/<user>/oc-mirror/mirror1 docker://localhost:6000 --apply --kubeconfig ./kubeconfig --v2
+
+	# Show what the cluster resources of a workspace would change in the cluster, then apply them
+	oc-mirror cluster-resources apply --workspace file:///home/<user>/oc-mirror/mirror1 --kubeconfig ./kubeconfig --diff-only
+	oc-mirror cluster-resources apply --workspace file:///home/<user>/oc-mirror/mirror1 --kubeconfig ./kubeconfig
+
+	# Mirror To Mirror, with a kustomization.yaml labelling the cluster resources and a helm chart wrapping them, for GitOps
+	oc-mirror -c ./isc.yaml --workspace file:///home/<user>/oc-mirror/mirror1 docker://localhost:6000 --kustomize-label app.kubernetes.io/managed-by=oc-mirror --helm-chart --v2
+
+	# Mirror To Disk then Disk To Mirror, with the cosign signatures, attestations and SBOMs of the images
+	oc-mirror -c ./isc.yaml file:///home/<user>/oc-mirror/mirror1 --sigstore-artifacts --v2
+	oc-mirror -c ./isc.yaml --from file:///home/<user>/oc-mirror/mirror1 docker://localhost:6000 --sigstore-artifacts --v2
+
+	# Inspect the content of an archive without extracting it
+	oc-mirror archive inspect --output json /home/<user>/oc-mirror/mirror1
+
+	# Export the blob inventory of the cache (disconnected side), to build an archive against it (connected side)
+	oc-mirror cache inventory ./inventory.txt
+	oc-mirror -c ./isc.yaml --baseline-inventory ./inventory.txt file:///home/<user>/oc-mirror/mirror1 --v2
+
+	# List the repositories, manifests and tags of the cache, and show its disk usage
+	oc-mirror cache ls --config ./isc.yaml --workspace file:///home/<user>/oc-mirror/mirror1
+	oc-mirror cache du --output json
+
+	# Remove the blobs of the cache no manifest references anymore (report only with --dry-run)
+	oc-mirror cache gc --dry-run
+	# ... as well as the manifests not mirrored by the latest run of an image set configuration
+	oc-mirror cache gc --config ./isc.yaml --workspace file:///home/<user>/oc-mirror/mirror1
+
+	# Seed the cache of another host with the images of the latest run of an image set configuration
+	oc-mirror cache export --images ./isc.yaml --workspace file:///home/<user>/oc-mirror/mirror1 ./cache-export.tar
+	oc-mirror cache import ./cache-export.tar
+	# ... or with a list of images of the cache, over ssh
+	oc-mirror cache export --images ./images.txt - | ssh build-host oc-mirror cache import -
+
+	# List the runs recorded in the history metadata of a workspace, and show the content of one of them
+	oc-mirror history list --workspace file:///home/<user>/oc-mirror/mirror1 --image ubi8
+	oc-mirror history show --workspace file:///home/<user>/oc-mirror/mirror1 <run id>
+
+	# Compact the history metadata of a workspace, keeping the 5 most recent runs as is
+	oc-mirror history prune --workspace file:///home/<user>/oc-mirror/mirror1 --keep 5
+
+	# Parallel Mirror To Disk runs sharing the cache, and a single local storage instance
+	oc-mirror -c ./isc-ocp.yaml file:///home/<user>/oc-mirror/ocp --shared-local-storage --v2 &
+	oc-mirror -c ./isc-operators.yaml file:///home/<user>/oc-mirror/operators --shared-local-storage --v2
+
+	# Mirror To Disk, with the cache in an S3 compatible bucket (MinIO here)
+	oc-mirror -c ./isc.yaml file:///home/<user>/oc-mirror/mirror1 --cache-driver s3 --cache-driver-param bucket=oc-mirror --cache-driver-param regionendpoint=http://minio:9000 --cache-driver-param region=us-east-1 --cache-driver-param forcepathstyle=true --v2
+
+	# Serve the cache as a standalone registry, read-only and over TLS, until interrupted
+	oc-mirror serve --cache-dir /home/<user>/.oc-mirror/.cache --port 5000 --tls-cert ./tls.crt --tls-key ./tls.key --read-only
+
 	# Delete Phase 1 (--generate)
 	oc-mirror delete -c ./delete-isc.yaml --generate --workspace file:///home/<user>/oc-mirror/delete1 --delete-id delete1-test docker://localhost:6000 --v2
 
 	# Delete Phase 2
 	oc-mirror delete --delete-yaml-file /home/<user>/oc-mirror/delete1/working-dir/delete/delete-images-delete1-test.yaml docker://localhost:6000 --v2
+
+	# Delete Phase 2, updating the cumulative mirror sets of the workspace the images were mirrored from
+	oc-mirror delete --delete-yaml-file /home/<user>/oc-mirror/delete1/working-dir/delete/delete-images-delete1-test.yaml --workspace file:///home/<user>/oc-mirror/mirror1 docker://localhost:6000 --v2
+
+	# Delete Phase 2, without deleting the manifests still used by the content of the image set configuration mirrored in the registry
+	oc-mirror delete --delete-yaml-file /home/<user>/oc-mirror/delete1/working-dir/delete/delete-images-delete1-test.yaml --keep-config ./isc.yaml docker://localhost:6000 --v2
 `
 
 	if len(os.Args) == 1 {
@@ -91,8 +294,8 @@ func Execute() error {
 	}
 
 	subCommand := mirrorCommand
-	if os.Args[1] == deleteCommand {
-		subCommand = deleteCommand
+	if os.Args[1] == deleteCommand || os.Args[1] == archiveCommand || os.Args[1] == cacheCommand || os.Args[1] == historyCommand || os.Args[1] == serveCommand || os.Args[1] == clusterResourcesCommand {
+		subCommand = os.Args[1]
 	}
 
 	switch subCommand {
@@ -150,8 +353,151 @@ func Execute() error {
 		endTime := time.Now()
 		execTime := endTime.Sub(startTime)
 		log.Info("mirror time     : %v", execTime)
+	case serveCommand:
+		if len(os.Args) > 2 && os.Args[2] == "--help" {
+			serveCmd.PrintDefaults()
+			os.Exit(0)
+		}
+		err := serveCmd.Parse(os.Args[2:])
+		if err != nil {
+			fmt.Printf("parsing serve command line args %v\n", err)
+			return fmt.Errorf("parsing serve command line args %w", err)
+		}
+		log := clog.New(options.LogLevel)
+		err = NewServeController(log, &options).Process(serveCmd.Args())
+		if err != nil {
+			log.Error(err.Error())
+			return err
+		}
+	case archiveCommand:
+		return executeSubCommand(usage, &options, map[string]groupCommand{
+			inspectSubCommand: {
+				flags: archiveInspectCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewArchiveInspectController(log, &options).Process(args)
+				},
+			},
+		})
+	case cacheCommand:
+		return executeSubCommand(usage, &options, map[string]groupCommand{
+			inventorySubCommand: {
+				flags: cacheInventoryCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewCacheInventoryController(log, &options).Process(args)
+				},
+			},
+			gcSubCommand: {
+				flags: cacheGCCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewCacheGCController(log, &options).Process(args)
+				},
+			},
+			exportSubCommand: {
+				flags: cacheExportCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewCacheExportController(log, &options).Process(args)
+				},
+			},
+			importSubCommand: {
+				flags: cacheImportCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewCacheImportController(log, &options).Process(args)
+				},
+			},
+			lsSubCommand: {
+				flags: cacheListCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewCacheListController(log, &options).Process(args)
+				},
+			},
+			duSubCommand: {
+				flags: cacheDiskUsageCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewCacheDiskUsageController(log, &options).Process(args)
+				},
+			},
+		})
+	case historyCommand:
+		return executeSubCommand(usage, &options, map[string]groupCommand{
+			listSubCommand: {
+				flags: historyListCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewHistoryListController(log, &options).Process(args)
+				},
+			},
+			showSubCommand: {
+				flags: historyShowCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewHistoryShowController(log, &options).Process(args)
+				},
+			},
+			pruneSubCommand: {
+				flags: historyPruneCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewHistoryPruneController(log, &options).Process(args)
+				},
+			},
+		})
+	case clusterResourcesCommand:
+		return executeSubCommand(usage, &options, map[string]groupCommand{
+			applySubCommand: {
+				flags: clusterResourcesApplyCmd,
+				run: func(log clog.PluggableLoggerInterface, args []string) error {
+					return NewClusterResourcesApplyController(log, &options).Process(args)
+				},
+			},
+		})
 	default:
 		return fmt.Errorf("it seems you stuffed up the command line args")
 	}
 	return nil
 }
+
+// addKeyValue adds the key=value s to values
+func addKeyValue(values *map[string]string, s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value")
+	}
+	if *values == nil {
+		*values = map[string]string{}
+	}
+	(*values)[key] = value
+	return nil
+}
+
+// groupCommand is a command of a group of commands: oc-mirror <group> <command>
+type groupCommand struct {
+	flags *flag.FlagSet
+	run   func(log clog.PluggableLoggerInterface, args []string) error
+}
+
+// executeSubCommand parses the flags of the command of the group selected by os.Args[2],
+// and runs it
+func executeSubCommand(usage string, options *common.MirrorOptions, commands map[string]groupCommand) error {
+	if len(os.Args) == 2 {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	cmd, ok := commands[os.Args[2]]
+	if !ok {
+		fmt.Println(usage)
+		os.Exit(1)
+	}
+	if len(os.Args) > 3 && os.Args[3] == "--help" {
+		cmd.flags.PrintDefaults()
+		os.Exit(0)
+	}
+	err := cmd.flags.Parse(os.Args[3:])
+	if err != nil {
+		fmt.Printf("parsing %s command line args %v\n", cmd.flags.Name(), err)
+		return fmt.Errorf("parsing %s command line args %w", cmd.flags.Name(), err)
+	}
+	log := clog.New(options.LogLevel)
+	err = cmd.run(log, cmd.flags.Args())
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/history"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+type HistoryListController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+	Out     io.Writer
+}
+
+type HistoryShowController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+	Out     io.Writer
+}
+
+type HistoryPruneController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+}
+
+func NewHistoryListController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) HistoryListController {
+	return HistoryListController{
+		Log:     log,
+		Options: opts,
+		Out:     os.Stdout,
+	}
+}
+
+func NewHistoryShowController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) HistoryShowController {
+	return HistoryShowController{
+		Log:     log,
+		Options: opts,
+		Out:     os.Stdout,
+	}
+}
+
+func NewHistoryPruneController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) HistoryPruneController {
+	return HistoryPruneController{
+		Log:     log,
+		Options: opts,
+	}
+}
+
+// Process lists the runs recorded in the history metadata of the workspace,
+// optionally only the ones that included an image matching --image
+func (o HistoryListController) Process(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("history list doesn't expect any argument")
+	}
+	if err := checkOutputFormat(o.Options.OutputFormat); err != nil {
+		return err
+	}
+	records, err := readHistoryRecords(o.Log, o.Options)
+	if err != nil {
+		return err
+	}
+	if o.Options.HistoryImageFilter != "" {
+		filtered := []history.Record{}
+		for _, record := range records {
+			if recordIncludesImage(record, o.Options.HistoryImageFilter) {
+				filtered = append(filtered, record)
+			}
+		}
+		records = filtered
+	}
+	if o.Options.OutputFormat == jsonOutput {
+		encoder := json.NewEncoder(o.Out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	}
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN ID\tTIMESTAMP\tMODE\tIMAGES\tBLOBS\tCHUNKS")
+	for _, record := range records {
+		mode := record.Mode
+		if record.Legacy {
+			mode = "legacy"
+		}
+		if record.Baseline {
+			mode = "baseline"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", record.RunID, record.Timestamp.Format(time.RFC3339), mode, len(record.Images), len(record.Blobs), len(record.Chunks))
+	}
+	return w.Flush()
+}
+
+// Process prints the content of the run passed as argument
+func (o HistoryShowController) Process(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("history show expects exactly one argument: the id of the run")
+	}
+	if err := checkOutputFormat(o.Options.OutputFormat); err != nil {
+		return err
+	}
+	records, err := readHistoryRecords(o.Log, o.Options)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record.RunID != args[0] {
+			continue
+		}
+		if o.Options.OutputFormat == jsonOutput {
+			encoder := json.NewEncoder(o.Out)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(record)
+		}
+		return o.printRecord(record)
+	}
+	return fmt.Errorf("no run %s found in the history metadata", args[0])
+}
+
+func (o HistoryShowController) printRecord(record history.Record) error {
+	w := tabwriter.NewWriter(o.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Run:\t%s\n", record.RunID)
+	fmt.Fprintf(w, "Timestamp:\t%s\n", record.Timestamp.Format(time.RFC3339))
+	switch {
+	case record.Legacy:
+		fmt.Fprintln(w, "Format:\tlegacy (blobs only, cumulative)")
+	case record.Baseline:
+		fmt.Fprintln(w, "Format:\tbaseline (blobs of the compacted runs)")
+	default:
+		fmt.Fprintf(w, "Mode:\t%s\n", record.Mode)
+		fmt.Fprintf(w, "Image set configuration:\t%s\n", record.ISCHash)
+	}
+	fmt.Fprintf(w, "Blobs:\t%d\n", len(record.Blobs))
+	if len(record.Chunks) > 0 {
+		fmt.Fprintf(w, "Chunks:\t%s\n", strings.Join(record.Chunks, ", "))
+	}
+	if len(record.Images) > 0 {
+		fmt.Fprintln(w, "\nORIGIN\tDIGEST\tBLOBS")
+		for _, image := range record.Images {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", image.Origin, image.Digest, len(image.Blobs))
+		}
+	}
+	return w.Flush()
+}
+
+// Process compacts the history files of the workspace falling out of the retention policy
+// set by --keep and --keep-since into a single baseline
+func (o HistoryPruneController) Process(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("history prune doesn't expect any argument")
+	}
+	if err := validateHistoryRetention(o.Options); err != nil {
+		return err
+	}
+	if o.Options.HistoryKeep == 0 && o.Options.HistoryKeepSinceString == "" {
+		return fmt.Errorf("use --keep and/or --keep-since to set the history files to keep")
+	}
+	historyWorkingDir, err := historyWorkingDir(o.Options)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockWorkspace(o.Log, historyWorkingDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	h, err := history.NewHistory(historyWorkingDir, time.Time{}, o.Log, history.OSFileCreator{})
+	if err != nil {
+		return fmt.Errorf("unable to access the history metadata: %w", err)
+	}
+	compacted, err := h.Compact(history.Retention{Keep: o.Options.HistoryKeep, Since: o.Options.HistoryKeepSince})
+	if err != nil {
+		return err
+	}
+	if len(compacted) == 0 {
+		o.Log.Info(emoji.Memo + " nothing to compact in the history metadata")
+		return nil
+	}
+	o.Log.Info(emoji.Memo+" compacted the history of %d runs into a baseline", len(compacted))
+	return nil
+}
+
+// historyWorkingDir returns the working-dir of --workspace, which must exist
+func historyWorkingDir(opts *common.MirrorOptions) (string, error) {
+	if opts.Workspace == "" {
+		return "", fmt.Errorf("use the --workspace flag to select the workspace the history metadata belongs to")
+	}
+	if !strings.HasPrefix(opts.Workspace, fileProtocol) {
+		return "", fmt.Errorf("when --workspace is used, it must have file:// prefix")
+	}
+	historyWorkingDir := filepath.Join(strings.TrimPrefix(opts.Workspace, fileProtocol), workingDir)
+	if _, err := os.Stat(historyWorkingDir); err != nil {
+		return "", fmt.Errorf("unable to access the working-dir of the workspace: %w", err)
+	}
+	return historyWorkingDir, nil
+}
+
+// readHistoryRecords reads all the records of the history metadata found in the working-dir of --workspace
+func readHistoryRecords(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) ([]history.Record, error) {
+	historyWorkingDir, err := historyWorkingDir(opts)
+	if err != nil {
+		return nil, err
+	}
+	h, err := history.NewHistory(historyWorkingDir, time.Time{}, log, history.OSFileCreator{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to access the history metadata: %w", err)
+	}
+	records, err := h.Records()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the history metadata: %w", err)
+	}
+	return records, nil
+}
+
+// recordIncludesImage checks whether an image of the record has an origin or destination containing filter,
+// or filter as digest
+func recordIncludesImage(record history.Record, filter string) bool {
+	for _, image := range record.Images {
+		if strings.Contains(image.Origin, filter) || strings.Contains(image.Destination, filter) || image.Digest == filter {
+			return true
+		}
+	}
+	return false
+}
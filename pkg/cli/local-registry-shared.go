@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/lock"
+)
+
+const (
+	sharedLocalStorageLockTimeout   = 10 * time.Second
+	sharedLocalStorageDrainInterval = 2 * time.Second
+)
+
+// sharedLocalStorage is the state file of the local storage registry an oc-mirror run serves
+// for the other runs using the same cache (--shared-local-storage). It sits in the cache directory.
+type sharedLocalStorage struct {
+	// Storage is the location of the cache served (see cache.StorageConfig.String)
+	Storage string `json:"storage"`
+	// Owner is the run serving the registry
+	Owner lock.Owner `json:"owner"`
+	Port  int        `json:"port"`
+	// CertDir is the directory of the CA of the registry, when served over TLS
+	CertDir string `json:"certDir,omitempty"`
+	// Clients are the other runs using the registry: the owner keeps serving until they all complete
+	Clients []lock.Owner `json:"clients,omitempty"`
+}
+
+// updateSharedLocalStorage reads the state of the shared local storage registry of cacheDir,
+// and replaces it by the one returned by update (nil removes it), while holding the lock of the state file:
+// update sees no concurrent change of the state
+func updateSharedLocalStorage(cacheDir string, update func(state *sharedLocalStorage) (*sharedLocalStorage, error)) error {
+	stateLock, err := lock.AcquireExclusive(filepath.Join(cacheDir, sharedLocalStorageLockFile), sharedLocalStorageLockTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to access the shared local storage state: %w", err)
+	}
+	defer stateLock.Release()
+
+	statePath := filepath.Join(cacheDir, sharedLocalStorageFile)
+	var state *sharedLocalStorage
+	content, err := os.ReadFile(statePath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("unable to read the shared local storage state: %w", err)
+	default:
+		state = &sharedLocalStorage{}
+		if err := json.Unmarshal(content, state); err != nil {
+			// a state that can't be read can't describe a live registry
+			state = nil
+		}
+	}
+
+	newState, err := update(state)
+	if err != nil {
+		return err
+	}
+	if newState == nil {
+		if err := os.Remove(statePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("unable to remove the shared local storage state: %w", err)
+		}
+		return nil
+	}
+	content, err = json.MarshalIndent(newState, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	tmp := statePath + "." + strconv.Itoa(os.Getpid()) + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("unable to write the shared local storage state: %w", err)
+	}
+	if err := os.Rename(tmp, statePath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to write the shared local storage state: %w", err)
+	}
+	return nil
+}
+
+// liveClients returns the clients of the registry still running
+func (o sharedLocalStorage) liveClients() []lock.Owner {
+	return slices.DeleteFunc(slices.Clone(o.Clients), func(client lock.Owner) bool {
+		return !lock.IsAlive(client)
+	})
+}
@@ -0,0 +1,111 @@
+package cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+)
+
+const (
+	localStorageServerDir    string = "server"
+	localStorageCertFile     string = "tls.crt"
+	localStorageKeyFile      string = "tls.key"
+	localStorageCertValidity        = 7 * 24 * time.Hour
+)
+
+// generateLocalStorageCertificates generates an ephemeral CA, and a certificate it signs for
+// localhost, 127.0.0.1 and ::1. The CA (the trust bundle of the run) is written to certDir,
+// the certificate and its key to the server sub directory: certDir is used as a docker cert
+// directory by the clients, where a key without client certificate is an error.
+// The CA key is never written: nothing else can be signed with it.
+func generateLocalStorageCertificates(certDir string) (string, string, error) {
+	notBefore := time.Now().Add(-time.Minute)
+	notAfter := notBefore.Add(localStorageCertValidity)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to generate the CA key: %w", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: "oc-mirror local storage CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to generate the CA certificate: %w", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return "", "", fmt.Errorf("%w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to generate the local storage key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to generate the local storage certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("%w", err)
+	}
+
+	serverDir := filepath.Join(certDir, localStorageServerDir)
+	if err := os.MkdirAll(serverDir, 0700); err != nil {
+		return "", "", fmt.Errorf("%w", err)
+	}
+	files := []struct {
+		path      string
+		blockType string
+		der       []byte
+		mode      os.FileMode
+	}{
+		{filepath.Join(certDir, common.LocalStorageCAFile), "CERTIFICATE", caDER, 0644},
+		{filepath.Join(serverDir, localStorageCertFile), "CERTIFICATE", certDER, 0644},
+		{filepath.Join(serverDir, localStorageKeyFile), "EC PRIVATE KEY", keyDER, 0600},
+	}
+	for _, file := range files {
+		content := pem.EncodeToMemory(&pem.Block{Type: file.blockType, Bytes: file.der})
+		if err := os.WriteFile(file.path, content, file.mode); err != nil {
+			return "", "", fmt.Errorf("unable to write %s: %w", file.path, err)
+		}
+	}
+	return files[1].path, files[2].path, nil
+}
+
+func newSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
@@ -10,15 +10,19 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"text/template"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/registry"
-	_ "github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	_ "github.com/distribution/distribution/v3/registry/auth/htpasswd"
 	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
 
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/cache"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/lock"
 	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
 )
 
@@ -33,10 +37,27 @@ type LocalStorage struct {
 
 // Setup calls setupLocalStorage - private function that sets up
 // a local (distribution) registry
-func (o LocalStorage) Setup() error {
+// Once Setup succeeds, StopLocalRegistry must be deferred by the caller, so that it runs on every exit path.
+// When Setup fails, it releases the shared registry and removes the certificates of this run itself.
+func (o LocalStorage) Setup() (err error) {
+	defer func() {
+		if err != nil {
+			o.releaseSetup()
+		}
+	}()
+	if o.Options.SharedLocalStorage {
+		if err := o.setupShared(); err != nil {
+			return err
+		}
+		if o.Options.LocalStorageReused {
+			return nil
+		}
+	} else if err := o.setupListener(); err != nil {
+		return err
+	}
 	config, err := setupLocalRegistryConfig(o.Options)
 	if err != nil {
-		o.Log.Error("parsing config %w", err)
+		return err
 	}
 	regLogger := logrus.New()
 	// prepare the logger
@@ -65,15 +86,142 @@ func (o LocalStorage) Setup() error {
 	return nil
 }
 
+// setupListener sets up the port of the local storage registry, and its certificates with --local-storage-tls
+func (o LocalStorage) setupListener() error {
+	if err := o.setupPort(); err != nil {
+		return err
+	}
+	if o.Options.LocalStorageTLS {
+		return o.setupTLS()
+	}
+	return nil
+}
+
+// setupShared reuses the local storage registry served on the same cache by another run of this host, if any.
+// Otherwise, it sets up the registry of this run, and publishes it for the next runs.
+func (o LocalStorage) setupShared() error {
+	storageConfig, err := cache.NewStorageConfig(o.Options.CacheDriver, o.Options.LocalStorageDisk, o.Options.CacheDriverParams)
+	if err != nil {
+		return err
+	}
+	self, err := lock.CurrentOwner()
+	if err != nil {
+		return fmt.Errorf("unable to set up the shared local storage registry: %w", err)
+	}
+	return updateSharedLocalStorage(o.Options.CacheDir, func(state *sharedLocalStorage) (*sharedLocalStorage, error) {
+		if state == nil || !lock.IsAlive(state.Owner) {
+			if err := o.setupListener(); err != nil {
+				return nil, err
+			}
+			o.Log.Debug("local storage registry shared with the next runs using %s", storageConfig)
+			return &sharedLocalStorage{
+				Storage: storageConfig.String(),
+				Owner:   self,
+				Port:    o.Options.Port,
+				CertDir: o.Options.LocalStorageCertDir,
+			}, nil
+		}
+		if state.Owner.Hostname != self.Hostname || state.Storage != storageConfig.String() || (state.CertDir != "") != o.Options.LocalStorageTLS {
+			o.Log.Warn("the local storage registry shared by process %d on %s doesn't match this run (storage, TLS): starting a dedicated one", state.Owner.PID, state.Owner.Hostname)
+			return state, o.setupListener()
+		}
+		state.Clients = append(state.liveClients(), self)
+		o.Options.Port = state.Port
+		o.Options.LocalStorageFQDN = "localhost:" + strconv.Itoa(state.Port)
+		o.Options.LocalStorageCertDir = state.CertDir
+		o.Options.LocalStorageReused = true
+		o.Log.Info("reusing the local storage registry of process %d on %s", state.Owner.PID, o.Options.LocalStorageFQDN)
+		return state, nil
+	})
+}
+
+// releaseShared unregisters this run from the shared local storage registry: a client leaves it,
+// the owner waits for its clients to complete before it stops serving
+func (o LocalStorage) releaseShared() {
+	self, err := lock.CurrentOwner()
+	if err != nil {
+		o.Log.Warn("unable to release the shared local storage registry: %v", err)
+		return
+	}
+	waiting := false
+	for {
+		done := true
+		err := updateSharedLocalStorage(o.Options.CacheDir, func(state *sharedLocalStorage) (*sharedLocalStorage, error) {
+			if state == nil {
+				return nil, nil
+			}
+			if !state.Owner.SameProcess(self) {
+				state.Clients = slices.DeleteFunc(state.liveClients(), self.SameProcess)
+				return state, nil
+			}
+			state.Clients = state.liveClients()
+			if len(state.Clients) == 0 {
+				return nil, nil
+			}
+			done = false
+			if !waiting {
+				o.Log.Info("waiting for %d oc-mirror run(s) still using the local storage registry", len(state.Clients))
+				waiting = true
+			}
+			return state, nil
+		})
+		if err != nil {
+			o.Log.Warn("%v", err)
+			return
+		}
+		if done {
+			return
+		}
+		time.Sleep(sharedLocalStorageDrainInterval)
+	}
+}
+
+// setupPort picks a free port when --port is 0, checks that the port is not already in use otherwise,
+// and updates LocalStorageFQDN accordingly
+func (o LocalStorage) setupPort() error {
+	if o.Options.Port == 0 {
+		port, err := freeLocalPort()
+		if err != nil {
+			return err
+		}
+		o.Options.Port = port
+		o.Log.Debug("local storage registry will listen on port %d", port)
+	} else if isLocalStoragePortBound(*o.Options) {
+		return fmt.Errorf("port %d of the local storage registry is already in use: use --port to select another port, or --port 0 to pick a free one", o.Options.Port)
+	}
+	o.Options.LocalStorageFQDN = "localhost:" + strconv.Itoa(o.Options.Port)
+	return nil
+}
+
+// setupTLS generates the ephemeral CA and certificate of the local storage registry for this run.
+// The CA is the only one trusted when reaching the local storage registry (see MirrorOptions.SetLocalStorageTLS).
+func (o LocalStorage) setupTLS() error {
+	certDir, err := os.MkdirTemp(o.Options.TmpDir, localStorageTLSDirPrefix)
+	if err != nil {
+		return fmt.Errorf("unable to create the local storage certificates directory: %w", err)
+	}
+	certFile, keyFile, err := generateLocalStorageCertificates(certDir)
+	if err != nil {
+		os.RemoveAll(certDir)
+		return err
+	}
+	o.Options.RegistryTLSCert = certFile
+	o.Options.RegistryTLSKey = keyFile
+	o.Options.LocalStorageCertDir = certDir
+	o.Log.Info("local storage registry served over TLS, CA of this run: %s", filepath.Join(certDir, common.LocalStorageCAFile))
+	return nil
+}
+
+// StartLocalRegistry serves the local storage registry until StopLocalRegistry is called.
+// The port was checked by Setup: failing to serve past that point leaves no way to continue.
+// Nothing is served when the run reuses the registry of another run.
 func (o LocalStorage) StartLocalRegistry() {
-	if isLocalStoragePortBound(*o.Options) {
-		o.Log.Error("Could not start local registry port is already in use")
-		// no point continuing
-		os.Exit(1)
+	if o.Options.LocalStorageReused {
+		return
 	}
 	err := o.Options.LocalStorageService.ListenAndServe()
 	if !errors.Is(err, http.ErrServerClosed) {
-		o.Log.Error("Could not start local registry: %w", err)
+		o.Log.Error("Could not start local registry: %v", err)
 		// no point continuing
 		os.Exit(1)
 	}
@@ -81,6 +229,12 @@ func (o LocalStorage) StartLocalRegistry() {
 
 // stopLocalRegistry - stops the local registry and closes the registry.log file
 func (o LocalStorage) StopLocalRegistry() {
+	if o.Options.SharedLocalStorage {
+		o.releaseShared()
+		if o.Options.LocalStorageReused {
+			return
+		}
+	}
 	// Try to gracefully shutdown the local registry
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -88,6 +242,8 @@ func (o LocalStorage) StopLocalRegistry() {
 		o.Log.Warn("Registry shutdown failure: %w", err)
 	}
 
+	o.removeCertificates()
+
 	if o.Options.RegistryLogFile != nil {
 		// NOTE: we cannot just close the registry.log file as it is set as logrus output, which could still be in use
 		// by other dependencies before we exit. First we need to make sure logrus uses a different output.
@@ -98,6 +254,28 @@ func (o LocalStorage) StopLocalRegistry() {
 	}
 }
 
+// releaseSetup releases what a failed Setup acquired: nothing is served by this run,
+// and the key of the certificates generated for it is not left on disk
+func (o LocalStorage) releaseSetup() {
+	if o.Options.SharedLocalStorage {
+		o.releaseShared()
+		if o.Options.LocalStorageReused {
+			return
+		}
+	}
+	o.removeCertificates()
+}
+
+// removeCertificates removes the certificates generated for this run only
+func (o LocalStorage) removeCertificates() {
+	if o.Options.LocalStorageCertDir == "" {
+		return
+	}
+	if err := os.RemoveAll(o.Options.LocalStorageCertDir); err != nil {
+		o.Log.Warn("unable to remove the local storage certificates: %v", err)
+	}
+}
+
 // isLocalStoragePortBound - private utility to check if port is bound
 func isLocalStoragePortBound(opts common.MirrorOptions) bool {
 	// Check if the port is already bound
@@ -109,66 +287,89 @@ func isLocalStoragePortBound(opts common.MirrorOptions) bool {
 	return false
 }
 
+// freeLocalPort - private utility returning a port not in use, picked by the system
+func freeLocalPort() (int, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, fmt.Errorf("unable to find a free port for the local storage registry: %w", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
 // setupLocalRegistryConfig - private function to parse registry config
-// used in both localregistry serve and localregistry garbage-collect (for delete)
+// used by the local storage registry and by serve (the cache commands use the storage driver directly)
 func setupLocalRegistryConfig(opts *common.MirrorOptions) (*configuration.Configuration, error) {
-	// create config file for local registry
-	// sonarqube scanner variable declaration convention
-	configYamlV01 := `
-version: 0.1
-log:
-  accesslog:
-    disabled: {{ .LogAccessOff }}
-  level: {{ .LogLevel }}
-  formatter: text
-  fields:
-    service: registry
-storage:
-  delete:
-    enabled: true
-  cache:
-    blobdescriptor: inmemory
-  filesystem:
-    rootdirectory: {{ .LocalStorageDisk }}
-http:
-  addr: :{{ .LocalStoragePort }}
-  headers:
-    X-Content-Type-Options: [nosniff]
-      #auth:
-      #htpasswd:
-      #realm: basic-realm
-      #path: /etc/registry
-`
-
-	var buff bytes.Buffer
-	type RegistryConfig struct {
-		LocalStorageDisk string
-		LocalStoragePort int
-		LogLevel         string
-		LogAccessOff     bool
-	}
-
-	rc := RegistryConfig{
-		LocalStorageDisk: opts.LocalStorageDisk,
-		LocalStoragePort: int(opts.Port),
-		LogLevel:         opts.LogLevel,
-		LogAccessOff:     true,
+	storageConfig, err := cache.NewStorageConfig(opts.CacheDriver, opts.LocalStorageDisk, opts.CacheDriverParams)
+	if err != nil {
+		return &configuration.Configuration{}, err
+	}
+
+	// the config is marshalled rather than rendered from a template:
+	// the paths of the workspace and of the flags are quoted whatever they contain
+	type enabled struct {
+		Enabled bool `json:"enabled"`
+	}
+	type tlsConfig struct {
+		Certificate string `json:"certificate"`
+		Key         string `json:"key"`
+	}
+	type registryConfig struct {
+		Version string `json:"version"`
+		Log     struct {
+			AccessLog struct {
+				Disabled bool `json:"disabled"`
+			} `json:"accesslog"`
+			Level     string            `json:"level"`
+			Formatter string            `json:"formatter"`
+			Fields    map[string]string `json:"fields"`
+		} `json:"log"`
+		Storage map[string]interface{} `json:"storage"`
+		HTTP    struct {
+			Addr    string              `json:"addr"`
+			Headers map[string][]string `json:"headers"`
+			TLS     *tlsConfig          `json:"tls,omitempty"`
+		} `json:"http"`
+		Auth map[string]interface{} `json:"auth,omitempty"`
 	}
 
+	rc := registryConfig{Version: "0.1"}
+	rc.Log.AccessLog.Disabled = true
+	rc.Log.Level = opts.LogLevel
+	rc.Log.Formatter = "text"
+	rc.Log.Fields = map[string]string{"service": "registry"}
 	if opts.LogLevel == "debug" || opts.LogLevel == "trace" {
-		rc.LogLevel = "debug"
-		rc.LogAccessOff = false
+		rc.Log.Level = "debug"
+		rc.Log.AccessLog.Disabled = false
+	}
+	// the parameters of the storage driver are arbitrary (credentials...): they are not marshalled
+	rc.Storage = map[string]interface{}{
+		"delete":                       enabled{Enabled: !opts.RegistryReadOnly},
+		"cache":                        map[string]string{"blobdescriptor": "inmemory"},
+		storageConfig.RegistryDriver(): map[string]interface{}{},
+	}
+	if opts.RegistryReadOnly {
+		rc.Storage["maintenance"] = map[string]interface{}{"readonly": enabled{Enabled: true}}
+	}
+	rc.HTTP.Addr = ":" + strconv.Itoa(opts.Port)
+	rc.HTTP.Headers = map[string][]string{"X-Content-Type-Options": {"nosniff"}}
+	if opts.RegistryTLSCert != "" {
+		rc.HTTP.TLS = &tlsConfig{Certificate: opts.RegistryTLSCert, Key: opts.RegistryTLSKey}
+	}
+	if opts.RegistryHtpasswd != "" {
+		rc.Auth = map[string]interface{}{
+			"htpasswd": map[string]string{"realm": "oc-mirror", "path": opts.RegistryHtpasswd},
+		}
 	}
 
-	t := template.Must(template.New("local-storage-config").Parse(configYamlV01))
-	err := t.Execute(&buff, rc)
+	content, err := yaml.Marshal(rc)
 	if err != nil {
-		return &configuration.Configuration{}, fmt.Errorf("error parsing the config template %w", err)
+		return &configuration.Configuration{}, fmt.Errorf("error generating the local storage configuration %w", err)
 	}
-
-	config, err := configuration.Parse(bytes.NewReader(buff.Bytes()))
+	config, err := configuration.Parse(bytes.NewReader(content))
 	if err != nil {
 		return &configuration.Configuration{}, fmt.Errorf("error parsing local storage configuration : %w", err)
 	}
+	config.Storage[storageConfig.RegistryDriver()] = configuration.Parameters(storageConfig.Parameters)
 	return config, nil
 }
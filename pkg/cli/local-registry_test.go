@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestReleaseSetupOfSharedLocalStorage(t *testing.T) {
+	cacheDir := t.TempDir()
+	opts := &common.MirrorOptions{
+		CacheDir:           cacheDir,
+		LocalStorageDisk:   filepath.Join(cacheDir, "cache"),
+		SharedLocalStorage: true,
+	}
+	localStorage := LocalStorage{Log: clog.New("error"), Options: opts}
+	require.NoError(t, localStorage.setupShared())
+	require.FileExists(t, filepath.Join(cacheDir, sharedLocalStorageFile))
+	require.False(t, opts.LocalStorageReused)
+
+	// a failed setup doesn't leave the registry published to the next runs, while nothing serves it
+	localStorage.releaseSetup()
+	require.NoFileExists(t, filepath.Join(cacheDir, sharedLocalStorageFile))
+}
+
+func TestGenerateLocalStorageCertificates(t *testing.T) {
+	certDir := t.TempDir()
+	certFile, keyFile, err := generateLocalStorageCertificates(certDir)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(certDir, localStorageServerDir, localStorageCertFile), certFile)
+	require.Equal(t, filepath.Join(certDir, localStorageServerDir, localStorageKeyFile), keyFile)
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	// a key in the cert directory itself would be taken for a client key
+	entries, err := os.ReadDir(certDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	roots := x509.NewCertPool()
+	caContent, err := os.ReadFile(filepath.Join(certDir, common.LocalStorageCAFile))
+	require.NoError(t, err)
+	require.True(t, roots.AppendCertsFromPEM(caContent))
+	certContent, err := os.ReadFile(certFile)
+	require.NoError(t, err)
+	block, _ := pem.Decode(certContent)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	for _, host := range []string{"localhost", "127.0.0.1", "::1"} {
+		_, err := cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: host})
+		require.NoError(t, err, host)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "registry.example.com"})
+	require.Error(t, err)
+}
+
+func TestSetupPort(t *testing.T) {
+	opts := &common.MirrorOptions{}
+	localStorage := LocalStorage{Log: clog.New("error"), Options: opts}
+	// --port 0 picks a free port
+	require.NoError(t, localStorage.setupPort())
+	require.NotZero(t, opts.Port)
+	require.Equal(t, "localhost:"+strconv.Itoa(opts.Port), opts.LocalStorageFQDN)
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	opts.Port = listener.Addr().(*net.TCPAddr).Port
+	require.ErrorContains(t, localStorage.setupPort(), "already in use")
+}
+
+func TestSetupFailureRemovesCertificates(t *testing.T) {
+	tmpDir := t.TempDir()
+	opts := &common.MirrorOptions{
+		TmpDir:          tmpDir,
+		LocalStorageTLS: true,
+		// the registry configuration can't be set up, once the certificates are generated
+		CacheDriver: "unknown",
+	}
+	localStorage := LocalStorage{Log: clog.New("error"), Options: opts}
+	require.ErrorContains(t, localStorage.Setup(), "unsupported cache driver")
+	require.NotEmpty(t, opts.LocalStorageCertDir)
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestSetupLocalRegistryConfig(t *testing.T) {
+	// paths breaking a YAML scalar left unquoted
+	dir := filepath.Join(t.TempDir(), "workspace: #1", "- tls")
+	opts := &common.MirrorOptions{
+		LocalStorageDisk: filepath.Join(dir, "cache"),
+		Port:             55000,
+		LogLevel:         "info",
+		RegistryTLSCert:  filepath.Join(dir, "tls.crt"),
+		RegistryTLSKey:   filepath.Join(dir, "tls.key"),
+		RegistryHtpasswd: filepath.Join(dir, "*htpasswd"),
+		RegistryReadOnly: true,
+	}
+	config, err := setupLocalRegistryConfig(opts)
+	require.NoError(t, err)
+	require.Equal(t, ":55000", config.HTTP.Addr)
+	require.Equal(t, opts.RegistryTLSCert, config.HTTP.TLS.Certificate)
+	require.Equal(t, opts.RegistryTLSKey, config.HTTP.TLS.Key)
+	require.Equal(t, "oc-mirror", config.Auth["htpasswd"]["realm"])
+	require.Equal(t, opts.RegistryHtpasswd, config.Auth["htpasswd"]["path"])
+	require.Equal(t, "filesystem", config.Storage.Type())
+	require.Equal(t, opts.LocalStorageDisk, config.Storage["filesystem"]["rootdirectory"])
+	require.Equal(t, false, config.Storage["delete"]["enabled"])
+	require.Equal(t, map[interface{}]interface{}{"enabled": true}, config.Storage["maintenance"]["readonly"])
+	require.True(t, config.Log.AccessLog.Disabled)
+	require.Equal(t, []string{"nosniff"}, config.HTTP.Headers["X-Content-Type-Options"])
+
+	opts = &common.MirrorOptions{LocalStorageDisk: filepath.Join(dir, "cache"), Port: 55000, LogLevel: "trace"}
+	config, err = setupLocalRegistryConfig(opts)
+	require.NoError(t, err)
+	require.Empty(t, config.HTTP.TLS.Certificate)
+	require.Empty(t, config.Auth)
+	require.Equal(t, true, config.Storage["delete"]["enabled"])
+	require.NotContains(t, config.Storage, "maintenance")
+	require.Equal(t, "debug", string(config.Log.Level))
+	require.False(t, config.Log.AccessLog.Disabled)
+}
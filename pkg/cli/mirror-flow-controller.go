@@ -3,25 +3,34 @@ package cli
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
+	digest "github.com/opencontainers/go-digest"
+
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/additional"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/archive"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/batch"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/cache"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/clusterresources"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/collector"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/config"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/helm"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/history"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/image"
 	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/manifest"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/mirror"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/operator"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/release"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/signature"
 )
 
 type MirrorFlowController struct {
@@ -49,6 +58,19 @@ func (o MirrorFlowController) Process(args []string) error {
 	o.Log.Info(emoji.WavingHandSign + " Hello, welcome to oc-mirror (version refactor)")
 	o.Log.Info(emoji.Gear + "  setting up the environment for you...")
 
+	// the locks are taken before setting up the directories, which resets the cluster resources of the workspace
+	unlock, err := lockWorkspace(o.Log, o.Options.WorkingDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	unlockCache, err := lockCache(o.Log, cacheDirectory(o.Options.CacheDir), false)
+	if err != nil {
+		return err
+	}
+	defer unlockCache()
+
 	err = o.Setup.CreateDirectories()
 	if err != nil {
 		return fmt.Errorf("setting up directories %s", err.Error())
@@ -75,12 +97,7 @@ func (o MirrorFlowController) Process(args []string) error {
 			archiveBaseDir = strings.Split(o.Options.WorkingDir, "working-dir")[0]
 		}
 		// extract the archive
-		extractor, err := archive.NewArchiveExtractor(archiveBaseDir, archiveBaseDir, o.Options.LocalStorageDisk)
-		if err != nil {
-			o.Log.Error(" %w ", err)
-			return err
-		}
-		err = extractor.Unarchive()
+		err = o.extractArchive(archiveBaseDir)
 		if err != nil {
 			o.Log.Error(" %w ", err)
 			return err
@@ -108,8 +125,16 @@ func (o MirrorFlowController) Process(args []string) error {
 		return err
 	}
 
+	// the registry is stopped on every exit path: as the owner of a shared registry,
+	// this run waits for the runs still using it, even when it fails
+	defer localStorage.StopLocalRegistry()
 	go localStorage.StartLocalRegistry()
 
+	trustPolicy, err := o.setupTrustPolicy(cfg.(v2alpha1.ImageSetConfiguration).TrustPolicy)
+	if err != nil {
+		return err
+	}
+
 	// use single responsibility principle
 	// use of open/close principle
 	collectManager.AddCollector(releaseCollector)
@@ -122,6 +147,7 @@ func (o MirrorFlowController) Process(args []string) error {
 	}
 	o.Log.Trace("source %v", allCollectorSchema)
 	batch := batch.New(o.Log, o.Options.WorkingDir+"/logs", mirror, 8)
+	batch.TrustPolicy = trustPolicy
 
 	copiedImages := getUpdatedCopiedImages(allCollectorSchema)
 
@@ -150,6 +176,13 @@ func (o MirrorFlowController) Process(args []string) error {
 		return err
 	}
 
+	if o.Options.IsDiskToMirror() || o.Options.IsMirrorToMirror() {
+		// the runs without archive are recorded in the history too, for cache gc --config
+		if err := o.recordRun(ctx, copiedImages.AllImages); err != nil {
+			o.Log.Warn("unable to record the run in the history: %v", err)
+		}
+	}
+
 	graphImage, err := graph.Create(graphURL)
 	if err != nil {
 		return err
@@ -165,11 +198,33 @@ func (o MirrorFlowController) Process(args []string) error {
 		return err
 	}
 
-	localStorage.StopLocalRegistry()
 	o.Log.Info(emoji.WavingHandSign + " Goodbye, thank you for using oc-mirror")
 	return nil
 }
 
+// setupTrustPolicy generates the signature policy of the trust policy of the image set configuration in the working-dir,
+// and verifies the images with it (instead of the policy of --secure-policy)
+func (o MirrorFlowController) setupTrustPolicy(cfg v2alpha1.TrustPolicy) (*signature.TrustPolicy, error) {
+	if cfg.IsEmpty() {
+		return nil, nil
+	}
+	trustPolicy := signature.NewTrustPolicy(cfg, o.Options.LocalStorageFQDN)
+	policyPath, registriesDirPath, err := trustPolicy.Write(filepath.Join(o.Options.WorkingDir, signature.TrustPolicyDir), o.Options.RegistriesDirPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate the trust policy: %w", err)
+	}
+	if o.Options.SecurePolicy {
+		o.Log.Warn("the trust policy of the image set configuration is used instead of the one of --secure-policy")
+	}
+	o.Options.SecurePolicy = true
+	o.Options.PolicyPath = policyPath
+	if registriesDirPath != "" {
+		o.Options.RegistriesDirPath = registriesDirPath
+	}
+	o.Log.Debug("verifying the images with the trust policy %s", policyPath)
+	return &trustPolicy, nil
+}
+
 // postMirrorProcessM2D
 func (o MirrorFlowController) postMirrorProcessM2D(ctx context.Context, copiedImages v2alpha1.CollectorSchema, allCollectorSchema []v2alpha1.CollectorSchema, cfg v2alpha1.ImageSetConfiguration, graphImage string) error {
 	// post batch process
@@ -195,7 +250,7 @@ func (o MirrorFlowController) postMirrorProcessAny2M(ctx context.Context, copied
 	if o.Options.IsDiskToMirror() || o.Options.IsMirrorToMirror() {
 		clusterRes := clusterresources.New(o.Log, cfg, o.Options)
 		forceRepositoryScope := o.Options.MaxNestedPaths > 0
-		err := clusterRes.IDMS_ITMSGenerator(copiedImages.AllImages, forceRepositoryScope)
+		err := clusterRes.MirrorSetsGenerator(copiedImages.AllImages, forceRepositoryScope)
 		errs = append(errs, err)
 
 		err = clusterRes.CatalogSourceGenerator(copiedImages.AllImages)
@@ -210,7 +265,11 @@ func (o MirrorFlowController) postMirrorProcessAny2M(ctx context.Context, copied
 			o.Log.Warn("%s", err)
 		}
 
-		err = checkAndBuildGraph(clusterRes, graphImage, allCollectorSchema)
+		err = checkAndBuildGraph(clusterRes, graphImage, copiedImages)
+		errs = append(errs, err)
+
+		// lists all the cluster resources above
+		err = clusterRes.KustomizationGenerator()
 		errs = append(errs, err)
 
 		for _, e := range errs {
@@ -218,13 +277,125 @@ func (o MirrorFlowController) postMirrorProcessAny2M(ctx context.Context, copied
 				return e
 			}
 		}
+
+		if o.Options.ApplyClusterResources {
+			return o.applyClusterResources(ctx)
+		}
+	}
+	return nil
+}
+
+// applyClusterResources applies the cluster resources generated by the run to the cluster of --kubeconfig
+func (o MirrorFlowController) applyClusterResources(ctx context.Context) error {
+	applier, err := clusterresources.NewApplierForKubeconfig(o.Log, o.Options.Kubeconfig, o.Options.ApplyDiffOnly)
+	if err != nil {
+		return err
+	}
+	applied, err := applier.ApplyDir(ctx, filepath.Join(o.Options.WorkingDir, clusterResourcesDir))
+	logAppliedObjects(o.Log, applied, o.Options.ApplyDiffOnly)
+	return err
+}
+
+// recordRun records the images of a disk-to-mirror or mirror-to-mirror run in the history of the workspace,
+// with the digest of the manifest of the ones in the cache (all of them in disk-to-mirror, the rebuilt
+// catalogs in mirror-to-mirror). Unlike the record of a mirror-to-disk run, it has no blob: no archive shipped any.
+func (o MirrorFlowController) recordRun(ctx context.Context, images []v2alpha1.CopyImageSchema) error {
+	iscDigest, err := config.Digest(o.Options.ConfigPath)
+	if err != nil {
+		return err
+	}
+	h, err := history.NewHistory(o.Options.WorkingDir, time.Time{}, o.Log, history.OSFileCreator{})
+	if err != nil {
+		return fmt.Errorf("unable to access the history metadata: %w", err)
+	}
+	runID := o.Options.UUID.String()
+	if o.Options.UUID == uuid.Nil {
+		runID = uuid.NewString()
+	}
+	record := history.NewRecord(runID, iscDigest, o.Options.Mode)
+	for _, img := range images {
+		imageRecord := history.ImageRecord{Origin: img.Origin, Destination: img.Destination}
+		switch {
+		case strings.Contains(img.Source, o.Options.LocalStorageFQDN):
+			imageRecord.CacheReference = img.Source
+		case strings.Contains(img.Destination, o.Options.LocalStorageFQDN):
+			imageRecord.CacheReference = img.Destination
+		}
+		if imageRecord.CacheReference != "" {
+			sourceCtx := o.Options.NewSystemContext()
+			o.Options.SetLocalStorageTLS(sourceCtx)
+			encoded, err := manifest.GetDigest(ctx, sourceCtx, imageRecord.CacheReference)
+			if err != nil {
+				return fmt.Errorf("unable to find the manifest of %s in the cache: %w", imageRecord.CacheReference, err)
+			}
+			imageRecord.Digest = digest.NewDigestFromEncoded(digest.SHA256, encoded).String()
+		}
+		record.Images = append(record.Images, imageRecord)
+	}
+	if _, err := h.Append(record); err != nil {
+		return fmt.Errorf("unable to update history metadata: %w", err)
 	}
 	return nil
 }
 
+func (o MirrorFlowController) extractArchive(archiveBaseDir string) error {
+	storageConfig, err := cache.NewStorageConfig(o.Options.CacheDriver, o.Options.LocalStorageDisk, o.Options.CacheDriverParams)
+	if err != nil {
+		return err
+	}
+	cacheStorage, err := storageConfig.Open(context.Background())
+	if err != nil {
+		return err
+	}
+	if !o.Options.IsArchiveStream() {
+		extractor, err := archive.NewArchiveExtractor(archiveBaseDir, archiveBaseDir, cacheStorage, o.Options.ArchiveIdentities)
+		if err != nil {
+			return err
+		}
+		return extractor.Unarchive()
+	}
+	reader, source := os.Stdin, "stdin"
+	if o.Options.ArchiveStream != stdStream {
+		f, err := os.Open(o.Options.ArchiveStream)
+		if err != nil {
+			return fmt.Errorf("unable to open archive stream %s : %w", o.Options.ArchiveStream, err)
+		}
+		defer f.Close()
+		reader, source = f, o.Options.ArchiveStream
+	}
+	o.Log.Info(emoji.Package+" Extracting the archive stream from %s", source)
+	extractor, err := archive.NewStreamExtractor(reader, archiveBaseDir, cacheStorage, o.Options.ArchiveIdentities)
+	if err != nil {
+		return err
+	}
+	return extractor.Unarchive()
+}
+
 func (o MirrorFlowController) createAndBuildArchive(ctx context.Context, copiedSchema v2alpha1.CollectorSchema, cfg v2alpha1.ImageSetConfiguration) error {
 	maxSize := cfg.ImageSetConfigurationSpec.ArchiveSize
-	archiver, err := archive.NewPermissiveMirrorArchive(o.Options, o.Log, maxSize)
+	o.Options.ArchiveRecipients = append(o.Options.ArchiveRecipients, cfg.ImageSetConfigurationSpec.ArchiveEncryption.Recipients...)
+	if o.Options.BaselineInventory != "" {
+		o.Log.Info(emoji.Memo+" The archive excludes the blobs of the baseline inventory %s", o.Options.BaselineInventory)
+	}
+	if len(o.Options.ArchiveRecipients) > 0 {
+		o.Log.Info(emoji.Lock+" The archive will be encrypted to %d recipient(s)", len(o.Options.ArchiveRecipients))
+	}
+	var archiver *archive.MirrorArchive
+	var err error
+	if o.Options.IsArchiveStream() {
+		writer := os.Stdout
+		if o.Options.ArchiveStream != stdStream {
+			f, err := createArchiveStream(o.Options.ArchiveStream)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			writer = f
+		}
+		archiver, err = archive.NewStreamMirrorArchive(o.Options, o.Log, maxSize, writer)
+	} else {
+		archiver, err = archive.NewPermissiveMirrorArchive(o.Options, o.Log, maxSize)
+	}
 	if err != nil {
 		return err
 	}
@@ -236,13 +407,20 @@ func (o MirrorFlowController) createAndBuildArchive(ctx context.Context, copiedS
 	return nil
 }
 
-func checkAndBuildGraph(clusterRes clusterresources.GeneratorInterface, graphImage string, allCollectorSchema []v2alpha1.CollectorSchema) error {
+// createArchiveStream opens the named pipe, device or file of a stream archive, truncated so that
+// nothing of a previous, longer archive is left after the new one
+func createArchiveStream(location string) (*os.File, error) {
+	f, err := os.OpenFile(location, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open archive stream %s : %w", location, err)
+	}
+	return f, nil
+}
+
+func checkAndBuildGraph(clusterRes clusterresources.GeneratorInterface, graphImage string, copiedImages v2alpha1.CollectorSchema) error {
 	if len(graphImage) > 0 {
-		releaseImage, err := findFirstRelease(allCollectorSchema)
-		if err != nil {
-			return err
-		}
-		err = clusterRes.UpdateServiceGenerator(graphImage, releaseImage.Destination)
+		// the destinations of the copied images are the ones rewritten by --max-nested-paths
+		err := clusterRes.UpdateServiceGenerator(graphImage, copiedImages.AllImages)
 		if err != nil {
 			return err
 		}
@@ -301,30 +479,19 @@ func addRebuiltCatalogs(cs v2alpha1.CollectorSchema) (v2alpha1.CollectorSchema,
 	return cs, nil
 }
 
-// findFirstRelease
-func findFirstRelease(cs []v2alpha1.CollectorSchema) (v2alpha1.CopyImageSchema, error) {
-	for _, v := range cs {
-		for _, img := range v.AllImages {
-			if img.Type == v2alpha1.TypeOCPRelease {
-				return img, nil
-			}
-		}
-	}
-	return v2alpha1.CopyImageSchema{}, fmt.Errorf("no release image found")
-}
-
 // withMaxNestedPaths()
 func withMaxNestedPaths(in []v2alpha1.CopyImageSchema, maxNestedPaths int) ([]v2alpha1.CopyImageSchema, error) {
+	if maxNestedPaths <= 0 {
+		return in, nil
+	}
 	out := []v2alpha1.CopyImageSchema{}
-	if maxNestedPaths > 0 {
-		for _, img := range in {
-			dst, err := image.WithMaxNestedPaths(img.Destination, maxNestedPaths)
-			if err != nil {
-				return nil, err
-			}
-			img.Destination = dst
-			out = append(out, img)
+	for _, img := range in {
+		dst, err := image.WithMaxNestedPaths(img.Destination, maxNestedPaths)
+		if err != nil {
+			return nil, err
 		}
+		img.Destination = dst
+		out = append(out, img)
 	}
 	return out, nil
 }
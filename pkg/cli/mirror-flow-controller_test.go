@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+)
+
+func TestWithMaxNestedPaths(t *testing.T) {
+	images := []v2alpha1.CopyImageSchema{
+		{Origin: "docker://quay.io/openshift-release-dev/ocp-release:4.18.1-x86_64", Destination: "docker://registry.enclave/mirror/openshift-release-dev/ocp-release:4.18.1-x86_64", Type: v2alpha1.TypeOCPRelease},
+		{Origin: "docker://registry.redhat.io/ubi9/ubi:latest", Destination: "docker://registry.enclave/mirror/ubi9/ubi:latest", Type: v2alpha1.TypeGeneric},
+	}
+	type testCase struct {
+		caseName       string
+		maxNestedPaths int
+		expected       []string
+	}
+	testCases := []testCase{
+		{
+			// the images are all copied as is without --max-nested-paths: none is dropped
+			caseName: "not set",
+			expected: []string{
+				"docker://registry.enclave/mirror/openshift-release-dev/ocp-release:4.18.1-x86_64",
+				"docker://registry.enclave/mirror/ubi9/ubi:latest",
+			},
+		},
+		{
+			caseName:       "two nested paths",
+			maxNestedPaths: 2,
+			expected: []string{
+				"docker://registry.enclave/mirror/openshift-release-dev-ocp-release:4.18.1-x86_64",
+				"docker://registry.enclave/mirror/ubi9-ubi:latest",
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			out, err := withMaxNestedPaths(images, testCase.maxNestedPaths)
+			require.NoError(t, err)
+			destinations := []string{}
+			for i, img := range out {
+				require.Equal(t, images[i].Origin, img.Origin)
+				require.Equal(t, images[i].Type, img.Type)
+				destinations = append(destinations, img.Destination)
+			}
+			require.Equal(t, testCase.expected, destinations)
+		})
+	}
+}
@@ -2,10 +2,12 @@ package cli
 
 import (
 	"fmt"
+	"os"
 	"path"
 	"strings"
 	"time"
 
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/cache"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
 	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
 )
@@ -57,6 +59,12 @@ func (o MirrorValidate) CheckArgs(args []string) error {
 	}
 
 	o.Options.MultiArch = "system"
+	if o.Options.SigstoreArtifacts {
+		// the sigstore artifacts are attached to the digest of the images: the manifest lists are mirrored as is
+		o.Options.MultiArch = "all"
+	}
+	// the signatures are not copied by containers/image: the cosign signatures, attestations and SBOMs
+	// are mirrored as artifacts of their own with --sigstore-artifacts
 	o.Options.RemoveSignatures = true
 	o.Options.Function = mirrorFunction
 	return nil
@@ -66,11 +74,57 @@ func (o MirrorValidate) mirrorOptionsValidate() error {
 	if len(o.Options.From) > 0 && o.Options.SinceString != "" {
 		o.Log.Warn("since flag is only taken into account during mirrorToDisk workflow")
 	}
+	if o.Options.BaselineInventory != "" {
+		if o.Options.Mode != mirrorToDisk {
+			return fmt.Errorf("--baseline-inventory can only be used in the mirror-to-disk workflow")
+		}
+		if o.Options.SinceString != "" {
+			return fmt.Errorf("--baseline-inventory and --since can't be used together")
+		}
+		if _, err := os.Stat(o.Options.BaselineInventory); err != nil {
+			return fmt.Errorf("unable to access the baseline inventory: %w", err)
+		}
+	}
+	if o.Options.ApplyClusterResources && o.Options.Mode == mirrorToDisk {
+		return fmt.Errorf("--apply can only be used in the disk-to-mirror and mirror-to-mirror workflows")
+	}
+	if o.Options.ApplyDiffOnly && !o.Options.ApplyClusterResources {
+		return fmt.Errorf("--diff-only can only be used with --apply")
+	}
+	if o.Options.Port < 0 || o.Options.Port > 65535 {
+		return fmt.Errorf("--port must be between 1 and 65535, or 0 to pick a free port")
+	}
+	storageConfig, err := cache.NewStorageConfig(o.Options.CacheDriver, "", o.Options.CacheDriverParams)
+	if err != nil {
+		return err
+	}
+	if o.Options.SharedLocalStorage && !storageConfig.IsPersistent() {
+		return fmt.Errorf("--shared-local-storage can't be used with the %s cache driver", storageConfig.Driver)
+	}
+	if o.Options.KeepArchiveGenerations < 0 {
+		return fmt.Errorf("--keep-archive-generations must be a positive number, or 0 to keep all generations")
+	}
 	if o.Options.SinceString != "" {
 		if _, err := time.Parse(time.DateOnly, o.Options.SinceString); err != nil {
 			return fmt.Errorf("--since flag needs to be in format yyyy-MM-dd")
 		}
 	}
+	return validateHistoryRetention(o.Options)
+}
+
+// validateHistoryRetention checks the --history-keep and --history-keep-since flags,
+// and parses the latter
+func validateHistoryRetention(opts *common.MirrorOptions) error {
+	if opts.HistoryKeep < 0 {
+		return fmt.Errorf("--history-keep must be a positive number, or 0 to keep all history files")
+	}
+	if opts.HistoryKeepSinceString != "" {
+		since, err := time.Parse(time.DateOnly, opts.HistoryKeepSinceString)
+		if err != nil {
+			return fmt.Errorf("--history-keep-since flag needs to be in format yyyy-MM-dd")
+		}
+		opts.HistoryKeepSince = since
+	}
 	return nil
 }
 
@@ -114,15 +168,51 @@ func argsContain(args []string, value string) (string, bool) {
 	return "", false
 }
 
+// streamLocation checks if ref designates a stream archive (`-`, `file://-` or `stream://[path]`)
+// and returns the location of the stream: `-` for stdin/stdout, or the path to a named pipe or device
+func streamLocation(ref string) (string, bool) {
+	switch {
+	case ref == stdStream || ref == fileProtocol+stdStream:
+		return stdStream, true
+	case strings.HasPrefix(ref, streamProtocol):
+		location := strings.TrimPrefix(ref, streamProtocol)
+		if location == "" {
+			location = stdStream
+		}
+		return location, true
+	default:
+		return "", false
+	}
+}
+
+// argsStream returns the first argument designating a stream archive and its location
+func argsStream(args []string) (string, string, bool) {
+	for _, arg := range args {
+		if location, ok := streamLocation(arg); ok {
+			return arg, location, true
+		}
+	}
+	return "", "", false
+}
+
 func checkAndSetModeD2M(args []string, opts *common.MirrorOptions, set bool) (bool, error) {
-	if !set && opts.From != "" && opts.Workspace == "" {
-		if !strings.Contains(opts.From, fileProtocol) {
+	stream, isStream := streamLocation(opts.From)
+	if !set && opts.From != "" && (opts.Workspace == "" || isStream) {
+		if !isStream && !strings.Contains(opts.From, fileProtocol) {
 			return false, fmt.Errorf("when using --from it must have a file:// prefix (disk-to-mirror)")
 		}
 		dest, ok := argsContain(args, dockerProtocol)
 		if ok {
 			opts.Mode = diskToMirror
-			opts.WorkingDir = path.Join(strings.TrimPrefix(opts.From, fileProtocol), "working-dir")
+			if isStream {
+				if !strings.Contains(opts.Workspace, fileProtocol) {
+					return false, fmt.Errorf("when using --from with a stream, use the --workspace flag with a file:// prefix to set where the working-dir is extracted (disk-to-mirror)")
+				}
+				opts.ArchiveStream = stream
+				opts.WorkingDir = path.Join(strings.TrimPrefix(opts.Workspace, fileProtocol), "working-dir")
+			} else {
+				opts.WorkingDir = path.Join(strings.TrimPrefix(opts.From, fileProtocol), "working-dir")
+			}
 			opts.Destination = dest
 			opts.OriginalDestination = dest
 			opts.DestinationRegistry = strings.TrimPrefix(dest, dockerProtocol)
@@ -135,7 +225,20 @@ func checkAndSetModeD2M(args []string, opts *common.MirrorOptions, set bool) (bo
 }
 
 func checkAndSetModeM2D(args []string, opts *common.MirrorOptions, set bool) (bool, error) {
-	if !set && opts.From == "" && opts.Workspace == "" {
+	streamDest, stream, isStream := argsStream(args)
+	if !set && opts.From == "" && (opts.Workspace == "" || isStream) {
+		if isStream {
+			if !strings.Contains(opts.Workspace, fileProtocol) {
+				return false, fmt.Errorf("when streaming the archive, use the --workspace flag with a file:// prefix to set where the working-dir is kept (mirror-to-disk)")
+			}
+			workspace := strings.TrimPrefix(opts.Workspace, fileProtocol)
+			opts.Mode = mirrorToDisk
+			opts.ArchiveStream = stream
+			opts.WorkingDir = path.Join(workspace, "working-dir")
+			opts.Destination = workspace
+			opts.OriginalDestination = streamDest
+			return true, nil
+		}
 		dest, ok := argsContain(args, fileProtocol)
 		if ok {
 			opts.Mode = mirrorToDisk
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+)
+
+func TestStreamLocation(t *testing.T) {
+	type testCase struct {
+		ref              string
+		expectedLocation string
+		expectedStream   bool
+	}
+	testCases := []testCase{
+		{ref: "-", expectedLocation: stdStream, expectedStream: true},
+		{ref: "file://-", expectedLocation: stdStream, expectedStream: true},
+		{ref: "stream://", expectedLocation: stdStream, expectedStream: true},
+		{ref: "stream:///dev/nst0", expectedLocation: "/dev/nst0", expectedStream: true},
+		{ref: "stream://./mirror.fifo", expectedLocation: "./mirror.fifo", expectedStream: true},
+		{ref: "file:///home/user/oc-mirror/mirror1"},
+		{ref: "docker://localhost:6000"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.ref, func(t *testing.T) {
+			location, ok := streamLocation(testCase.ref)
+			require.Equal(t, testCase.expectedStream, ok)
+			require.Equal(t, testCase.expectedLocation, location)
+		})
+	}
+}
+
+func TestCheckAndSetModeStream(t *testing.T) {
+	type testCase struct {
+		caseName      string
+		args          []string
+		opts          common.MirrorOptions
+		expected      common.MirrorOptions
+		expectedError string
+	}
+	testCases := []testCase{
+		{
+			caseName: "mirror to a stream",
+			args:     []string{"stream:///dev/nst0"},
+			opts:     common.MirrorOptions{Workspace: "file:///home/user/mirror1"},
+			expected: common.MirrorOptions{
+				Workspace:           "file:///home/user/mirror1",
+				Mode:                mirrorToDisk,
+				ArchiveStream:       "/dev/nst0",
+				WorkingDir:          "/home/user/mirror1/working-dir",
+				Destination:         "/home/user/mirror1",
+				OriginalDestination: "stream:///dev/nst0",
+			},
+		},
+		{
+			caseName:      "mirror to a stream without workspace",
+			args:          []string{"-"},
+			expectedError: "use the --workspace flag",
+		},
+		{
+			caseName: "mirror from stdin",
+			args:     []string{"docker://localhost:6000"},
+			opts:     common.MirrorOptions{From: "-", Workspace: "file:///home/user/mirror1"},
+			expected: common.MirrorOptions{
+				From:                "-",
+				Workspace:           "file:///home/user/mirror1",
+				Mode:                diskToMirror,
+				ArchiveStream:       stdStream,
+				WorkingDir:          "/home/user/mirror1/working-dir",
+				Destination:         "docker://localhost:6000",
+				OriginalDestination: "docker://localhost:6000",
+				DestinationRegistry: "localhost:6000",
+			},
+		},
+		{
+			caseName:      "mirror from a stream without workspace",
+			args:          []string{"docker://localhost:6000"},
+			opts:          common.MirrorOptions{From: "stream://./mirror.fifo"},
+			expectedError: "use the --workspace flag",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			opts := testCase.opts
+			set, err := checkAndSetModeD2M(testCase.args, &opts, false)
+			if err == nil {
+				_, err = checkAndSetModeM2D(testCase.args, &opts, set)
+			}
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, testCase.expected, opts)
+		})
+	}
+}
+
+func TestCreateArchiveStream(t *testing.T) {
+	// a previous, longer archive
+	location := filepath.Join(t.TempDir(), "mirror.tar")
+	require.NoError(t, os.WriteFile(location, []byte("previous archive content"), 0644))
+
+	f, err := createArchiveStream(location)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("archive"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	content, err := os.ReadFile(location)
+	require.NoError(t, err)
+	require.Equal(t, "archive", string(content))
+}
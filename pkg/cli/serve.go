@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/cache"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+const serveShutdownTimeout = 30 * time.Second
+
+type ServeController struct {
+	Log     clog.PluggableLoggerInterface
+	Options *common.MirrorOptions
+}
+
+func NewServeController(log clog.PluggableLoggerInterface, opts *common.MirrorOptions) ServeController {
+	return ServeController{
+		Log:     log,
+		Options: opts,
+	}
+}
+
+// Process serves the cache as a standalone registry, until SIGINT or SIGTERM is received:
+// the registry then stops accepting connections, and in-flight requests are drained
+func (o ServeController) Process(args []string) error {
+	if err := o.validate(args); err != nil {
+		return err
+	}
+	storageConfig, err := existingCacheStorage(o.Options)
+	if err != nil {
+		return err
+	}
+	o.Options.LocalStorageDisk = standaloneCacheDirectory(o.Options.CacheDir)
+	unlockCache, err := lockCache(o.Log, o.Options.LocalStorageDisk, false)
+	if err != nil {
+		return err
+	}
+	defer unlockCache()
+	if storageConfig.Driver == cache.FilesystemDriver {
+		if _, err := os.Stat(filepath.Join(o.Options.LocalStorageDisk, cacheRegistryDir)); err != nil {
+			o.Log.Warn("%s doesn't contain any registry content yet", o.Options.LocalStorageDisk)
+		}
+	}
+	if o.Options.Port == 0 {
+		port, err := freeLocalPort()
+		if err != nil {
+			return err
+		}
+		o.Options.Port = port
+	} else if isLocalStoragePortBound(*o.Options) {
+		return fmt.Errorf("unable to serve the cache: port %d is already in use", o.Options.Port)
+	}
+	config, err := setupLocalRegistryConfig(o.Options)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	reg, err := registry.NewRegistry(ctx, config)
+	if err != nil {
+		return fmt.Errorf("setting up registry %w", err)
+	}
+
+	scheme := "http"
+	if o.Options.RegistryTLSCert != "" {
+		scheme = "https"
+	}
+	mode := "read-write"
+	if o.Options.RegistryReadOnly {
+		mode = "read-only"
+	}
+	o.Log.Info(emoji.Package+" serving %s (%s) on %s://localhost:%d", storageConfig, mode, scheme, o.Options.Port)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- reg.ListenAndServe()
+	}()
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("unable to serve the cache: %w", err)
+	case <-ctx.Done():
+	}
+
+	o.Log.Info("shutting down, draining connections for up to %v", serveShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	if err := reg.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("unable to shut the registry down cleanly: %w", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("unable to serve the cache: %w", err)
+	}
+	o.Log.Info(emoji.WavingHandSign + " registry stopped")
+	return nil
+}
+
+func (o ServeController) validate(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("serve doesn't expect any argument")
+	}
+	if o.Options.Port < 0 || o.Options.Port > 65535 {
+		return fmt.Errorf("--port must be between 1 and 65535, or 0 to pick a free port")
+	}
+	if (o.Options.RegistryTLSCert == "") != (o.Options.RegistryTLSKey == "") {
+		return fmt.Errorf("--tls-cert and --tls-key must be used together")
+	}
+	for _, file := range []string{o.Options.RegistryTLSCert, o.Options.RegistryTLSKey, o.Options.RegistryHtpasswd} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("unable to access %s: %w", file, err)
+		}
+	}
+	if o.Options.RegistryHtpasswd != "" && o.Options.RegistryTLSCert == "" {
+		o.Log.Warn("htpasswd credentials will be sent in clear text: consider using --tls-cert and --tls-key")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestServeValidate(t *testing.T) {
+	existing := filepath.Join(t.TempDir(), "tls.crt")
+	require.NoError(t, os.WriteFile(existing, []byte{}, 0600))
+	type testCase struct {
+		caseName      string
+		args          []string
+		opts          common.MirrorOptions
+		expectedError string
+	}
+	testCases := []testCase{
+		{caseName: "defaults", opts: common.MirrorOptions{Port: 55000}},
+		{caseName: "free port", opts: common.MirrorOptions{Port: 0}},
+		{caseName: "TLS", opts: common.MirrorOptions{Port: 55000, RegistryTLSCert: existing, RegistryTLSKey: existing}},
+		{caseName: "argument", args: []string{"docker://localhost:5000"}, expectedError: "serve doesn't expect any argument"},
+		{caseName: "port out of range", opts: common.MirrorOptions{Port: 65536}, expectedError: "--port must be between 1 and 65535"},
+		{caseName: "certificate without key", opts: common.MirrorOptions{RegistryTLSCert: existing}, expectedError: "--tls-cert and --tls-key must be used together"},
+		{caseName: "missing htpasswd", opts: common.MirrorOptions{RegistryHtpasswd: existing + ".missing"}, expectedError: "unable to access"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			opts := testCase.opts
+			err := NewServeController(clog.New("error"), &opts).validate(testCase.args)
+			if testCase.expectedError != "" {
+				require.ErrorContains(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestServeProcess(t *testing.T) {
+	// --cache-dir alone selects the cache served
+	t.Setenv("HOME", t.TempDir())
+	cacheDir := t.TempDir()
+	// the certificates sit in a directory whose name isn't a plain YAML scalar
+	certDir := filepath.Join(t.TempDir(), "tls: #1")
+	certFile, keyFile, err := generateLocalStorageCertificates(certDir)
+	require.NoError(t, err)
+	port, err := freeLocalPort()
+	require.NoError(t, err)
+	opts := &common.MirrorOptions{
+		CacheDir:         cacheDir,
+		Port:             port,
+		LogLevel:         "error",
+		RegistryTLSCert:  certFile,
+		RegistryTLSKey:   keyFile,
+		RegistryReadOnly: true,
+	}
+
+	processErr := make(chan error, 1)
+	go func() {
+		processErr <- NewServeController(clog.New("error"), opts).Process(nil)
+	}()
+
+	ca, err := os.ReadFile(filepath.Join(certDir, common.LocalStorageCAFile))
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(ca))
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}}}
+	url := "https://localhost:" + strconv.Itoa(port)
+	require.Eventually(t, func() bool {
+		resp, err := client.Get(url + "/v2/")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// read-only: the uploads are refused
+	resp, err := client.Post(url+"/v2/ns/img/blobs/uploads/", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NotEqual(t, http.StatusAccepted, resp.StatusCode)
+
+	// the registry stops on SIGINT
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	select {
+	case err := <-processErr:
+		require.NoError(t, err)
+	case <-time.After(serveShutdownTimeout):
+		t.Fatal("the registry didn't stop on SIGINT")
+	}
+}
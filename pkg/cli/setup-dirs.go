@@ -6,6 +6,7 @@ import (
 	"path"
 	"path/filepath"
 
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/cache"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
 	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
 )
@@ -21,12 +22,7 @@ type Setup struct {
 
 func (o Setup) CreateDirectories() error {
 
-	if os.Getenv(cacheEnvVar) != "" && o.Options.CacheDir != "" {
-		o.Options.CacheDir = os.Getenv(cacheEnvVar)
-	} else {
-		home := os.Getenv("HOME")
-		o.Options.CacheDir = home + "/.oc-mirror/.cache"
-	}
+	o.Options.CacheDir = cacheDirectory(o.Options.CacheDir)
 
 	// ensure working dir exists
 	createDirs := []string{
@@ -61,3 +57,40 @@ func (o Setup) CreateDirectories() error {
 	o.Options.LocalStorageDisk = o.Options.CacheDir
 	return nil
 }
+
+// cacheDirectory returns the cache directory to use, as the mirroring runs always did:
+// the one set by the OC_MIRROR_CACHE environment variable along with --cache-dir, otherwise $HOME/.oc-mirror/.cache
+func cacheDirectory(cacheDir string) string {
+	if os.Getenv(cacheEnvVar) != "" && cacheDir != "" {
+		return os.Getenv(cacheEnvVar)
+	}
+	return filepath.Join(os.Getenv("HOME"), cacheRelativePath)
+}
+
+// standaloneCacheDirectory returns the cache directory of the commands working on the cache outside of a
+// mirroring run (cache, serve): the one set by --cache-dir, otherwise the default one of the mirroring runs
+func standaloneCacheDirectory(cacheDir string) string {
+	if cacheDir != "" {
+		return cacheDir
+	}
+	return cacheDirectory(cacheDir)
+}
+
+// existingCacheStorage returns the storage configuration of the cache used by the commands
+// working on the cache outside of a mirroring run (cache, serve): the cache must outlive the run
+func existingCacheStorage(opts *common.MirrorOptions) (cache.StorageConfig, error) {
+	cacheDir := standaloneCacheDirectory(opts.CacheDir)
+	storageConfig, err := cache.NewStorageConfig(opts.CacheDriver, cacheDir, opts.CacheDriverParams)
+	if err != nil {
+		return cache.StorageConfig{}, err
+	}
+	if !storageConfig.IsPersistent() {
+		return cache.StorageConfig{}, fmt.Errorf("the %s cache driver only lives for the duration of a mirroring run", storageConfig.Driver)
+	}
+	if storageConfig.Driver == cache.FilesystemDriver {
+		if _, err := os.Stat(cacheDir); err != nil {
+			return cache.StorageConfig{}, fmt.Errorf("unable to access the cache directory: %w", err)
+		}
+	}
+	return storageConfig, nil
+}
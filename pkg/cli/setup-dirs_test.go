@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheDirectory(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	defaultCacheDir := filepath.Join(home, cacheRelativePath)
+	type testCase struct {
+		caseName           string
+		env                string
+		cacheDir           string
+		expectedMirroring  string
+		expectedStandalone string
+	}
+	testCases := []testCase{
+		{caseName: "default", expectedMirroring: defaultCacheDir, expectedStandalone: defaultCacheDir},
+		{caseName: "environment without flag", env: "/env/cache", expectedMirroring: defaultCacheDir, expectedStandalone: defaultCacheDir},
+		{caseName: "flag without environment", cacheDir: "/flag/cache", expectedMirroring: defaultCacheDir, expectedStandalone: "/flag/cache"},
+		{caseName: "environment and flag", env: "/env/cache", cacheDir: "/flag/cache", expectedMirroring: "/env/cache", expectedStandalone: "/flag/cache"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			t.Setenv(cacheEnvVar, testCase.env)
+			require.Equal(t, testCase.expectedMirroring, cacheDirectory(testCase.cacheDir))
+			require.Equal(t, testCase.expectedStandalone, standaloneCacheDirectory(testCase.cacheDir))
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/lock"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+// lockWorkspace takes the exclusive lock of the workspace of workingDir, and returns
+// the function releasing it.
+// The lock file sits next to the working-dir, so that it is never archived.
+func lockWorkspace(log clog.PluggableLoggerInterface, workingDir string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(workingDir), 0755); err != nil {
+		return nil, fmt.Errorf("unable to lock the workspace: %w", err)
+	}
+	workspaceLock, err := lock.Acquire(filepath.Join(filepath.Dir(workingDir), workspaceLockFile))
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("acquired the lock of workspace %s", filepath.Dir(workingDir))
+	return func() {
+		if err := workspaceLock.Release(); err != nil {
+			log.Warn("%v", err)
+		}
+	}, nil
+}
@@ -0,0 +1,310 @@
+package clusterresources
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+const applyFieldManager = "oc-mirror"
+
+// ApplyResult is the outcome of the apply of an object
+type ApplyResult string
+
+const (
+	ApplyCreated   ApplyResult = "created"
+	ApplyUpdated   ApplyResult = "updated"
+	ApplyUnchanged ApplyResult = "unchanged"
+	// ApplySkipped reports an object of a kind the cluster doesn't serve
+	ApplySkipped ApplyResult = "skipped"
+)
+
+// AppliedObject reports the apply of an object of the cluster resources
+type AppliedObject struct {
+	File      string      `json:"file"`
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace,omitempty"`
+	Name      string      `json:"name"`
+	Result    ApplyResult `json:"result"`
+	// Diff is the difference between the object in the cluster and the one applied, when updated
+	Diff string `json:"diff,omitempty"`
+}
+
+// Applier server-side applies the cluster resources generated by ClusterResourcesGenerator
+type Applier struct {
+	Log    clog.PluggableLoggerInterface
+	Client dynamic.Interface
+	Mapper meta.RESTMapper
+	// DiffOnly only reports what would be applied
+	DiffOnly bool
+}
+
+func NewApplier(log clog.PluggableLoggerInterface, client dynamic.Interface, mapper meta.RESTMapper, diffOnly bool) Applier {
+	return Applier{
+		Log:      log,
+		Client:   client,
+		Mapper:   mapper,
+		DiffOnly: diffOnly,
+	}
+}
+
+// NewApplierForKubeconfig returns an Applier for the cluster of kubeconfig. Without kubeconfig,
+// the usual locations are used ($KUBECONFIG, then $HOME/.kube/config)
+func NewApplierForKubeconfig(log clog.PluggableLoggerInterface, kubeconfig string, diffOnly bool) (Applier, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return Applier{}, fmt.Errorf("unable to load the kubeconfig: %w", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return Applier{}, fmt.Errorf("%w", err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return Applier{}, fmt.Errorf("%w", err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	return NewApplier(log, client, mapper, diffOnly), nil
+}
+
+// ApplyDir applies the objects of the yaml files of the cluster resources directory dir. The mirror sets
+// generated for the run are skipped when their cumulative version is present: it supersedes them.
+// The objects of a kind the cluster doesn't serve (an IDMS on a cluster older than 4.13...) are reported
+// as skipped, rather than failing the apply of the others.
+func (o Applier) ApplyDir(ctx context.Context, dir string) ([]AppliedObject, error) {
+	files, err := ClusterResourceFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	applied := []AppliedObject{}
+	for _, file := range files {
+		objects, err := readObjects(filepath.Join(dir, file))
+		if err != nil {
+			return applied, err
+		}
+		for _, obj := range objects {
+			result, err := o.Apply(ctx, obj)
+			if meta.IsNoMatchError(err) {
+				o.Log.Warn("%s %s from %s skipped: the cluster doesn't serve the %s kind", obj.GetKind(), obj.GetName(), file, obj.GroupVersionKind().GroupKind())
+				result = AppliedObject{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName(), Result: ApplySkipped}
+				err = nil
+			}
+			if err != nil {
+				return applied, fmt.Errorf("unable to apply %s %s from %s: %w", obj.GetKind(), obj.GetName(), file, err)
+			}
+			result.File = file
+			applied = append(applied, result)
+		}
+	}
+	return applied, nil
+}
+
+// ClusterResourceFiles returns the yaml files of the cluster resources directory dir to apply, sorted.
+// The kustomization.yaml is not part of them.
+func ClusterResourceFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the cluster resources: %w", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	files := []string{}
+	for _, name := range names {
+		if filepath.Ext(name) != ".yaml" || name == kustomizationFileName {
+			continue
+		}
+		if !strings.HasSuffix(strings.TrimSuffix(name, ".yaml"), cumulativeSuffix) && slices.Contains(names, mirrorSetFileName(name, true)) {
+			continue
+		}
+		files = append(files, name)
+	}
+	slices.Sort(files)
+	return files, nil
+}
+
+// Apply server-side applies obj, unless it is already up to date
+func (o Applier) Apply(ctx context.Context, obj *unstructured.Unstructured) (AppliedObject, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := o.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return AppliedObject{}, fmt.Errorf("%w", err)
+	}
+	var resource dynamic.ResourceInterface = o.Client.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(metav1.NamespaceDefault)
+		}
+		resource = o.Client.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+	}
+	applied := AppliedObject{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+
+	current, err := resource.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		applied.Result = ApplyCreated
+	case err != nil:
+		return AppliedObject{}, fmt.Errorf("%w", err)
+	default:
+		// only the fields set by oc-mirror are compared: the cluster adds its own
+		currentFields := prunedTo(current.Object, obj.Object)
+		if reflect.DeepEqual(currentFields, obj.Object) {
+			applied.Result = ApplyUnchanged
+			return applied, nil
+		}
+		applied.Result = ApplyUpdated
+		applied.Diff, err = yamlDiff(currentFields, obj.Object)
+		if err != nil {
+			return AppliedObject{}, err
+		}
+	}
+	if o.DiffOnly {
+		return applied, nil
+	}
+	if _, err := resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{FieldManager: applyFieldManager, Force: true}); err != nil {
+		return AppliedObject{}, fmt.Errorf("%w", err)
+	}
+	o.Log.Debug("%s %s %s", applied.Result, applied.Kind, applied.Name)
+	return applied, nil
+}
+
+// readObjects reads the objects of the (multi-document) yaml file
+func readObjects(file string) ([]*unstructured.Unstructured, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer f.Close()
+	reader := k8syaml.NewYAMLReader(bufio.NewReader(f))
+	objects := []*unstructured.Unstructured{}
+	for {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", file, err)
+		}
+		if len(bytes.TrimSpace(bytes.TrimPrefix(bytes.TrimSpace(document), []byte("---")))) == 0 {
+			continue
+		}
+		content, err := k8syaml.ToJSON(document)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", file, err)
+		}
+		// the numbers are decoded as int64, like the ones of the objects read from the cluster
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(content); err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", file, err)
+		}
+		if obj.GetName() == "" {
+			return nil, fmt.Errorf("unable to read %s: an object without name was found", file)
+		}
+		// status is not applied
+		delete(obj.Object, "status")
+		objects = append(objects, obj)
+	}
+}
+
+// prunedTo returns the fields of current that are set in desired
+func prunedTo(current, desired interface{}) interface{} {
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		currentValue, ok := current.(map[string]interface{})
+		if !ok {
+			return current
+		}
+		pruned := map[string]interface{}{}
+		for key, value := range desiredValue {
+			if c, ok := currentValue[key]; ok {
+				pruned[key] = prunedTo(c, value)
+			}
+		}
+		return pruned
+	case []interface{}:
+		currentValue, ok := current.([]interface{})
+		if !ok || len(currentValue) != len(desiredValue) {
+			return current
+		}
+		pruned := make([]interface{}, len(currentValue))
+		for i := range currentValue {
+			pruned[i] = prunedTo(currentValue[i], desiredValue[i])
+		}
+		return pruned
+	default:
+		return current
+	}
+}
+
+// yamlDiff returns the lines of the yaml of current and desired, prefixed by - when only in current,
+// + when only in desired
+func yamlDiff(current, desired interface{}) (string, error) {
+	currentYaml, err := yaml.Marshal(current)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	desiredYaml, err := yaml.Marshal(desired)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	a := strings.Split(strings.TrimSuffix(string(currentYaml), "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(string(desiredYaml), "\n"), "\n")
+	// longest common subsequence of the lines
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	diff := strings.Builder{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			diff.WriteString("+ " + b[j] + "\n")
+			j++
+		default:
+			diff.WriteString("- " + a[i] + "\n")
+			i++
+		}
+	}
+	return diff.String(), nil
+}
@@ -0,0 +1,190 @@
+package clusterresources
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+var (
+	idmsGVR          = schema.GroupVersionResource{Group: "config.openshift.io", Version: "v1", Resource: "imagedigestmirrorsets"}
+	catalogSourceGVR = schema.GroupVersionResource{Group: "operators.coreos.com", Version: "v1alpha1", Resource: "catalogsources"}
+)
+
+const (
+	testIDMS = `---
+apiVersion: config.openshift.io/v1
+kind: ImageDigestMirrorSet
+metadata:
+  name: idms-release-0
+spec:
+  imageDigestMirrors:
+  - mirrors:
+    - registry.enclave/openshift
+    source: quay.io/openshift-release-dev
+status: {}
+`
+	// ICSP is not served by the test clusters
+	testICSP = `---
+apiVersion: operator.openshift.io/v1alpha1
+kind: ImageContentSourcePolicy
+metadata:
+  name: icsp-release-0
+spec:
+  repositoryDigestMirrors:
+  - mirrors:
+    - registry.enclave/openshift
+    source: quay.io/openshift-release-dev
+`
+	testCatalogSource = `apiVersion: operators.coreos.com/v1alpha1
+kind: CatalogSource
+metadata:
+  name: cs-redhat-operator-index-v4-18
+  namespace: openshift-marketplace
+spec:
+  image: registry.enclave/redhat/redhat-operator-index:v4.18
+  sourceType: grpc
+`
+)
+
+func TestClusterResourceFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{idmsFileName, mirrorSetFileName(idmsFileName, true), itmsFileName, registriesConfFileName, kustomizationFileName, "signature-configmap.json", "signature-configmap.yaml"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, file), []byte{}, 0644))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, helmChartDir), 0755))
+	files, err := ClusterResourceFiles(dir)
+	require.NoError(t, err)
+	// the cumulative IDMS supersedes the one of the run
+	require.Equal(t, []string{"idms-oc-mirror-cumulative.yaml", itmsFileName, "signature-configmap.yaml"}, files)
+}
+
+func TestApplyDir(t *testing.T) {
+	type testCase struct {
+		caseName        string
+		existing        []runtime.Object
+		diffOnly        bool
+		expectedResults map[string]ApplyResult
+		expectedImage   string
+	}
+	testCases := []testCase{
+		{
+			caseName:        "objects are created",
+			expectedResults: map[string]ApplyResult{"idms-release-0": ApplyCreated, "cs-redhat-operator-index-v4-18": ApplyCreated, "icsp-release-0": ApplySkipped},
+			expectedImage:   "registry.enclave/redhat/redhat-operator-index:v4.18",
+		},
+		{
+			caseName: "objects up to date are unchanged, the others are updated",
+			existing: []runtime.Object{
+				testObject(t, testIDMS, map[string]interface{}{"uid": "1234"}),
+				testObject(t, testCatalogSource, nil),
+			},
+			expectedResults: map[string]ApplyResult{"idms-release-0": ApplyUnchanged, "cs-redhat-operator-index-v4-18": ApplyUpdated, "icsp-release-0": ApplySkipped},
+			expectedImage:   "registry.enclave/redhat/redhat-operator-index:v4.18",
+		},
+		{
+			caseName:        "diff only",
+			existing:        []runtime.Object{testObject(t, testCatalogSource, nil)},
+			diffOnly:        true,
+			expectedResults: map[string]ApplyResult{"idms-release-0": ApplyCreated, "cs-redhat-operator-index-v4-18": ApplyUpdated, "icsp-release-0": ApplySkipped},
+			expectedImage:   "registry.enclave/redhat/redhat-operator-index:v4.17",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, idmsFileName), []byte(testIDMS), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "cs-redhat-operator-index-v4-18.yaml"), []byte(testCatalogSource), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, icspFileName), []byte(testICSP), 0644))
+
+			client := newTestDynamicClient(testCase.existing...)
+			applier := NewApplier(clog.New("error"), client, newTestRESTMapper(), testCase.diffOnly)
+			applied, err := applier.ApplyDir(context.Background(), dir)
+			require.NoError(t, err)
+			results := map[string]ApplyResult{}
+			for _, object := range applied {
+				results[object.Name] = object.Result
+				if object.Result == ApplyUpdated {
+					require.Contains(t, object.Diff, "v4.17")
+				}
+			}
+			require.Equal(t, testCase.expectedResults, results)
+
+			_, err = client.Resource(idmsGVR).Get(context.Background(), "idms-release-0", metav1.GetOptions{})
+			if testCase.diffOnly {
+				require.True(t, apierrors.IsNotFound(err))
+			} else {
+				require.NoError(t, err)
+			}
+			cs, err := client.Resource(catalogSourceGVR).Namespace("openshift-marketplace").Get(context.Background(), "cs-redhat-operator-index-v4-18", metav1.GetOptions{})
+			require.NoError(t, err)
+			image, _, err := unstructured.NestedString(cs.Object, "spec", "image")
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedImage, image)
+		})
+	}
+}
+
+// testObject returns the object of content as found in the cluster: with the catalog image of an older version,
+// and the extra metadata set by the cluster
+func testObject(t *testing.T, content string, extraMetadata map[string]interface{}) *unstructured.Unstructured {
+	file := filepath.Join(t.TempDir(), "object.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(content), 0644))
+	objects, err := readObjects(file)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	obj := objects[0]
+	if obj.GetKind() == "CatalogSource" {
+		require.NoError(t, unstructured.SetNestedField(obj.Object, "registry.enclave/redhat/redhat-operator-index:v4.17", "spec", "image"))
+	}
+	for key, value := range extraMetadata {
+		require.NoError(t, unstructured.SetNestedField(obj.Object, value, "metadata", key))
+	}
+	return obj
+}
+
+// newTestDynamicClient returns a fake dynamic client handling server-side apply: the fake object tracker
+// doesn't support it for unstructured objects
+func newTestDynamicClient(objects ...runtime.Object) *fake.FakeDynamicClient {
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		idmsGVR:          "ImageDigestMirrorSetList",
+		catalogSourceGVR: "CatalogSourceList",
+	}, objects...)
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch, ok := action.(k8stesting.PatchAction)
+		if !ok || patch.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+			return true, nil, err
+		}
+		_, err := client.Tracker().Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+		if apierrors.IsNotFound(err) {
+			return true, obj, client.Tracker().Create(patch.GetResource(), obj, patch.GetNamespace())
+		}
+		return true, obj, client.Tracker().Update(patch.GetResource(), obj, patch.GetNamespace())
+	})
+	return client
+}
+
+func newTestRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "config.openshift.io", Version: "v1", Kind: "ImageDigestMirrorSet"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Group: "operators.coreos.com", Version: "v1alpha1", Kind: "CatalogSource"}, meta.RESTScopeNamespace)
+	return mapper
+}
@@ -0,0 +1,117 @@
+package clusterresources
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	ofv1 "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/operator-framework/v1"
+	ofv1alpha1 "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/operator-framework/v1alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+const testClusterCatalogTemplate = `apiVersion: olm.operatorframework.io/v1
+kind: ClusterCatalog
+metadata:
+  name: to-be-replaced
+  labels:
+    team: platform
+spec:
+  priority: 100
+  source:
+    type: Image
+    image:
+      ref: to-be-replaced
+      pollIntervalMinutes: 30
+`
+
+func TestCatalogGenerators(t *testing.T) {
+	type testCase struct {
+		caseName               string
+		operator               v2alpha1.Operator
+		destination            string
+		expectedCatalogSource  string
+		expectedClusterCat     string
+		expectedClusterCatFile string
+		expectedPriority       int32
+		expectedPollInterval   *int
+	}
+	pollInterval := 30
+	testCases := []testCase{
+		{
+			caseName:              "names derived from the catalog",
+			operator:              v2alpha1.Operator{Catalog: "registry.redhat.io/redhat/redhat-operator-index:v4.18"},
+			destination:           "docker://registry.enclave/redhat/redhat-operator-index:v4.18",
+			expectedCatalogSource: "cs-redhat-operator-index-v4-18",
+			expectedClusterCat:    "cc-redhat-operator-index-v4-18",
+		},
+		{
+			caseName:              "explicit name, with a cluster catalog template",
+			operator:              v2alpha1.Operator{Catalog: "registry.redhat.io/redhat/redhat-operator-index:v4.18", CatalogSourceName: "redhat-operators", TargetClusterCatalogTemplate: "template.yaml"},
+			destination:           "docker://registry.enclave/redhat/redhat-operator-index:v4.18",
+			expectedCatalogSource: "redhat-operators",
+			expectedClusterCat:    "redhat-operators",
+			// both resources share the name, not the file
+			expectedClusterCatFile: "cc-redhat-operators",
+			expectedPriority:       100,
+			expectedPollInterval:   &pollInterval,
+		},
+		{
+			caseName:              "cluster catalog template polling a catalog mirrored by digest",
+			operator:              v2alpha1.Operator{Catalog: "registry.redhat.io/redhat/redhat-operator-index@sha256:0f2dbb8a41f0c8c8b5b4aa0c0a0f2a3e8c3d4b6c1d9e2f7a8b9c0d1e2f3a4b5c", TargetClusterCatalogTemplate: "template.yaml"},
+			destination:           "docker://registry.enclave/redhat/redhat-operator-index@sha256:0f2dbb8a41f0c8c8b5b4aa0c0a0f2a3e8c3d4b6c1d9e2f7a8b9c0d1e2f3a4b5c",
+			expectedCatalogSource: "cs-redhat-operator-index-0f2dbb8a41f0",
+			// falls back to the cluster catalog without template
+			expectedClusterCat: "cc-redhat-operator-index-0f2dbb8a41f0",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			workingDir := t.TempDir()
+			if testCase.operator.TargetClusterCatalogTemplate != "" {
+				testCase.operator.TargetClusterCatalogTemplate = filepath.Join(workingDir, testCase.operator.TargetClusterCatalogTemplate)
+				require.NoError(t, os.WriteFile(testCase.operator.TargetClusterCatalogTemplate, []byte(testClusterCatalogTemplate), 0644))
+			}
+			cfg := v2alpha1.ImageSetConfiguration{}
+			cfg.Mirror.Operators = []v2alpha1.Operator{testCase.operator}
+			generator := New(clog.New("error"), cfg, &common.MirrorOptions{WorkingDir: workingDir, LocalStorageFQDN: "localhost:55000"})
+			images := []v2alpha1.CopyImageSchema{{Origin: "docker://" + testCase.operator.Catalog, Destination: testCase.destination, Type: v2alpha1.TypeOperatorCatalog}}
+			require.NoError(t, generator.CatalogSourceGenerator(images))
+			require.NoError(t, generator.ClusterCatalogGenerator(images))
+
+			catalogSource := ofv1alpha1.CatalogSource{}
+			readTestClusterResource(t, workingDir, testCase.expectedCatalogSource, &catalogSource)
+			require.Equal(t, "CatalogSource", catalogSource.Kind)
+			require.Equal(t, testCase.expectedCatalogSource, catalogSource.Name)
+			require.Equal(t, ofv1alpha1.SourceType("grpc"), catalogSource.Spec.SourceType)
+			require.Equal(t, testCase.destination[len(dockerProtocol):], catalogSource.Spec.Image)
+
+			clusterCatalogFile := testCase.expectedClusterCatFile
+			if clusterCatalogFile == "" {
+				clusterCatalogFile = testCase.expectedClusterCat
+			}
+			clusterCatalog := ofv1.ClusterCatalog{}
+			readTestClusterResource(t, workingDir, clusterCatalogFile, &clusterCatalog)
+			require.Equal(t, ofv1.ClusterCatalogKind, clusterCatalog.Kind)
+			require.Equal(t, testCase.expectedClusterCat, clusterCatalog.Name)
+			require.Equal(t, ofv1.SourceTypeImage, clusterCatalog.Spec.Source.Type)
+			require.Equal(t, testCase.expectedPriority, clusterCatalog.Spec.Priority)
+			require.Equal(t, testCase.destination[len(dockerProtocol):], clusterCatalog.Spec.Source.Image.Ref)
+			require.Equal(t, testCase.expectedPollInterval, clusterCatalog.Spec.Source.Image.PollIntervalMinutes)
+			if testCase.expectedPriority != 0 {
+				require.Equal(t, map[string]string{"team": "platform"}, clusterCatalog.Labels)
+			}
+		})
+	}
+}
+
+func readTestClusterResource(t *testing.T, workingDir, name string, obj interface{}) {
+	data, err := os.ReadFile(filepath.Join(workingDir, clusterResourcesDir, name+".yaml"))
+	require.NoError(t, err)
+	require.NoError(t, yaml.Unmarshal(data, obj))
+}
@@ -9,10 +9,13 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"unicode"
 
 	confv1 "github.com/openshift/api/config/v1"
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -32,9 +35,11 @@ import (
 
 const (
 	clusterResourcesDir            string = "cluster-resources"
+	dockerProtocol                 string = "docker://"
 	updateServiceFilename          string = "updateService.yaml"
 	updateServiceResourceName      string = "update-service-oc-mirror"
 	updateServiceResourceKind      string = "UpdateService"
+	updateServiceReplicas                 = 2
 	configMapApiVersion                   = "v1"
 	configMapKind                         = "ConfigMap"
 	configMapBinaryDataIndexFormat        = "sha256-%s-%d"
@@ -47,11 +52,14 @@ const (
 )
 
 type GeneratorInterface interface {
-	IDMS_ITMSGenerator(allRelatedImages []v2alpha1.CopyImageSchema, forceRepositoryScope bool) error
-	UpdateServiceGenerator(graphImage, releaseImage string) error
+	MirrorSetsGenerator(allRelatedImages []v2alpha1.CopyImageSchema, forceRepositoryScope bool) error
+	RemoveFromCumulativeMirrorSets(deletedImages []v2alpha1.CopyImageSchema) error
+	UpdateServiceGenerator(graphImage string, allRelatedImages []v2alpha1.CopyImageSchema) error
 	CatalogSourceGenerator(allRelatedImages []v2alpha1.CopyImageSchema) error
 	GenerateSignatureConfigMap(allRelatedImages []v2alpha1.CopyImageSchema) error
 	ClusterCatalogGenerator(allRelatedImages []v2alpha1.CopyImageSchema) error
+	KustomizationGenerator() error
+	UpdateKustomization() error
 }
 
 func New(log clog.PluggableLoggerInterface,
@@ -75,6 +83,16 @@ type categorizedMirrors struct {
 	mirrors  map[string][]confv1.ImageMirror
 }
 
+// sources returns the sources of the mirrors, sorted: the mirror sets generated from them are stable across runs
+func (o categorizedMirrors) sources() []string {
+	sources := make([]string, 0, len(o.mirrors))
+	for source := range o.mirrors {
+		sources = append(sources, source)
+	}
+	slices.Sort(sources)
+	return sources
+}
+
 const (
 	DigestsOnlyMode = iota
 	TagsOnlyMode
@@ -83,55 +101,183 @@ const (
 	operatorCategory
 	genericCategory
 
-	idmsFileName = "idms-oc-mirror.yaml"
-	itmsFileName = "itms-oc-mirror.yaml"
+	idmsFileName           = "idms-oc-mirror.yaml"
+	itmsFileName           = "itms-oc-mirror.yaml"
+	icspFileName           = "icsp-oc-mirror.yaml"
+	registriesConfFileName = "registries-oc-mirror.conf"
+	cumulativeSuffix       = "-cumulative"
+)
+
+// Formats of the mirror configuration generated for the mirrored images
+const (
+	IDMSITMSFormat       = "idms-itms"
+	ICSPFormat           = "icsp"
+	RegistriesConfFormat = "registries-conf"
 )
 
-func (o *ClusterResourcesGenerator) IDMS_ITMSGenerator(allRelatedImages []v2alpha1.CopyImageSchema, forceRepositoryScope bool) error {
+var MirrorSetFormats = []string{IDMSITMSFormat, ICSPFormat, RegistriesConfFormat}
+
+// mirrorSetWriter writes the mirror configuration of a format, from the mirrors by digest and by tag.
+// The cumulative one covers all the images mirrored from the workspace.
+type mirrorSetWriter func(o *ClusterResourcesGenerator, byDigestMirrors, byTagMirrors []categorizedMirrors, cumulative bool) error
+
+var mirrorSetWriters = map[string]mirrorSetWriter{
+	IDMSITMSFormat:       (*ClusterResourcesGenerator).writeIDMS_ITMS,
+	ICSPFormat:           (*ClusterResourcesGenerator).writeICSP,
+	RegistriesConfFormat: (*ClusterResourcesGenerator).writeRegistriesConf,
+}
+
+// MirrorSetsGenerator generates the mirror configuration, in the formats of --mirror-set-format (IDMS and ITMS by default),
+// of the images mirrored by this run (i.e. idms-oc-mirror.yaml), and the cumulative one, covering all the images
+// mirrored from the workspace (i.e. idms-oc-mirror-cumulative.yaml)
+func (o *ClusterResourcesGenerator) MirrorSetsGenerator(allRelatedImages []v2alpha1.CopyImageSchema, forceRepositoryScope bool) error {
 	if len(allRelatedImages) == 0 {
-		o.Log.Info(emoji.PageFacingUp + " Nothing mirrored. Skipping IDMS and ITMS files generation.")
-		return nil
+		o.Log.Info(emoji.PageFacingUp + " Nothing mirrored. Skipping mirror set files generation.")
+	} else {
+		// byDigestMirrors
+		byDigestMirrors, err := o.generateImageMirrors(allRelatedImages, DigestsOnlyMode, forceRepositoryScope)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		// byTagMirrors
+		byTagMirrors, err := o.generateImageMirrors(allRelatedImages, TagsOnlyMode, forceRepositoryScope)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		if err := o.writeMirrorSets(o.mirrorSetFormats(), byDigestMirrors, byTagMirrors, false); err != nil {
+			return err
+		}
 	}
 
-	// byDigestMirrors
-	byDigestMirrors, err := o.generateImageMirrors(allRelatedImages, DigestsOnlyMode, forceRepositoryScope)
+	state, err := readMirrorSetState(o.Options.WorkingDir)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return err
+	}
+	if err := state.add(allRelatedImages, forceRepositoryScope); err != nil {
+		return err
+	}
+	if err := state.write(o.Options.WorkingDir); err != nil {
+		return err
 	}
+	return o.writeMirrorSets(o.mirrorSetFormats(), categorize(state.ImageDigestMirrors), categorize(state.ImageTagMirrors), true)
+}
 
-	// byTagMirrors
-	byTagMirrors, err := o.generateImageMirrors(allRelatedImages, TagsOnlyMode, forceRepositoryScope)
+// RemoveFromCumulativeMirrorSets removes the images deleted from the destination registry from the cumulative
+// mirror configuration: the mirrors needed only by them are dropped. The cumulative files found in cluster-resources
+// are rewritten, whatever their format.
+// The state of the cumulative mirror configuration belongs to the workspace the images were mirrored from: the delete
+// must use this workspace (--workspace), otherwise nothing is updated.
+func (o *ClusterResourcesGenerator) RemoveFromCumulativeMirrorSets(deletedImages []v2alpha1.CopyImageSchema) error {
+	if _, err := os.Stat(filepath.Join(o.Options.WorkingDir, mirrorSetStateFile)); errors.Is(err, os.ErrNotExist) {
+		o.Log.Warn("no cumulative mirror sets in %s: use the --workspace the images were mirrored from to update them", o.Options.WorkingDir)
+		return nil
+	}
+	state, err := readMirrorSetState(o.Options.WorkingDir)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return err
 	}
-	// if byTagMirrors not empty
+	state.remove(deletedImages)
+	if err := state.write(o.Options.WorkingDir); err != nil {
+		return err
+	}
+	formats := []string{}
+	for format, fileNames := range map[string][]string{
+		IDMSITMSFormat:       {idmsFileName, itmsFileName},
+		ICSPFormat:           {icspFileName},
+		RegistriesConfFormat: {registriesConfFileName},
+	} {
+		for _, fileName := range fileNames {
+			if _, err := os.Stat(filepath.Join(o.Options.WorkingDir, clusterResourcesDir, mirrorSetFileName(fileName, true))); err == nil {
+				formats = append(formats, format)
+				break
+			}
+		}
+	}
+	return o.writeMirrorSets(formats, categorize(state.ImageDigestMirrors), categorize(state.ImageTagMirrors), true)
+}
+
+// mirrorSetFormats returns the formats of --mirror-set-format, IDMS and ITMS by default
+func (o *ClusterResourcesGenerator) mirrorSetFormats() []string {
+	if len(o.Options.MirrorSetFormats) == 0 {
+		return []string{IDMSITMSFormat}
+	}
+	return o.Options.MirrorSetFormats
+}
+
+// writeMirrorSets writes the mirror configuration in formats
+func (o *ClusterResourcesGenerator) writeMirrorSets(formats []string, byDigestMirrors, byTagMirrors []categorizedMirrors, cumulative bool) error {
+	for _, format := range formats {
+		write, ok := mirrorSetWriters[format]
+		if !ok {
+			return fmt.Errorf("unknown mirror set format %s, expected one of (%s)", format, strings.Join(MirrorSetFormats, ", "))
+		}
+		if err := write(o, byDigestMirrors, byTagMirrors, cumulative); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *ClusterResourcesGenerator) writeIDMS_ITMS(byDigestMirrors, byTagMirrors []categorizedMirrors, cumulative bool) error {
+	idmsFile, itmsFile := mirrorSetFileName(idmsFileName, cumulative), mirrorSetFileName(itmsFileName, cumulative)
+	// if byDigestMirrors not empty
 	if len(byDigestMirrors) > 0 {
-		o.Log.Info(emoji.PageFacingUp + " Generating IDMS file...")
+		o.Log.Info(emoji.PageFacingUp+" Generating %s file...", mirrorSetDescription("IDMS", cumulative))
 		idmsList, err := o.generateIDMS(byDigestMirrors)
 		if err != nil {
 			return fmt.Errorf("%w", err)
 		}
 
-		err = writeMirrorSet(idmsList, o.Options.WorkingDir, idmsFileName, o.Log)
+		err = writeMirrorSet(idmsList, o.Options.WorkingDir, idmsFile, o.Log)
 		if err != nil {
 			return fmt.Errorf("%w", err)
 		}
 	} else {
 		o.Log.Info(emoji.PageFacingUp + " No images by digests were mirrored. Skipping IDMS generation.")
+		if err := removeClusterResource(o.Options.WorkingDir, idmsFile); err != nil {
+			return err
+		}
 	}
 	// if byTagMirrors not empty
 	if len(byTagMirrors) > 0 {
-		o.Log.Info(emoji.PageFacingUp + " Generating ITMS file...")
+		o.Log.Info(emoji.PageFacingUp+" Generating %s file...", mirrorSetDescription("ITMS", cumulative))
 		itmsList, err := o.generateITMS(byTagMirrors)
 		if err != nil {
 			return fmt.Errorf("%w", err)
 		}
-		err = writeMirrorSet(itmsList, o.Options.WorkingDir, itmsFileName, o.Log)
+		err = writeMirrorSet(itmsList, o.Options.WorkingDir, itmsFile, o.Log)
 		if err != nil {
 			return fmt.Errorf("%w", err)
 		}
 	} else {
 		o.Log.Info(emoji.PageFacingUp + " No images by tag were mirrored. Skipping ITMS generation.")
+		if err := removeClusterResource(o.Options.WorkingDir, itmsFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mirrorSetFileName returns the name of the cumulative version of fileName when cumulative is set
+func mirrorSetFileName(fileName string, cumulative bool) string {
+	if !cumulative {
+		return fileName
+	}
+	ext := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + cumulativeSuffix + ext
+}
+
+func mirrorSetDescription(kind string, cumulative bool) string {
+	if cumulative {
+		return "cumulative " + kind
+	}
+	return kind
+}
+
+func removeClusterResource(workingDir, fileName string) error {
+	if err := os.Remove(filepath.Join(workingDir, clusterResourcesDir, fileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w", err)
 	}
 	return nil
 }
@@ -152,10 +298,10 @@ func (o *ClusterResourcesGenerator) generateITMS(mirrorsByCategory []categorized
 				ImageTagMirrors: []confv1.ImageTagMirrors{},
 			},
 		}
-		for source, imgMirrors := range catMirrors.mirrors {
+		for _, source := range catMirrors.sources() {
 			itm := confv1.ImageTagMirrors{
 				Source:  source,
-				Mirrors: imgMirrors,
+				Mirrors: catMirrors.mirrors[source],
 			}
 			itmsList[index].Spec.ImageTagMirrors = append(itmsList[index].Spec.ImageTagMirrors, itm)
 		}
@@ -164,7 +310,7 @@ func (o *ClusterResourcesGenerator) generateITMS(mirrorsByCategory []categorized
 	return itmsList, nil
 }
 
-func writeMirrorSet[T confv1.ImageDigestMirrorSet | confv1.ImageTagMirrorSet](mirrorSetsList []T, workingDir, fileName string, log clog.PluggableLoggerInterface) error {
+func writeMirrorSet[T confv1.ImageDigestMirrorSet | confv1.ImageTagMirrorSet | operatorv1alpha1.ImageContentSourcePolicy](mirrorSetsList []T, workingDir, fileName string, log clog.PluggableLoggerInterface) error {
 	msFilePath := filepath.Join(workingDir, clusterResourcesDir, fileName)
 	msAggregation := []byte{}
 	var err error
@@ -201,8 +347,10 @@ func writeMirrorSet[T confv1.ImageDigestMirrorSet | confv1.ImageTagMirrorSet](mi
 
 	defer msFile.Close()
 
-	_, err = msFile.Write(msAggregation)
-	return fmt.Errorf("%w", err)
+	if _, err := msFile.Write(msAggregation); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
 }
 
 func (o *ClusterResourcesGenerator) CatalogSourceGenerator(allRelatedImages []v2alpha1.CopyImageSchema) error {
@@ -221,9 +369,9 @@ func (o *ClusterResourcesGenerator) CatalogSourceGenerator(allRelatedImages []v2
 				o.Log.Info(emoji.PageFacingUp + " Generating CatalogSource file...")
 				firstCatalog = false
 			}
-			// check if ImageSetConfig contains a CatalogSourceTemplate for this catalog, and use it
-			template := o.getCSTemplate(copyImage.Origin)
-			err := o.generateCatalogSource(copyImage.Destination, template)
+			// check if ImageSetConfig contains a CatalogSourceTemplate and a name for this catalog, and use them
+			op := o.operatorOfCatalog(copyImage.Origin)
+			err := o.generateCatalogSource(copyImage.Destination, op.CatalogSourceName, op.TargetCatalogSourceTemplate)
 			if err != nil {
 				return fmt.Errorf("%w", err)
 			}
@@ -250,58 +398,73 @@ func (o *ClusterResourcesGenerator) ClusterCatalogGenerator(allRelatedImages []v
 			o.Log.Info(emoji.PageFacingUp + " Generating ClusterCatalog file...")
 			firstCatalog = false
 		}
-		// TODO: add template support
-		if err := o.generateClusterCatalog(copyImage.Destination); err != nil {
+		// check if ImageSetConfig contains a ClusterCatalogTemplate and a name for this catalog, and use them
+		op := o.operatorOfCatalog(copyImage.Origin)
+		if err := o.generateClusterCatalog(copyImage.Destination, op.CatalogSourceName, op.TargetClusterCatalogTemplate); err != nil {
 			return fmt.Errorf("%w", err)
 		}
 	}
 	return nil
 }
 
-func (o *ClusterResourcesGenerator) getCSTemplate(catalogRef string) string {
+// operatorOfCatalog returns the operator of the ImageSetConfig the catalog catalogRef is mirrored for
+func (o *ClusterResourcesGenerator) operatorOfCatalog(catalogRef string) v2alpha1.Operator {
 	for _, op := range o.Config.ImageSetConfigurationSpec.Mirror.Operators {
 		if strings.Contains(catalogRef, op.Catalog) {
-			return op.TargetCatalogSourceTemplate
+			return op
 		}
 	}
-	return ""
+	return v2alpha1.Operator{}
 }
 
-func (o *ClusterResourcesGenerator) generateCatalogSource(catalogRef string, catalogSourceTemplateFile string) error {
-
-	catalogSpec, err := image.ParseRef(catalogRef)
-	if err != nil {
-		return fmt.Errorf("%w", err)
+// catalogResourceName returns name when set, otherwise the name derived from the repository and the tag (or digest)
+// of catalogSpec, with prefix: i.e. cs-redhat-operator-index-v4-18
+func catalogResourceName(prefix, name string, catalogSpec image.ImageSpec) (string, error) {
+	if name != "" {
+		return name, nil
 	}
-
-	var csSuffix string
+	var suffix string
 	if catalogSpec.IsImageByDigestOnly() {
 		if len(catalogSpec.Digest) >= hashTruncLen {
-			csSuffix = catalogSpec.Digest[:hashTruncLen]
+			suffix = catalogSpec.Digest[:hashTruncLen]
 		} else {
-			csSuffix = catalogSpec.Digest
+			suffix = catalogSpec.Digest
 		}
 	} else {
 		tag := catalogSpec.Tag
 		if len(tag) >= hashTruncLen {
-			csSuffix = strings.Map(toRFC1035, tag[:hashTruncLen])
+			suffix = strings.Map(toRFC1035, tag[:hashTruncLen])
 		} else {
-			csSuffix = strings.Map(toRFC1035, tag)
+			suffix = strings.Map(toRFC1035, tag)
 		}
 	}
 
-	if csSuffix == "" {
-		csSuffix = "0" // default value
+	if suffix == "" {
+		suffix = "0" // default value
 	}
 
 	pathComponents := strings.Split(catalogSpec.PathComponent, "/")
 	catalogRepository := pathComponents[len(pathComponents)-1]
-	catalogSourceName := "cs-" + catalogRepository + "-" + csSuffix
+	name = prefix + "-" + catalogRepository + "-" + suffix
 	// maybe needs some updating (i.e other unwanted characters !@# etc )
-	catalogSourceName = strings.ReplaceAll(catalogSourceName, ".", "-")
-	errs := validation.IsDNS1035Label(catalogSourceName)
-	if len(errs) != 0 && !isValidRFC1123(catalogSourceName) {
-		return fmt.Errorf("error creating catalog source name: %s", strings.Join(errs, ", "))
+	name = strings.ReplaceAll(name, ".", "-")
+	errs := validation.IsDNS1035Label(name)
+	if len(errs) != 0 && !isValidRFC1123(name) {
+		return "", fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return name, nil
+}
+
+func (o *ClusterResourcesGenerator) generateCatalogSource(catalogRef, catalogSourceName, catalogSourceTemplateFile string) error {
+
+	catalogSpec, err := image.ParseRef(catalogRef)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	catalogSourceName, err = catalogResourceName("cs", catalogSourceName, catalogSpec)
+	if err != nil {
+		return fmt.Errorf("error creating catalog source name: %w", err)
 	}
 
 	var obj ofv1alpha1.CatalogSource
@@ -360,10 +523,11 @@ func (o *ClusterResourcesGenerator) generateCatalogSource(catalogRef string, cat
 
 	defer csFile.Close()
 
-	_, err = csFile.Write(bytes)
+	if _, err := csFile.Write(bytes); err != nil {
+		return fmt.Errorf("%w", err)
+	}
 	o.Log.Info("%s file created", csFileName)
-
-	return fmt.Errorf("%w", err)
+	return nil
 }
 
 func catalogSourceContentFromTemplate(templateFile, catalogSourceName, image string) (ofv1alpha1.CatalogSource, error) {
@@ -413,59 +577,51 @@ func catalogSourceContentFromTemplate(templateFile, catalogSourceName, image str
 	return obj, nil
 }
 
-// TODO: support generation with template (or equivalent)
-func (o *ClusterResourcesGenerator) generateClusterCatalog(catalogRef string) error {
+func (o *ClusterResourcesGenerator) generateClusterCatalog(catalogRef, clusterCatalogName, clusterCatalogTemplateFile string) error {
 	catalogSpec, err := image.ParseRef(catalogRef)
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
-	var ccSuffix string
-	if catalogSpec.IsImageByDigestOnly() {
-		if len(catalogSpec.Digest) >= hashTruncLen {
-			ccSuffix = catalogSpec.Digest[:hashTruncLen]
-		} else {
-			ccSuffix = catalogSpec.Digest
-		}
-	} else {
-		tag := catalogSpec.Tag
-		if len(tag) >= hashTruncLen {
-			ccSuffix = strings.Map(toRFC1035, tag[:hashTruncLen])
-		} else {
-			ccSuffix = strings.Map(toRFC1035, tag)
-		}
+	// an explicit name is shared with the CatalogSource: the file name is prefixed so that both files are kept
+	fileName := clusterCatalogName
+	clusterCatalogName, err = catalogResourceName("cc", clusterCatalogName, catalogSpec)
+	if err != nil {
+		return fmt.Errorf("error creating cluster catalog name: %w", err)
 	}
-
-	if ccSuffix == "" {
-		ccSuffix = "0" // default value
+	if fileName == "" {
+		fileName = clusterCatalogName
+	} else {
+		fileName = "cc-" + fileName
 	}
 
-	pathComponents := strings.Split(catalogSpec.PathComponent, "/")
-	catalogRepository := pathComponents[len(pathComponents)-1]
-	clusterCatalogName := "cc-" + catalogRepository + "-" + ccSuffix
-	// maybe needs some updating (i.e other unwanted characters !@# etc )
-	clusterCatalogName = strings.ReplaceAll(clusterCatalogName, ".", "-")
-	errs := validation.IsDNS1035Label(clusterCatalogName)
-	if len(errs) != 0 && !isValidRFC1123(clusterCatalogName) {
-		return fmt.Errorf("error creating cluster catalog name: %s", strings.Join(errs, ", "))
+	var obj ofv1.ClusterCatalog
+	generateWithoutTemplate := false
+	if clusterCatalogTemplateFile != "" {
+		obj, err = clusterCatalogContentFromTemplate(clusterCatalogTemplateFile, clusterCatalogName, catalogSpec)
+		if err != nil {
+			generateWithoutTemplate = true
+			o.Log.Error("error generating cluster catalog from template. Fall back to generating cluster catalog without template: %v", err)
+		}
 	}
-
-	obj := ofv1.ClusterCatalog{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: ofv1.ClusterCatalogCRDAPIVersion,
-			Kind:       ofv1.ClusterCatalogKind,
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name: clusterCatalogName,
-		},
-		Spec: ofv1.ClusterCatalogSpec{
-			Source: ofv1.CatalogSource{
-				Type: ofv1.SourceTypeImage,
-				Image: &ofv1.ImageSource{
-					Ref: catalogSpec.Reference,
+	if generateWithoutTemplate || clusterCatalogTemplateFile == "" {
+		obj = ofv1.ClusterCatalog{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: ofv1.ClusterCatalogCRDAPIVersion,
+				Kind:       ofv1.ClusterCatalogKind,
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: clusterCatalogName,
+			},
+			Spec: ofv1.ClusterCatalogSpec{
+				Source: ofv1.CatalogSource{
+					Type: ofv1.SourceTypeImage,
+					Image: &ofv1.ImageSource{
+						Ref: catalogSpec.Reference,
+					},
 				},
 			},
-		},
+		}
 	}
 
 	// Create an unstructured object for removing creationTimestamp
@@ -481,7 +637,7 @@ func (o *ClusterResourcesGenerator) generateClusterCatalog(catalogRef string) er
 		return fmt.Errorf("unable to marshal ClusterCatalog yaml: %w", err)
 	}
 
-	ccFileName := filepath.Join(o.Options.WorkingDir, clusterResourcesDir, clusterCatalogName+".yaml")
+	ccFileName := filepath.Join(o.Options.WorkingDir, clusterResourcesDir, fileName+".yaml")
 	// save ClusterCatalog struct to file
 	if _, err := os.Stat(ccFileName); errors.Is(err, os.ErrNotExist) {
 		o.Log.Debug("%s does not exist, creating it", ccFileName)
@@ -497,10 +653,48 @@ func (o *ClusterResourcesGenerator) generateClusterCatalog(catalogRef string) er
 
 	defer ccFile.Close()
 
-	_, err = ccFile.Write(bytes)
+	if _, err := ccFile.Write(bytes); err != nil {
+		return fmt.Errorf("%w", err)
+	}
 	o.Log.Info("%s file created", ccFileName)
+	return nil
+}
 
-	return fmt.Errorf("%w", err)
+func clusterCatalogContentFromTemplate(templateFile, clusterCatalogName string, catalogSpec image.ImageSpec) (ofv1.ClusterCatalog, error) {
+	// Initializing clusterCatalog `obj` from template
+	var obj ofv1.ClusterCatalog
+	bytesRead, err := os.ReadFile(templateFile)
+	if err != nil {
+		return obj, fmt.Errorf("error during ClusterCatalog generation using template: error reading targetClusterCatalogTemplate file %s: %w", templateFile, err)
+	}
+	err = yaml.Unmarshal(bytesRead, &obj)
+	if err != nil {
+		return obj, fmt.Errorf("error during ClusterCatalog generation using template: %s is not a valid cluster catalog template and could not be unmarshaled: %w", templateFile, err)
+	}
+
+	// validate that the clusterCatalog is sourced from an image, otherwise fail
+	if obj.APIVersion != ofv1.ClusterCatalogCRDAPIVersion {
+		return ofv1.ClusterCatalog{}, fmt.Errorf("error during ClusterCatalog generation using template: cluster catalog template does not correspond to the apiVersion %s : %s", ofv1.ClusterCatalogCRDAPIVersion, obj.APIVersion)
+	}
+	if obj.Kind != ofv1.ClusterCatalogKind {
+		return ofv1.ClusterCatalog{}, fmt.Errorf("error during ClusterCatalog generation using template: cluster catalog template does not correspond to Kind %s : %s", ofv1.ClusterCatalogKind, obj.Kind)
+	}
+	if obj.Spec.Source.Type != "" && obj.Spec.Source.Type != ofv1.SourceTypeImage {
+		return ofv1.ClusterCatalog{}, fmt.Errorf("error during ClusterCatalog generation using template: cluster catalog template is not of source type %s", ofv1.SourceTypeImage)
+	}
+	// fill obj with the values for this catalog, keeping the poll interval of the template
+	obj.Name = clusterCatalogName
+	obj.Spec.Source.Type = ofv1.SourceTypeImage
+	if obj.Spec.Source.Image == nil {
+		obj.Spec.Source.Image = &ofv1.ImageSource{}
+	}
+	obj.Spec.Source.Image.Ref = catalogSpec.Reference
+	// the catalog image can't be polled when it is pinned by digest
+	if obj.Spec.Source.Image.PollIntervalMinutes != nil && catalogSpec.IsImageByDigestOnly() {
+		return ofv1.ClusterCatalog{}, fmt.Errorf("error during ClusterCatalog generation using template: pollIntervalMinutes can't be set for the catalog %s, mirrored by digest", catalogSpec.Reference)
+	}
+
+	return obj, nil
 }
 
 func (o *ClusterResourcesGenerator) generateIDMS(mirrorsByCategory []categorizedMirrors) ([]confv1.ImageDigestMirrorSet, error) {
@@ -519,10 +713,10 @@ func (o *ClusterResourcesGenerator) generateIDMS(mirrorsByCategory []categorized
 				ImageDigestMirrors: []confv1.ImageDigestMirrors{},
 			},
 		}
-		for source, imgMirrors := range catMirrors.mirrors {
+		for _, source := range catMirrors.sources() {
 			idm := confv1.ImageDigestMirrors{
 				Source:  source,
-				Mirrors: imgMirrors,
+				Mirrors: catMirrors.mirrors[source],
 			}
 			idmsList[index].Spec.ImageDigestMirrors = append(idmsList[index].Spec.ImageDigestMirrors, idm)
 		}
@@ -537,48 +731,13 @@ func (o *ClusterResourcesGenerator) generateImageMirrors(allRelatedImages []v2al
 		if relatedImage.Origin == "" {
 			return nil, fmt.Errorf("unable to generate IDMS/ITMS: original reference for (%s,%s) undetermined", relatedImage.Source, relatedImage.Destination)
 		}
-		if relatedImage.Type == v2alpha1.TypeCincinnatiGraph || relatedImage.Type == v2alpha1.TypeOperatorCatalog {
-			// cincinnati graph images and operator catalog images don't need to be in the IDMS/ITMS file.
-			// * cincinnati graph image has been generated from scratch by oc-mirror and will be copied to the destination registry.
-			// The updateservice.yaml file will instruct the cluster to use it.
-			// * operator catalogs are added to catalog source custom resources, and is consumed by the cluster from there.
-			// it therefore doesn't need to be added to IDMS, same as oc-mirror
-			// [v1 doesn't add it to ICSP](https://github.com/openshift/oc-mirror/blob/fa0c2caa6a3eb33ed7a7b3350e3b5fc7430bad55/pkg/cli/mirror/mirror.go#L539).
-			continue
-		}
-		srcImgSpec, err := image.ParseRef(relatedImage.Origin)
-		if err != nil {
-			return nil, fmt.Errorf("unable to generate IDMS/ITMS: %w", err)
-		}
-		dstImgSpec, err := image.ParseRef(relatedImage.Destination)
+		source, mirror, toBeAdded, err := imageMirror(relatedImage, mode, forceRepositoryScope)
 		if err != nil {
-			return nil, fmt.Errorf("unable to generate IDMS/ITMS: %w", err)
-		}
-		toBeAdded := true
-		switch mode {
-		case TagsOnlyMode:
-			if srcImgSpec.IsImageByDigestOnly() {
-				toBeAdded = false
-			}
-		case DigestsOnlyMode:
-			// CLID-205: In order to achieve retrocompatibility with v1, and allow for the installer
-			// to have the correct mirror for the release images as well as for the release components in the IDMS
-			// we include the release image mirror in the IDMS, even though it is by tag
-			if !srcImgSpec.IsImageByDigestOnly() && relatedImage.Type != v2alpha1.TypeOCPRelease {
-				toBeAdded = false
-			}
+			return nil, err
 		}
 		if !toBeAdded {
 			continue
 		}
-		source := ""
-		mirror := ""
-		if forceRepositoryScope {
-			source = repositoryScope(srcImgSpec)
-			mirror = repositoryScope(dstImgSpec)
-		} else {
-			source, mirror = attemptNamespaceScope(srcImgSpec, dstImgSpec)
-		}
 
 		categoryOfImage := imageTypeToCategory(relatedImage.Type)
 		if _, ok := mirrorsByCategory[categoryOfImage]; !ok {
@@ -612,43 +771,122 @@ func (o *ClusterResourcesGenerator) generateImageMirrors(allRelatedImages []v2al
 	return categorizedMirrorsList, nil
 }
 
-func (o *ClusterResourcesGenerator) UpdateServiceGenerator(graphImageRef, releaseImageRef string) error {
-	o.Log.Info(emoji.PageFacingUp + " Generating UpdateService file...")
+// imageMirror returns the source and the mirror of relatedImage in the mirror sets of mode,
+// and whether relatedImage belongs to them
+func imageMirror(relatedImage v2alpha1.CopyImageSchema, mode imageMirrorsGeneratorMode, forceRepositoryScope bool) (string, string, bool, error) {
+	if relatedImage.Type == v2alpha1.TypeCincinnatiGraph || relatedImage.Type == v2alpha1.TypeOperatorCatalog {
+		// cincinnati graph images and operator catalog images don't need to be in the IDMS/ITMS file.
+		// * cincinnati graph image has been generated from scratch by oc-mirror and will be copied to the destination registry.
+		// The updateservice.yaml file will instruct the cluster to use it.
+		// * operator catalogs are added to catalog source custom resources, and is consumed by the cluster from there.
+		// it therefore doesn't need to be added to IDMS, same as oc-mirror
+		// [v1 doesn't add it to ICSP](https://github.com/openshift/oc-mirror/blob/fa0c2caa6a3eb33ed7a7b3350e3b5fc7430bad55/pkg/cli/mirror/mirror.go#L539).
+		return "", "", false, nil
+	}
+	srcImgSpec, err := image.ParseRef(relatedImage.Origin)
+	if err != nil {
+		return "", "", false, fmt.Errorf("unable to generate IDMS/ITMS: %w", err)
+	}
+	dstImgSpec, err := image.ParseRef(relatedImage.Destination)
+	if err != nil {
+		return "", "", false, fmt.Errorf("unable to generate IDMS/ITMS: %w", err)
+	}
+	switch mode {
+	case TagsOnlyMode:
+		if srcImgSpec.IsImageByDigestOnly() {
+			return "", "", false, nil
+		}
+	case DigestsOnlyMode:
+		// CLID-205: In order to achieve retrocompatibility with v1, and allow for the installer
+		// to have the correct mirror for the release images as well as for the release components in the IDMS
+		// we include the release image mirror in the IDMS, even though it is by tag
+		if !srcImgSpec.IsImageByDigestOnly() && relatedImage.Type != v2alpha1.TypeOCPRelease {
+			return "", "", false, nil
+		}
+	}
+	if forceRepositoryScope {
+		return repositoryScope(srcImgSpec), repositoryScope(dstImgSpec), true, nil
+	}
+	source, mirror := attemptNamespaceScope(srcImgSpec, dstImgSpec)
+	return source, mirror, true, nil
+}
+
+// UpdateServiceGenerator generates the UpdateService of the graph image, for each repository the releases of
+// allRelatedImages were mirrored to (i.e. OCP and OKD releases, or releases rewritten by maxNestedPaths)
+func (o *ClusterResourcesGenerator) UpdateServiceGenerator(graphImageRef string, allRelatedImages []v2alpha1.CopyImageSchema) error {
 	// truncate tag or digest from release image
 	// according to https://docs.openshift.com/container-platform/4.14/updating/updating_a_cluster/updating_disconnected_cluster/disconnected-update-osus.html#update-service-create-service-cli_updating-restricted-network-cluster-osus
-	releaseImage, err := image.ParseRef(releaseImageRef)
-	if err != nil {
-		return fmt.Errorf("%w", err)
+	releaseRepositories := []string{}
+	for _, copyImage := range allRelatedImages {
+		// as for the catalogs, the releases copied to the local cache during mirror to mirror are skipped
+		if copyImage.Type != v2alpha1.TypeOCPRelease || strings.Contains(copyImage.Destination, o.Options.LocalStorageFQDN) {
+			continue
+		}
+		releaseImage, err := image.ParseRef(copyImage.Destination)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		if !slices.Contains(releaseRepositories, releaseImage.Name) {
+			releaseRepositories = append(releaseRepositories, releaseImage.Name)
+		}
+	}
+	if len(releaseRepositories) == 0 {
+		return fmt.Errorf("no release image found")
 	}
-	releaseImageName := releaseImage.Name
+	slices.Sort(releaseRepositories)
 
 	graphImage, err := image.ParseRef(graphImageRef)
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
-	osus := updateservicev1.UpdateService{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: updateservicev1.GroupVersion.String(),
-			Kind:       updateServiceResourceKind,
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name: updateServiceResourceName,
-		},
-		Spec: updateservicev1.UpdateServiceSpec{
-			Replicas:       2,
-			Releases:       releaseImageName,
-			GraphDataImage: graphImage.Reference,
-		},
+	o.Log.Info(emoji.PageFacingUp + " Generating UpdateService file...")
+	config := o.Config.Mirror.Platform.UpdateService
+	name := updateServiceResourceName
+	if config.Name != "" {
+		name = config.Name
+	}
+	replicas := int32(updateServiceReplicas)
+	if config.Replicas > 0 {
+		replicas = config.Replicas
 	}
+	osusAggregation := []byte{}
+	names := map[string]bool{}
+	for _, releaseRepository := range releaseRepositories {
+		osusName := name
+		// one UpdateService per repository, named after it
+		if len(releaseRepositories) > 1 {
+			osusName = updateServiceName(name, releaseRepository, names)
+		}
+		names[osusName] = true
+		osus := updateservicev1.UpdateService{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: updateservicev1.GroupVersion.String(),
+				Kind:       updateServiceResourceKind,
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      osusName,
+				Namespace: config.Namespace,
+			},
+			Spec: updateservicev1.UpdateServiceSpec{
+				Replicas:       replicas,
+				Releases:       releaseRepository,
+				GraphDataImage: graphImage.Reference,
+			},
+		}
 
-	// put UpdateService in yaml
-	osusBytes, err := yaml.Marshal(osus)
-	if err != nil {
-		return fmt.Errorf("%w", err)
+		// put UpdateService in yaml
+		osusBytes, err := yaml.Marshal(osus)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		// creationTimestamp is a struct, omitempty does not apply
+		osusBytes = bytes.ReplaceAll(osusBytes, []byte("  creationTimestamp: null\n"), []byte(""))
+		if len(releaseRepositories) > 1 {
+			osusAggregation = append(osusAggregation, []byte("---\n")...)
+		}
+		osusAggregation = append(osusAggregation, osusBytes...)
 	}
-	// creationTimestamp is a struct, omitempty does not apply
-	osusBytes = bytes.ReplaceAll(osusBytes, []byte("  creationTimestamp: null\n"), []byte(""))
 
 	// save UpdateService struct to file
 	osusPath := filepath.Join(o.Options.WorkingDir, clusterResourcesDir, updateServiceFilename)
@@ -669,8 +907,23 @@ func (o *ClusterResourcesGenerator) UpdateServiceGenerator(graphImageRef, releas
 
 	defer osusFile.Close()
 
-	_, err = osusFile.Write(osusBytes)
-	return fmt.Errorf("%w", err)
+	if _, err := osusFile.Write(osusAggregation); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// updateServiceName returns the name of the UpdateService of the releases of releaseRepository:
+// name suffixed with the last path component of the repository (i.e. update-service-oc-mirror-ocp-release),
+// and an index when it is already one of names
+func updateServiceName(name, releaseRepository string, names map[string]bool) string {
+	pathComponents := strings.Split(releaseRepository, "/")
+	suffix := strings.Trim(strings.Map(toRFC1035, strings.ToLower(pathComponents[len(pathComponents)-1])), "-")
+	osusName := name + "-" + suffix
+	for index := 1; names[osusName]; index++ {
+		osusName = name + "-" + suffix + "-" + strconv.Itoa(index)
+	}
+	return osusName
 }
 
 func attemptNamespaceScope(srcImgSpec, dstImgSpec image.ImageSpec) (string, string) {
@@ -796,7 +1049,12 @@ func (o *ClusterResourcesGenerator) GenerateSignatureConfigMap(allRelatedImages
 			if err != nil {
 				return fmt.Errorf(signatureConfigMapMsg, err)
 			}
-			if file, ok := signatureFiles[imgSpec.Tag]; ok {
+			// the signatures of the releases by digest are named after the digest (see signature.FileName)
+			signatureKey := imgSpec.Tag
+			if signatureKey == "" {
+				signatureKey = imgSpec.Digest
+			}
+			if file, ok := signatureFiles[signatureKey]; ok {
 				data, err := os.ReadFile(sigDir + "/" + file)
 				if err != nil {
 					o.Log.Warn("[GenerateSignatureConfigMap] release index image signature with tag %s : SKIPPED. %v", imgSpec.Tag, err)
@@ -0,0 +1,147 @@
+package clusterresources
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"helm.sh/helm/v3/pkg/chart"
+	kustomizetypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/yaml"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
+)
+
+const (
+	kustomizationFileName = "kustomization.yaml"
+	helmChartDir          = "chart"
+	helmChartName         = "oc-mirror-cluster-resources"
+	helmChartVersion      = "0.1.0"
+	helmTemplatesDir      = "templates"
+)
+
+// KustomizationGenerator generates the kustomization.yaml listing the cluster resources of the run, with the namespace,
+// labels and annotations of --kustomize-namespace, --kustomize-label and --kustomize-annotation, so that cluster-resources
+// can be committed as is to a GitOps repository. With --helm-chart, a helm chart wrapping them is generated in cluster-resources/chart.
+func (o *ClusterResourcesGenerator) KustomizationGenerator() error {
+	kustomization := kustomizetypes.Kustomization{
+		TypeMeta: kustomizetypes.TypeMeta{
+			APIVersion: kustomizetypes.KustomizationVersion,
+			Kind:       kustomizetypes.KustomizationKind,
+		},
+		Namespace:         o.Options.KustomizeNamespace,
+		CommonAnnotations: o.Options.KustomizeAnnotations,
+	}
+	if len(o.Options.KustomizeLabels) > 0 {
+		kustomization.Labels = []kustomizetypes.Label{{Pairs: o.Options.KustomizeLabels}}
+	}
+	created, err := o.writeKustomization(kustomization)
+	if err != nil || !created || !o.Options.HelmChart {
+		return err
+	}
+	return o.writeHelmChart()
+}
+
+// UpdateKustomization updates the resources of the kustomization.yaml and helm chart already generated in cluster-resources,
+// after the cluster resources were updated (i.e. by delete)
+func (o *ClusterResourcesGenerator) UpdateKustomization() error {
+	kustomizationPath := filepath.Join(o.Options.WorkingDir, clusterResourcesDir, kustomizationFileName)
+	data, err := os.ReadFile(kustomizationPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	kustomization := kustomizetypes.Kustomization{}
+	if err := yaml.Unmarshal(data, &kustomization); err != nil {
+		return fmt.Errorf("unable to read %s: %w", kustomizationPath, err)
+	}
+	created, err := o.writeKustomization(kustomization)
+	if err != nil || !created {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(o.Options.WorkingDir, clusterResourcesDir, helmChartDir)); err != nil {
+		return nil
+	}
+	return o.writeHelmChart()
+}
+
+// writeKustomization writes kustomization with the cluster resources found in cluster-resources as resources.
+// Without cluster resources, the kustomization is removed and false is returned.
+func (o *ClusterResourcesGenerator) writeKustomization(kustomization kustomizetypes.Kustomization) (bool, error) {
+	crPath := filepath.Join(o.Options.WorkingDir, clusterResourcesDir)
+	files, err := ClusterResourceFiles(crPath)
+	if err != nil {
+		return false, err
+	}
+	if len(files) == 0 {
+		o.Log.Info(emoji.PageFacingUp + " No cluster resources were generated. Skipping kustomization generation.")
+		if err := removeClusterResource(o.Options.WorkingDir, kustomizationFileName); err != nil {
+			return false, err
+		}
+		if err := os.RemoveAll(filepath.Join(crPath, helmChartDir)); err != nil {
+			return false, fmt.Errorf("%w", err)
+		}
+		return false, nil
+	}
+	o.Log.Info(emoji.PageFacingUp + " Generating kustomization file...")
+	kustomization.Resources = files
+	data, err := yaml.Marshal(kustomization)
+	if err != nil {
+		return false, fmt.Errorf("%w", err)
+	}
+	kustomizationPath := filepath.Join(crPath, kustomizationFileName)
+	// #nosec G306
+	if err := os.WriteFile(kustomizationPath, data, 0644); err != nil {
+		return false, fmt.Errorf("%w", err)
+	}
+	o.Log.Info("%s file created", kustomizationPath)
+	return true, nil
+}
+
+// writeHelmChart writes a minimal helm chart whose templates are the cluster resources, as is
+func (o *ClusterResourcesGenerator) writeHelmChart() error {
+	crPath := filepath.Join(o.Options.WorkingDir, clusterResourcesDir)
+	files, err := ClusterResourceFiles(crPath)
+	if err != nil {
+		return err
+	}
+	o.Log.Info(emoji.PageFacingUp + " Generating helm chart...")
+	chartPath := filepath.Join(crPath, helmChartDir)
+	// the templates of the resources removed since the last generation are removed too
+	if err := os.RemoveAll(filepath.Join(chartPath, helmTemplatesDir)); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(chartPath, helmTemplatesDir), 0755); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	metadata := chart.Metadata{
+		APIVersion:  chart.APIVersionV2,
+		Name:        helmChartName,
+		Description: "Cluster resources generated by oc-mirror for the mirrored images",
+		Type:        "application",
+		Version:     helmChartVersion,
+	}
+	data, err := yaml.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	// #nosec G306
+	if err := os.WriteFile(filepath.Join(chartPath, "Chart.yaml"), data, 0644); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(crPath, file))
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		// #nosec G306
+		if err := os.WriteFile(filepath.Join(chartPath, helmTemplatesDir, file), data, 0644); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+	o.Log.Info("%s helm chart created", chartPath)
+	return nil
+}
@@ -0,0 +1,78 @@
+package clusterresources
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	kustomizetypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/yaml"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestKustomizationGenerator(t *testing.T) {
+	workingDir := t.TempDir()
+	crPath := filepath.Join(workingDir, clusterResourcesDir)
+	require.NoError(t, os.MkdirAll(crPath, 0755))
+	for file, content := range map[string]string{
+		idmsFileName:                                     testIDMS,
+		mirrorSetFileName(idmsFileName, true):            testIDMS,
+		"cs-redhat-operator-index-v4-18.yaml":            testCatalogSource,
+		"signature-configmap.json":                       "{}",
+		mirrorSetFileName(registriesConfFileName, false): "",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(crPath, file), []byte(content), 0644))
+	}
+	opts := &common.MirrorOptions{
+		WorkingDir:           workingDir,
+		KustomizeNamespace:   "mirror",
+		KustomizeLabels:      map[string]string{"app.kubernetes.io/managed-by": "oc-mirror"},
+		KustomizeAnnotations: map[string]string{"argocd.argoproj.io/sync-wave": "-1"},
+		HelmChart:            true,
+	}
+	generator := New(clog.New("error"), v2alpha1.ImageSetConfiguration{}, opts)
+	require.NoError(t, generator.KustomizationGenerator())
+
+	expectedResources := []string{"cs-redhat-operator-index-v4-18.yaml", "idms-oc-mirror-cumulative.yaml"}
+	kustomization := readTestKustomization(t, crPath)
+	require.Equal(t, kustomizetypes.KustomizationKind, kustomization.Kind)
+	require.Equal(t, expectedResources, kustomization.Resources)
+	require.Equal(t, "mirror", kustomization.Namespace)
+	require.Equal(t, opts.KustomizeLabels, kustomization.Labels[0].Pairs)
+	require.Equal(t, opts.KustomizeAnnotations, kustomization.CommonAnnotations)
+	templates, err := os.ReadDir(filepath.Join(crPath, helmChartDir, helmTemplatesDir))
+	require.NoError(t, err)
+	require.Len(t, templates, len(expectedResources))
+	require.FileExists(t, filepath.Join(crPath, helmChartDir, "Chart.yaml"))
+
+	// the update (i.e. after delete) keeps the overlays, without the options of the run
+	require.NoError(t, os.Remove(filepath.Join(crPath, "cs-redhat-operator-index-v4-18.yaml")))
+	generator = New(clog.New("error"), v2alpha1.ImageSetConfiguration{}, &common.MirrorOptions{WorkingDir: workingDir})
+	require.NoError(t, generator.UpdateKustomization())
+	kustomization = readTestKustomization(t, crPath)
+	require.Equal(t, []string{"idms-oc-mirror-cumulative.yaml"}, kustomization.Resources)
+	require.Equal(t, "mirror", kustomization.Namespace)
+	require.Equal(t, opts.KustomizeLabels, kustomization.Labels[0].Pairs)
+	templates, err = os.ReadDir(filepath.Join(crPath, helmChartDir, helmTemplatesDir))
+	require.NoError(t, err)
+	require.Len(t, templates, 1)
+
+	// without cluster resources left, the kustomization and the chart are removed
+	require.NoError(t, os.Remove(filepath.Join(crPath, "idms-oc-mirror-cumulative.yaml")))
+	require.NoError(t, os.Remove(filepath.Join(crPath, idmsFileName)))
+	require.NoError(t, generator.UpdateKustomization())
+	require.NoFileExists(t, filepath.Join(crPath, kustomizationFileName))
+	require.NoDirExists(t, filepath.Join(crPath, helmChartDir))
+}
+
+func readTestKustomization(t *testing.T, crPath string) kustomizetypes.Kustomization {
+	data, err := os.ReadFile(filepath.Join(crPath, kustomizationFileName))
+	require.NoError(t, err)
+	kustomization := kustomizetypes.Kustomization{}
+	require.NoError(t, yaml.Unmarshal(data, &kustomization))
+	return kustomization
+}
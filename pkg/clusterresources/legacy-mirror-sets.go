@@ -0,0 +1,118 @@
+package clusterresources
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	operatorv1alpha1 "github.com/openshift/api/operator/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
+)
+
+const (
+	pullFromMirrorDigestOnly = "digest-only"
+	pullFromMirrorTagOnly    = "tag-only"
+)
+
+// writeICSP writes the ImageContentSourcePolicy of the mirrors by digest, for the clusters older than 4.13
+// (without IDMS). ICSP has no equivalent of ITMS: the mirrors by tag are not part of it.
+func (o *ClusterResourcesGenerator) writeICSP(byDigestMirrors, byTagMirrors []categorizedMirrors, cumulative bool) error {
+	icspFile := mirrorSetFileName(icspFileName, cumulative)
+	if len(byDigestMirrors) == 0 {
+		o.Log.Info(emoji.PageFacingUp + " No images by digests were mirrored. Skipping ICSP generation.")
+		return removeClusterResource(o.Options.WorkingDir, icspFile)
+	}
+	if len(byTagMirrors) > 0 {
+		o.Log.Warn("ICSP only applies to images pulled by digest: the mirrors of the images by tag are only part of the ITMS and registries.conf")
+	}
+	o.Log.Info(emoji.PageFacingUp+" Generating %s file...", mirrorSetDescription("ICSP", cumulative))
+	icspList := make([]operatorv1alpha1.ImageContentSourcePolicy, len(byDigestMirrors))
+	for index, catMirrors := range byDigestMirrors {
+		icspList[index] = operatorv1alpha1.ImageContentSourcePolicy{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: operatorv1alpha1.GroupVersion.String(),
+				Kind:       "ImageContentSourcePolicy",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "icsp-" + catMirrors.category.toString() + "-0",
+			},
+			Spec: operatorv1alpha1.ImageContentSourcePolicySpec{
+				RepositoryDigestMirrors: []operatorv1alpha1.RepositoryDigestMirrors{},
+			},
+		}
+		for _, source := range catMirrors.sources() {
+			rdm := operatorv1alpha1.RepositoryDigestMirrors{
+				Source: source,
+			}
+			for _, m := range catMirrors.mirrors[source] {
+				rdm.Mirrors = append(rdm.Mirrors, string(m))
+			}
+			icspList[index].Spec.RepositoryDigestMirrors = append(icspList[index].Spec.RepositoryDigestMirrors, rdm)
+		}
+	}
+	return writeMirrorSet(icspList, o.Options.WorkingDir, icspFile, o.Log)
+}
+
+// writeRegistriesConf writes the mirrors as a containers-registries.conf(5) drop-in (v2 format), for podman
+// and CRI-O outside of OpenShift: the mirrors by digest are digest-only, the mirrors by tag are tag-only,
+// like the machine config operator renders IDMS and ITMS
+func (o *ClusterResourcesGenerator) writeRegistriesConf(byDigestMirrors, byTagMirrors []categorizedMirrors, cumulative bool) error {
+	confFile := mirrorSetFileName(registriesConfFileName, cumulative)
+	if len(byDigestMirrors) == 0 && len(byTagMirrors) == 0 {
+		o.Log.Info(emoji.PageFacingUp + " No images were mirrored. Skipping registries.conf generation.")
+		return removeClusterResource(o.Options.WorkingDir, confFile)
+	}
+	o.Log.Info(emoji.PageFacingUp+" Generating %s file...", mirrorSetDescription("registries.conf", cumulative))
+
+	// mirrors of each source, with their pull-from-mirror
+	type registryMirror struct {
+		location       string
+		pullFromMirror string
+	}
+	registries := map[string][]registryMirror{}
+	for pullFromMirror, mirrorsByCategory := range map[string][]categorizedMirrors{pullFromMirrorDigestOnly: byDigestMirrors, pullFromMirrorTagOnly: byTagMirrors} {
+		for _, catMirrors := range mirrorsByCategory {
+			for source, imgMirrors := range catMirrors.mirrors {
+				for _, m := range imgMirrors {
+					mirror := registryMirror{location: string(m), pullFromMirror: pullFromMirror}
+					if !slices.Contains(registries[source], mirror) {
+						registries[source] = append(registries[source], mirror)
+					}
+				}
+			}
+		}
+	}
+	sources := make([]string, 0, len(registries))
+	for source := range registries {
+		sources = append(sources, source)
+	}
+	slices.Sort(sources)
+
+	conf := strings.Builder{}
+	conf.WriteString("# generated by oc-mirror: drop in /etc/containers/registries.conf.d/\n")
+	for _, source := range sources {
+		mirrors := registries[source]
+		slices.SortStableFunc(mirrors, func(a, b registryMirror) int {
+			return strings.Compare(a.pullFromMirror+a.location, b.pullFromMirror+b.location)
+		})
+		fmt.Fprintf(&conf, "\n[[registry]]\nlocation = %s\n", strconv.Quote(source))
+		for _, mirror := range mirrors {
+			fmt.Fprintf(&conf, "\n[[registry.mirror]]\nlocation = %s\npull-from-mirror = %s\n", strconv.Quote(mirror.location), strconv.Quote(mirror.pullFromMirror))
+		}
+	}
+
+	confPath := filepath.Join(o.Options.WorkingDir, clusterResourcesDir, confFile)
+	if err := os.MkdirAll(filepath.Dir(confPath), 0755); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if err := os.WriteFile(confPath, []byte(conf.String()), 0644); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	o.Log.Info("%s file created", confPath)
+	return nil
+}
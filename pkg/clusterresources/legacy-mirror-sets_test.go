@@ -0,0 +1,154 @@
+package clusterresources
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestLegacyMirrorSets(t *testing.T) {
+	type testCase struct {
+		caseName               string
+		repositoryScope        bool
+		expectedICSP           string
+		expectedRegistriesConf string
+	}
+	testCases := []testCase{
+		{
+			caseName: "namespace scope",
+			expectedICSP: `---
+apiVersion: operator.openshift.io/v1alpha1
+kind: ImageContentSourcePolicy
+metadata:
+  name: icsp-generic-0
+spec:
+  repositoryDigestMirrors:
+  - mirrors:
+    - registry.enclave/mirror/ns
+    source: quay.io/ns
+---
+apiVersion: operator.openshift.io/v1alpha1
+kind: ImageContentSourcePolicy
+metadata:
+  name: icsp-operator-0
+spec:
+  repositoryDigestMirrors:
+  - mirrors:
+    - registry.enclave/mirror/ubi9
+    source: registry.redhat.io/ubi9
+`,
+			// the namespace is mirrored both by digest (app, other) and by tag (tool)
+			expectedRegistriesConf: `# generated by oc-mirror: drop in /etc/containers/registries.conf.d/
+
+[[registry]]
+location = "quay.io/ns"
+
+[[registry.mirror]]
+location = "registry.enclave/mirror/ns"
+pull-from-mirror = "digest-only"
+
+[[registry.mirror]]
+location = "registry.enclave/mirror/ns"
+pull-from-mirror = "tag-only"
+
+[[registry]]
+location = "registry.redhat.io/ubi9"
+
+[[registry.mirror]]
+location = "registry.enclave/mirror/ubi9"
+pull-from-mirror = "digest-only"
+`,
+		},
+		{
+			caseName:        "repository scope",
+			repositoryScope: true,
+			expectedICSP: `---
+apiVersion: operator.openshift.io/v1alpha1
+kind: ImageContentSourcePolicy
+metadata:
+  name: icsp-generic-0
+spec:
+  repositoryDigestMirrors:
+  - mirrors:
+    - registry.enclave/mirror/ns/app
+    source: quay.io/ns/app
+  - mirrors:
+    - registry.enclave/mirror/ns/other
+    source: quay.io/ns/other
+---
+apiVersion: operator.openshift.io/v1alpha1
+kind: ImageContentSourcePolicy
+metadata:
+  name: icsp-operator-0
+spec:
+  repositoryDigestMirrors:
+  - mirrors:
+    - registry.enclave/mirror/ubi9/ubi
+    source: registry.redhat.io/ubi9/ubi
+`,
+			expectedRegistriesConf: `# generated by oc-mirror: drop in /etc/containers/registries.conf.d/
+
+[[registry]]
+location = "quay.io/ns/app"
+
+[[registry.mirror]]
+location = "registry.enclave/mirror/ns/app"
+pull-from-mirror = "digest-only"
+
+[[registry]]
+location = "quay.io/ns/other"
+
+[[registry.mirror]]
+location = "registry.enclave/mirror/ns/other"
+pull-from-mirror = "digest-only"
+
+[[registry]]
+location = "quay.io/ns/tool"
+
+[[registry.mirror]]
+location = "registry.enclave/mirror/ns/tool"
+pull-from-mirror = "tag-only"
+
+[[registry]]
+location = "registry.redhat.io/ubi9/ubi"
+
+[[registry.mirror]]
+location = "registry.enclave/mirror/ubi9/ubi"
+pull-from-mirror = "digest-only"
+`,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			state := mirrorSetState{}
+			require.NoError(t, state.add([]v2alpha1.CopyImageSchema{testTool, testRelated, testOther, testCatalog, testApp}, testCase.repositoryScope))
+			byDigestMirrors, byTagMirrors := categorize(state.ImageDigestMirrors), categorize(state.ImageTagMirrors)
+			workingDir := t.TempDir()
+			generator := New(clog.New("error"), v2alpha1.ImageSetConfiguration{}, &common.MirrorOptions{WorkingDir: workingDir})
+			// the output doesn't depend on the order of the maps of the mirrors
+			for i := 0; i < 5; i++ {
+				require.NoError(t, generator.writeICSP(byDigestMirrors, byTagMirrors, false))
+				require.NoError(t, generator.writeRegistriesConf(byDigestMirrors, byTagMirrors, false))
+
+				icsp, err := os.ReadFile(filepath.Join(workingDir, clusterResourcesDir, icspFileName))
+				require.NoError(t, err)
+				require.Equal(t, testCase.expectedICSP, string(icsp))
+				registriesConf, err := os.ReadFile(filepath.Join(workingDir, clusterResourcesDir, registriesConfFileName))
+				require.NoError(t, err)
+				require.Equal(t, testCase.expectedRegistriesConf, string(registriesConf))
+			}
+
+			// nothing mirrored anymore: the files of a previous run are removed
+			require.NoError(t, generator.writeICSP(nil, nil, false))
+			require.NoError(t, generator.writeRegistriesConf(nil, nil, false))
+			require.NoFileExists(t, filepath.Join(workingDir, clusterResourcesDir, icspFileName))
+			require.NoFileExists(t, filepath.Join(workingDir, clusterResourcesDir, registriesConfFileName))
+		})
+	}
+}
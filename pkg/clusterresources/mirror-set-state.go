@@ -0,0 +1,172 @@
+package clusterresources
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	confv1 "github.com/openshift/api/config/v1"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+)
+
+// mirrorSetStateFile is the cumulative state of the mirror sets of a workspace. It sits in the working dir,
+// outside of cluster-resources which is recreated by every run.
+const mirrorSetStateFile = ".mirror-sets.json"
+
+// mirrorSetState holds the mirrors of all the images mirrored from a workspace:
+// the runs add to it, only the delete flow removes from it
+type mirrorSetState struct {
+	ImageDigestMirrors []mirrorSetEntry `json:"imageDigestMirrors,omitempty"`
+	ImageTagMirrors    []mirrorSetEntry `json:"imageTagMirrors,omitempty"`
+}
+
+// mirrorSetEntry is a mirror of a source, with the images it is generated for
+type mirrorSetEntry struct {
+	Category string `json:"category"`
+	Source   string `json:"source"`
+	Mirror   string `json:"mirror"`
+	// Images are the destinations of the mirrored images needing the mirror
+	Images []string `json:"images"`
+}
+
+func readMirrorSetState(workingDir string) (mirrorSetState, error) {
+	state := mirrorSetState{}
+	content, err := os.ReadFile(filepath.Join(workingDir, mirrorSetStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("unable to read the cumulative IDMS/ITMS state: %w", err)
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return state, fmt.Errorf("unable to read the cumulative IDMS/ITMS state: %w", err)
+	}
+	return state, nil
+}
+
+func (s mirrorSetState) write(workingDir string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if err := os.WriteFile(filepath.Join(workingDir, mirrorSetStateFile), content, 0644); err != nil {
+		return fmt.Errorf("unable to write the cumulative IDMS/ITMS state: %w", err)
+	}
+	return nil
+}
+
+// add records the mirrors of allRelatedImages
+func (s *mirrorSetState) add(allRelatedImages []v2alpha1.CopyImageSchema, forceRepositoryScope bool) error {
+	var err error
+	s.ImageDigestMirrors, err = addMirrorSetEntries(s.ImageDigestMirrors, allRelatedImages, DigestsOnlyMode, forceRepositoryScope)
+	if err != nil {
+		return err
+	}
+	s.ImageTagMirrors, err = addMirrorSetEntries(s.ImageTagMirrors, allRelatedImages, TagsOnlyMode, forceRepositoryScope)
+	return err
+}
+
+// remove drops deletedImages, and the mirrors no other image needs
+func (s *mirrorSetState) remove(deletedImages []v2alpha1.CopyImageSchema) {
+	deleted := map[string]bool{}
+	for _, img := range deletedImages {
+		deleted[stateImage(img)] = true
+	}
+	s.ImageDigestMirrors = removeMirrorSetEntries(s.ImageDigestMirrors, deleted)
+	s.ImageTagMirrors = removeMirrorSetEntries(s.ImageTagMirrors, deleted)
+}
+
+func addMirrorSetEntries(entries []mirrorSetEntry, allRelatedImages []v2alpha1.CopyImageSchema, mode imageMirrorsGeneratorMode, forceRepositoryScope bool) ([]mirrorSetEntry, error) {
+	byKey := map[string]int{}
+	for i, entry := range entries {
+		byKey[entry.key()] = i
+	}
+	for _, relatedImage := range allRelatedImages {
+		if relatedImage.Origin == "" {
+			return nil, fmt.Errorf("unable to generate IDMS/ITMS: original reference for (%s,%s) undetermined", relatedImage.Source, relatedImage.Destination)
+		}
+		source, mirror, toBeAdded, err := imageMirror(relatedImage, mode, forceRepositoryScope)
+		if err != nil {
+			return nil, err
+		}
+		if !toBeAdded {
+			continue
+		}
+		entry := mirrorSetEntry{Category: imageTypeToCategory(relatedImage.Type).toString(), Source: source, Mirror: mirror}
+		i, ok := byKey[entry.key()]
+		if !ok {
+			i = len(entries)
+			byKey[entry.key()] = i
+			entries = append(entries, entry)
+		}
+		if img := stateImage(relatedImage); !slices.Contains(entries[i].Images, img) {
+			entries[i].Images = append(entries[i].Images, img)
+		}
+	}
+	for i := range entries {
+		slices.Sort(entries[i].Images)
+	}
+	// keep the state stable across runs: the mirror sets generated from it are sorted by source
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key() < entries[j].key()
+	})
+	return entries, nil
+}
+
+func removeMirrorSetEntries(entries []mirrorSetEntry, deleted map[string]bool) []mirrorSetEntry {
+	kept := []mirrorSetEntry{}
+	for _, entry := range entries {
+		entry.Images = slices.DeleteFunc(entry.Images, func(img string) bool {
+			return deleted[img]
+		})
+		if len(entry.Images) > 0 {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+func (e mirrorSetEntry) key() string {
+	return e.Category + " " + e.Source + " " + e.Mirror
+}
+
+// stateImage identifies a mirrored image in the state: its destination, which is also the reference
+// the delete flow works on
+func stateImage(img v2alpha1.CopyImageSchema) string {
+	return strings.TrimPrefix(img.Destination, dockerProtocol)
+}
+
+// categorize groups entries by category, as expected by generateIDMS and generateITMS
+func categorize(entries []mirrorSetEntry) []categorizedMirrors {
+	byCategory := map[string]int{}
+	mirrorsByCategory := []categorizedMirrors{}
+	for _, entry := range entries {
+		i, ok := byCategory[entry.Category]
+		if !ok {
+			i = len(mirrorsByCategory)
+			byCategory[entry.Category] = i
+			mirrorsByCategory = append(mirrorsByCategory, categorizedMirrors{
+				category: mirrorCategoryOf(entry.Category),
+				mirrors:  make(map[string][]confv1.ImageMirror),
+			})
+		}
+		mirrors := mirrorsByCategory[i].mirrors
+		mirrors[entry.Source] = append(mirrors[entry.Source], confv1.ImageMirror(entry.Mirror))
+	}
+	return mirrorsByCategory
+}
+
+func mirrorCategoryOf(name string) mirrorCategory {
+	for _, category := range []mirrorCategory{releaseCategory, operatorCategory} {
+		if category.toString() == name {
+			return category
+		}
+	}
+	return genericCategory
+}
@@ -0,0 +1,106 @@
+package clusterresources
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	confv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+const (
+	testDigestA = "sha256:0f2dbb8a41f0c8c8b5b4aa0c0a0f2a3e8c3d4b6c1d9e2f7a8b9c0d1e2f3a4b5c"
+	testDigestB = "sha256:1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f809"
+	testDigestC = "sha256:2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091a"
+)
+
+var (
+	testApp     = v2alpha1.CopyImageSchema{Origin: "docker://quay.io/ns/app@" + testDigestA, Destination: "docker://registry.enclave/mirror/ns/app@" + testDigestA, Type: v2alpha1.TypeGeneric}
+	testOther   = v2alpha1.CopyImageSchema{Origin: "docker://quay.io/ns/other@" + testDigestB, Destination: "docker://registry.enclave/mirror/ns/other@" + testDigestB, Type: v2alpha1.TypeGeneric}
+	testTool    = v2alpha1.CopyImageSchema{Origin: "docker://quay.io/ns/tool:v1", Destination: "docker://registry.enclave/mirror/ns/tool:v1", Type: v2alpha1.TypeGeneric}
+	testRelated = v2alpha1.CopyImageSchema{Origin: "docker://registry.redhat.io/ubi9/ubi@" + testDigestC, Destination: "docker://registry.enclave/mirror/ubi9/ubi@" + testDigestC, Type: v2alpha1.TypeOperatorRelatedImage}
+	testCatalog = v2alpha1.CopyImageSchema{Origin: "docker://registry.redhat.io/redhat/redhat-operator-index:v4.18", Destination: "docker://registry.enclave/mirror/redhat/redhat-operator-index:v4.18", Type: v2alpha1.TypeOperatorCatalog}
+)
+
+func TestMirrorSetState(t *testing.T) {
+	state := mirrorSetState{}
+	require.NoError(t, state.add([]v2alpha1.CopyImageSchema{testRelated, testApp, testTool, testCatalog}, false))
+	// a later run adds to the state, the images already recorded are not duplicated
+	require.NoError(t, state.add([]v2alpha1.CopyImageSchema{testOther, testApp}, false))
+	require.Equal(t, mirrorSetState{
+		ImageDigestMirrors: []mirrorSetEntry{
+			{Category: "generic", Source: "quay.io/ns", Mirror: "registry.enclave/mirror/ns", Images: []string{
+				"registry.enclave/mirror/ns/app@" + testDigestA,
+				"registry.enclave/mirror/ns/other@" + testDigestB,
+			}},
+			{Category: "operator", Source: "registry.redhat.io/ubi9", Mirror: "registry.enclave/mirror/ubi9", Images: []string{"registry.enclave/mirror/ubi9/ubi@" + testDigestC}},
+		},
+		ImageTagMirrors: []mirrorSetEntry{
+			{Category: "generic", Source: "quay.io/ns", Mirror: "registry.enclave/mirror/ns", Images: []string{"registry.enclave/mirror/ns/tool:v1"}},
+		},
+	}, state)
+
+	require.Equal(t, []categorizedMirrors{
+		{category: genericCategory, mirrors: map[string][]confv1.ImageMirror{"quay.io/ns": {"registry.enclave/mirror/ns"}}},
+		{category: operatorCategory, mirrors: map[string][]confv1.ImageMirror{"registry.redhat.io/ubi9": {"registry.enclave/mirror/ubi9"}}},
+	}, categorize(state.ImageDigestMirrors))
+
+	// the mirror is kept as long as an image needs it
+	state.remove([]v2alpha1.CopyImageSchema{testApp, testRelated})
+	require.Equal(t, []mirrorSetEntry{
+		{Category: "generic", Source: "quay.io/ns", Mirror: "registry.enclave/mirror/ns", Images: []string{"registry.enclave/mirror/ns/other@" + testDigestB}},
+	}, state.ImageDigestMirrors)
+	state.remove([]v2alpha1.CopyImageSchema{testOther, testTool})
+	require.Empty(t, state.ImageDigestMirrors)
+	require.Empty(t, state.ImageTagMirrors)
+}
+
+func TestMirrorSetStateRepositoryScope(t *testing.T) {
+	state := mirrorSetState{}
+	require.NoError(t, state.add([]v2alpha1.CopyImageSchema{testApp, testOther}, true))
+	require.Equal(t, []mirrorSetEntry{
+		{Category: "generic", Source: "quay.io/ns/app", Mirror: "registry.enclave/mirror/ns/app", Images: []string{"registry.enclave/mirror/ns/app@" + testDigestA}},
+		{Category: "generic", Source: "quay.io/ns/other", Mirror: "registry.enclave/mirror/ns/other", Images: []string{"registry.enclave/mirror/ns/other@" + testDigestB}},
+	}, state.ImageDigestMirrors)
+
+	// the mirror sets are sorted by source, whatever the order of the map of the mirrors
+	generator := New(clog.New("error"), v2alpha1.ImageSetConfiguration{}, &common.MirrorOptions{})
+	for i := 0; i < 10; i++ {
+		idmsList, err := generator.generateIDMS(categorize(state.ImageDigestMirrors))
+		require.NoError(t, err)
+		require.Len(t, idmsList, 1)
+		require.Equal(t, "quay.io/ns/app", idmsList[0].Spec.ImageDigestMirrors[0].Source)
+		require.Equal(t, "quay.io/ns/other", idmsList[0].Spec.ImageDigestMirrors[1].Source)
+	}
+}
+
+func TestRemoveFromCumulativeMirrorSets(t *testing.T) {
+	workingDir := t.TempDir()
+	generator := New(clog.New("error"), v2alpha1.ImageSetConfiguration{}, &common.MirrorOptions{WorkingDir: workingDir})
+	require.NoError(t, generator.MirrorSetsGenerator([]v2alpha1.CopyImageSchema{testApp, testRelated}, false))
+	require.NoError(t, generator.MirrorSetsGenerator([]v2alpha1.CopyImageSchema{testOther, testTool}, false))
+
+	require.NoError(t, generator.RemoveFromCumulativeMirrorSets([]v2alpha1.CopyImageSchema{testApp, testOther, testTool}))
+	idms := confv1.ImageDigestMirrorSet{}
+	data, err := os.ReadFile(filepath.Join(workingDir, clusterResourcesDir, mirrorSetFileName(idmsFileName, true)))
+	require.NoError(t, err)
+	require.NoError(t, yaml.Unmarshal(data, &idms))
+	require.Equal(t, "idms-operator-0", idms.Name)
+	require.Equal(t, []confv1.ImageDigestMirrors{{Source: "registry.redhat.io/ubi9", Mirrors: []confv1.ImageMirror{"registry.enclave/mirror/ubi9"}}}, idms.Spec.ImageDigestMirrors)
+	// no image by tag left
+	require.NoFileExists(t, filepath.Join(workingDir, clusterResourcesDir, mirrorSetFileName(itmsFileName, true)))
+	// the mirror sets of the last run are left as is
+	require.FileExists(t, filepath.Join(workingDir, clusterResourcesDir, itmsFileName))
+
+	// without the state of the workspace the images were mirrored from, nothing is written
+	otherWorkingDir := t.TempDir()
+	generator = New(clog.New("error"), v2alpha1.ImageSetConfiguration{}, &common.MirrorOptions{WorkingDir: otherWorkingDir})
+	require.NoError(t, generator.RemoveFromCumulativeMirrorSets([]v2alpha1.CopyImageSchema{testApp}))
+	require.NoFileExists(t, filepath.Join(otherWorkingDir, mirrorSetStateFile))
+}
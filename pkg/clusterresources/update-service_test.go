@@ -0,0 +1,86 @@
+package clusterresources
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestUpdateServiceGenerator(t *testing.T) {
+	type expectedUpdateService struct {
+		name      string
+		namespace string
+		replicas  int64
+		releases  string
+	}
+	type testCase struct {
+		caseName      string
+		config        v2alpha1.UpdateService
+		images        []v2alpha1.CopyImageSchema
+		expected      []expectedUpdateService
+		expectedError string
+	}
+	ocpRelease := v2alpha1.CopyImageSchema{Destination: "docker://registry.enclave/openshift/release-images:4.18.1-x86_64", Type: v2alpha1.TypeOCPRelease}
+	okdRelease := v2alpha1.CopyImageSchema{Destination: "docker://registry.enclave/okd/scos-release:4.18.0-okd-scos.1", Type: v2alpha1.TypeOCPRelease}
+	testCases := []testCase{
+		{
+			caseName: "single release repository, with the defaults",
+			images: []v2alpha1.CopyImageSchema{
+				ocpRelease,
+				{Destination: "docker://registry.enclave/openshift/release-images:4.18.2-x86_64", Type: v2alpha1.TypeOCPRelease},
+				{Destination: "docker://registry.enclave/openshift/release:4.18.1-x86_64-etcd", Type: v2alpha1.TypeOCPReleaseContent},
+				// copied to the local cache during mirror to mirror
+				{Destination: "docker://localhost:55000/openshift/release-images:4.18.1-x86_64", Type: v2alpha1.TypeOCPRelease},
+			},
+			expected: []expectedUpdateService{{name: updateServiceResourceName, replicas: 2, releases: "registry.enclave/openshift/release-images"}},
+		},
+		{
+			caseName: "OCP and OKD releases, configured",
+			config:   v2alpha1.UpdateService{Name: "osus", Namespace: "openshift-update-service", Replicas: 1},
+			images:   []v2alpha1.CopyImageSchema{ocpRelease, okdRelease},
+			expected: []expectedUpdateService{
+				{name: "osus-scos-release", namespace: "openshift-update-service", replicas: 1, releases: "registry.enclave/okd/scos-release"},
+				{name: "osus-release-images", namespace: "openshift-update-service", replicas: 1, releases: "registry.enclave/openshift/release-images"},
+			},
+		},
+		{
+			caseName:      "no release",
+			images:        []v2alpha1.CopyImageSchema{{Destination: "docker://registry.enclave/ubi9/ubi:latest", Type: v2alpha1.TypeGeneric}},
+			expectedError: "no release image found",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			workingDir := t.TempDir()
+			cfg := v2alpha1.ImageSetConfiguration{}
+			cfg.Mirror.Platform.UpdateService = testCase.config
+			generator := New(clog.New("error"), cfg, &common.MirrorOptions{WorkingDir: workingDir, LocalStorageFQDN: "localhost:55000"})
+			err := generator.UpdateServiceGenerator("docker://registry.enclave/openshift/graph-image:latest", testCase.images)
+			if testCase.expectedError != "" {
+				require.EqualError(t, err, testCase.expectedError)
+				return
+			}
+			require.NoError(t, err)
+
+			objects, err := readObjects(filepath.Join(workingDir, clusterResourcesDir, updateServiceFilename))
+			require.NoError(t, err)
+			updateServices := []expectedUpdateService{}
+			for _, obj := range objects {
+				spec := obj.Object["spec"].(map[string]interface{})
+				require.Equal(t, "registry.enclave/openshift/graph-image:latest", spec["graphDataImage"])
+				updateServices = append(updateServices, expectedUpdateService{
+					name:      obj.GetName(),
+					namespace: obj.GetNamespace(),
+					replicas:  spec["replicas"].(int64),
+					releases:  spec["releases"].(string),
+				})
+			}
+			require.Equal(t, testCase.expected, updateServices)
+		})
+	}
+}
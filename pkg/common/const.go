@@ -9,4 +9,6 @@ const (
 	mirrorToMirror string = "mirror-to-mirror"
 	deleteFunction string = "delete"
 	mirrorFunction string = "copy"
+	// LocalStorageCAFile is the CA trusted to reach the local storage registry over TLS, in LocalStorageCertDir
+	LocalStorageCAFile string = "ca.crt"
 )
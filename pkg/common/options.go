@@ -92,6 +92,37 @@ type MirrorOptions struct {
 	DeleteGenerate               bool
 	DeleteYaml                   string
 	DeleteV1                     bool
+	DeleteKeepConfig             string   // image set configuration of the content kept in the registry, whose manifests are not deleted
+	ArchiveStream                string   // "-" for stdout/stdin, or the path to a named pipe or device used as a stream archive
+	ArchiveRecipients            []string // age recipients or OpenPGP public key files the archive is encrypted to
+	ArchiveIdentities            []string // age identity or OpenPGP private key files used to decrypt the archive
+	OutputFormat                 string   // output format of the reporting commands (text, json)
+	KeepArchiveGenerations       int      // number of archive generations kept in the mirror-to-disk destination, 0 to keep all
+	BaselineInventory            string   // blob inventory of the disconnected side, used instead of the history metadata to build the archive
+	HistoryImageFilter           string   // only list the history records including an image matching this filter
+	HistoryKeep                  int      // number of history files kept as is, older ones are compacted into a baseline
+	HistoryKeepSinceString       string   // history files dated before this date (yyyy-MM-dd) are compacted into a baseline
+	HistoryKeepSince             time.Time
+	RegistryTLSCert              string            // certificate served by the local storage registry, to enable TLS
+	RegistryTLSKey               string            // key of RegistryTLSCert
+	RegistryHtpasswd             string            // htpasswd file used to authenticate the clients of the local storage registry
+	RegistryReadOnly             bool              // serve the local storage registry in read-only mode
+	LocalStorageTLS              bool              // serve the local storage registry over TLS, with an ephemeral CA generated for the run
+	LocalStorageCertDir          string            // directory holding the CA (ca.crt) trusted to reach the local storage registry over TLS
+	CacheDriver                  string            // storage driver of the cache: filesystem (default, under CacheDir), inmemory or s3
+	CacheDriverParams            map[string]string // parameters of the cache storage driver (bucket, region, regionendpoint...)
+	SharedLocalStorage           bool              // reuse the local storage registry of another run using the same cache, or share the one of this run
+	LocalStorageReused           bool              // the local storage registry is served by another run (see SharedLocalStorage)
+	CacheExportImages            string            // image set configuration or list of images exported by cache export
+	MirrorSetFormats             []string          // formats of the mirror configuration generated in cluster-resources (idms-itms, icsp, registries-conf)
+	ApplyClusterResources        bool              // server-side apply the generated cluster resources to the cluster of Kubeconfig
+	Kubeconfig                   string            // kubeconfig of the cluster the cluster resources are applied to ($KUBECONFIG, then $HOME/.kube/config when empty)
+	ApplyDiffOnly                bool              // only report the differences between the cluster resources and the cluster, without applying them
+	KustomizeNamespace           string            // namespace set by the kustomization.yaml generated in cluster-resources
+	KustomizeLabels              map[string]string // labels added by the kustomization.yaml generated in cluster-resources
+	KustomizeAnnotations         map[string]string // annotations added by the kustomization.yaml generated in cluster-resources
+	HelmChart                    bool              // generate a helm chart wrapping the cluster resources in cluster-resources/chart
+	SigstoreArtifacts            bool              // mirror the cosign signatures, attestations and SBOMs attached to the images along with them
 }
 
 const defaultUserAgent string = "oc-mirror"
@@ -154,6 +185,17 @@ func (opts MirrorOptions) NewSystemContext() *types.SystemContext {
 	return ctx
 }
 
+// SetLocalStorageTLS updates ctx to reach the local storage registry: over TLS, trusting only
+// the ephemeral CA of the run, when LocalStorageCertDir is set, without TLS verification otherwise
+func (opts MirrorOptions) SetLocalStorageTLS(ctx *types.SystemContext) {
+	if opts.LocalStorageCertDir != "" {
+		ctx.DockerCertPath = opts.LocalStorageCertDir
+		ctx.DockerInsecureSkipTLSVerify = types.OptionalBoolFalse
+		return
+	}
+	ctx.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+}
+
 // nolint: unused
 func parseCreds(creds string) (string, string, error) {
 	if creds == "" {
@@ -197,6 +239,10 @@ func (o MirrorOptions) IsDiskToMirror() bool {
 	return o.Mode == diskToMirror
 }
 
+func (o MirrorOptions) IsArchiveStream() bool {
+	return o.ArchiveStream != ""
+}
+
 func (o MirrorOptions) IsDelete() bool {
 	return o.Function == deleteFunction
 }
@@ -8,8 +8,10 @@ import (
 	"path/filepath"
 	"strings"
 
-	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	digest "github.com/opencontainers/go-digest"
 	"sigs.k8s.io/yaml"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
 )
 
 type ConfigInterface interface {
@@ -53,6 +55,20 @@ func (o Config) Read(configPath string, kind string) (interface{}, error) {
 	return nil, fmt.Errorf("could not parse imagesetconfiguration ")
 }
 
+// Digest returns the digest of the image set configuration of configPath, as parsed:
+// reformatting the file, reordering its keys or editing its comments doesn't change it
+func Digest(configPath string) (string, error) {
+	cfg, err := Config{}.Read(configPath, v2alpha1.ImageSetConfigurationKind)
+	if err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return digest.FromBytes(content).String(), nil
+}
+
 // LoadConfig loads data into a v2alpha1.ImageSetConfiguration or
 // v2alpha1.DeleteImageSetConfiguration instance
 // nolint: ireturn
@@ -86,8 +102,8 @@ func LoadConfigDelete(data []byte) (c v2alpha1.DeleteImageSetConfiguration, err
 	return c, nil
 }
 
-// nolint: unused
-func getTypeMeta(data []byte) (typeMeta v2alpha1.TypeMeta, err error) {
+// GetTypeMeta returns the kind and api version of the configuration data
+func GetTypeMeta(data []byte) (typeMeta v2alpha1.TypeMeta, err error) {
 	if err := yaml.Unmarshal(data, &typeMeta); err != nil {
 		return typeMeta, fmt.Errorf("get type meta: %w", err)
 	}
@@ -364,3 +364,49 @@ func TestReadConfigDelete(t *testing.T) {
 		}
 	})
 }
+
+func TestDigest(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(name, content string) string {
+		configPath := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0600))
+		return configPath
+	}
+	original := writeConfig("original.yaml", `kind: ImageSetConfiguration
+apiVersion: mirror.openshift.io/v2alpha1
+mirror:
+  additionalImages:
+  - name: registry.redhat.io/ubi9/ubi:latest
+  - name: quay.io/ns/app:v1
+`)
+	// same configuration: keys reordered, indented differently, commented
+	reformatted := writeConfig("reformatted.yaml", `# the images of the enclave
+apiVersion: mirror.openshift.io/v2alpha1
+kind: ImageSetConfiguration
+mirror:
+    additionalImages:
+        - name: "registry.redhat.io/ubi9/ubi:latest"
+        # the application
+        - name: quay.io/ns/app:v1
+`)
+	changed := writeConfig("changed.yaml", `kind: ImageSetConfiguration
+apiVersion: mirror.openshift.io/v2alpha1
+mirror:
+  additionalImages:
+  - name: registry.redhat.io/ubi9/ubi:latest
+  - name: quay.io/ns/app:v2
+`)
+
+	originalDigest, err := Digest(original)
+	require.NoError(t, err)
+	require.Regexp(t, "^sha256:[0-9a-f]{64}$", originalDigest)
+	reformattedDigest, err := Digest(reformatted)
+	require.NoError(t, err)
+	require.Equal(t, originalDigest, reformattedDigest)
+	changedDigest, err := Digest(changed)
+	require.NoError(t, err)
+	require.NotEqual(t, originalDigest, changedDigest)
+
+	_, err = Digest(filepath.Join(dir, "missing.yaml"))
+	require.Error(t, err)
+}
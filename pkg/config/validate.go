@@ -2,16 +2,21 @@ package config
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/distribution/reference"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+const ociProtocol = "oci://"
+
 type validationFunc func(cfg *v2alpha1.ImageSetConfiguration) []error
 type validationDeleteFunc func(cfg *v2alpha1.DeleteImageSetConfiguration) error
 
-var validationChecks = []validationFunc{validateOperatorOptions, validateReleaseChannels}
+var validationChecks = []validationFunc{validateOperatorOptions, validateReleaseChannels, validateGraphData, validateUpdateService, validateReleaseSignatures, validateTrustPolicy}
 var validationDeleteChecks = []validationDeleteFunc{validateOperatorOptionsDelete, validateReleaseChannelsDelete}
 
 // Validate will check an ImagesetConfiguration for input errors.
@@ -27,6 +32,7 @@ func Validate(cfg *v2alpha1.ImageSetConfiguration) error {
 
 func validateOperatorOptions(cfg *v2alpha1.ImageSetConfiguration) []error {
 	seen := map[string]bool{}
+	seenCatalogSourceNames := map[string]bool{}
 	errs := []error{}
 	for _, ctlg := range cfg.Mirror.Operators {
 		ctlgName, err := ctlg.GetUniqueName()
@@ -38,6 +44,15 @@ func validateOperatorOptions(cfg *v2alpha1.ImageSetConfiguration) []error {
 				"catalog %q: duplicate found in configuration", ctlgName,
 			))
 		}
+		if ctlg.CatalogSourceName != "" {
+			if validationErrs := validation.IsDNS1035Label(ctlg.CatalogSourceName); len(validationErrs) > 0 {
+				errs = append(errs, fmt.Errorf("catalog %q: catalogSourceName %q is not valid: %s", ctlgName, ctlg.CatalogSourceName, strings.Join(validationErrs, ", ")))
+			}
+			if seenCatalogSourceNames[ctlg.CatalogSourceName] {
+				errs = append(errs, fmt.Errorf("catalog %q: catalogSourceName %q is used by another catalog", ctlgName, ctlg.CatalogSourceName))
+			}
+			seenCatalogSourceNames[ctlg.CatalogSourceName] = true
+		}
 		if filterErrs := validateOperatorFiltering(ctlg); len(filterErrs) > 0 {
 			errs = append(errs, filterErrs...)
 		}
@@ -107,6 +122,98 @@ func validateReleaseChannels(cfg *v2alpha1.ImageSetConfiguration) []error {
 	return nil
 }
 
+func validateGraphData(cfg *v2alpha1.ImageSetConfiguration) []error {
+	graphData := cfg.Mirror.Platform.GraphData
+	if graphData == (v2alpha1.GraphData{}) {
+		return nil
+	}
+	errs := []error{}
+	if !cfg.Mirror.Platform.Graph {
+		errs = append(errs, fmt.Errorf("graphData: graph must be true"))
+	}
+	if graphData.BaseImage != "" && !strings.HasPrefix(graphData.BaseImage, ociProtocol) {
+		if _, err := reference.Parse(graphData.BaseImage); err != nil {
+			errs = append(errs, fmt.Errorf("graphData: baseImage %q must be an OCI layout (oci://) or an image of the cache: %w", graphData.BaseImage, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateUpdateService(cfg *v2alpha1.ImageSetConfiguration) []error {
+	updateService := cfg.Mirror.Platform.UpdateService
+	errs := []error{}
+	if updateService.Name != "" {
+		if validationErrs := validation.IsDNS1035Label(updateService.Name); len(validationErrs) > 0 {
+			errs = append(errs, fmt.Errorf("updateService: name %q is not valid: %s", updateService.Name, strings.Join(validationErrs, ", ")))
+		}
+	}
+	if updateService.Namespace != "" {
+		if validationErrs := validation.IsDNS1123Label(updateService.Namespace); len(validationErrs) > 0 {
+			errs = append(errs, fmt.Errorf("updateService: namespace %q is not valid: %s", updateService.Namespace, strings.Join(validationErrs, ", ")))
+		}
+	}
+	if updateService.Replicas < 0 {
+		errs = append(errs, fmt.Errorf("updateService: replicas must be a positive number"))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateReleaseSignatures(cfg *v2alpha1.ImageSetConfiguration) []error {
+	signatures := cfg.Mirror.Platform.Signatures
+	errs := []error{}
+	switch signatures.Policy {
+	case "", v2alpha1.SignaturePolicyRequire, v2alpha1.SignaturePolicyWarn, v2alpha1.SignaturePolicySkip:
+	default:
+		errs = append(errs, fmt.Errorf("signatures: policy %q must be one of (%s, %s, %s)", signatures.Policy,
+			v2alpha1.SignaturePolicyRequire, v2alpha1.SignaturePolicyWarn, v2alpha1.SignaturePolicySkip))
+	}
+	for _, store := range signatures.Stores {
+		if store == "" || (strings.Contains(store, "://") && !strings.HasPrefix(store, "http://") && !strings.HasPrefix(store, "https://") && !strings.HasPrefix(store, "file://")) {
+			errs = append(errs, fmt.Errorf("signatures: store %q must be an http(s):// URL or a local directory", store))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateTrustPolicy(cfg *v2alpha1.ImageSetConfiguration) []error {
+	trustPolicy := cfg.TrustPolicy
+	errs := []error{}
+	switch trustPolicy.Default {
+	case "", v2alpha1.TrustPolicyAccept, v2alpha1.TrustPolicyReject:
+	default:
+		errs = append(errs, fmt.Errorf("trustPolicy: default %q must be one of (%s, %s)", trustPolicy.Default, v2alpha1.TrustPolicyAccept, v2alpha1.TrustPolicyReject))
+	}
+	scopes := map[string]bool{}
+	for _, scope := range trustPolicy.Scopes {
+		switch {
+		case scope.Scope == "":
+			errs = append(errs, fmt.Errorf("trustPolicy: scope is mandatory"))
+			continue
+		case strings.Contains(scope.Scope, "://") || strings.ContainsAny(scope.Scope, "@") || strings.HasSuffix(scope.Scope, "/"):
+			errs = append(errs, fmt.Errorf("trustPolicy: scope %q must be a registry, a namespace or a repository, without transport, tag or digest", scope.Scope))
+		case scopes[scope.Scope]:
+			errs = append(errs, fmt.Errorf("trustPolicy: scope %q is defined more than once", scope.Scope))
+		}
+		scopes[scope.Scope] = true
+		if scope.Lookaside != "" && !strings.HasPrefix(scope.Lookaside, "http://") && !strings.HasPrefix(scope.Lookaside, "https://") && !strings.HasPrefix(scope.Lookaside, "file://") {
+			errs = append(errs, fmt.Errorf("trustPolicy: lookaside %q of scope %q must be an http(s):// or file:// URL", scope.Lookaside, scope.Scope))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
 // ValidateDelete will check an DeleteImagesetConfiguration for input errors.
 func ValidateDelete(cfg *v2alpha1.DeleteImageSetConfiguration) error {
 	var errs []error
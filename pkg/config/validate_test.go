@@ -163,6 +163,61 @@ func TestValidate(t *testing.T) {
 			},
 			expError: "invalid configuration: targetCatalog: a/b/test@sha256:45df874 - value is not valid. It should not contain a tag or a digest. It is expected to be composed of 1 or more path components separated by /, where each path component is a set of alpha-numeric and  regexp (?:[._]|__|[-]*). For more, see https://github.com/containers/image/blob/main/docker/reference/regexp.go",
 		},
+		{
+			name: "Valid/CatalogSourceNames",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Operators: []v2alpha1.Operator{
+							{
+								Catalog:           "test-catalog1:latest",
+								CatalogSourceName: "redhat-operators",
+							},
+							{
+								Catalog:           "test-catalog2:latest",
+								CatalogSourceName: "certified-operators",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Invalid/CatalogSourceName",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Operators: []v2alpha1.Operator{
+							{
+								Catalog:           "test-catalog1:latest",
+								CatalogSourceName: "Redhat.Operators",
+							},
+						},
+					},
+				},
+			},
+			expError: "invalid configuration: catalog \"test-catalog1:latest\": catalogSourceName \"Redhat.Operators\" is not valid: a DNS-1035 label must consist of lower case alphanumeric characters or '-', start with an alphabetic character, and end with an alphanumeric character (e.g. 'my-name',  or 'abc-123', regex used for validation is '[a-z]([-a-z0-9]*[a-z0-9])?')",
+		},
+		{
+			name: "Invalid/DuplicateCatalogSourceNames",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Operators: []v2alpha1.Operator{
+							{
+								Catalog:           "test-catalog1:latest",
+								CatalogSourceName: "redhat-operators",
+							},
+							{
+								Catalog:           "test-catalog2:latest",
+								CatalogSourceName: "redhat-operators",
+							},
+						},
+					},
+				},
+			},
+			expError: "invalid configuration: catalog \"test-catalog2:latest\": catalogSourceName \"redhat-operators\" is used by another catalog",
+		},
 		{
 			name: "Invalid/CatalogFilteringIncorrectChannelVersions",
 			config: &v2alpha1.ImageSetConfiguration{
@@ -274,6 +329,158 @@ func TestValidate(t *testing.T) {
 			},
 			expError: "invalid configuration: release channel \"channel\": duplicate found in configuration",
 		},
+		{
+			name: "Valid/GraphDataFromOCILayout",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Platform: v2alpha1.Platform{
+							Graph: true,
+							GraphData: v2alpha1.GraphData{
+								Tarball:   "/data/cincinnati-graph-data.tar.gz",
+								BaseImage: "oci:///data/ubi9",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Valid/GraphDataFromCache",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Platform: v2alpha1.Platform{
+							Graph: true,
+							GraphData: v2alpha1.GraphData{
+								BaseImage: "ubi9/ubi:latest",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Valid/UpdateService",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Platform: v2alpha1.Platform{
+							Graph:         true,
+							UpdateService: v2alpha1.UpdateService{Name: "osus", Namespace: "openshift-update-service", Replicas: 1},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Invalid/UpdateService",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Platform: v2alpha1.Platform{
+							Graph:         true,
+							UpdateService: v2alpha1.UpdateService{Namespace: "Update_Service", Replicas: -1},
+						},
+					},
+				},
+			},
+			expError: "invalid configuration: [updateService: namespace \"Update_Service\" is not valid: a lowercase RFC 1123 label must consist of lower case alphanumeric characters or '-', and must start and end with an alphanumeric character (e.g. 'my-name',  or '123-abc', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?'), updateService: replicas must be a positive number]",
+		},
+		{
+			name: "Valid/ReleaseSignatures",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Platform: v2alpha1.Platform{
+							Signatures: v2alpha1.ReleaseSignatures{
+								Stores:     []string{"https://mirror.openshift.com/pub/openshift-v4/signatures/openshift/release", "file:///srv/signatures", "/srv/signatures"},
+								PublicKeys: []string{"/etc/pki/rpm-gpg/RPM-GPG-KEY-redhat-release"},
+								Policy:     v2alpha1.SignaturePolicyRequire,
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Invalid/ReleaseSignatures",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Platform: v2alpha1.Platform{
+							Signatures: v2alpha1.ReleaseSignatures{
+								Stores: []string{"s3://signatures"},
+								Policy: "fail",
+							},
+						},
+					},
+				},
+			},
+			expError: "invalid configuration: [signatures: policy \"fail\" must be one of (require, warn, skip), signatures: store \"s3://signatures\" must be an http(s):// URL or a local directory]",
+		},
+		{
+			name: "Valid/TrustPolicy",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					TrustPolicy: v2alpha1.TrustPolicy{
+						Default: v2alpha1.TrustPolicyReject,
+						Scopes: []v2alpha1.TrustScope{
+							{Scope: "registry.redhat.io", Keys: []string{"/etc/pki/rpm-gpg/RPM-GPG-KEY-redhat-release"}, Lookaside: "https://registry.redhat.io/containers/sigstore"},
+							{Scope: "quay.io/example/app", SigstorePublicKeys: []string{"/etc/pki/cosign.pub"}},
+							{Scope: "*.example.com"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Invalid/TrustPolicy",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					TrustPolicy: v2alpha1.TrustPolicy{
+						Default: "deny",
+						Scopes: []v2alpha1.TrustScope{
+							{Scope: "docker://registry.redhat.io"},
+							{Scope: "quay.io/example", Lookaside: "s3://signatures"},
+							{Scope: "quay.io/example"},
+						},
+					},
+				},
+			},
+			expError: "invalid configuration: [trustPolicy: default \"deny\" must be one of (accept, reject), trustPolicy: scope \"docker://registry.redhat.io\" must be a registry, a namespace or a repository, without transport, tag or digest, trustPolicy: lookaside \"s3://signatures\" of scope \"quay.io/example\" must be an http(s):// or file:// URL, trustPolicy: scope \"quay.io/example\" is defined more than once]",
+		},
+		{
+			name: "Invalid/GraphDataWithoutGraph",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Platform: v2alpha1.Platform{
+							GraphData: v2alpha1.GraphData{
+								Tarball: "/data/cincinnati-graph-data.tar.gz",
+							},
+						},
+					},
+				},
+			},
+			expError: "invalid configuration: graphData: graph must be true",
+		},
+		{
+			name: "Invalid/GraphDataBaseImage",
+			config: &v2alpha1.ImageSetConfiguration{
+				ImageSetConfigurationSpec: v2alpha1.ImageSetConfigurationSpec{
+					Mirror: v2alpha1.Mirror{
+						Platform: v2alpha1.Platform{
+							Graph: true,
+							GraphData: v2alpha1.GraphData{
+								BaseImage: "UBI9:latest",
+							},
+						},
+					},
+				},
+			},
+			expError: "invalid configuration: graphData: baseImage \"UBI9:latest\" must be an OCI layout (oci://) or an image of the cache: repository name must be lowercase",
+		},
 	}
 
 	for _, c := range cases {
@@ -0,0 +1,255 @@
+package delete
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/docker/distribution/registry/api/errcode"
+	v2 "github.com/docker/distribution/registry/api/v2"
+	"sigs.k8s.io/yaml"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/mirror"
+)
+
+const deleteAnalysisYaml string = "delete/delete-analysis.yaml"
+
+// DeleteAnalysis reports the images of the delete list excluded from the deletion, because their manifest is
+// still referenced by the content kept in the registry, and the blobs the deleted images share with this content
+type DeleteAnalysis struct {
+	Kind       string            `json:"kind"`
+	APIVersion string            `json:"apiVersion"`
+	Excluded   []DeleteExclusion `json:"excluded"`
+	Deleted    []DeleteRetention `json:"deleted"`
+}
+
+// DeleteExclusion explains why an image of the delete list is not deleted
+type DeleteExclusion struct {
+	ImageName      string   `json:"imageName"`
+	ImageReference string   `json:"imageReference"`
+	Digest         string   `json:"digest"`
+	KeptBy         []string `json:"keptBy"`
+	Reason         string   `json:"reason"`
+}
+
+// DeleteRetention records the blobs of a deleted image still referenced by the content kept in the registry:
+// they are not reclaimed by its garbage collection
+type DeleteRetention struct {
+	ImageReference string `json:"imageReference"`
+	Digest         string `json:"digest"`
+	SharedBlobs    int    `json:"sharedBlobs"`
+}
+
+// imageManifests are the manifests of an image in its repository: the manifest of its reference and,
+// for a manifest list, the manifests of its instances, along with all their blobs
+type imageManifests struct {
+	repository string
+	digest     string
+	manifests  []string
+	blobs      map[string]struct{}
+}
+
+// ExcludeKeptImages returns deleteImageList without the images whose manifest is still referenced, in the same repository,
+// by keptImages (i.e. the images of the image set configuration of the content to keep): deleting a manifest removes it
+// for all the tags pointing to it and breaks the manifest lists including it. The analysis is written to the working-dir.
+// Only the images absent from the registry are skipped: any other error aborts the analysis, and so the deletion.
+func (o DeleteImages) ExcludeKeptImages(ctx context.Context, deleteImageList v2alpha1.DeleteImageList, keptImages []v2alpha1.CopyImageSchema) (v2alpha1.DeleteImageList, error) {
+	o.Log.Info(emoji.LeftPointingMagnifyingGlass + " Analyzing the manifests shared with the content to keep...")
+
+	// manifests of the kept images, by repository@digest
+	keptManifests := map[string][]string{}
+	keptBlobs := map[string]struct{}{}
+	for _, img := range keptImages {
+		manifests, err := o.resolveManifests(ctx, img.Destination)
+		if isManifestUnknown(err) {
+			// not mirrored (yet), nothing to protect
+			o.Log.Debug("kept image %s not found in the registry: %v", img.Destination, err)
+			continue
+		}
+		if err != nil {
+			// the deletion can't be proven safe
+			return v2alpha1.DeleteImageList{}, fmt.Errorf("unable to analyze the kept image %s: %w", img.Destination, err)
+		}
+		for _, digest := range manifests.manifests {
+			key := manifests.repository + "@" + digest
+			keptManifests[key] = append(keptManifests[key], img.Destination)
+		}
+		for blob := range manifests.blobs {
+			keptBlobs[blob] = struct{}{}
+		}
+	}
+
+	analysis := DeleteAnalysis{
+		Kind:       "DeleteAnalysis",
+		APIVersion: "mirror.openshift.io/v2alpha1",
+		Excluded:   []DeleteExclusion{},
+		Deleted:    []DeleteRetention{},
+	}
+	remaining := []v2alpha1.DeleteItem{}
+	for _, item := range deleteImageList.Items {
+		manifests, err := o.resolveManifests(ctx, item.ImageReference)
+		if isManifestUnknown(err) {
+			// left to the deletion, which reports it
+			o.Log.Debug("image to delete %s not found in the registry: %v", item.ImageReference, err)
+			remaining = append(remaining, item)
+			continue
+		}
+		if err != nil {
+			return v2alpha1.DeleteImageList{}, fmt.Errorf("unable to analyze the image to delete %s: %w", item.ImageReference, err)
+		}
+		if keptBy, ok := keptManifests[manifests.repository+"@"+manifests.digest]; ok {
+			analysis.Excluded = append(analysis.Excluded, newDeleteExclusion(item, manifests, keptBy))
+			continue
+		}
+		remaining = append(remaining, item)
+		sharedBlobs := 0
+		for blob := range manifests.blobs {
+			if _, ok := keptBlobs[blob]; ok {
+				sharedBlobs++
+			}
+		}
+		analysis.Deleted = append(analysis.Deleted, DeleteRetention{ImageReference: item.ImageReference, Digest: manifests.digest, SharedBlobs: sharedBlobs})
+	}
+
+	filename, err := o.writeDeleteAnalysis(analysis)
+	if err != nil {
+		return v2alpha1.DeleteImageList{}, err
+	}
+	if len(analysis.Excluded) > 0 {
+		o.Log.Warn("%d images excluded from the deletion, their manifest is still referenced by the content to keep: see %s", len(analysis.Excluded), filename)
+	} else {
+		o.Log.Info("no manifest shared with the content to keep, see %s", filename)
+	}
+	deleteImageList.Items = remaining
+	return deleteImageList, nil
+}
+
+func newDeleteExclusion(item v2alpha1.DeleteItem, manifests imageManifests, keptBy []string) DeleteExclusion {
+	sort.Strings(keptBy)
+	reason := fmt.Sprintf("manifest %s is still referenced in %s by %s", manifests.digest, manifests.repository, strings.Join(keptBy, ", "))
+	for _, kept := range keptBy {
+		if kept == item.ImageReference {
+			reason = "the image is part of the content to keep"
+			break
+		}
+	}
+	return DeleteExclusion{
+		ImageName:      item.ImageName,
+		ImageReference: item.ImageReference,
+		Digest:         manifests.digest,
+		KeptBy:         keptBy,
+		Reason:         reason,
+	}
+}
+
+// writeDeleteAnalysis writes the analysis next to the delete yaml file, and returns its path
+func (o DeleteImages) writeDeleteAnalysis(analysis DeleteAnalysis) (string, error) {
+	filename := filepath.Join(o.Options.WorkingDir, deleteAnalysisYaml)
+	if len(o.Options.DeleteID) > 0 {
+		filename = filepath.Join(o.Options.WorkingDir, strings.ReplaceAll(deleteAnalysisYaml, ".", "-"+o.Options.DeleteID+"."))
+	}
+	ymlData, err := yaml.Marshal(analysis)
+	if err != nil {
+		return "", fmt.Errorf(deleteImagesErrMsg, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return "", fmt.Errorf(deleteImagesErrMsg, err)
+	}
+	// #nosec G306
+	if err := os.WriteFile(filename, ymlData, 0644); err != nil {
+		return "", fmt.Errorf(deleteImagesErrMsg, err)
+	}
+	return filename, nil
+}
+
+// resolveManifests returns the manifests of imgRef (docker://...) in the registry
+func (o DeleteImages) resolveManifests(ctx context.Context, imgRef string) (imageManifests, error) {
+	if err := mirror.ReexecIfNecessaryForImages(imgRef); err != nil {
+		return imageManifests{}, fmt.Errorf("%w", err)
+	}
+	ref, err := alltransports.ParseImageName(imgRef)
+	if err != nil {
+		return imageManifests{}, fmt.Errorf("invalid image name %s: %w", imgRef, err)
+	}
+	if ref.DockerReference() == nil {
+		return imageManifests{}, fmt.Errorf("%s is not in a registry", imgRef)
+	}
+	sysCtx := o.Options.NewSystemContext()
+	if strings.Contains(imgRef, o.Options.LocalStorageFQDN) {
+		o.Options.SetLocalStorageTLS(sysCtx)
+	}
+	src, err := ref.NewImageSource(ctx, sysCtx)
+	if err != nil {
+		return imageManifests{}, fmt.Errorf("%w", err)
+	}
+	defer src.Close()
+
+	manifestBytes, mime, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return imageManifests{}, fmt.Errorf("%w", err)
+	}
+	digest, err := manifest.Digest(manifestBytes)
+	if err != nil {
+		return imageManifests{}, fmt.Errorf("%w", err)
+	}
+	result := imageManifests{
+		repository: ref.DockerReference().Name(),
+		digest:     digest.String(),
+		manifests:  []string{digest.String()},
+		blobs:      map[string]struct{}{digest.String(): {}},
+	}
+
+	if !manifest.MIMETypeIsMultiImage(mime) {
+		err = addManifestBlobs(result.blobs, manifestBytes, mime)
+		return result, err
+	}
+	list, err := manifest.ListFromBlob(manifestBytes, mime)
+	if err != nil {
+		return imageManifests{}, fmt.Errorf("%w", err)
+	}
+	for _, instance := range list.Instances() {
+		instanceBytes, instanceMime, err := src.GetManifest(ctx, &instance)
+		if err != nil {
+			return imageManifests{}, fmt.Errorf("%w", err)
+		}
+		result.manifests = append(result.manifests, instance.String())
+		result.blobs[instance.String()] = struct{}{}
+		if err := addManifestBlobs(result.blobs, instanceBytes, instanceMime); err != nil {
+			return imageManifests{}, err
+		}
+	}
+	return result, nil
+}
+
+// isManifestUnknown checks whether err reports a manifest, or a repository, absent from the registry.
+// Any other error (authentication, TLS, network, server errors) says nothing about the presence of the image.
+func isManifestUnknown(err error) bool {
+	var ec errcode.ErrorCoder
+	if errors.As(err, &ec) {
+		code := ec.ErrorCode()
+		return code == v2.ErrorCodeManifestUnknown || code == v2.ErrorCodeNameUnknown
+	}
+	// registries not using the error codes of the distribution spec still answer 404
+	return err != nil && strings.Contains(err.Error(), fmt.Sprintf("StatusCode: %d", http.StatusNotFound))
+}
+
+func addManifestBlobs(blobs map[string]struct{}, manifestBytes []byte, mime string) error {
+	m, err := manifest.FromBlob(manifestBytes, mime)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling manifest: %w", err)
+	}
+	for _, layer := range m.LayerInfos() {
+		blobs[layer.Digest.String()] = struct{}{}
+	}
+	blobs[m.ConfigInfo().Digest.String()] = struct{}{}
+	return nil
+}
@@ -0,0 +1,135 @@
+package delete
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestExcludeKeptImages(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	kept, err := random.Image(1024, 1)
+	require.NoError(t, err)
+	keptDigest, err := kept.Digest()
+	require.NoError(t, err)
+	layer, err := random.Layer(1024, "application/vnd.docker.image.rootfs.diff.tar.gzip")
+	require.NoError(t, err)
+	// an older version of the kept image, sharing its first layer
+	old, err := mutate.AppendLayers(kept, layer)
+	require.NoError(t, err)
+	index, err := random.Index(1024, 1, 2)
+	require.NoError(t, err)
+	indexManifest, err := index.IndexManifest()
+	require.NoError(t, err)
+	instanceDigest := indexManifest.Manifests[0].Digest
+
+	pushTestImage(t, host+"/mirror/app:v1", kept)
+	pushTestImage(t, host+"/mirror/app:v1-alias", kept)
+	pushTestImage(t, host+"/mirror/app:v0", old)
+	pushTestImage(t, host+"/mirror/other:v1", kept)
+	ref, err := name.ParseReference(host + "/mirror/multi:latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(ref, index))
+
+	deleteImageList := v2alpha1.DeleteImageList{
+		Items: []v2alpha1.DeleteItem{
+			{ImageName: "quay.io/app:v0", ImageReference: "docker://" + host + "/mirror/app:v0", Type: v2alpha1.TypeGeneric},
+			{ImageName: "quay.io/app:v1-alias", ImageReference: "docker://" + host + "/mirror/app:v1-alias", Type: v2alpha1.TypeGeneric},
+			// the same manifest in another repository is deleted
+			{ImageName: "quay.io/other:v1", ImageReference: "docker://" + host + "/mirror/other:v1", Type: v2alpha1.TypeGeneric},
+			{ImageName: "quay.io/multi@" + instanceDigest.String(), ImageReference: "docker://" + host + "/mirror/multi@" + instanceDigest.String(), Type: v2alpha1.TypeGeneric},
+			{ImageName: "quay.io/missing:v1", ImageReference: "docker://" + host + "/mirror/missing:v1", Type: v2alpha1.TypeGeneric},
+		},
+	}
+	keptImages := []v2alpha1.CopyImageSchema{
+		{Origin: "docker://quay.io/app:v1", Destination: "docker://" + host + "/mirror/app:v1", Type: v2alpha1.TypeGeneric},
+		{Origin: "docker://quay.io/multi:latest", Destination: "docker://" + host + "/mirror/multi:latest", Type: v2alpha1.TypeGeneric},
+		{Origin: "docker://quay.io/app:v2", Destination: "docker://" + host + "/mirror/app:v2", Type: v2alpha1.TypeGeneric},
+	}
+
+	workingDir := t.TempDir()
+	opts := &common.MirrorOptions{WorkingDir: workingDir, DeleteID: "test", LocalStorageFQDN: "localhost:55000"}
+	deleteImages := New(clog.New("error"), opts, nil, nil, v2alpha1.DeleteImageSetConfiguration{})
+	remaining, err := deleteImages.ExcludeKeptImages(context.Background(), deleteImageList, keptImages)
+	require.NoError(t, err)
+	require.Equal(t, []v2alpha1.DeleteItem{deleteImageList.Items[0], deleteImageList.Items[2], deleteImageList.Items[4]}, remaining.Items)
+
+	data, err := os.ReadFile(filepath.Join(workingDir, "delete", "delete-analysis-test.yaml"))
+	require.NoError(t, err)
+	analysis := DeleteAnalysis{}
+	require.NoError(t, yaml.Unmarshal(data, &analysis))
+	require.Equal(t, []DeleteExclusion{
+		{
+			ImageName:      "quay.io/app:v1-alias",
+			ImageReference: "docker://" + host + "/mirror/app:v1-alias",
+			Digest:         keptDigest.String(),
+			KeptBy:         []string{"docker://" + host + "/mirror/app:v1"},
+			Reason:         "manifest " + keptDigest.String() + " is still referenced in " + host + "/mirror/app by docker://" + host + "/mirror/app:v1",
+		},
+		{
+			ImageName:      "quay.io/multi@" + instanceDigest.String(),
+			ImageReference: "docker://" + host + "/mirror/multi@" + instanceDigest.String(),
+			Digest:         instanceDigest.String(),
+			KeptBy:         []string{"docker://" + host + "/mirror/multi:latest"},
+			Reason:         "manifest " + instanceDigest.String() + " is still referenced in " + host + "/mirror/multi by docker://" + host + "/mirror/multi:latest",
+		},
+	}, analysis.Excluded)
+	require.Len(t, analysis.Deleted, 2)
+	// the first layer of app:v0 is kept by app:v1
+	require.Equal(t, "docker://"+host+"/mirror/app:v0", analysis.Deleted[0].ImageReference)
+	require.Equal(t, 1, analysis.Deleted[0].SharedBlobs)
+	// the manifest, config and layer of other:v1 are still stored for app:v1
+	require.Equal(t, "docker://"+host+"/mirror/other:v1", analysis.Deleted[1].ImageReference)
+	require.Equal(t, 3, analysis.Deleted[1].SharedBlobs)
+}
+
+func TestExcludeKeptImagesFailsClosed(t *testing.T) {
+	// the registry denies the access to the kept image: it can't be told apart from an image absent from the registry
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":[{"code":"DENIED","message":"access denied"}]}`))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	deleteImageList := v2alpha1.DeleteImageList{
+		Items: []v2alpha1.DeleteItem{{ImageName: "quay.io/app:v0", ImageReference: "docker://" + host + "/mirror/app:v0", Type: v2alpha1.TypeGeneric}},
+	}
+	keptImages := []v2alpha1.CopyImageSchema{{Origin: "docker://quay.io/app:v1", Destination: "docker://" + host + "/mirror/app:v1", Type: v2alpha1.TypeGeneric}}
+	opts := &common.MirrorOptions{WorkingDir: t.TempDir(), LocalStorageFQDN: "localhost:55000"}
+	deleteImages := New(clog.New("error"), opts, nil, nil, v2alpha1.DeleteImageSetConfiguration{})
+	_, err := deleteImages.ExcludeKeptImages(context.Background(), deleteImageList, keptImages)
+	require.ErrorContains(t, err, "unable to analyze the kept image")
+}
+
+func pushTestImage(t *testing.T, image string, img v1.Image) {
+	ref, err := name.ParseReference(image)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(ref, img))
+}
@@ -56,6 +56,7 @@ type DeleteInterface interface {
 	WriteDeleteMetaData([]v2alpha1.CopyImageSchema) error
 	ReadDeleteMetaData() (v2alpha1.DeleteImageList, error)
 	DeleteRegistryImages(images v2alpha1.DeleteImageList) error
+	ExcludeKeptImages(ctx context.Context, images v2alpha1.DeleteImageList, keptImages []v2alpha1.CopyImageSchema) (v2alpha1.DeleteImageList, error)
 }
 
 type DeleteImages struct {
@@ -20,4 +20,5 @@ const (
 	Gear                        string = "\u2699\uFE0F"         // ⚙️
 	Warning                     string = "\U000026A0\U0000FE0F" // ⚠️
 	Exclamation                 string = "\U00002757"           // ❗
+	Lock                        string = "\U0001F512"           // 🔒
 )
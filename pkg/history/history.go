@@ -2,14 +2,17 @@ package history
 
 import (
 	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
 )
@@ -22,8 +25,17 @@ const (
 )
 
 type History interface {
+	// Read returns the blobs of all the runs recorded before the `before` time
+	// of the history (of all the runs when `before` is zero)
 	Read() (map[string]string, error)
-	Append(map[string]string) (map[string]string, error)
+	// Append writes the record of a run to the history metadata, and returns
+	// all the blobs recorded, including the ones of this record
+	Append(record Record) (map[string]string, error)
+	// Records returns all the records of the history metadata, oldest first
+	Records() ([]Record, error)
+	// Compact merges the history files falling out of the retention policy
+	// into a single baseline record, and returns the ids of the runs compacted
+	Compact(retention Retention) ([]string, error)
 }
 
 type FileCreator interface {
@@ -59,71 +71,141 @@ func NewHistory(workingDir string, before time.Time, logg clog.PluggableLoggerIn
 
 func (o history) Read() (map[string]string, error) {
 	historyMap := make(map[string]string)
-	historyFile, err := o.getHistoryFile(o.before)
-	// if err is of type EmptyHistoryError
-	// then return the erorr and an empty historyMap
-	if errors.Is(err, &EmptyHistoryError{}) {
-		return historyMap, err
-	} else if err != nil {
-		return nil, fmt.Errorf("%w", err)
-	}
-
-	file, err := os.Open(historyFile)
+	records, err := o.readRecords(o.before)
 	if err != nil {
-		return nil, fmt.Errorf("%w", err)
+		return nil, err
 	}
-	defer file.Close()
+	// if there are no records, return an EmptyHistoryError
+	// and an empty historyMap
+	if len(records) == 0 {
+		return historyMap, EmptyHistoryErrorf("no history metadata found under %s", filepath.Dir(o.historyDir))
+	}
+	for _, record := range records {
+		for _, blob := range record.Blobs {
+			historyMap[blob] = ""
+		}
+	}
+	return historyMap, nil
+}
 
-	scanner := bufio.NewScanner(file)
+func (o history) Records() ([]Record, error) {
+	return o.readRecords(time.Time{})
+}
 
-	for scanner.Scan() {
-		blob := scanner.Text()
-		historyMap[blob] = ""
+// readRecords reads the records of all the history files dated before `before`
+// (all of them when `before` is zero), oldest first
+func (o history) readRecords(before time.Time) ([]Record, error) {
+	historyFiles, err := o.listHistoryFiles()
+	if err != nil {
+		return nil, err
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("%w", err)
+	records := []Record{}
+	for _, historyFile := range historyFiles {
+		if !before.IsZero() && !historyFile.date.Before(before) {
+			continue
+		}
+		fileRecords, err := readHistoryFile(historyFile.path, historyFile.date)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, fileRecords...)
 	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Timestamp.Before(records[j].Timestamp) })
+	return records, nil
+}
 
-	return historyMap, nil
+// historyFile is a file of the history metadata, and the date found in its name
+type historyFile struct {
+	path string
+	date time.Time
 }
 
-func (o history) getHistoryFile(before time.Time) (string, error) {
-	historyFilePath := ""
-	historyFiles, err := os.ReadDir(o.historyDir)
+// listHistoryFiles returns the history files, oldest first
+func (o history) listHistoryFiles() ([]historyFile, error) {
+	entries, err := os.ReadDir(o.historyDir)
 	if err != nil {
-		return "", fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
+	historyFiles := []historyFile{}
+	for _, entry := range entries {
+		if !isHistoryFile(entry) {
+			continue
+		}
+		fileTime, err := getFileDate(entry)
+		if err != nil {
+			return nil, err
+		}
+		historyFiles = append(historyFiles, historyFile{path: filepath.Join(o.historyDir, entry.Name()), date: fileTime})
+	}
+	sort.SliceStable(historyFiles, func(i, j int) bool { return historyFiles[i].date.Before(historyFiles[j].date) })
+	return historyFiles, nil
+}
 
-	var latestFile fs.DirEntry
-	var latestTime time.Time
+// readHistoryFile reads the records of a history file.
+// A file that doesn't contain JSON records is a legacy history file:
+// it lists the digests of all the blobs mirrored up to its date, one per line.
+func readHistoryFile(historyFile string, fileTime time.Time) ([]Record, error) {
+	file, err := os.Open(historyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer file.Close()
 
-	for _, historyFile := range historyFiles {
-		if isHistoryFile(historyFile) {
-			fileTime, err := getFileDate(historyFile)
+	reader := bufio.NewReader(file)
+	if isRecordFile(reader) {
+		records := []Record{}
+		decoder := json.NewDecoder(reader)
+		for {
+			var record Record
+			err := decoder.Decode(&record)
+			if errors.Is(err, io.EOF) {
+				break
+			}
 			if err != nil {
-				return "", fmt.Errorf("%w", err)
+				return nil, fmt.Errorf("unable to read history record from %s: %w", historyFile, err)
 			}
-
-			if !before.IsZero() {
-				if fileTime.After(latestTime) && fileTime.Before(before) {
-					latestFile = historyFile
-					latestTime = fileTime
-				}
-			} else {
-				if fileTime.After(latestTime) {
-					latestFile = historyFile
-					latestTime = fileTime
-				}
+			if record.Kind != RecordKind {
+				return nil, fmt.Errorf("unexpected kind %q in history file %s", record.Kind, historyFile)
 			}
+			records = append(records, record)
 		}
+		return records, nil
 	}
-	if latestFile != nil {
-		historyFilePath = filepath.Join(o.historyDir, latestFile.Name())
-	} else {
-		return "", EmptyHistoryErrorf("no history metadata found under %s", filepath.Dir(o.historyDir))
+
+	legacy := Record{
+		Kind:       RecordKind,
+		APIVersion: RecordAPIVersion,
+		RunID:      fileTime.Format(time.RFC3339),
+		Timestamp:  fileTime,
+		Blobs:      []string{},
+		Legacy:     true,
+	}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		blob := strings.TrimSpace(scanner.Text())
+		if blob != "" {
+			legacy.Blobs = append(legacy.Blobs, blob)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return []Record{legacy}, nil
+}
+
+// isRecordFile checks whether the first non blank character of the file opens a JSON object
+func isRecordFile(reader *bufio.Reader) bool {
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			return false
+		}
+		if !unicode.IsSpace(rune(b[0])) {
+			return b[0] == '{'
+		}
+		_, _ = reader.ReadByte()
 	}
-	return historyFilePath, fmt.Errorf("%w", err)
 }
 
 func isHistoryFile(historyFile fs.DirEntry) bool {
@@ -137,20 +219,22 @@ func getFileDate(historyFile fs.DirEntry) (time.Time, error) {
 		log.Error("unable to parse time from filename %s: %s", historyFile.Name(), err.Error())
 		return time.Time{}, fmt.Errorf("%w", err)
 	}
-	return dateTime, fmt.Errorf("%w", err)
+	return dateTime, nil
 }
 
-func (o history) Append(blobsToAppend map[string]string) (map[string]string, error) {
-
-	filename := o.newFileName()
+func (o history) Append(record Record) (map[string]string, error) {
+	if record.Timestamp.IsZero() {
+		record.Timestamp = time.Now().UTC()
+	}
+	filename := o.newFileName(record.Timestamp)
 
 	historyBlobs, err := o.Read()
 	if err != nil && !errors.Is(err, &EmptyHistoryError{}) {
-		return nil, fmt.Errorf("%w", err)
+		return nil, err
 	}
 
-	for k, v := range blobsToAppend {
-		historyBlobs[k] = v
+	for _, blob := range record.Blobs {
+		historyBlobs[blob] = ""
 	}
 
 	file, err := o.fileCreator.Create(filename)
@@ -160,13 +244,10 @@ func (o history) Append(blobsToAppend map[string]string) (map[string]string, err
 	defer file.Close()
 
 	writer := bufio.NewWriter(file)
-
-	for blob := range historyBlobs {
-		_, err := writer.WriteString(blob + "\n")
-		if err != nil {
-			log.Error("unable to write to history file: %s", err.Error())
-			return historyBlobs, fmt.Errorf("%w", err)
-		}
+	err = json.NewEncoder(writer).Encode(record)
+	if err != nil {
+		log.Error("unable to write to history file: %s", err.Error())
+		return historyBlobs, fmt.Errorf("%w", err)
 	}
 
 	err = writer.Flush()
@@ -175,15 +256,17 @@ func (o history) Append(blobsToAppend map[string]string) (map[string]string, err
 		return historyBlobs, fmt.Errorf("%w", err)
 	}
 
-	return historyBlobs, fmt.Errorf("%w", err)
-
+	return historyBlobs, nil
 }
 
-func (o history) newFileName() string {
-	return filepath.Join(o.historyDir, historyNamePrefix+time.Now().UTC().Format(time.RFC3339))
+func (o history) newFileName(timestamp time.Time) string {
+	return filepath.Join(o.historyDir, historyNamePrefix+timestamp.UTC().Format(time.RFC3339))
 }
 
 func (OSFileCreator) Create(filename string) (io.WriteCloser, error) {
 	file, err := os.Create(filename)
-	return file, fmt.Errorf("%w", err)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return file, nil
 }
@@ -0,0 +1,94 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryRead(t *testing.T) {
+	type testCase struct {
+		caseName      string
+		before        time.Time
+		expectedBlobs map[string]string
+		expectedError bool
+	}
+
+	workingDir := t.TempDir()
+	writeLegacyHistoryFile(t, workingDir, "2024-01-01T00:00:00Z", "sha256:aaa\nsha256:bbb\n")
+	h, err := NewHistory(workingDir, time.Time{}, clog.New("error"), OSFileCreator{})
+	require.NoError(t, err)
+	record := NewRecord("run-2", "sha256:isc", "mirror-to-disk")
+	record.Timestamp = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	record.Blobs = []string{"sha256:ccc"}
+	_, err = h.Append(record)
+	require.NoError(t, err)
+
+	testCases := []testCase{
+		{
+			caseName:      "all records should be read when before is not set",
+			expectedBlobs: map[string]string{"sha256:aaa": "", "sha256:bbb": "", "sha256:ccc": ""},
+		},
+		{
+			caseName:      "only records before the since date should be read",
+			before:        time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			expectedBlobs: map[string]string{"sha256:aaa": "", "sha256:bbb": ""},
+		},
+		{
+			caseName:      "no record before the since date should return an empty history error",
+			before:        time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			expectedBlobs: map[string]string{},
+			expectedError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			h, err := NewHistory(workingDir, testCase.before, clog.New("error"), OSFileCreator{})
+			require.NoError(t, err)
+			blobs, err := h.Read()
+			if testCase.expectedError {
+				require.ErrorIs(t, err, &EmptyHistoryError{})
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, testCase.expectedBlobs, blobs)
+		})
+	}
+}
+
+func TestHistoryRecords(t *testing.T) {
+	workingDir := t.TempDir()
+	writeLegacyHistoryFile(t, workingDir, "2024-01-01T00:00:00Z", "sha256:aaa\n")
+	h, err := NewHistory(workingDir, time.Time{}, clog.New("error"), OSFileCreator{})
+	require.NoError(t, err)
+
+	record := NewRecord("run-2", "sha256:isc", "mirror-to-disk")
+	record.Timestamp = time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	record.Blobs = []string{"sha256:bbb"}
+	record.Chunks = []string{"mirror_g0001_000001.tar"}
+	record.Images = []ImageRecord{{Origin: "quay.io/ns/img:v1", Destination: "localhost:55000/ns/img:v1", Digest: "sha256:ddd", Blobs: []string{"sha256:bbb"}}}
+	blobs, err := h.Append(record)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"sha256:aaa": "", "sha256:bbb": ""}, blobs)
+
+	records, err := h.Records()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	require.True(t, records[0].Legacy)
+	require.Equal(t, "2024-01-01T00:00:00Z", records[0].RunID)
+	require.Equal(t, []string{"sha256:aaa"}, records[0].Blobs)
+
+	require.False(t, records[1].Legacy)
+	require.Equal(t, record, records[1])
+}
+
+func writeLegacyHistoryFile(t *testing.T, workingDir, timestamp, content string) {
+	historyDir := filepath.Join(workingDir, historyPath)
+	require.NoError(t, os.MkdirAll(historyDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(historyDir, historyNamePrefix+timestamp), []byte(content), 0600))
+}
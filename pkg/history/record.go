@@ -0,0 +1,64 @@
+package history
+
+import (
+	"time"
+)
+
+const (
+	RecordKind       = "HistoryRecord"
+	RecordAPIVersion = "mirror.openshift.io/v2alpha1"
+)
+
+// Record is the history metadata of one run of oc-mirror.
+// Each history file contains one record per line (JSON lines).
+// History files written before records were introduced only contain
+// a list of blob digests: they are read as legacy records.
+type Record struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	// RunID identifies the run
+	RunID     string    `json:"runId"`
+	Timestamp time.Time `json:"timestamp"`
+	// ISCHash is the digest of the image set configuration used by the run
+	ISCHash string `json:"iscHash,omitempty"`
+	// Mode is the workflow of the run (mirror-to-disk...)
+	Mode string `json:"mode,omitempty"`
+	// Images are the images included in the run
+	Images []ImageRecord `json:"images,omitempty"`
+	// Blobs are the digests of the blobs shipped by the run.
+	// For legacy records, these are all the blobs shipped up to this record.
+	Blobs []string `json:"blobs"`
+	// Chunks are the names of the archive chunks created by the run
+	Chunks []string `json:"chunks,omitempty"`
+	// Legacy is true for records read from history files that only contain blob digests
+	Legacy bool `json:"legacy,omitempty"`
+	// Baseline is true for the record resulting from the compaction of past records:
+	// its blobs are all the blobs shipped by these records
+	Baseline bool `json:"baseline,omitempty"`
+}
+
+// ImageRecord describes an image included in a run
+type ImageRecord struct {
+	Origin      string `json:"origin"`
+	Destination string `json:"destination"`
+	// Digest is the digest of the manifest (or manifest list) of the image
+	Digest string `json:"digest,omitempty"`
+	// CacheReference is the reference of the image in the cache, when it isn't Destination
+	// (a disk-to-mirror run copies the images from the cache)
+	CacheReference string `json:"cacheReference,omitempty"`
+	// Blobs are the digests of all the blobs of the image, manifests included
+	Blobs []string `json:"blobs,omitempty"`
+}
+
+// NewRecord creates a record for the run `runID`, timestamped now
+func NewRecord(runID, iscHash, mode string) Record {
+	return Record{
+		Kind:       RecordKind,
+		APIVersion: RecordAPIVersion,
+		RunID:      runID,
+		Timestamp:  time.Now().UTC(),
+		ISCHash:    iscHash,
+		Mode:       mode,
+		Blobs:      []string{},
+	}
+}
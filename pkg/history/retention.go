@@ -0,0 +1,102 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const compactionTmpFile = "compaction.tmp"
+
+// Retention is the retention policy of the history metadata: a history file is kept
+// when it is one of the Keep most recent ones, or when it is dated Since or later.
+// The files that are not kept are compacted into a single baseline record.
+// The zero Retention keeps all the history files.
+type Retention struct {
+	Keep  int
+	Since time.Time
+}
+
+// IsSet checks whether the retention policy would compact anything
+func (o Retention) IsSet() bool {
+	return o.Keep > 0 || !o.Since.IsZero()
+}
+
+// Compact merges the history files falling out of the retention policy into a single baseline record.
+// The baseline record carries the union of the blobs of the compacted records, so that
+// Read returns the same blobs before and after the compaction: only the details of the
+// compacted runs (images, chunks...) are lost.
+// The baseline is dated like the most recent compacted file: a --since date older than that
+// now ignores the whole baseline, which can only make the next archive bigger, never incomplete.
+func (o history) Compact(retention Retention) ([]string, error) {
+	if !retention.IsSet() {
+		return nil, nil
+	}
+	historyFiles, err := o.listHistoryFiles()
+	if err != nil {
+		return nil, err
+	}
+	compacted := []historyFile{}
+	for i, historyFile := range historyFiles {
+		recent := retention.Keep > 0 && i >= len(historyFiles)-retention.Keep
+		newer := !retention.Since.IsZero() && !historyFile.date.Before(retention.Since)
+		if !recent && !newer {
+			compacted = append(compacted, historyFile)
+		}
+	}
+	// a single file is already a baseline
+	if len(compacted) < 2 {
+		return nil, nil
+	}
+
+	newest := compacted[len(compacted)-1]
+	baseline := Record{
+		Kind:       RecordKind,
+		APIVersion: RecordAPIVersion,
+		RunID:      "baseline-" + newest.date.Format(time.RFC3339),
+		Timestamp:  newest.date,
+		Blobs:      []string{},
+		Baseline:   true,
+	}
+	blobs := map[string]struct{}{}
+	runIDs := []string{}
+	for _, historyFile := range compacted {
+		records, err := readHistoryFile(historyFile.path, historyFile.date)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			runIDs = append(runIDs, record.RunID)
+			for _, blob := range record.Blobs {
+				blobs[blob] = struct{}{}
+			}
+		}
+	}
+	for blob := range blobs {
+		baseline.Blobs = append(baseline.Blobs, blob)
+	}
+	sort.Strings(baseline.Blobs)
+
+	// the baseline replaces the most recent compacted file atomically, so that an interrupted
+	// compaction never loses blobs: the remaining compacted files are removed afterwards
+	tmp := filepath.Join(o.historyDir, compactionTmpFile)
+	content, err := json.Marshal(baseline)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compact history metadata: %w", err)
+	}
+	if err := os.WriteFile(tmp, append(content, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("unable to compact history metadata: %w", err)
+	}
+	if err := os.Rename(tmp, newest.path); err != nil {
+		return nil, fmt.Errorf("unable to compact history metadata: %w", err)
+	}
+	for _, historyFile := range compacted[:len(compacted)-1] {
+		if err := os.Remove(historyFile.path); err != nil {
+			return runIDs, fmt.Errorf("unable to remove compacted history file %s: %w", historyFile.path, err)
+		}
+	}
+	return runIDs, nil
+}
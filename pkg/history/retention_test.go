@@ -0,0 +1,84 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryCompact(t *testing.T) {
+	type testCase struct {
+		caseName          string
+		retention         Retention
+		expectedCompacted []string
+		expectedFiles     []string
+	}
+
+	testCases := []testCase{
+		{
+			caseName:      "no retention policy should keep all files",
+			expectedFiles: []string{".history-2024-01-01T00:00:00Z", ".history-2024-02-01T00:00:00Z", ".history-2024-03-01T00:00:00Z", ".history-2024-04-01T00:00:00Z"},
+		},
+		{
+			caseName:          "keep should compact all but the most recent files",
+			retention:         Retention{Keep: 1},
+			expectedCompacted: []string{"2024-01-01T00:00:00Z", "run-2", "run-3"},
+			expectedFiles:     []string{".history-2024-03-01T00:00:00Z", ".history-2024-04-01T00:00:00Z"},
+		},
+		{
+			caseName:          "since should compact the files older than the date",
+			retention:         Retention{Since: time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC)},
+			expectedCompacted: []string{"2024-01-01T00:00:00Z", "run-2"},
+			expectedFiles:     []string{".history-2024-02-01T00:00:00Z", ".history-2024-03-01T00:00:00Z", ".history-2024-04-01T00:00:00Z"},
+		},
+		{
+			caseName:      "a single file out of the retention policy should not be compacted",
+			retention:     Retention{Keep: 3},
+			expectedFiles: []string{".history-2024-01-01T00:00:00Z", ".history-2024-02-01T00:00:00Z", ".history-2024-03-01T00:00:00Z", ".history-2024-04-01T00:00:00Z"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			workingDir := t.TempDir()
+			writeLegacyHistoryFile(t, workingDir, "2024-01-01T00:00:00Z", "sha256:aaa\n")
+			h, err := NewHistory(workingDir, time.Time{}, clog.New("error"), OSFileCreator{})
+			require.NoError(t, err)
+			for i, blob := range []string{"sha256:bbb", "sha256:ccc", "sha256:ddd"} {
+				record := NewRecord(fmt.Sprintf("run-%d", i+2), "sha256:isc", "mirror-to-disk")
+				record.Timestamp = time.Date(2024, time.Month(2+i), 1, 0, 0, 0, 0, time.UTC)
+				record.Blobs = []string{blob}
+				_, err = h.Append(record)
+				require.NoError(t, err)
+			}
+			blobsBefore, err := h.Read()
+			require.NoError(t, err)
+
+			compacted, err := h.Compact(testCase.retention)
+			require.NoError(t, err)
+			require.Equal(t, testCase.expectedCompacted, compacted)
+
+			entries, err := os.ReadDir(filepath.Join(workingDir, historyPath))
+			require.NoError(t, err)
+			files := []string{}
+			for _, entry := range entries {
+				files = append(files, entry.Name())
+			}
+			require.Equal(t, testCase.expectedFiles, files)
+
+			blobsAfter, err := h.Read()
+			require.NoError(t, err)
+			require.Equal(t, blobsBefore, blobsAfter)
+
+			if len(testCase.expectedCompacted) > 0 {
+				records, err := h.Records()
+				require.NoError(t, err)
+				require.True(t, records[0].Baseline)
+			}
+		})
+	}
+}
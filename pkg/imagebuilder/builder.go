@@ -6,6 +6,7 @@ import (
 	"compress/gzip"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
@@ -84,6 +85,14 @@ func NewBuilder(logger log.PluggableLoggerInterface, opts common.MirrorOptions)
 		// create our own roundTripper to pass insecure=true
 		insecureRoundTripper := createInsecureRoundTripper()
 		remoteOptions = append(remoteOptions, remote.WithTransport(insecureRoundTripper))
+	} else if opts.LocalStorageCertDir != "" {
+		// the local storage registry is served over TLS, with the ephemeral CA of the run
+		localStorageRoundTripper, err := createLocalStorageRoundTripper(opts.LocalStorageCertDir)
+		if err != nil {
+			logger.Warn("unable to trust the CA of the local storage registry: %v", err)
+			localStorageRoundTripper = remote.DefaultTransport
+		}
+		remoteOptions = append(remoteOptions, remote.WithTransport(localStorageRoundTripper))
 	} else {
 		remoteOptions = append(remoteOptions, remote.WithTransport(remote.DefaultTransport))
 	}
@@ -124,6 +133,28 @@ func createInsecureRoundTripper() http.RoundTripper {
 	}
 }
 
+// createLocalStorageRoundTripper returns a transport trusting the system CAs
+// and the CA (common.LocalStorageCAFile) found in certDir
+func createLocalStorageRoundTripper(certDir string) (http.RoundTripper, error) {
+	ca, err := os.ReadFile(filepath.Join(certDir, common.LocalStorageCAFile))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", filepath.Join(certDir, common.LocalStorageCAFile))
+	}
+	transport := remote.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    rootCAs,
+		MinVersion: tls.VersionTLS12,
+	}
+	return transport, nil
+}
+
 // Run modifies and pushes the catalog image existing in an OCI layout. The image configuration will be updated
 // with the required labels and any provided layers will be appended.
 // # Arguments
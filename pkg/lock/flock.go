@@ -0,0 +1,91 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const flockRetryInterval = 100 * time.Millisecond
+
+// BusyError is returned when a SharedLock can't be acquired in the requested mode before the timeout
+type BusyError struct {
+	Path      string
+	Exclusive bool
+}
+
+func (e *BusyError) Error() string {
+	if e.Exclusive {
+		return fmt.Sprintf("%s is in use by another process", e.Path)
+	}
+	return fmt.Sprintf("%s is held exclusively by another process", e.Path)
+}
+
+func (e *BusyError) Is(err error) bool {
+	_, ok := err.(*BusyError)
+	return ok
+}
+
+// SharedLock is a readers-writer lock between the processes of a host (flock).
+// Unlike FileLock, it is released by the system when its process exits: it never goes stale.
+type SharedLock struct {
+	file *os.File
+}
+
+// AcquireShared takes the lock `path` in shared mode: any number of processes can hold it
+// in shared mode, as long as no process holds it in exclusive mode.
+// It waits up to timeout for the lock to be available, 0 means a single attempt.
+func AcquireShared(path string, timeout time.Duration) (*SharedLock, error) {
+	return acquireFlock(path, syscall.LOCK_SH, timeout)
+}
+
+// AcquireExclusive takes the lock `path` in exclusive mode: no other process can hold it.
+// It waits up to timeout for the lock to be available, 0 means a single attempt.
+func AcquireExclusive(path string, timeout time.Duration) (*SharedLock, error) {
+	return acquireFlock(path, syscall.LOCK_EX, timeout)
+}
+
+func acquireFlock(path string, how int, timeout time.Duration) (*SharedLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire lock %s: %w", path, err)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return &SharedLock{file: file}, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			file.Close()
+			return nil, fmt.Errorf("unable to acquire lock %s: %w", path, err)
+		}
+		if !time.Now().Add(flockRetryInterval).Before(deadline) {
+			file.Close()
+			return nil, &BusyError{Path: path, Exclusive: how == syscall.LOCK_EX}
+		}
+		time.Sleep(flockRetryInterval)
+	}
+}
+
+// Release releases the lock. The lock file is kept: removing it would let another
+// process lock a new file while a third one still holds the old one.
+func (o *SharedLock) Release() error {
+	defer o.file.Close()
+	if err := syscall.Flock(int(o.file.Fd()), syscall.LOCK_UN); err != nil {
+		return fmt.Errorf("unable to release lock %s: %w", o.file.Name(), err)
+	}
+	return nil
+}
+
+// IsAlive checks whether the owner of a lock may still be running:
+// the processes of other hosts are always considered alive
+func IsAlive(owner Owner) bool {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return true
+	}
+	return !isStale(owner, hostname)
+}
@@ -0,0 +1,184 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	takeoverSuffix  = ".takeover"
+	takeoverTimeout = 10 * time.Second
+)
+
+// Owner describes the process holding a lock
+type Owner struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+}
+
+// CurrentOwner describes the current process, as the owner of a lock created now
+func CurrentOwner() (Owner, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return Owner{}, fmt.Errorf("%w", err)
+	}
+	return Owner{
+		PID:      os.Getpid(),
+		Hostname: hostname,
+		Created:  time.Now().UTC(),
+	}, nil
+}
+
+// SameProcess checks whether two owners are the same process
+func (o Owner) SameProcess(other Owner) bool {
+	return o.PID == other.PID && o.Hostname == other.Hostname
+}
+
+// LockedError is returned when the lock is held by another live process
+type LockedError struct {
+	Path  string
+	Owner Owner
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is held by process %d on %s since %s: wait for it to complete, or remove %s if that process is gone",
+		e.Path, e.Owner.PID, e.Owner.Hostname, e.Owner.Created.Format(time.RFC3339), e.Path)
+}
+
+func (e *LockedError) Is(err error) bool {
+	_, ok := err.(*LockedError)
+	return ok
+}
+
+// FileLock is an exclusive lock materialized by a file containing its Owner
+type FileLock struct {
+	path  string
+	owner Owner
+}
+
+// Acquire takes the exclusive lock `path`.
+// The lock file is created atomically with its content, so that other processes
+// always read a complete Owner.
+// A lock left behind by a process that no longer runs on this host is stale: it is taken over.
+// Locks held from other hosts are never considered stale.
+func Acquire(path string) (*FileLock, error) {
+	owner, err := CurrentOwner()
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire lock %s: %w", path, err)
+	}
+	hostname := owner.Hostname
+	content, err := json.Marshal(owner)
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire lock %s: %w", path, err)
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire lock %s: %w", path, err)
+	}
+	tmp := tmpFile.Name()
+	defer os.Remove(tmp)
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire lock %s: %w", path, err)
+	}
+
+	// one attempt, plus one after removing a stale lock
+	for attempt := 0; attempt < 2; attempt++ {
+		err := os.Link(tmp, path)
+		if err == nil {
+			return &FileLock{path: path, owner: owner}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("unable to acquire lock %s: %w", path, err)
+		}
+		holder, err := ReadOwner(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// released in the meantime
+			continue
+		}
+		if err == nil && !isStale(holder, hostname) {
+			return nil, &LockedError{Path: path, Owner: holder}
+		}
+		if err := removeStale(path, hostname); err != nil {
+			return nil, err
+		}
+	}
+	holder, err := ReadOwner(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to acquire lock %s", path)
+	}
+	return nil, &LockedError{Path: path, Owner: holder}
+}
+
+// removeStale removes the lock `path` if it is stale, or its content is not readable: it can't belong to a live process.
+// Processes taking over a stale lock are serialized by the flock of a takeover file, and the lock is checked again once
+// it is held: another process may have taken over the lock and acquired it in the meantime, its lock must not be removed.
+func removeStale(path, hostname string) error {
+	takeover, err := AcquireExclusive(path+takeoverSuffix, takeoverTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to remove stale lock %s: %w", path, err)
+	}
+	defer takeover.Release()
+	holder, err := ReadOwner(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err == nil && !isStale(holder, hostname) {
+		return &LockedError{Path: path, Owner: holder}
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to remove stale lock %s: %w", path, err)
+	}
+	return nil
+}
+
+// Release removes the lock, if it is still held by its owner
+func (o *FileLock) Release() error {
+	holder, err := ReadOwner(o.path)
+	if err != nil {
+		return fmt.Errorf("unable to release lock %s: %w", o.path, err)
+	}
+	if holder.PID != o.owner.PID || holder.Hostname != o.owner.Hostname || !holder.Created.Equal(o.owner.Created) {
+		return fmt.Errorf("unable to release lock %s: it was taken over by process %d on %s", o.path, holder.PID, holder.Hostname)
+	}
+	if err := os.Remove(o.path); err != nil {
+		return fmt.Errorf("unable to release lock %s: %w", o.path, err)
+	}
+	return nil
+}
+
+// ReadOwner reads the owner of the lock `path`
+func ReadOwner(path string) (Owner, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Owner{}, fmt.Errorf("%w", err)
+	}
+	var owner Owner
+	if err := json.Unmarshal(content, &owner); err != nil {
+		return Owner{}, fmt.Errorf("invalid lock file %s: %w", path, err)
+	}
+	return owner, nil
+}
+
+// isStale checks whether the owner of a lock is a process of this host that doesn't run anymore
+func isStale(owner Owner, hostname string) bool {
+	if owner.Hostname != hostname {
+		return false
+	}
+	if owner.PID <= 0 {
+		return true
+	}
+	err := syscall.Kill(owner.PID, syscall.Signal(0))
+	// EPERM: the process exists, but belongs to another user
+	return errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,130 @@
+package lock
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquire(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	exited := exec.Command("true")
+	require.NoError(t, exited.Run())
+
+	type testCase struct {
+		caseName      string
+		existingOwner *Owner
+		expectedError bool
+	}
+	testCases := []testCase{
+		{
+			caseName: "free lock should be acquired",
+		},
+		{
+			caseName:      "lock held by a live process should fail",
+			existingOwner: &Owner{PID: os.Getppid(), Hostname: hostname, Created: time.Now()},
+			expectedError: true,
+		},
+		{
+			caseName:      "lock held by a process of another host should fail",
+			existingOwner: &Owner{PID: exited.Process.Pid, Hostname: "other-" + hostname, Created: time.Now()},
+			expectedError: true,
+		},
+		{
+			caseName:      "stale lock of a process gone should be taken over",
+			existingOwner: &Owner{PID: exited.Process.Pid, Hostname: hostname, Created: time.Now()},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			lockPath := filepath.Join(t.TempDir(), "test.lock")
+			if testCase.existingOwner != nil {
+				content, err := json.Marshal(testCase.existingOwner)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(lockPath, content, 0644))
+			}
+			l, err := Acquire(lockPath)
+			if testCase.expectedError {
+				require.ErrorIs(t, err, &LockedError{})
+				return
+			}
+			require.NoError(t, err)
+			owner, err := ReadOwner(lockPath)
+			require.NoError(t, err)
+			require.Equal(t, os.Getpid(), owner.PID)
+
+			_, err = Acquire(lockPath)
+			require.ErrorIs(t, err, &LockedError{})
+
+			require.NoError(t, l.Release())
+			require.NoFileExists(t, lockPath)
+		})
+	}
+}
+
+func TestAcquireStaleConcurrently(t *testing.T) {
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	exited := exec.Command("true")
+	require.NoError(t, exited.Run())
+
+	for i := 0; i < 20; i++ {
+		lockPath := filepath.Join(t.TempDir(), "test.lock")
+		content, err := json.Marshal(Owner{PID: exited.Process.Pid, Hostname: hostname, Created: time.Now()})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(lockPath, content, 0644))
+
+		// the stale lock is taken over once: the other attempts find it held
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for j := 0; j < cap(errs); j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := Acquire(lockPath)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		acquired := 0
+		for err := range errs {
+			if err == nil {
+				acquired++
+				continue
+			}
+			require.ErrorIs(t, err, &LockedError{})
+		}
+		require.Equal(t, 1, acquired)
+	}
+}
+
+func TestSharedLock(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), "test.lock")
+
+	first, err := AcquireShared(lockPath, 0)
+	require.NoError(t, err)
+	second, err := AcquireShared(lockPath, 0)
+	require.NoError(t, err)
+
+	// exclusive while shared holders remain
+	_, err = AcquireExclusive(lockPath, 200*time.Millisecond)
+	require.ErrorIs(t, err, &BusyError{})
+
+	require.NoError(t, first.Release())
+	require.NoError(t, second.Release())
+	exclusive, err := AcquireExclusive(lockPath, 0)
+	require.NoError(t, err)
+
+	_, err = AcquireShared(lockPath, 0)
+	require.ErrorIs(t, err, &BusyError{})
+	require.NoError(t, exclusive.Release())
+	require.FileExists(t, lockPath)
+}
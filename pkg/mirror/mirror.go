@@ -25,6 +25,7 @@ type Mode string
 type MirrorInterface interface {
 	Copy(ctx context.Context, src, dest string, opts *common.MirrorOptions) (retErr error)
 	Delete(ctx context.Context, dest string, opts *common.MirrorOptions) (retErr error)
+	CopySigstoreArtifacts(ctx context.Context, src, dest string, opts *common.MirrorOptions) error
 }
 
 type MirrorController struct {
@@ -63,15 +64,15 @@ func (o MirrorController) Copy(ctx context.Context, src, dest string, opts *comm
 	}
 
 	sourceCtx := opts.NewSystemContext()
-	if strings.Contains(src, opts.LocalStorageFQDN) { // when copying from cache, use HTTP
-		sourceCtx.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	if strings.Contains(src, opts.LocalStorageFQDN) { // when copying from cache, use HTTP or the TLS of the run
+		opts.SetLocalStorageTLS(sourceCtx)
 	}
 
 	destinationCtx := opts.NewSystemContext()
 
 	if strings.Contains(dest, opts.LocalStorageFQDN) {
-		// when copying to cache, use HTTP
-		destinationCtx.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+		// when copying to cache, use HTTP or the TLS of the run
+		opts.SetLocalStorageTLS(destinationCtx)
 	}
 
 	var manifestType string
@@ -221,8 +222,8 @@ func (o MirrorController) Delete(ctx context.Context, image string, opts *common
 
 	sysCtx := opts.NewSystemContext()
 
-	if strings.Contains(image, opts.LocalStorageFQDN) { // when copying to cache, use HTTP
-		sysCtx.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	if strings.Contains(image, opts.LocalStorageFQDN) { // when copying to cache, use HTTP or the TLS of the run
+		opts.SetLocalStorageTLS(sysCtx)
 	}
 
 	// nolint: wrapcheck
@@ -0,0 +1,209 @@
+package mirror
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containers/common/pkg/retry"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+)
+
+const dockerProtocol = "docker://"
+
+// SigstoreTagSuffixes are the suffixes of the tags cosign attaches its artifacts to an image with
+// (sha256-<digest>.sig, .att and .sbom). The empty suffix is the tag of the referrers tag schema
+// (sha256-<digest>), which tracks the OCI 1.1 referrers in the registries without referrers API.
+var SigstoreTagSuffixes = []string{".sig", ".att", ".sbom", ""}
+
+// SigstoreArtifacts returns the cosign signatures, attestations and SBOMs attached to image (docker://...):
+// the tags of its repository following the cosign tag convention, and the digests of its OCI 1.1 referrers.
+// Tags and digests are told apart by the colon of the digests.
+func SigstoreArtifacts(ctx context.Context, image string, opts *common.MirrorOptions) ([]string, error) {
+	ref, remoteOpts, err := sigstoreReference(ctx, image, opts)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := remote.Head(ref, remoteOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the digest of %s: %w", image, err)
+	}
+
+	artifacts := []string{}
+	tagPrefix := desc.Digest.Algorithm + "-" + desc.Digest.Hex
+	for _, suffix := range SigstoreTagSuffixes {
+		tag := tagPrefix + suffix
+		_, err := remote.Head(ref.Context().Tag(tag), remoteOpts...)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to look up the sigstore artifact %s of %s: %w", tag, image, err)
+		}
+		artifacts = append(artifacts, tag)
+	}
+
+	referrers, err := remote.Referrers(ref.Context().Digest(desc.Digest.String()), remoteOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the referrers of %s: %w", image, err)
+	}
+	index, err := referrers.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the referrers of %s: %w", image, err)
+	}
+	for _, referrer := range index.Manifests {
+		artifacts = append(artifacts, referrer.Digest.String())
+	}
+	return artifacts, nil
+}
+
+// CopySigstoreArtifacts copies the sigstore artifacts attached to src (see SigstoreArtifacts) to the repository of dest,
+// keeping their tags and digests. src and dest are docker:// references: other transports have no artifacts.
+// dest must have the digest of src, the artifacts refer to it.
+// The referrers written to a registry without referrers API are tracked in its referrers tag schema.
+func (o MirrorController) CopySigstoreArtifacts(ctx context.Context, src, dest string, opts *common.MirrorOptions) error {
+	if !strings.HasPrefix(src, dockerProtocol) || !strings.HasPrefix(dest, dockerProtocol) {
+		return nil
+	}
+	artifacts, err := SigstoreArtifacts(ctx, src, opts)
+	if err != nil {
+		return err
+	}
+	if len(artifacts) == 0 {
+		return nil
+	}
+	srcRef, srcOpts, err := sigstoreReference(ctx, src, opts)
+	if err != nil {
+		return err
+	}
+	destRef, destOpts, err := sigstoreReference(ctx, dest, opts)
+	if err != nil {
+		return err
+	}
+	// the artifacts are attached to the digest of src: they only apply to dest when it was copied as is
+	// (i.e. not a single image of a manifest list)
+	srcDesc, err := remote.Head(srcRef, srcOpts...)
+	if err != nil {
+		return fmt.Errorf("unable to find the digest of %s: %w", src, err)
+	}
+	destDesc, err := remote.Head(destRef, destOpts...)
+	if err != nil {
+		return fmt.Errorf("unable to find the digest of %s: %w", dest, err)
+	}
+	if srcDesc.Digest != destDesc.Digest {
+		return fmt.Errorf("unable to copy the sigstore artifacts of %s: they are attached to %s, while %s was mirrored as %s", src, srcDesc.Digest, dest, destDesc.Digest)
+	}
+
+	for _, artifact := range artifacts {
+		srcArtifact := artifactReference(srcRef.Context(), artifact)
+		destArtifact := artifactReference(destRef.Context(), artifact)
+		// nolint: wrapcheck
+		err := retry.IfNecessary(ctx, func() error {
+			desc, err := remote.Get(srcArtifact, srcOpts...)
+			if err != nil {
+				return fmt.Errorf("%w", err)
+			}
+			if desc.MediaType.IsIndex() {
+				index, err := desc.ImageIndex()
+				if err != nil {
+					return fmt.Errorf("%w", err)
+				}
+				return remote.WriteIndex(destArtifact, index, destOpts...)
+			}
+			img, err := desc.Image()
+			if err != nil {
+				return fmt.Errorf("%w", err)
+			}
+			return remote.Write(destArtifact, img, destOpts...)
+		}, opts.RetryOpts)
+		if err != nil {
+			return fmt.Errorf("unable to copy the sigstore artifact %s of %s: %w", artifact, src, err)
+		}
+		o.Log.Debug("copied the sigstore artifact %s to %s", srcArtifact, destArtifact)
+	}
+	return nil
+}
+
+// SigstoreArtifactImage returns the docker:// reference of artifact, as returned by SigstoreArtifacts,
+// in the repository of image
+func SigstoreArtifactImage(image, artifact string) (string, error) {
+	ref, err := name.ParseReference(strings.TrimPrefix(image, dockerProtocol))
+	if err != nil {
+		return "", fmt.Errorf("invalid image reference %s: %w", image, err)
+	}
+	return dockerProtocol + artifactReference(ref.Context(), artifact).String(), nil
+}
+
+// artifactReference returns the reference of artifact, a tag or a digest, in repo
+func artifactReference(repo name.Repository, artifact string) name.Reference {
+	if strings.Contains(artifact, ":") {
+		return repo.Digest(artifact)
+	}
+	return repo.Tag(artifact)
+}
+
+// sigstoreReference parses image (docker://...) and returns the options to reach its registry,
+// like the system contexts of Copy: the local storage registry with its TLS settings,
+// the other registries with the credentials of the default keychain
+func sigstoreReference(ctx context.Context, image string, opts *common.MirrorOptions) (name.Reference, []remote.Option, error) {
+	nameOpts := []name.Option{}
+	remoteOpts := []remote.Option{
+		remote.WithAuthFromKeychain(authn.DefaultKeychain),
+		remote.WithContext(ctx),
+		remote.WithUserAgent("oc-mirror"),
+	}
+	httpTransport := remote.DefaultTransport.(*http.Transport).Clone()
+	switch {
+	case opts.LocalStorageFQDN != "" && strings.Contains(image, opts.LocalStorageFQDN) && opts.LocalStorageCertDir != "":
+		rootCAs, err := localStorageCAs(opts.LocalStorageCertDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		httpTransport.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	case opts.LocalStorageFQDN != "" && strings.Contains(image, opts.LocalStorageFQDN) || !opts.DestinationTlsVerify:
+		nameOpts = append(nameOpts, name.Insecure)
+		httpTransport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true, // #nosec G402
+			MinVersion:         tls.VersionTLS12,
+		}
+	}
+	remoteOpts = append(remoteOpts, remote.WithTransport(httpTransport))
+
+	ref, err := name.ParseReference(strings.TrimPrefix(image, dockerProtocol), nameOpts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid image reference %s: %w", image, err)
+	}
+	return ref, remoteOpts, nil
+}
+
+// localStorageCAs returns the system CAs along with the CA of the local storage registry found in certDir
+func localStorageCAs(certDir string) (*x509.CertPool, error) {
+	ca, err := os.ReadFile(filepath.Join(certDir, common.LocalStorageCAFile))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s", filepath.Join(certDir, common.LocalStorageCAFile))
+	}
+	return rootCAs, nil
+}
+
+func isNotFound(err error) bool {
+	var transportErr *transport.Error
+	return errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,141 @@
+package mirror
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/containers/common/pkg/retry"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestCopySigstoreArtifacts(t *testing.T) {
+	// the source registry serves the referrers API, the destination registry relies on the referrers tag schema
+	srcRegistry := httptest.NewServer(registry.New(registry.WithReferrersSupport(true), registry.Logger(log.New(io.Discard, "", 0))))
+	defer srcRegistry.Close()
+	destRegistry := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer destRegistry.Close()
+	srcHost := strings.TrimPrefix(srcRegistry.URL, "http://")
+	destHost := strings.TrimPrefix(destRegistry.URL, "http://")
+
+	img, err := random.Image(1024, 1)
+	require.NoError(t, err)
+	imgDigest, err := img.Digest()
+	require.NoError(t, err)
+	signature, err := random.Image(256, 1)
+	require.NoError(t, err)
+	sbom, err := random.Image(256, 1)
+	require.NoError(t, err)
+	imgMediaType, err := img.MediaType()
+	require.NoError(t, err)
+	sbom = mutate.Subject(mutate.MediaType(sbom, types.OCIManifestSchema1), v1.Descriptor{
+		MediaType: imgMediaType,
+		Digest:    imgDigest,
+		Size:      mustSize(t, img),
+	}).(v1.Image)
+	sbomDigest, err := sbom.Digest()
+	require.NoError(t, err)
+
+	signatureTag := imgDigest.Algorithm + "-" + imgDigest.Hex + ".sig"
+	pushTestImage(t, srcHost+"/ubi9/ubi:latest", img)
+	pushTestImage(t, srcHost+"/ubi9/ubi:"+signatureTag, signature)
+	pushTestImage(t, srcHost+"/ubi9/ubi@"+sbomDigest.String(), sbom)
+	// the image itself is copied by Copy
+	pushTestImage(t, destHost+"/mirror/ubi9/ubi:latest", img)
+
+	opts := &common.MirrorOptions{RetryOpts: &retry.Options{}}
+	artifacts, err := SigstoreArtifacts(context.Background(), "docker://"+srcHost+"/ubi9/ubi:latest", opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{signatureTag, sbomDigest.String()}, artifacts)
+
+	mirror := New(clog.New("error"), opts)
+	err = mirror.CopySigstoreArtifacts(context.Background(), "docker://"+srcHost+"/ubi9/ubi:latest", "docker://"+destHost+"/mirror/ubi9/ubi:latest", opts)
+	require.NoError(t, err)
+
+	// the referrer is tracked by the referrers tag schema of the destination
+	artifacts, err = SigstoreArtifacts(context.Background(), "docker://"+destHost+"/mirror/ubi9/ubi:latest", opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{signatureTag, imgDigest.Algorithm + "-" + imgDigest.Hex, sbomDigest.String()}, artifacts)
+
+	artifactImage, err := SigstoreArtifactImage("docker://"+destHost+"/mirror/ubi9/ubi:latest", sbomDigest.String())
+	require.NoError(t, err)
+	require.Equal(t, "docker://"+destHost+"/mirror/ubi9/ubi@"+sbomDigest.String(), artifactImage)
+
+	// images of other transports have no artifacts
+	require.NoError(t, mirror.CopySigstoreArtifacts(context.Background(), "oci:///tmp/catalog", "docker://"+destHost+"/mirror/catalog:latest", opts))
+}
+
+func TestCopySigstoreArtifactsOfIndex(t *testing.T) {
+	srcRegistry := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer srcRegistry.Close()
+	destRegistry := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer destRegistry.Close()
+	srcHost := strings.TrimPrefix(srcRegistry.URL, "http://")
+	destHost := strings.TrimPrefix(destRegistry.URL, "http://")
+
+	index, err := random.Index(1024, 1, 2)
+	require.NoError(t, err)
+	indexDigest, err := index.Digest()
+	require.NoError(t, err)
+	indexManifest, err := index.IndexManifest()
+	require.NoError(t, err)
+	instance, err := index.Image(indexManifest.Manifests[0].Digest)
+	require.NoError(t, err)
+	signature, err := random.Image(256, 1)
+	require.NoError(t, err)
+
+	// the signature is attached to the digest of the manifest list
+	signatureTag := indexDigest.Algorithm + "-" + indexDigest.Hex + ".sig"
+	pushTestIndex(t, srcHost+"/ubi9/ubi:latest", index)
+	pushTestImage(t, srcHost+"/ubi9/ubi:"+signatureTag, signature)
+
+	opts := &common.MirrorOptions{RetryOpts: &retry.Options{}}
+	mirror := New(clog.New("error"), opts)
+
+	// the manifest list mirrored as is
+	pushTestIndex(t, destHost+"/mirror/ubi9/ubi:latest", index)
+	err = mirror.CopySigstoreArtifacts(context.Background(), "docker://"+srcHost+"/ubi9/ubi:latest", "docker://"+destHost+"/mirror/ubi9/ubi:latest", opts)
+	require.NoError(t, err)
+	artifacts, err := SigstoreArtifacts(context.Background(), "docker://"+destHost+"/mirror/ubi9/ubi:latest", opts)
+	require.NoError(t, err)
+	require.Equal(t, []string{signatureTag}, artifacts)
+
+	// a single image of the manifest list: the signature would be orphaned
+	pushTestImage(t, destHost+"/mirror/ubi9/single:latest", instance)
+	err = mirror.CopySigstoreArtifacts(context.Background(), "docker://"+srcHost+"/ubi9/ubi:latest", "docker://"+destHost+"/mirror/ubi9/single:latest", opts)
+	require.ErrorContains(t, err, "was mirrored as")
+	artifacts, err = SigstoreArtifacts(context.Background(), "docker://"+destHost+"/mirror/ubi9/single:latest", opts)
+	require.NoError(t, err)
+	require.Empty(t, artifacts)
+}
+
+func pushTestIndex(t *testing.T, ref string, index v1.ImageIndex) {
+	parsed, err := name.ParseReference(ref)
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(parsed, index))
+}
+
+func pushTestImage(t *testing.T, ref string, img v1.Image) {
+	parsed, err := name.ParseReference(ref)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(parsed, img))
+}
+
+func mustSize(t *testing.T, img v1.Image) int64 {
+	size, err := img.Size()
+	require.NoError(t, err)
+	return size
+}
@@ -7,7 +7,6 @@ import (
 	"path"
 	"strings"
 
-	"github.com/containers/image/v5/types"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/image"
@@ -138,8 +137,8 @@ func (o Operator) catalogDigest(ctx context.Context, catalog v2alpha1.Operator)
 
 	sourceCtx := o.Options.NewSystemContext()
 	// OCPBUGS-37948 : No TLS verification when getting manifests from the cache registry
-	if strings.Contains(src, o.Options.LocalStorageFQDN) { // when copying from cache, use HTTP
-		sourceCtx.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	if strings.Contains(src, o.Options.LocalStorageFQDN) { // when copying from cache, use HTTP or the TLS of the run
+		o.Options.SetLocalStorageTLS(sourceCtx)
 	}
 
 	catalogDigest, err := manifest.GetDigest(ctx, sourceCtx, imgSpec.ReferenceWithTransport)
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/common"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/emoji"
@@ -46,18 +47,9 @@ func (o *GraphUpdate) Create(url string) (string, error) {
 		// try download the graph data
 		if len(image) == 0 && err != nil && o.Options.IsMirrorToDisk() {
 			o.Log.Info(emoji.RepeatSingleButton + " building graph image")
-			// HTTP Get the graph updates from api endpoint
-			// #nosec G107
-			// nolint: noctx
-			resp, err := http.Get(url)
+			body, err := o.graphData(url)
 			if err != nil {
-				return "", fmt.Errorf("%w", err)
-			}
-			defer resp.Body.Close()
-
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				return "", fmt.Errorf("%w", err)
+				return "", err
 			}
 
 			// save graph data in a container layer modifying UID and GID to root.
@@ -74,11 +66,11 @@ func (o *GraphUpdate) Create(url string) (string, error) {
 				return "", fmt.Errorf("%w", err)
 			}
 
-			// Use the imgBuilder to pull the ubi9 image to layoutDir
+			// Use the imgBuilder to save the base image (ubi9 by default) to layoutDir
 			builder := imagebuilder.NewBuilder(o.Log, *o.Options)
-			layoutPath, err := builder.SaveImageLayoutToDir(ctx, graphBaseImage, layoutDir)
+			layoutPath, err := o.saveBaseImageLayout(ctx, builder, layoutDir)
 			if err != nil {
-				return "", fmt.Errorf("%w", err)
+				return "", err
 			}
 
 			// preprare the CMD to []string{"/bin/bash", "-c", fmt.Sprintf("exec cp -rp %s/* %s", graphDataDir, graphDataMountPath)}
@@ -98,6 +90,64 @@ func (o *GraphUpdate) Create(url string) (string, error) {
 	return "", nil
 }
 
+// graphData returns the graph data tarball (tar.gz): the one of graphData.tarball in the image set configuration,
+// or the one downloaded from url
+func (o *GraphUpdate) graphData(url string) ([]byte, error) {
+	if tarball := o.Config.Mirror.Platform.GraphData.Tarball; tarball != "" {
+		o.Log.Debug("using graph data %s", tarball)
+		body, err := os.ReadFile(tarball)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read graph data: %w", err)
+		}
+		return body, nil
+	}
+
+	// HTTP Get the graph updates from api endpoint
+	// #nosec G107
+	// nolint: noctx
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return body, nil
+}
+
+// saveBaseImageLayout saves the base image of the graph image to layoutDir: the one of graphData.baseImage
+// in the image set configuration, either an OCI layout or an image of the cache, or ubi9 by default
+func (o *GraphUpdate) saveBaseImageLayout(ctx context.Context, builder *imagebuilder.ImageBuilder, layoutDir string) (layout.Path, error) {
+	baseImage := o.Config.Mirror.Platform.GraphData.BaseImage
+	switch {
+	case baseImage == "":
+		baseImage = graphBaseImage
+	case strings.HasPrefix(baseImage, ociProtocol):
+		o.Log.Debug("using graph base image %s", baseImage)
+		// the layout is copied: building the graph image rewrites the index of layoutDir
+		idx, err := layout.ImageIndexFromPath(strings.TrimPrefix(baseImage, ociProtocol))
+		if err != nil {
+			return "", fmt.Errorf("unable to read graph base image %s: %w", baseImage, err)
+		}
+		layoutPath, err := layout.Write(layoutDir, idx)
+		if err != nil {
+			return "", fmt.Errorf("unable to save graph base image %s: %w", baseImage, err)
+		}
+		return layoutPath, nil
+	default:
+		o.Log.Debug("using graph base image %s from the cache", baseImage)
+		baseImage = filepath.Join(o.Options.LocalStorageFQDN, baseImage)
+	}
+	layoutPath, err := builder.SaveImageLayoutToDir(ctx, baseImage, layoutDir)
+	if err != nil {
+		return "", fmt.Errorf("unable to save graph base image %s: %w", baseImage, err)
+	}
+	return layoutPath, nil
+}
+
 func (o *GraphUpdate) graphImageInWorkingDir(ctx context.Context) (string, error) {
 	var layoutDir string
 	fullPath, _ := os.Getwd()
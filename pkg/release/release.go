@@ -17,6 +17,7 @@ import (
 	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/manifest"
 	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/mirror"
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/signature"
 	digest "github.com/opencontainers/go-digest"
 	"gopkg.in/yaml.v2"
 )
@@ -77,6 +78,14 @@ func (o CollectRelease) Collect() (v2alpha1.CollectorSchema, error) {
 	cs := v2alpha1.CollectorSchema{}
 	if o.Options.IsMirrorToDisk() || o.Options.IsMirrorToMirror() {
 		ctx := context.Background()
+		var releaseSignatures signature.SignatureInterface
+		if signature.Enabled(o.Config.Mirror.Platform.Signatures) && !o.Options.DryRun {
+			rs, err := signature.New(o.Log, o.Config.Mirror.Platform.Signatures, o.Options.WorkingDir)
+			if err != nil {
+				return cs, err
+			}
+			releaseSignatures = rs
+		}
 		for _, img := range o.Config.Mirror.Platform.Releases {
 			hld := strings.Split(img.Name, "/")
 			releaseRepoAndTag := hld[len(hld)-1]
@@ -126,6 +135,12 @@ func (o CollectRelease) Collect() (v2alpha1.CollectorSchema, error) {
 			mfst := validDigest.Encoded()
 			o.Log.Debug(collectorPrefix+"image manifest digest %s", mfst)
 
+			if releaseSignatures != nil {
+				if err := releaseSignatures.AcquireReleaseSignature(ctx, imgSpec.Tag, validDigest); err != nil {
+					return cs, err
+				}
+			}
+
 			manifestDir := filepath.Join(dir, blobsDir, mfst)
 			m, err := manifest.GetImageManifest(manifestDir)
 			if err != nil {
@@ -166,7 +181,7 @@ func (o CollectRelease) Collect() (v2alpha1.CollectorSchema, error) {
 		}
 
 		if o.Config.Mirror.Platform.Graph {
-			graphImage, err := handleGraphImage(ctx, o.Options)
+			graphImage, err := handleGraphImage(ctx, o.Options, o.Config.Mirror.Platform.GraphData)
 			if err != nil {
 				o.Log.Warn("could not process graph image - SKIPPING: %v", err)
 			} else if graphImage.Source != "" {
@@ -398,8 +413,10 @@ func getKubeVirtImage(releaseArtifactsDir string) (v2alpha1.RelatedImage, error)
 	return kubeVirtImage, nil
 }
 
-func handleGraphImage(ctx context.Context, opts *common.MirrorOptions) (v2alpha1.CopyImageSchema, error) {
-	if updateURLOverride := os.Getenv("UPDATE_URL_OVERRIDE"); len(updateURLOverride) != 0 {
+func handleGraphImage(ctx context.Context, opts *common.MirrorOptions, graphData v2alpha1.GraphData) (v2alpha1.CopyImageSchema, error) {
+	// with local graph data (graphData.tarball), the graph image is built and pushed as usual:
+	// UPDATE_URL_OVERRIDE is not needed
+	if updateURLOverride := os.Getenv("UPDATE_URL_OVERRIDE"); len(updateURLOverride) != 0 && graphData.Tarball == "" {
 
 		// OCPBUGS-38037: this indicates that the official cincinnati API is not reacheable
 		// and that graph image cannot be rebuilt on top the complete graph in tar.gz format
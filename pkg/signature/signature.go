@@ -0,0 +1,260 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	digest "github.com/opencontainers/go-digest"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+const (
+	// DefaultStore is the signature store of the OpenShift releases
+	DefaultStore = "https://mirror.openshift.com/pub/openshift-v4/signatures/openshift/release"
+	// DefaultPublicKey is the key the OpenShift releases are signed with, as installed on RHEL
+	DefaultPublicKey = "/etc/pki/rpm-gpg/RPM-GPG-KEY-redhat-release"
+	// SignaturesDir is the directory of the working-dir the verified signatures are stored in
+	SignaturesDir = "signatures"
+
+	signatureType       = "atomic container signature"
+	maxSignaturesLookup = 10
+	fileProtocol        = "file://"
+	httpTimeout         = 30 * time.Second
+	signaturePrefix     = "[ReleaseSignatures] "
+	pgpArmorHeader      = "-----BEGIN PGP"
+)
+
+// errNoSignature is returned by a store without (more) signatures for a release
+var errNoSignature = errors.New("no signature found")
+
+type SignatureInterface interface {
+	AcquireReleaseSignature(ctx context.Context, releaseTag string, releaseDigest digest.Digest) error
+}
+
+// ReleaseSignatures acquires the detached signatures of the releases from the signature stores,
+// verifies them with the public keys, and stores the valid ones in the signatures directory of the working-dir
+type ReleaseSignatures struct {
+	Log        clog.PluggableLoggerInterface
+	Config     v2alpha1.ReleaseSignatures
+	WorkingDir string
+	Client     *http.Client
+	keyRing    openpgp.EntityList
+}
+
+// Enabled reports whether the signatures of the releases are acquired with cfg: acquiring them is opt-in,
+// with a policy other than skip, or with signature stores or public keys. Otherwise every run would wait
+// for the default store, out of reach of the disconnected and proxied hosts.
+func Enabled(cfg v2alpha1.ReleaseSignatures) bool {
+	if cfg.Policy != "" {
+		return cfg.Policy != v2alpha1.SignaturePolicySkip
+	}
+	return len(cfg.Stores) > 0 || len(cfg.PublicKeys) > 0
+}
+
+// New returns the ReleaseSignatures of cfg. The public keys are read once: when none is available,
+// the signatures can't be verified, which fails with the require policy.
+func New(log clog.PluggableLoggerInterface, cfg v2alpha1.ReleaseSignatures, workingDir string) (*ReleaseSignatures, error) {
+	if len(cfg.Stores) == 0 {
+		cfg.Stores = []string{DefaultStore}
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = v2alpha1.SignaturePolicyWarn
+	}
+	publicKeys := cfg.PublicKeys
+	if len(publicKeys) == 0 {
+		publicKeys = []string{DefaultPublicKey}
+	}
+	keyRing := openpgp.EntityList{}
+	for _, publicKey := range publicKeys {
+		keys, err := readPublicKeys(publicKey)
+		if err != nil && len(cfg.PublicKeys) == 0 && errors.Is(err, os.ErrNotExist) {
+			// the default key is only installed on RHEL
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		keyRing = append(keyRing, keys...)
+	}
+	if len(keyRing) == 0 {
+		if cfg.Policy == v2alpha1.SignaturePolicyRequire {
+			return nil, fmt.Errorf(signaturePrefix+"no public key to verify the release signatures with: %s not found, set platform.signatures.publicKeys", DefaultPublicKey)
+		}
+		log.Warn(signaturePrefix+"no public key to verify the release signatures with: %s not found, the release signatures are not acquired", DefaultPublicKey)
+	}
+	return &ReleaseSignatures{
+		Log:        log,
+		Config:     cfg,
+		WorkingDir: workingDir,
+		Client:     &http.Client{Timeout: httpTimeout},
+		keyRing:    keyRing,
+	}, nil
+}
+
+// readPublicKeys reads the GPG public keys of the file, armored or binary
+func readPublicKeys(file string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf(signaturePrefix+"unable to read the public key: %w", err)
+	}
+	var keys openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(pgpArmorHeader)) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf(signaturePrefix+"invalid public key %s: %w", file, err)
+	}
+	return keys, nil
+}
+
+// FileName returns the name of the file of the signature of the release releaseTag, with releaseDigest,
+// in the signatures directory (i.e. 4.18.1-x86_64-sha256-<hex>). The releases by digest use the digest as tag.
+func FileName(releaseTag string, releaseDigest digest.Digest) string {
+	if releaseTag == "" {
+		releaseTag = releaseDigest.Encoded()
+	}
+	return releaseTag + "-" + releaseDigest.Algorithm().String() + "-" + releaseDigest.Encoded()
+}
+
+// AcquireReleaseSignature acquires the first valid signature of the release releaseTag with releaseDigest found
+// in the signature stores. Without a valid signature, an error is returned with the require policy,
+// otherwise a warning is logged.
+func (o *ReleaseSignatures) AcquireReleaseSignature(ctx context.Context, releaseTag string, releaseDigest digest.Digest) error {
+	if o.Config.Policy == v2alpha1.SignaturePolicySkip || len(o.keyRing) == 0 {
+		return nil
+	}
+	signaturePath := filepath.Join(o.WorkingDir, SignaturesDir, FileName(releaseTag, releaseDigest))
+	if _, err := os.Stat(signaturePath); err == nil {
+		o.Log.Debug(signaturePrefix+"signature of release %s already acquired", releaseTag)
+		return nil
+	}
+
+	errs := []string{}
+	for _, store := range o.Config.Stores {
+		signature, err := o.findValidSignature(ctx, store, releaseDigest)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", store, err))
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(signaturePath), 0755); err != nil {
+			return fmt.Errorf(signaturePrefix+"%w", err)
+		}
+		// #nosec G306
+		if err := os.WriteFile(signaturePath, signature, 0644); err != nil {
+			return fmt.Errorf(signaturePrefix+"%w", err)
+		}
+		o.Log.Debug(signaturePrefix+"signature of release %s (%s) acquired from %s", releaseTag, releaseDigest, store)
+		return nil
+	}
+	err := fmt.Errorf(signaturePrefix+"no valid signature found for release %s (%s): %s", releaseTag, releaseDigest, strings.Join(errs, ", "))
+	if o.Config.Policy == v2alpha1.SignaturePolicyRequire {
+		return err
+	}
+	o.Log.Warn("%v", err)
+	return nil
+}
+
+// findValidSignature returns the first signature of releaseDigest in store verified by the key ring
+func (o *ReleaseSignatures) findValidSignature(ctx context.Context, store string, releaseDigest digest.Digest) ([]byte, error) {
+	invalid := []string{}
+	for index := 1; index <= maxSignaturesLookup; index++ {
+		signature, err := o.readSignature(ctx, store, releaseDigest, index)
+		if errors.Is(err, errNoSignature) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := o.verify(signature, releaseDigest); err != nil {
+			invalid = append(invalid, fmt.Sprintf("signature-%d %v", index, err))
+			continue
+		}
+		return signature, nil
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(invalid, ", "))
+	}
+	return nil, errNoSignature
+}
+
+// readSignature reads the signature index of releaseDigest in store: <store>/sha256=<hex>/signature-<index>
+func (o *ReleaseSignatures) readSignature(ctx context.Context, store string, releaseDigest digest.Digest, index int) ([]byte, error) {
+	signaturePath := releaseDigest.Algorithm().String() + "=" + releaseDigest.Encoded() + "/signature-" + strconv.Itoa(index)
+	if !strings.HasPrefix(store, "http://") && !strings.HasPrefix(store, "https://") {
+		signature, err := os.ReadFile(filepath.Join(strings.TrimPrefix(store, fileProtocol), filepath.FromSlash(signaturePath)))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errNoSignature
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		return signature, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(store, "/")+"/"+signaturePath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errNoSignature
+	default:
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	signature, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return signature, nil
+}
+
+// verify checks that signature is signed by a key of the key ring, and that it signs releaseDigest
+func (o *ReleaseSignatures) verify(signature []byte, releaseDigest digest.Digest) error {
+	md, err := openpgp.ReadMessage(bytes.NewReader(signature), o.keyRing, nil, nil)
+	if err != nil {
+		return fmt.Errorf("is not a valid signature: %w", err)
+	}
+	// the signature is only checked once the content is read
+	content, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return fmt.Errorf("is not a valid signature: %w", err)
+	}
+	if !md.IsSigned || md.SignedBy == nil {
+		return fmt.Errorf("is not signed by a trusted key")
+	}
+	if md.SignatureError != nil {
+		return fmt.Errorf("is not a valid signature: %w", md.SignatureError)
+	}
+	var schema v2alpha1.SignatureContentSchema
+	if err := json.Unmarshal(content, &schema); err != nil {
+		return fmt.Errorf("has an invalid content: %w", err)
+	}
+	if schema.Critical.Type != signatureType {
+		return fmt.Errorf("has an invalid type %q", schema.Critical.Type)
+	}
+	if schema.Critical.Image.DockerManifestDigest != releaseDigest.String() {
+		return fmt.Errorf("signs %s", schema.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package signature
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+	clog "github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/log"
+)
+
+func TestAcquireReleaseSignature(t *testing.T) {
+	releaseDigest := digest.FromString("release")
+	otherDigest := digest.FromString("other release")
+	trusted := newTestEntity(t)
+	untrusted := newTestEntity(t)
+	publicKey := writeTestPublicKey(t, trusted)
+
+	type testCase struct {
+		caseName      string
+		signatures    [][]byte
+		policy        string
+		expectedError string
+		// index of the signature expected in the signatures directory, -1 for none
+		expectedSignature int
+	}
+	testCases := []testCase{
+		{
+			caseName:          "valid signature",
+			signatures:        [][]byte{signTestRelease(t, trusted, releaseDigest)},
+			expectedSignature: 0,
+		},
+		{
+			caseName:          "the first valid signature is kept",
+			signatures:        [][]byte{signTestRelease(t, untrusted, releaseDigest), signTestRelease(t, trusted, otherDigest), signTestRelease(t, trusted, releaseDigest)},
+			expectedSignature: 2,
+		},
+		{
+			caseName:          "no valid signature, with the warn policy",
+			signatures:        [][]byte{signTestRelease(t, untrusted, releaseDigest)},
+			expectedSignature: -1,
+		},
+		{
+			caseName:          "no valid signature, with the require policy",
+			signatures:        [][]byte{signTestRelease(t, trusted, otherDigest)},
+			policy:            v2alpha1.SignaturePolicyRequire,
+			expectedError:     "signature-1 signs " + otherDigest.String(),
+			expectedSignature: -1,
+		},
+		{
+			caseName:          "no signature, with the require policy",
+			policy:            v2alpha1.SignaturePolicyRequire,
+			expectedError:     "no signature found",
+			expectedSignature: -1,
+		},
+	}
+	for _, testCase := range testCases {
+		for _, storeKind := range []string{"http", "directory"} {
+			t.Run(testCase.caseName+" from "+storeKind, func(t *testing.T) {
+				signaturesDir := t.TempDir()
+				for index, signature := range testCase.signatures {
+					dir := filepath.Join(signaturesDir, "sha256="+releaseDigest.Encoded())
+					require.NoError(t, os.MkdirAll(dir, 0755))
+					require.NoError(t, os.WriteFile(filepath.Join(dir, "signature-"+string(rune('1'+index))), signature, 0644))
+				}
+				store := "file://" + signaturesDir
+				if storeKind == "http" {
+					server := httptest.NewServer(http.FileServer(http.Dir(signaturesDir)))
+					defer server.Close()
+					store = server.URL
+				}
+
+				workingDir := t.TempDir()
+				releaseSignatures, err := New(clog.New("error"), v2alpha1.ReleaseSignatures{Stores: []string{store}, PublicKeys: []string{publicKey}, Policy: testCase.policy}, workingDir)
+				require.NoError(t, err)
+				err = releaseSignatures.AcquireReleaseSignature(context.Background(), "4.18.1-x86_64", releaseDigest)
+				if testCase.expectedError != "" {
+					require.ErrorContains(t, err, testCase.expectedError)
+				} else {
+					require.NoError(t, err)
+				}
+				signaturePath := filepath.Join(workingDir, SignaturesDir, "4.18.1-x86_64-sha256-"+releaseDigest.Encoded())
+				if testCase.expectedSignature < 0 {
+					require.NoFileExists(t, signaturePath)
+					return
+				}
+				signature, err := os.ReadFile(signaturePath)
+				require.NoError(t, err)
+				require.Equal(t, testCase.signatures[testCase.expectedSignature], signature)
+			})
+		}
+	}
+}
+
+func TestNewWithoutPublicKey(t *testing.T) {
+	_, err := New(clog.New("error"), v2alpha1.ReleaseSignatures{PublicKeys: []string{filepath.Join(t.TempDir(), "missing.gpg")}}, t.TempDir())
+	require.ErrorContains(t, err, "unable to read the public key")
+}
+
+func TestEnabled(t *testing.T) {
+	type testCase struct {
+		caseName string
+		cfg      v2alpha1.ReleaseSignatures
+		expected bool
+	}
+	testCases := []testCase{
+		// the default store is never reached unasked
+		{caseName: "not configured", expected: false},
+		{caseName: "store", cfg: v2alpha1.ReleaseSignatures{Stores: []string{"file:///signatures"}}, expected: true},
+		{caseName: "public key", cfg: v2alpha1.ReleaseSignatures{PublicKeys: []string{"release.gpg"}}, expected: true},
+		{caseName: "warn policy", cfg: v2alpha1.ReleaseSignatures{Policy: v2alpha1.SignaturePolicyWarn}, expected: true},
+		{caseName: "require policy", cfg: v2alpha1.ReleaseSignatures{Policy: v2alpha1.SignaturePolicyRequire}, expected: true},
+		{caseName: "skip policy", cfg: v2alpha1.ReleaseSignatures{Stores: []string{"file:///signatures"}, Policy: v2alpha1.SignaturePolicySkip}, expected: false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			require.Equal(t, testCase.expected, Enabled(testCase.cfg))
+		})
+	}
+}
+
+func TestFileName(t *testing.T) {
+	releaseDigest := digest.FromString("release")
+	require.Equal(t, "4.18.1-x86_64-sha256-"+releaseDigest.Encoded(), FileName("4.18.1-x86_64", releaseDigest))
+	require.Equal(t, releaseDigest.Encoded()+"-sha256-"+releaseDigest.Encoded(), FileName("", releaseDigest))
+}
+
+func newTestEntity(t *testing.T) *openpgp.Entity {
+	entity, err := openpgp.NewEntity("release", "", "release@example.com", nil)
+	require.NoError(t, err)
+	return entity
+}
+
+func writeTestPublicKey(t *testing.T, entity *openpgp.Entity) string {
+	buf := &bytes.Buffer{}
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	file := filepath.Join(t.TempDir(), "key.asc")
+	require.NoError(t, os.WriteFile(file, buf.Bytes(), 0644))
+	return file
+}
+
+// signTestRelease returns the signature of releaseDigest by entity, as found in the OpenShift signature store
+func signTestRelease(t *testing.T, entity *openpgp.Entity, releaseDigest digest.Digest) []byte {
+	content := v2alpha1.SignatureContentSchema{}
+	content.Critical.Type = signatureType
+	content.Critical.Image.DockerManifestDigest = releaseDigest.String()
+	content.Critical.Identity.DockerReference = "quay.io/openshift-release-dev/ocp-release:4.18.1-x86_64"
+	data, err := json.Marshal(content)
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	w, err := openpgp.Sign(buf, entity, nil, nil)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
@@ -0,0 +1,297 @@
+package signature
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/docker"
+	imgsignature "github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports/alltransports"
+	"sigs.k8s.io/yaml"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+)
+
+const (
+	// TrustPolicyDir is the directory of the working-dir the trust policy is generated in
+	TrustPolicyDir = "trust-policy"
+
+	// VerificationVerified is the status of the images verified by the keys of their scope
+	VerificationVerified = "verified"
+	// VerificationAccepted is the status of the images accepted without verification
+	VerificationAccepted = "accepted"
+	// VerificationRejected is the status of the images rejected by the trust policy
+	VerificationRejected = "rejected"
+	// VerificationFailed is the status of the images failing to be copied for another reason
+	VerificationFailed = "failed"
+
+	policyFileName           = "policy.json"
+	registriesDirName        = "registries.d"
+	registriesFileName       = "oc-mirror.yaml"
+	systemRegistriesDirPath  = "/etc/containers/registries.d"
+	userRegistriesDirPath    = ".config/containers/registries.d"
+	verificationReportPrefix = "signature_verification_"
+	sourceImageRejected      = "Source image rejected"
+)
+
+// TrustPolicy generates the containers/image signature policy of the trust policy of the image set configuration,
+// and reports the verification of the images copied with it
+type TrustPolicy struct {
+	Config           v2alpha1.TrustPolicy
+	LocalStorageFQDN string
+}
+
+// VerificationResult is the result of the verification of an image by the trust policy
+type VerificationResult struct {
+	Image  string `json:"image"`
+	Scope  string `json:"scope,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// registriesConfiguration is a registries.d configuration file, see containers-registries.d(5)
+type registriesConfiguration struct {
+	DefaultDocker map[string]interface{}            `json:"default-docker,omitempty"`
+	Docker        map[string]map[string]interface{} `json:"docker,omitempty"`
+}
+
+// NewTrustPolicy returns the TrustPolicy of cfg. The images of the local storage registry,
+// already verified when copied to it, are always accepted.
+func NewTrustPolicy(cfg v2alpha1.TrustPolicy, localStorageFQDN string) TrustPolicy {
+	return TrustPolicy{Config: cfg, LocalStorageFQDN: localStorageFQDN}
+}
+
+// Policy returns the containers/image signature policy of the trust policy: the images of a scope
+// must be signed by one of its GPG keys and by one of its sigstore public keys
+func (o TrustPolicy) Policy() (*imgsignature.Policy, error) {
+	defaultRequirements := imgsignature.PolicyRequirements{imgsignature.NewPRInsecureAcceptAnything()}
+	if o.Config.Default == v2alpha1.TrustPolicyReject {
+		defaultRequirements = imgsignature.PolicyRequirements{imgsignature.NewPRReject()}
+	}
+	scopes := imgsignature.PolicyTransportScopes{"": defaultRequirements}
+	for _, scope := range o.Config.Scopes {
+		requirements, err := scopeRequirements(scope)
+		if err != nil {
+			return nil, fmt.Errorf("trust policy of %s: %w", scope.Scope, err)
+		}
+		scopes[scope.Scope] = requirements
+	}
+	if o.LocalStorageFQDN != "" {
+		scopes[o.LocalStorageFQDN] = imgsignature.PolicyRequirements{imgsignature.NewPRInsecureAcceptAnything()}
+	}
+	return &imgsignature.Policy{
+		// the other transports are local to oc-mirror (i.e. oci layouts of the catalogs)
+		Default:    imgsignature.PolicyRequirements{imgsignature.NewPRInsecureAcceptAnything()},
+		Transports: map[string]imgsignature.PolicyTransportScopes{docker.Transport.Name(): scopes},
+	}, nil
+}
+
+func scopeRequirements(scope v2alpha1.TrustScope) (imgsignature.PolicyRequirements, error) {
+	requirements := imgsignature.PolicyRequirements{}
+	if len(scope.Keys) > 0 {
+		keys, err := absolutePaths(scope.Keys)
+		if err != nil {
+			return nil, err
+		}
+		requirement, err := imgsignature.NewPRSignedByKeyPaths(imgsignature.SBKeyTypeGPGKeys, keys, imgsignature.NewPRMMatchRepoDigestOrExact())
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		requirements = append(requirements, requirement)
+	}
+	if len(scope.SigstorePublicKeys) > 0 {
+		keys, err := absolutePaths(scope.SigstorePublicKeys)
+		if err != nil {
+			return nil, err
+		}
+		requirement, err := imgsignature.NewPRSigstoreSigned(
+			imgsignature.PRSigstoreSignedWithKeyPaths(keys),
+			imgsignature.PRSigstoreSignedWithSignedIdentity(imgsignature.NewPRMMatchRepoDigestOrExact()),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		requirements = append(requirements, requirement)
+	}
+	if len(requirements) == 0 {
+		requirements = append(requirements, imgsignature.NewPRInsecureAcceptAnything())
+	}
+	return requirements, nil
+}
+
+func absolutePaths(paths []string) ([]string, error) {
+	absolute := []string{}
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		absolute = append(absolute, abs)
+	}
+	return absolute, nil
+}
+
+// Write writes the signature policy (policy.json) to dir. When scopes use sigstore signatures or a lookaside,
+// it also writes the registries.d configuration enabling them, merged with the one of registriesDirPath
+// (the configuration of the user or of the system when empty), and returns its directory.
+func (o TrustPolicy) Write(dir, registriesDirPath string) (string, string, error) {
+	policy, err := o.Policy()
+	if err != nil {
+		return "", "", err
+	}
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return "", "", fmt.Errorf("%w", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", fmt.Errorf("%w", err)
+	}
+	policyPath := filepath.Join(dir, policyFileName)
+	// #nosec G306
+	if err := os.WriteFile(policyPath, data, 0644); err != nil {
+		return "", "", fmt.Errorf("%w", err)
+	}
+
+	registries := registriesConfiguration{Docker: map[string]map[string]interface{}{}}
+	for _, scope := range o.Config.Scopes {
+		if len(scope.SigstorePublicKeys) == 0 && scope.Lookaside == "" {
+			continue
+		}
+		registries.Docker[scope.Scope] = map[string]interface{}{}
+		if len(scope.SigstorePublicKeys) > 0 {
+			registries.Docker[scope.Scope]["use-sigstore-attachments"] = true
+		}
+		if scope.Lookaside != "" {
+			registries.Docker[scope.Scope]["lookaside"] = scope.Lookaside
+		}
+	}
+	if len(registries.Docker) == 0 {
+		return policyPath, "", nil
+	}
+	if err := mergeRegistriesDir(&registries, registriesDirPath); err != nil {
+		return "", "", err
+	}
+	data, err = yaml.Marshal(registries)
+	if err != nil {
+		return "", "", fmt.Errorf("%w", err)
+	}
+	registriesDir := filepath.Join(dir, registriesDirName)
+	if err := os.MkdirAll(registriesDir, 0755); err != nil {
+		return "", "", fmt.Errorf("%w", err)
+	}
+	// #nosec G306
+	if err := os.WriteFile(filepath.Join(registriesDir, registriesFileName), data, 0644); err != nil {
+		return "", "", fmt.Errorf("%w", err)
+	}
+	return policyPath, registriesDir, nil
+}
+
+// mergeRegistriesDir adds to registries the configuration of the files of registriesDirPath
+// (i.e. the lookasides of the registries), the scopes of registries taking precedence.
+// As containers/image, it defaults to the directory of the user, then to the one of the system.
+func mergeRegistriesDir(registries *registriesConfiguration, registriesDirPath string) error {
+	if registriesDirPath == "" {
+		registriesDirPath = systemRegistriesDirPath
+		if home, err := os.UserHomeDir(); err == nil {
+			if _, err := os.Stat(filepath.Join(home, userRegistriesDirPath)); err == nil {
+				registriesDirPath = filepath.Join(home, userRegistriesDirPath)
+			}
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(registriesDirPath, "*.yaml"))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		config := registriesConfiguration{}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("invalid registries.d configuration %s: %w", file, err)
+		}
+		if registries.DefaultDocker == nil {
+			registries.DefaultDocker = config.DefaultDocker
+		}
+		for scope, namespace := range config.Docker {
+			if trusted, ok := registries.Docker[scope]; ok {
+				for key, value := range namespace {
+					if _, ok := trusted[key]; !ok {
+						trusted[key] = value
+					}
+				}
+				continue
+			}
+			registries.Docker[scope] = namespace
+		}
+	}
+	return nil
+}
+
+// Verification returns the verification result of image (docker://...), whose copy failed with copyErr when not nil
+func (o TrustPolicy) Verification(image string, copyErr error) VerificationResult {
+	result := VerificationResult{Image: strings.TrimPrefix(image, "docker://"), Status: VerificationAccepted}
+	scope, ok := o.scopeOf(image)
+	if ok {
+		result.Scope = scope.Scope
+	}
+	switch {
+	case copyErr != nil && isRejected(copyErr):
+		result.Status = VerificationRejected
+		result.Error = copyErr.Error()
+	case copyErr != nil:
+		result.Status = VerificationFailed
+		result.Error = copyErr.Error()
+	case ok && (len(scope.Keys) > 0 || len(scope.SigstorePublicKeys) > 0):
+		result.Status = VerificationVerified
+	}
+	return result
+}
+
+// scopeOf returns the scope of the trust policy applying to image, the most specific one, like containers/image
+func (o TrustPolicy) scopeOf(image string) (v2alpha1.TrustScope, bool) {
+	ref, err := alltransports.ParseImageName(image)
+	if err != nil || ref.Transport().Name() != docker.Transport.Name() {
+		return v2alpha1.TrustScope{}, false
+	}
+	scopes := map[string]v2alpha1.TrustScope{}
+	for _, scope := range o.Config.Scopes {
+		scopes[scope.Scope] = scope
+	}
+	candidates := append([]string{ref.PolicyConfigurationIdentity()}, ref.PolicyConfigurationNamespaces()...)
+	for _, candidate := range candidates {
+		if scope, ok := scopes[candidate]; ok {
+			return scope, true
+		}
+	}
+	return v2alpha1.TrustScope{}, false
+}
+
+func isRejected(err error) bool {
+	var requirementErr imgsignature.PolicyRequirementError
+	return errors.As(err, &requirementErr) || strings.Contains(err.Error(), sourceImageRejected)
+}
+
+// SaveVerificationReport writes results, sorted by image, to a json file of logsDir and returns its name
+func SaveVerificationReport(logsDir string, results []VerificationResult) (string, error) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Image < results[j].Image
+	})
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	filename := verificationReportPrefix + time.Now().Format("20060102_150405") + ".json"
+	// #nosec G306
+	if err := os.WriteFile(filepath.Join(logsDir, filename), data, 0644); err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return filename, nil
+}
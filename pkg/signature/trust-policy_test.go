@@ -0,0 +1,221 @@
+package signature
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/image/v5/copy"
+	imgsignature "github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/signature/sigstore"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+
+	"github.com/lmzuccarelli/golang-oc-mirror-refactor/pkg/api/v2alpha1"
+)
+
+var testTrustPolicy = v2alpha1.TrustPolicy{
+	Default: v2alpha1.TrustPolicyReject,
+	Scopes: []v2alpha1.TrustScope{
+		{Scope: "registry.redhat.io", Keys: []string{"/etc/pki/rpm-gpg/RPM-GPG-KEY-redhat-release"}, Lookaside: "https://registry.redhat.io/containers/sigstore"},
+		{Scope: "quay.io/example/app", SigstorePublicKeys: []string{"/etc/pki/cosign.pub"}},
+		{Scope: "quay.io/example"},
+	},
+}
+
+func TestTrustPolicyWrite(t *testing.T) {
+	registriesDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(registriesDir, "default.yaml"), []byte(`default-docker:
+  lookaside: file:///var/lib/containers/sigstore
+docker:
+  registry.redhat.io:
+    lookaside: https://registry.redhat.io/containers/sigstore-mirror
+  registry.access.redhat.com:
+    lookaside: https://access.redhat.com/webassets/docker/content/sigstore
+`), 0644))
+
+	dir := t.TempDir()
+	policyPath, registriesDirPath, err := NewTrustPolicy(testTrustPolicy, "localhost:55000").Write(dir, registriesDir)
+	require.NoError(t, err)
+
+	policy, err := imgsignature.NewPolicyFromFile(policyPath)
+	require.NoError(t, err)
+	scopes := policy.Transports["docker"]
+	require.Equal(t, imgsignature.PolicyRequirements{imgsignature.NewPRReject()}, scopes[""])
+	require.Equal(t, imgsignature.PolicyRequirements{imgsignature.NewPRInsecureAcceptAnything()}, scopes["localhost:55000"])
+	require.Equal(t, imgsignature.PolicyRequirements{imgsignature.NewPRInsecureAcceptAnything()}, scopes["quay.io/example"])
+	require.Len(t, scopes["registry.redhat.io"], 1)
+	require.Len(t, scopes["quay.io/example/app"], 1)
+
+	require.Equal(t, filepath.Join(dir, registriesDirName), registriesDirPath)
+	data, err := os.ReadFile(filepath.Join(registriesDirPath, registriesFileName))
+	require.NoError(t, err)
+	registries := registriesConfiguration{}
+	require.NoError(t, yaml.Unmarshal(data, &registries))
+	require.Equal(t, map[string]interface{}{"lookaside": "file:///var/lib/containers/sigstore"}, registries.DefaultDocker)
+	require.Equal(t, map[string]map[string]interface{}{
+		// the scopes of the trust policy take precedence
+		"registry.redhat.io":         {"lookaside": "https://registry.redhat.io/containers/sigstore"},
+		"registry.access.redhat.com": {"lookaside": "https://access.redhat.com/webassets/docker/content/sigstore"},
+		"quay.io/example/app":        {"use-sigstore-attachments": true},
+	}, registries.Docker)
+
+	// without sigstore signatures or lookaside, the registries.d configuration is kept as is
+	_, registriesDirPath, err = NewTrustPolicy(v2alpha1.TrustPolicy{Scopes: []v2alpha1.TrustScope{{Scope: "quay.io", Keys: []string{"key.gpg"}}}}, "").Write(t.TempDir(), registriesDir)
+	require.NoError(t, err)
+	require.Empty(t, registriesDirPath)
+}
+
+func TestTrustPolicyVerification(t *testing.T) {
+	type testCase struct {
+		caseName string
+		image    string
+		copyErr  error
+		expected VerificationResult
+	}
+	testCases := []testCase{
+		{
+			caseName: "verified by the keys of the registry",
+			image:    "docker://registry.redhat.io/ubi9/ubi:latest",
+			expected: VerificationResult{Image: "registry.redhat.io/ubi9/ubi:latest", Scope: "registry.redhat.io", Status: VerificationVerified},
+		},
+		{
+			caseName: "verified by the keys of the repository, more specific than the namespace",
+			image:    "docker://quay.io/example/app@sha256:0f2dbb8a41f0c8c8b5b4aa0c0a0f2a3e8c3d4b6c1d9e2f7a8b9c0d1e2f3a4b5c",
+			expected: VerificationResult{Image: "quay.io/example/app@sha256:0f2dbb8a41f0c8c8b5b4aa0c0a0f2a3e8c3d4b6c1d9e2f7a8b9c0d1e2f3a4b5c", Scope: "quay.io/example/app", Status: VerificationVerified},
+		},
+		{
+			caseName: "accepted by a scope without key",
+			image:    "docker://quay.io/example/tools:v1",
+			expected: VerificationResult{Image: "quay.io/example/tools:v1", Scope: "quay.io/example", Status: VerificationAccepted},
+		},
+		{
+			caseName: "rejected",
+			image:    "docker://registry.redhat.io/ubi9/ubi:latest",
+			copyErr:  fmt.Errorf("Source image rejected: %w", imgsignature.PolicyRequirementError("A signature was required, but no signature exists")),
+			expected: VerificationResult{Image: "registry.redhat.io/ubi9/ubi:latest", Scope: "registry.redhat.io", Status: VerificationRejected, Error: "Source image rejected: A signature was required, but no signature exists"},
+		},
+		{
+			caseName: "rejected by the default policy",
+			image:    "docker://docker.io/library/busybox:latest",
+			copyErr:  fmt.Errorf("Source image rejected: %w", imgsignature.PolicyRequirementError("Running image docker://busybox:latest is rejected by policy.")),
+			expected: VerificationResult{Image: "docker.io/library/busybox:latest", Status: VerificationRejected, Error: "Source image rejected: Running image docker://busybox:latest is rejected by policy."},
+		},
+		{
+			caseName: "failed",
+			image:    "docker://registry.redhat.io/ubi9/ubi:latest",
+			copyErr:  errors.New("manifest unknown"),
+			expected: VerificationResult{Image: "registry.redhat.io/ubi9/ubi:latest", Scope: "registry.redhat.io", Status: VerificationFailed, Error: "manifest unknown"},
+		},
+	}
+	trustPolicy := NewTrustPolicy(testTrustPolicy, "localhost:55000")
+	for _, testCase := range testCases {
+		t.Run(testCase.caseName, func(t *testing.T) {
+			require.Equal(t, testCase.expected, trustPolicy.Verification(testCase.image, testCase.copyErr))
+		})
+	}
+}
+
+func TestTrustPolicyEnforced(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	keysDir := t.TempDir()
+	trustedKey := generateSigstoreKey(t, keysDir, "trusted")
+	untrustedKey := generateSigstoreKey(t, keysDir, "untrusted")
+	// the staging image is pushed as is, then copied to the scope of the trust policy
+	img, err := random.Image(1024, 1)
+	require.NoError(t, err)
+	staging, err := name.ParseReference(host + "/staging/app:v1")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(staging, img))
+	signImage(t, "docker://"+staging.String(), "docker://"+host+"/example/signed:v1", trustedKey)
+	signImage(t, "docker://"+staging.String(), "docker://"+host+"/example/forged:v1", untrustedKey)
+	signImage(t, "docker://"+staging.String(), "docker://"+host+"/example/unsigned:v1", "")
+	signImage(t, "docker://"+staging.String(), "docker://"+host+"/other/app:v1", "")
+
+	trustPolicy := NewTrustPolicy(v2alpha1.TrustPolicy{
+		Default: v2alpha1.TrustPolicyReject,
+		Scopes:  []v2alpha1.TrustScope{{Scope: host + "/example", SigstorePublicKeys: []string{trustedKey + ".pub"}}},
+	}, "")
+	policyPath, registriesDirPath, err := trustPolicy.Write(t.TempDir(), t.TempDir())
+	require.NoError(t, err)
+	require.NotEmpty(t, registriesDirPath)
+	policy, err := imgsignature.NewPolicyFromFile(policyPath)
+	require.NoError(t, err)
+
+	type testCase struct {
+		image    string
+		expected string
+	}
+	testCases := []testCase{
+		{image: "example/signed:v1", expected: VerificationVerified},
+		{image: "example/forged:v1", expected: VerificationRejected},
+		{image: "example/unsigned:v1", expected: VerificationRejected},
+		{image: "other/app:v1", expected: VerificationRejected},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.image, func(t *testing.T) {
+			image := "docker://" + host + "/" + testCase.image
+			// copied as the mirror does: the signatures are verified, not copied
+			copyErr := copyImage(t, image, "dir:"+t.TempDir(), policy, &copy.Options{
+				SourceCtx:        &types.SystemContext{RegistriesDirPath: registriesDirPath, DockerInsecureSkipTLSVerify: types.OptionalBoolTrue},
+				RemoveSignatures: true,
+			})
+			result := trustPolicy.Verification(image, copyErr)
+			require.Equal(t, testCase.expected, result.Status, result.Error)
+		})
+	}
+}
+
+// generateSigstoreKey writes a sigstore key pair to dir, and returns the path of the private key
+// (the public key is the same path with a .pub suffix)
+func generateSigstoreKey(t *testing.T, dir, keyName string) string {
+	keys, err := sigstore.GenerateKeyPair([]byte(keyName))
+	require.NoError(t, err)
+	privateKey := filepath.Join(dir, keyName+".key")
+	require.NoError(t, os.WriteFile(privateKey, keys.PrivateKey, 0600))
+	require.NoError(t, os.WriteFile(privateKey+".pub", keys.PublicKey, 0600))
+	return privateKey
+}
+
+// signImage copies src to dest, signing it with the sigstore privateKey when not empty
+func signImage(t *testing.T, src, dest, privateKey string) {
+	registriesDir := t.TempDir()
+	host := strings.SplitN(strings.TrimPrefix(dest, "docker://"), "/", 2)[0]
+	require.NoError(t, os.WriteFile(filepath.Join(registriesDir, "sign.yaml"), []byte("docker:\n  "+host+":\n    use-sigstore-attachments: true\n"), 0600))
+	sysCtx := &types.SystemContext{RegistriesDirPath: registriesDir, DockerInsecureSkipTLSVerify: types.OptionalBoolTrue}
+	opts := &copy.Options{SourceCtx: sysCtx, DestinationCtx: sysCtx}
+	if privateKey != "" {
+		opts.SignBySigstorePrivateKeyFile = privateKey
+		opts.SignSigstorePrivateKeyPassphrase = []byte(strings.TrimSuffix(filepath.Base(privateKey), ".key"))
+	}
+	policy := &imgsignature.Policy{Default: imgsignature.PolicyRequirements{imgsignature.NewPRInsecureAcceptAnything()}}
+	require.NoError(t, copyImage(t, src, dest, policy, opts))
+}
+
+func copyImage(t *testing.T, src, dest string, policy *imgsignature.Policy, opts *copy.Options) error {
+	srcRef, err := alltransports.ParseImageName(src)
+	require.NoError(t, err)
+	destRef, err := alltransports.ParseImageName(dest)
+	require.NoError(t, err)
+	policyContext, err := imgsignature.NewPolicyContext(policy)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, policyContext.Destroy()) }()
+	_, err = copy.Image(context.Background(), policyContext, destRef, srcRef, opts)
+	return err
+}